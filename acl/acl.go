@@ -0,0 +1,308 @@
+/*
+Package acl keeps the users clients authenticate as and checks the commands
+they run against their permissions: the commands and categories of commands
+they may run, and the keys and pub/sub channels they may access.
+*/
+package acl
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxLogEntries is the number of denials ACL LOG remembers.
+const MaxLogEntries = 128
+
+// logMergeWindow is how recent an entry must be for an identical denial to count in it rather than get its own.
+const logMergeWindow = time.Minute
+
+var (
+	ErrWrongPass   = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	ErrDefaultUser = errors.New("ERR The 'default' user cannot be removed")
+	ErrNoFile      = errors.New("ERR This FlashDB instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then set aclfile to save them with ACL SAVE.")
+	errInvalidName = errors.New("Usernames can't contain spaces or null characters")
+)
+
+// The reasons and contexts of the ACL LOG entries.
+const (
+	reasonCommand   = "command"
+	reasonKey       = "key"
+	reasonChannel   = "channel"
+	reasonAuth      = "auth"
+	contextToplevel = "toplevel"
+	contextMulti    = "multi"
+)
+
+// DeniedError is returned when a user is not allowed to run a command. Object
+// is the command, the key or the channel refused, depending on Reason.
+type DeniedError struct {
+	Reason string
+	Object string
+	User   string
+}
+
+func (e *DeniedError) Error() string {
+	switch e.Reason {
+	case reasonKey:
+		return "NOPERM No permissions to access a key"
+	case reasonChannel:
+		return "NOPERM No permissions to access a channel"
+	}
+	return "NOPERM User " + e.User + " has no permissions to run the '" + e.Object + "' command"
+}
+
+// LogEntry is a denial ACL LOG reports, identical denials are counted in a single entry.
+type LogEntry struct {
+	Count      int
+	Reason     string
+	Context    string
+	Object     string
+	Username   string
+	ClientInfo string
+	Created    time.Time
+	Updated    time.Time
+}
+
+/*
+ACL holds the users. It starts with the default user, which has every
+permission and needs no password, so that a server without ACL configuration is
+open as before.
+
+categories maps every command, lowercase, to its categories, subcommands of
+container commands such as client|kill having their own entry when their
+categories differ from the container's.
+*/
+type ACL struct {
+	categories map[string][]string
+
+	mu    sync.RWMutex
+	file  string
+	users map[string]*User
+	log   []*LogEntry
+}
+
+func New(categories map[string][]string) *ACL {
+	return &ACL{
+		categories: categories,
+		users:      map[string]*User{DefaultUser: defaultUser()},
+	}
+}
+
+/*
+SetUser creates the user if needed and applies rules to it in order, see
+User.apply. Either every rule applies or the user is left untouched, the error
+then names the rule refused.
+*/
+func (a *ACL) SetUser(name string, rules ...string) error {
+	if name == "" || slices.ContainsFunc([]byte(name), func(c byte) bool { return c == ' ' || c == 0 }) {
+		return ruleError("", errInvalidName)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user := newUser(name)
+	if existing, ok := a.users[name]; ok {
+		user = existing.clone()
+	}
+	for _, rule := range rules {
+		if err := user.apply(rule, a.categories); err != nil {
+			return ruleError(rule, err)
+		}
+	}
+	a.users[name] = user
+	return nil
+}
+
+func ruleError(rule string, err error) error {
+	return errors.New("ERR " + modifierError(rule, err))
+}
+
+func modifierError(rule string, err error) string {
+	return "Error in ACL SETUSER modifier '" + rule + "': " + err.Error()
+}
+
+// DelUser deletes the user and reports whether it existed. The default user can not be deleted.
+func (a *ACL) DelUser(name string) (bool, error) {
+	if name == DefaultUser {
+		return false, ErrDefaultUser
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.users[name]
+	delete(a.users, name)
+	return ok, nil
+}
+
+// User returns a copy of the user.
+func (a *ACL) User(name string) (User, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user, ok := a.users[name]
+	if !ok {
+		return User{}, false
+	}
+	return *user.clone(), true
+}
+
+// Users returns the names of the users, sorted.
+func (a *ACL) Users() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List describes every user, sorted by name, see User.Describe.
+func (a *ACL) List() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return describe(a.users)
+}
+
+func describe(users map[string]*User) []string {
+	lines := make([]string, 0, len(users))
+	for _, user := range users {
+		lines = append(lines, user.Describe())
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// Authenticate checks the password of an enabled user.
+func (a *ACL) Authenticate(name, password string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if user, ok := a.users[name]; ok && user.Enabled && user.checkPassword(password) {
+		return nil
+	}
+	return ErrWrongPass
+}
+
+// DefaultNoPass reports whether the default user is enabled without a password,
+// in which case connections are authenticated as default from the start.
+func (a *ACL) DefaultNoPass() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user := a.users[DefaultUser]
+	return user.Enabled && user.NoPass
+}
+
+/*
+Check returns a *DeniedError when the user may not run command, lowercase with
+its subcommand for container commands, or access one of keys or channels.
+With patterns, channels are the patterns of PSUBSCRIBE: they must be one of the
+user's channel patterns, not merely match one.
+*/
+func (a *ACL) Check(name, command string, keys, channels [][]byte, patterns bool) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user, ok := a.users[name]
+	if !ok || !user.canRun(command, a.categories) {
+		return &DeniedError{Reason: reasonCommand, Object: command, User: name}
+	}
+	for _, key := range keys {
+		if !matchAny(user.Keys, key) {
+			return &DeniedError{Reason: reasonKey, Object: string(key), User: name}
+		}
+	}
+	for _, channel := range channels {
+		allowed := matchAny(user.Channels, channel)
+		if patterns {
+			allowed = slices.ContainsFunc(user.Channels, func(pattern string) bool {
+				return pattern == "*" || pattern == string(channel)
+			})
+		}
+		if !allowed {
+			return &DeniedError{Reason: reasonChannel, Object: string(channel), User: name}
+		}
+	}
+	return nil
+}
+
+// Categories returns the names of the command categories, sorted.
+func (a *ACL) Categories() []string {
+	var names []string
+	for _, list := range a.categories {
+		for _, category := range list {
+			if !slices.Contains(names, category) {
+				names = append(names, category)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CategoryCommands returns the commands of category, sorted, and false when there is no such category.
+func (a *ACL) CategoryCommands(category string) ([]string, bool) {
+	if !slices.Contains(a.Categories(), category) {
+		return nil, false
+	}
+	var commands []string
+	for command := range a.categories {
+		if inCategory(command, category, a.categories) {
+			commands = append(commands, command)
+		}
+	}
+	sort.Strings(commands)
+	return commands, true
+}
+
+// LogDenied records a command refused by Check, err being the error it returned.
+func (a *ACL) LogDenied(err *DeniedError, inMulti bool, clientInfo string) {
+	context := contextToplevel
+	if inMulti {
+		context = contextMulti
+	}
+	a.addLog(LogEntry{Reason: err.Reason, Context: context, Object: err.Object, Username: err.User, ClientInfo: clientInfo})
+}
+
+// LogAuthFailure records a failed AUTH or HELLO AUTH for username.
+func (a *ACL) LogAuthFailure(username, clientInfo string) {
+	a.addLog(LogEntry{Reason: reasonAuth, Context: contextToplevel, Object: "AUTH", Username: username, ClientInfo: clientInfo})
+}
+
+func (a *ACL) addLog(entry LogEntry) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, e := range a.log {
+		if e.Reason == entry.Reason && e.Context == entry.Context && e.Object == entry.Object &&
+			e.Username == entry.Username && now.Sub(e.Updated) < logMergeWindow {
+			e.Count++
+			e.Updated, e.ClientInfo = now, entry.ClientInfo
+			return
+		}
+	}
+	entry.Count, entry.Created, entry.Updated = 1, now, now
+	a.log = slices.Insert(a.log, 0, &entry)
+	if len(a.log) > MaxLogEntries {
+		a.log = a.log[:MaxLogEntries]
+	}
+}
+
+// Log returns up to count entries of the log, the most recent first, every entry with a negative count.
+func (a *ACL) Log(count int) []LogEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if count < 0 || count > len(a.log) {
+		count = len(a.log)
+	}
+	entries := make([]LogEntry, count)
+	for i := range entries {
+		entries[i] = *a.log[i]
+	}
+	return entries
+}
+
+func (a *ACL) ResetLog() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.log = nil
+}
@@ -0,0 +1,105 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+The ACL file holds one user per line in the format of ACL LIST:
+
+	user alice on #<sha-256 of the password> ~cache:* resetchannels -@all +get +set
+
+Blank lines and lines starting with # are ignored. Passwords are only ever
+written as their hash.
+*/
+
+// SetFile sets the ACL file used by Load and Save.
+func (a *ACL) SetFile(path string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file = path
+}
+
+/*
+Load replaces the users with those of the ACL file. The file is checked as a
+whole, an error leaves the users untouched. The default user is created as
+New creates it when the file does not define it.
+*/
+func (a *ACL) Load() error {
+	a.mu.RLock()
+	path := a.file
+	a.mu.RUnlock()
+	if path == "" {
+		return ErrNoFile
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("ERR failed to load the ACL file: %w", err)
+	}
+	defer f.Close()
+
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("ERR %s:%d: line should start with user keyword", path, line)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return fmt.Errorf("ERR %s:%d: duplicate user '%s'", path, line, name)
+		}
+		user := newUser(name)
+		for _, rule := range fields[2:] {
+			if err := user.apply(rule, a.categories); err != nil {
+				return fmt.Errorf("ERR %s:%d: %s", path, line, modifierError(rule, err))
+			}
+		}
+		users[name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ERR failed to load the ACL file: %w", err)
+	}
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = defaultUser()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = users
+	return nil
+}
+
+// Save writes the users to the ACL file, through a temporary file renamed over it.
+func (a *ACL) Save() error {
+	a.mu.RLock()
+	path := a.file
+	lines := describe(a.users)
+	a.mu.RUnlock()
+	if path == "" {
+		return ErrNoFile
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("ERR failed to save the ACL file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ERR failed to save the ACL file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ERR failed to save the ACL file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("ERR failed to save the ACL file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+// DefaultUser is the user every connection starts as.
+const DefaultUser = "default"
+
+var (
+	errRuleSyntax   = errors.New("Syntax error")
+	errUnknownRule  = errors.New("Unknown command or category name in ACL")
+	errPasswordHash = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errNoPassword   = errors.New("no such password")
+)
+
+/*
+User is a set of permissions clients get by authenticating as Name.
+
+Commands holds the command rules in the order they were given, +@category,
+-@category, +command or -command, a command being either a whole command or a
+container command and its subcommand such as client|kill. The last rule matching
+a command decides, no rule matching denies it. Keys and Channels hold the glob
+patterns the keys and the pub/sub channels used must match.
+*/
+type User struct {
+	Name    string
+	Enabled bool
+	NoPass  bool
+	// Passwords holds the SHA-256 hashes of the passwords, in hex.
+	Passwords []string
+	Commands  []string
+	Keys      []string
+	Channels  []string
+}
+
+// newUser returns a user as ACL SETUSER creates it: disabled, without passwords and permissions.
+func newUser(name string) *User {
+	return &User{Name: name}
+}
+
+func defaultUser() *User {
+	return &User{
+		Name:     DefaultUser,
+		Enabled:  true,
+		NoPass:   true,
+		Commands: []string{"+@all"},
+		Keys:     []string{"*"},
+		Channels: []string{"*"},
+	}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.Passwords = slices.Clone(u.Passwords)
+	c.Commands = slices.Clone(u.Commands)
+	c.Keys = slices.Clone(u.Keys)
+	c.Channels = slices.Clone(u.Channels)
+	return &c
+}
+
+// HashPassword returns the SHA-256 hash of password in hex, the form users keep their passwords in.
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPassword reports whether password is one of the user's, or anything for a nopass user.
+func (u *User) checkPassword(password string) bool {
+	if u.NoPass {
+		return true
+	}
+	hash := HashPassword(password)
+	match := false
+	for _, p := range u.Passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			match = true
+		}
+	}
+	return match
+}
+
+/*
+apply changes the user according to one ACL SETUSER rule:
+
+	on, off                    enable or disable the user
+	>password, <password       add or remove a password
+	#hash, !hash               add or remove a password given as its SHA-256 hash
+	nopass, resetpass          accept any password, or remove every password and nopass
+	~pattern, allkeys          allow the keys matching pattern, or every key
+	resetkeys                  forget the key patterns
+	&pattern, allchannels      allow the channels matching pattern, or every channel
+	resetchannels              forget the channel patterns
+	+command, -command         allow or deny a command, or a subcommand such as client|kill
+	+@category, -@category     allow or deny the commands of a category, @all for every command
+	allcommands, nocommands    the same as +@all and -@all
+	reset                      back to the state of a new user
+*/
+func (u *User) apply(rule string, categories map[string][]string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.Enabled = true
+	case "off":
+		u.Enabled = false
+	case "nopass":
+		u.NoPass, u.Passwords = true, nil
+	case "resetpass":
+		u.NoPass, u.Passwords = false, nil
+	case "allkeys":
+		u.Keys = []string{"*"}
+	case "resetkeys":
+		u.Keys = nil
+	case "allchannels":
+		u.Channels = []string{"*"}
+	case "resetchannels":
+		u.Channels = nil
+	case "allcommands":
+		u.addCommandRule("+@all")
+	case "nocommands":
+		u.addCommandRule("-@all")
+	case "reset":
+		*u = *newUser(u.Name)
+	default:
+		if rule == "" {
+			return errRuleSyntax
+		}
+		arg := rule[1:]
+		switch rule[0] {
+		case '>':
+			u.addPassword(HashPassword(arg))
+		case '#':
+			if !validHash(arg) {
+				return errPasswordHash
+			}
+			u.addPassword(arg)
+		case '<', '!':
+			hash := arg
+			if rule[0] == '<' {
+				hash = HashPassword(arg)
+			} else if !validHash(arg) {
+				return errPasswordHash
+			}
+			i := slices.Index(u.Passwords, hash)
+			if i < 0 {
+				return errNoPassword
+			}
+			u.Passwords = slices.Delete(u.Passwords, i, i+1)
+		case '~':
+			u.Keys = addPattern(u.Keys, arg)
+		case '&':
+			u.Channels = addPattern(u.Channels, arg)
+		case '+', '-':
+			name := strings.ToLower(arg)
+			if !knownRule(name, categories) {
+				return errUnknownRule
+			}
+			u.addCommandRule(rule[:1] + name)
+		default:
+			return errRuleSyntax
+		}
+	}
+	return nil
+}
+
+func (u *User) addPassword(hash string) {
+	u.NoPass = false
+	if !slices.Contains(u.Passwords, hash) {
+		u.Passwords = append(u.Passwords, hash)
+	}
+}
+
+func validHash(hash string) bool {
+	return len(hash) == 64 && !strings.ContainsFunc(hash, func(r rune) bool {
+		return (r < '0' || r > '9') && (r < 'a' || r > 'f')
+	})
+}
+
+// addPattern adds pattern to patterns, * making every other pattern useless.
+func addPattern(patterns []string, pattern string) []string {
+	switch {
+	case pattern == "*":
+		return []string{"*"}
+	case slices.Contains(patterns, "*"), slices.Contains(patterns, pattern):
+		return patterns
+	}
+	return append(patterns, pattern)
+}
+
+// addCommandRule appends rule, dropping the earlier rules it overrides.
+func (u *User) addCommandRule(rule string) {
+	if rule[1:] == "@all" {
+		u.Commands = []string{rule}
+		return
+	}
+	u.Commands = slices.DeleteFunc(u.Commands, func(r string) bool { return r[1:] == rule[1:] })
+	u.Commands = append(u.Commands, rule)
+}
+
+// knownRule reports whether name, a command, a subcommand or an @category, is known.
+func knownRule(name string, categories map[string][]string) bool {
+	if category, ok := strings.CutPrefix(name, "@"); ok {
+		if category == "all" {
+			return true
+		}
+		for _, list := range categories {
+			if slices.Contains(list, category) {
+				return true
+			}
+		}
+		return false
+	}
+	if _, ok := categories[name]; ok {
+		return true
+	}
+	// Subcommands without categories of their own are known through their container.
+	container, sub, ok := strings.Cut(name, "|")
+	_, known := categories[container]
+	return ok && sub != "" && known
+}
+
+// inCategory reports whether command belongs to category. A subcommand without
+// categories of its own belongs to those of its container command.
+func inCategory(command, category string, categories map[string][]string) bool {
+	list, ok := categories[command]
+	if !ok {
+		container, _, _ := strings.Cut(command, "|")
+		list = categories[container]
+	}
+	return slices.Contains(list, category)
+}
+
+// canRun reports whether the user may run command, lowercase, subcommand included for container commands.
+func (u *User) canRun(command string, categories map[string][]string) bool {
+	container, _, _ := strings.Cut(command, "|")
+	allowed := false
+	for _, rule := range u.Commands {
+		target := rule[1:]
+		var match bool
+		if category, ok := strings.CutPrefix(target, "@"); ok {
+			match = category == "all" || inCategory(command, category, categories)
+		} else {
+			match = target == command || target == container
+		}
+		if match {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// matchAny reports whether s matches one of the glob patterns.
+func matchAny(patterns []string, s []byte) bool {
+	for _, pattern := range patterns {
+		if util.GlobMatch(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandRules returns the command rules as ACL GETUSER reports them, starting from -@all unless they reset it.
+func (u *User) CommandRules() string {
+	if len(u.Commands) == 0 {
+		return "-@all"
+	}
+	rules := strings.Join(u.Commands, " ")
+	if u.Commands[0][1:] != "@all" {
+		rules = "-@all " + rules
+	}
+	return rules
+}
+
+// Describe returns the user the way ACL LIST reports it and the ACL file stores
+// it, a line that recreates the user when given to ACL SETUSER.
+func (u *User) Describe() string {
+	fields := []string{"user", u.Name}
+	if u.Enabled {
+		fields = append(fields, "on")
+	} else {
+		fields = append(fields, "off")
+	}
+	if u.NoPass {
+		fields = append(fields, "nopass")
+	}
+	for _, hash := range u.Passwords {
+		fields = append(fields, "#"+hash)
+	}
+	for _, pattern := range u.Keys {
+		fields = append(fields, "~"+pattern)
+	}
+	if len(u.Channels) == 0 {
+		fields = append(fields, "resetchannels")
+	}
+	for _, pattern := range u.Channels {
+		fields = append(fields, "&"+pattern)
+	}
+	fields = append(fields, u.CommandRules())
+	return strings.Join(fields, " ")
+}
@@ -2,13 +2,10 @@ package aof
 
 import (
 	"bufio"
-	"fmt"
 	"io"
+	"log"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
-	"time"
 
 	"github.com/PetarGeorgiev-hash/flashdb/protocol"
 	"github.com/PetarGeorgiev-hash/flashdb/store"
@@ -16,6 +13,7 @@ import (
 
 type IAOF interface {
 	AppendCommand(args ...string) error
+	AppendTransaction(commands [][]string) error
 	Reset() error
 	Close() error
 	LoadAOF(filename string, s store.IStore) error
@@ -30,11 +28,25 @@ func (a *AOF) AppendCommand(args ...string) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	cmd := fmt.Sprintf("*%d\r\n", len(args))
-	for _, arg := range args {
-		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	_, err := a.file.Write(protocol.AppendCommand(nil, args...))
+	return err
+}
+
+/*
+AppendTransaction records the writes of an EXEC between MULTI and EXEC, in a
+single write so that no other command lands in the middle. A crash can still cut
+the block short, LoadAOF then drops it instead of replaying half a transaction.
+*/
+func (a *AOF) AppendTransaction(commands [][]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	block := protocol.AppendCommand(nil, "MULTI")
+	for _, args := range commands {
+		block = protocol.AppendCommand(block, args...)
 	}
-	_, err := a.file.WriteString(cmd)
+	block = protocol.AppendCommand(block, "EXEC")
+	_, err := a.file.Write(block)
 	return err
 }
 
@@ -67,42 +79,35 @@ func (a *AOF) LoadAOF(filename string, s store.IStore) error {
 		return err
 	}
 	defer file.Close()
-	parser := protocol.NewRESPParser()
+	// The file was written by this server, it is not held to the client limits.
+	parser := protocol.NewRESPParser(protocol.Limits{})
 	reader := bufio.NewReader(file)
+	replayer := store.NewReplayer(s)
 
 	for {
-		parts, err := parser.ParseRESP(reader)
+		args, err := parser.ParseRESP(reader)
 		if err == io.EOF {
 			break
 		}
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("[AOF] ignoring the command cut short at the end of the file")
+			break
+		}
 		if err != nil {
 			return err
 		}
-		if len(parts) == 0 {
+		if len(args) == 0 {
 			continue
 		}
+		parts := protocol.Strings(args)
 
-		command := strings.ToUpper(parts[0])
-		switch command {
-		case "SET":
-			ttl := time.Duration(0)
-			if len(parts) == 4 {
-				if sec, err := strconv.Atoi(parts[3]); err == nil {
-					ttl = time.Duration(sec) * time.Second
-				}
-			}
-			s.Set(parts[1], []byte(parts[2]), ttl)
-		case "DEL":
-			s.Delete(parts[1])
-		case "EXPIRE":
-			key := parts[1]
-			sec, _ := strconv.Atoi(parts[2])
-			item, _ := s.Get(key)
-			if item != nil {
-				item.ExpiresAt = time.Now().Add(time.Duration(sec) * time.Second)
-			}
+		if err := replayer.Apply(parts); err != nil {
+			log.Printf("[AOF] failed to replay %v: %v", parts, err)
 		}
 	}
+	if n := replayer.Pending(); n > 0 {
+		log.Printf("[AOF] dropped an incomplete transaction of %d commands", n)
+	}
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -12,6 +13,7 @@ import (
 type NodeInfo struct {
 	ID       string   `json:"id"`       // Unique ID for node
 	Addr     string   `json:"addr"`     // e.g., "127.0.0.1:6379"
+	TLSAddr  string   `json:"tls_addr"` // Address of the TLS port, if any
 	Role     string   `json:"role"`     // "master" or "replica"
 	Slots    [2]int   `json:"slots"`    // Start and end slot range owned by this node [1-300]
 	Replicas []string `json:"replicas"` // List of replica addresses
@@ -63,12 +65,24 @@ func NewManager(cfg *Config, selfAddr string) *Manager {
 	return m
 }
 
-// GetSlotForKey computes the slot for a given key.
-func (m *Manager) GetSlotForKey(key string) int {
-	sum := util.CRC16([]byte(key))
+// GetSlotForKey computes the slot for a given key. Like Redis, when the key
+// contains a non-empty {hash tag} only the tag is hashed, so related keys can be
+// kept in the same slot and used together by multi-key commands.
+func (m *Manager) GetSlotForKey(key []byte) int {
+	if start := bytes.IndexByte(key, '{'); start >= 0 {
+		if end := bytes.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	sum := util.CRC16(key)
 	return int(sum % 1024)
 }
 
+// Enabled reports whether the node runs as part of a cluster.
+func (m *Manager) Enabled() bool {
+	return len(m.Nodes) > 0
+}
+
 // IsLocal returns true if this node owns the given slot.
 func (m *Manager) IsLocal(slot int) bool {
 	return slot >= m.Self.Slots[0] && slot <= m.Self.Slots[1]
@@ -81,3 +95,15 @@ func (m *Manager) GetOwner(slot int) string {
 	}
 	return ""
 }
+
+// GetTLSOwner returns the TLS address of the node that owns the slot, for
+// redirecting clients connected over TLS, or its address when it has no TLS port.
+func (m *Manager) GetTLSOwner(slot int) string {
+	owner := m.GetOwner(slot)
+	for _, n := range m.Nodes {
+		if n.Addr == owner && n.TLSAddr != "" {
+			return n.TLSAddr
+		}
+	}
+	return owner
+}
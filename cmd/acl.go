@@ -0,0 +1,535 @@
+package cmd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/acl"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	AuthCommand = "AUTH"
+	ACLCommand  = "ACL"
+)
+
+var (
+	errNoAuth      = errors.New("NOAUTH Authentication required.")
+	errHelloNoAuth = errors.New("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	errAuthNoPass  = errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	errACLLogCount = errors.New("ERR value is out of range, must be positive")
+)
+
+/*
+commandCategories lists the ACL categories of every command. Container commands
+may list subcommands with categories of their own, CLIENT KILL is an
+administrative command while CLIENT SETNAME is not.
+*/
+var commandCategories = map[string][]string{
+	SetCommand:                   {"write", "string", "slow"},
+	GetCommand:                   {"read", "string", "fast"},
+	DelCommand:                   {"keyspace", "write", "slow"},
+	UnlinkCommand:                {"keyspace", "write", "fast"},
+	PingCommand:                  {"fast", "connection"},
+	ExistsCommand:                {"keyspace", "read", "fast"},
+	TTLCommand:                   {"keyspace", "read", "fast"},
+	PTTLCommand:                  {"keyspace", "read", "fast"},
+	ExpireTimeCommand:            {"keyspace", "read", "fast"},
+	PExpireTimeCommand:           {"keyspace", "read", "fast"},
+	ExpireCommand:                {"keyspace", "write", "fast"},
+	PExpireCommand:               {"keyspace", "write", "fast"},
+	ExpireAtCommand:              {"keyspace", "write", "fast"},
+	PExpireAtCommand:             {"keyspace", "write", "fast"},
+	SaveCommand:                  {"admin", "slow", "dangerous"},
+	InfoCommand:                  {"slow", "dangerous"},
+	ConfigCommand:                {"slow"},
+	ConfigCommand + "|GET":       {"admin", "slow", "dangerous"},
+	ConfigCommand + "|SET":       {"admin", "slow", "dangerous"},
+	ConfigCommand + "|REWRITE":   {"admin", "slow", "dangerous"},
+	ConfigCommand + "|RESETSTAT": {"admin", "slow", "dangerous"},
+	CommandCommand:               {"slow", "connection"},
+
+	DBSizeCommand:    {"keyspace", "read", "fast"},
+	FlushDBCommand:   {"keyspace", "write", "slow", "dangerous"},
+	FlushAllCommand:  {"keyspace", "write", "slow", "dangerous"},
+	RenameCommand:    {"keyspace", "write", "slow"},
+	RenameNXCommand:  {"keyspace", "write", "fast"},
+	CopyCommand:      {"keyspace", "write", "slow"},
+	TypeCommand:      {"keyspace", "read", "fast"},
+	RandomKeyCommand: {"keyspace", "read", "slow"},
+	TouchCommand:     {"keyspace", "read", "fast"},
+	PersistCommand:   {"keyspace", "write", "fast"},
+	ScanCommand:      {"keyspace", "read", "slow"},
+	KeysCommand:      {"keyspace", "read", "slow", "dangerous"},
+
+	IncrCommand:        {"write", "string", "fast"},
+	DecrCommand:        {"write", "string", "fast"},
+	IncrByCommand:      {"write", "string", "fast"},
+	DecrByCommand:      {"write", "string", "fast"},
+	IncrByFloatCommand: {"write", "string", "fast"},
+	AppendCommand:      {"write", "string", "fast"},
+	StrLenCommand:      {"read", "string", "fast"},
+	GetRangeCommand:    {"read", "string", "slow"},
+	SetRangeCommand:    {"write", "string", "slow"},
+	GetDelCommand:      {"write", "string", "fast"},
+	GetExCommand:       {"write", "string", "fast"},
+	GetSetCommand:      {"write", "string", "fast"},
+	MGetCommand:        {"read", "string", "fast"},
+	MSetCommand:        {"write", "string", "slow"},
+	MSetNXCommand:      {"write", "string", "slow"},
+
+	HSetCommand:         {"write", "hash", "fast"},
+	HSetNXCommand:       {"write", "hash", "fast"},
+	HGetCommand:         {"read", "hash", "fast"},
+	HMGetCommand:        {"read", "hash", "fast"},
+	HDelCommand:         {"write", "hash", "fast"},
+	HLenCommand:         {"read", "hash", "fast"},
+	HExistsCommand:      {"read", "hash", "fast"},
+	HKeysCommand:        {"read", "hash", "slow"},
+	HValsCommand:        {"read", "hash", "slow"},
+	HGetAllCommand:      {"read", "hash", "slow"},
+	HIncrByCommand:      {"write", "hash", "fast"},
+	HIncrByFloatCommand: {"write", "hash", "fast"},
+	HScanCommand:        {"read", "hash", "slow"},
+
+	LPushCommand:      {"write", "list", "fast"},
+	RPushCommand:      {"write", "list", "fast"},
+	LPushXCommand:     {"write", "list", "fast"},
+	RPushXCommand:     {"write", "list", "fast"},
+	LPopCommand:       {"write", "list", "fast"},
+	RPopCommand:       {"write", "list", "fast"},
+	LLenCommand:       {"read", "list", "fast"},
+	LIndexCommand:     {"read", "list", "slow"},
+	LSetCommand:       {"write", "list", "slow"},
+	LRangeCommand:     {"read", "list", "slow"},
+	LTrimCommand:      {"write", "list", "slow"},
+	LRemCommand:       {"write", "list", "slow"},
+	LInsertCommand:    {"write", "list", "slow"},
+	LPosCommand:       {"read", "list", "slow"},
+	LMoveCommand:      {"write", "list", "slow"},
+	RPopLPushCommand:  {"write", "list", "slow"},
+	BLPopCommand:      {"write", "list", "slow", "blocking"},
+	BRPopCommand:      {"write", "list", "slow", "blocking"},
+	BLMoveCommand:     {"write", "list", "slow", "blocking"},
+	BRPopLPushCommand: {"write", "list", "slow", "blocking"},
+
+	SAddCommand:        {"write", "set", "fast"},
+	SRemCommand:        {"write", "set", "fast"},
+	SIsMemberCommand:   {"read", "set", "fast"},
+	SMIsMemberCommand:  {"read", "set", "fast"},
+	SCardCommand:       {"read", "set", "fast"},
+	SMembersCommand:    {"read", "set", "slow"},
+	SPopCommand:        {"write", "set", "fast"},
+	SRandMemberCommand: {"read", "set", "slow"},
+	SMoveCommand:       {"write", "set", "fast"},
+	SInterCommand:      {"read", "set", "slow"},
+	SUnionCommand:      {"read", "set", "slow"},
+	SDiffCommand:       {"read", "set", "slow"},
+	SInterStoreCommand: {"write", "set", "slow"},
+	SUnionStoreCommand: {"write", "set", "slow"},
+	SDiffStoreCommand:  {"write", "set", "slow"},
+	SInterCardCommand:  {"read", "set", "slow"},
+	SScanCommand:       {"read", "set", "slow"},
+
+	ZAddCommand:             {"write", "sortedset", "fast"},
+	ZIncrByCommand:          {"write", "sortedset", "fast"},
+	ZRemCommand:             {"write", "sortedset", "fast"},
+	ZScoreCommand:           {"read", "sortedset", "fast"},
+	ZCardCommand:            {"read", "sortedset", "fast"},
+	ZRankCommand:            {"read", "sortedset", "fast"},
+	ZRevRankCommand:         {"read", "sortedset", "fast"},
+	ZRangeCommand:           {"read", "sortedset", "slow"},
+	ZRevRangeCommand:        {"read", "sortedset", "slow"},
+	ZRangeByScoreCommand:    {"read", "sortedset", "slow"},
+	ZRevRangeByScoreCommand: {"read", "sortedset", "slow"},
+	ZRangeByLexCommand:      {"read", "sortedset", "slow"},
+	ZRevRangeByLexCommand:   {"read", "sortedset", "slow"},
+	ZCountCommand:           {"read", "sortedset", "fast"},
+	ZPopMinCommand:          {"write", "sortedset", "fast"},
+	ZPopMaxCommand:          {"write", "sortedset", "fast"},
+	BZPopMinCommand:         {"write", "sortedset", "fast", "blocking"},
+	BZPopMaxCommand:         {"write", "sortedset", "fast", "blocking"},
+	ZUnionStoreCommand:      {"write", "sortedset", "slow"},
+	ZInterStoreCommand:      {"write", "sortedset", "slow"},
+	ZScanCommand:            {"read", "sortedset", "slow"},
+
+	XAddCommand:       {"write", "stream", "fast"},
+	XLenCommand:       {"read", "stream", "fast"},
+	XRangeCommand:     {"read", "stream", "slow"},
+	XRevRangeCommand:  {"read", "stream", "slow"},
+	XDelCommand:       {"write", "stream", "fast"},
+	XTrimCommand:      {"write", "stream", "slow"},
+	XReadCommand:      {"read", "stream", "slow", "blocking"},
+	XGroupCommand:     {"write", "stream", "slow"},
+	XReadGroupCommand: {"write", "stream", "slow", "blocking"},
+	XAckCommand:       {"write", "stream", "fast"},
+	XPendingCommand:   {"read", "stream", "slow"},
+	XClaimCommand:     {"write", "stream", "fast"},
+	XAutoClaimCommand: {"write", "stream", "fast"},
+
+	SubscribeCommand:    {"pubsub", "slow"},
+	UnsubscribeCommand:  {"pubsub", "slow"},
+	PSubscribeCommand:   {"pubsub", "slow"},
+	PUnsubscribeCommand: {"pubsub", "slow"},
+	PublishCommand:      {"pubsub", "fast"},
+	PubSubCommand:       {"pubsub", "slow"},
+
+	MultiCommand:   {"fast", "transaction"},
+	ExecCommand:    {"slow", "transaction"},
+	DiscardCommand: {"fast", "transaction"},
+	WatchCommand:   {"fast", "transaction"},
+	UnwatchCommand: {"fast", "transaction"},
+
+	HelloCommand:                {"fast", "connection"},
+	AuthCommand:                 {"fast", "connection"},
+	ClientCommand:               {"slow", "connection"},
+	ClientCommand + "|KILL":     {"admin", "slow", "dangerous", "connection"},
+	ClientCommand + "|LIST":     {"admin", "slow", "dangerous", "connection"},
+	ClientCommand + "|PAUSE":    {"admin", "slow", "dangerous", "connection"},
+	ClientCommand + "|UNPAUSE":  {"admin", "slow", "dangerous", "connection"},
+	ClientCommand + "|NO-EVICT": {"admin", "slow", "dangerous", "connection"},
+	ACLCommand:                  {"slow"},
+	ACLCommand + "|SETUSER":     {"admin", "slow", "dangerous"},
+	ACLCommand + "|GETUSER":     {"admin", "slow", "dangerous"},
+	ACLCommand + "|DELUSER":     {"admin", "slow", "dangerous"},
+	ACLCommand + "|LIST":        {"admin", "slow", "dangerous"},
+	ACLCommand + "|USERS":       {"admin", "slow", "dangerous"},
+	ACLCommand + "|LOG":         {"admin", "slow", "dangerous"},
+	ACLCommand + "|SAVE":        {"admin", "slow", "dangerous"},
+	ACLCommand + "|LOAD":        {"admin", "slow", "dangerous"},
+}
+
+// NewACL returns the users of a server, the default user alone until configured otherwise.
+func NewACL() *acl.ACL {
+	categories := make(map[string][]string, len(commandCategories))
+	for command, list := range commandCategories {
+		categories[strings.ToLower(command)] = list
+	}
+	return acl.New(categories)
+}
+
+// commandNames are the upper and lower case names of a command.
+type commandNames struct {
+	upper, lower string
+}
+
+// knownCommands holds the names of the commands by upper case name, so that the
+// name of a known command read from a connection is found without allocating.
+var knownCommands = func() map[string]commandNames {
+	names := make(map[string]commandNames, len(commandCategories))
+	for command := range commandCategories {
+		names[command] = commandNames{upper: command, lower: strings.ToLower(command)}
+	}
+	return names
+}()
+
+// lookupCommand returns the names of the command named by arg.
+func lookupCommand(arg []byte) (commandNames, bool) {
+	var buf [24]byte
+	if len(arg) > len(buf) {
+		return commandNames{}, false
+	}
+	upper := buf[:len(arg)]
+	for i, c := range arg {
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper[i] = c
+	}
+	names, ok := knownCommands[string(upper)]
+	return names, ok
+}
+
+// CommandName returns the upper case name of the command named by arg, the
+// first argument of a request as the parser returns it.
+func CommandName(arg []byte) string {
+	if names, ok := lookupCommand(arg); ok {
+		return names.upper
+	}
+	return strings.ToUpper(string(arg))
+}
+
+// commandName returns the name of the command in args the way ACLs and CLIENT
+// LIST refer to it: lowercase, with the subcommand of container commands.
+func commandName(args [][]byte) string {
+	names, ok := lookupCommand(args[0])
+	if !ok {
+		return strings.ToLower(string(args[0]))
+	}
+	if containerCommands[names.upper] && len(args) > 1 {
+		return names.lower + "|" + strings.ToLower(string(args[1]))
+	}
+	return names.lower
+}
+
+/*
+Authorize checks that the client may run the command in args, before the
+server dispatches it and before its arguments are copied out of the parser. Until the client authenticates it may only send AUTH and
+HELLO, afterwards the ACL of its user decides. Commands refused by the ACL are
+recorded for ACL LOG, inMulti telling whether the command was to be queued.
+*/
+func (c *Client) Authorize(args [][]byte, inMulti bool) error {
+	command := CommandName(args[0])
+	if command == AuthCommand {
+		return nil
+	}
+	user, authenticated := c.User()
+	if !authenticated {
+		if command == HelloCommand {
+			return nil
+		}
+		return errNoAuth
+	}
+	// Unknown commands are left for the dispatch to refuse.
+	if _, known := commandCategories[command]; !known {
+		return nil
+	}
+	channels, patterns := CommandChannels(args)
+	err := c.server.ACL.Check(user, commandName(args), CommandKeys(args), channels, patterns)
+	var denied *acl.DeniedError
+	if errors.As(err, &denied) {
+		c.server.ACL.LogDenied(denied, inMulti, c.info())
+	}
+	return err
+}
+
+// authenticate logs the client in as user, or records the failure for ACL LOG.
+func (c *Client) authenticate(user, password string) error {
+	if err := c.server.ACL.Authenticate(user, password); err != nil {
+		c.server.ACL.LogAuthFailure(user, c.info())
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.user, c.authenticated = user, true
+	return nil
+}
+
+// handleAuth serves AUTH [username] password, the username defaulting to default.
+func (c *Client) handleAuth(w protocol.ReplyWriter, parts []string) {
+	var user, password string
+	switch len(parts) {
+	case 2:
+		if c.server.ACL.DefaultNoPass() {
+			util.WriteErr(w, errAuthNoPass)
+			return
+		}
+		user, password = acl.DefaultUser, parts[1]
+	case 3:
+		user, password = parts[1], parts[2]
+	default:
+		util.WriteError(w, "wrong number of arguments for 'AUTH' command")
+		return
+	}
+	if err := c.authenticate(user, password); err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteString(w, "OK")
+}
+
+// handleACL serves ACL SETUSER, GETUSER, DELUSER, LIST, USERS, WHOAMI, CAT, LOG, SAVE and LOAD.
+func (c *Client) handleACL(w protocol.ReplyWriter, parts []string) {
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'ACL' command")
+		return
+	}
+	subcommand := strings.ToUpper(parts[1])
+	wrongArgs := func() {
+		util.WriteError(w, "wrong number of arguments for 'ACL|"+strings.ToLower(subcommand)+"' command")
+	}
+	switch subcommand {
+	case "SETUSER":
+		if len(parts) < 3 {
+			wrongArgs()
+			return
+		}
+		if err := c.server.ACL.SetUser(parts[2], parts[3:]...); err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		util.WriteString(w, "OK")
+	case "GETUSER":
+		if len(parts) != 3 {
+			wrongArgs()
+			return
+		}
+		user, ok := c.server.ACL.User(parts[2])
+		if !ok {
+			w.WriteNull()
+			return
+		}
+		writeUser(w, user)
+	case "DELUSER":
+		if len(parts) < 3 {
+			wrongArgs()
+			return
+		}
+		deleted := 0
+		for _, name := range parts[2:] {
+			ok, err := c.server.ACL.DelUser(name)
+			if err != nil {
+				util.WriteErr(w, err)
+				return
+			}
+			if ok {
+				deleted++
+			}
+		}
+		c.disconnectOrphans()
+		w.WriteInteger(int64(deleted))
+	case "LIST", "USERS":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		lines := c.server.ACL.List()
+		if subcommand == "USERS" {
+			lines = c.server.ACL.Users()
+		}
+		w.WriteArrayHeader(len(lines))
+		for _, line := range lines {
+			w.WriteBulkString(line)
+		}
+	case "WHOAMI":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		user, _ := c.User()
+		w.WriteBulkString(user)
+	case "CAT":
+		if len(parts) > 3 {
+			wrongArgs()
+			return
+		}
+		names := c.server.ACL.Categories()
+		if len(parts) == 3 {
+			var ok bool
+			if names, ok = c.server.ACL.CategoryCommands(strings.ToLower(parts[2])); !ok {
+				util.WriteError(w, "Unknown category '"+parts[2]+"'")
+				return
+			}
+		}
+		w.WriteArrayHeader(len(names))
+		for _, name := range names {
+			w.WriteBulkString(name)
+		}
+	case "LOG":
+		c.aclLog(w, parts[2:])
+	case "SAVE", "LOAD":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		var err error
+		if subcommand == "SAVE" {
+			err = c.server.ACL.Save()
+		} else if err = c.server.ACL.Load(); err == nil {
+			c.disconnectOrphans()
+		}
+		if err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		util.WriteString(w, "OK")
+	default:
+		util.WriteError(w, "unknown subcommand '"+parts[1]+"'. Try ACL HELP.")
+	}
+}
+
+// writeUser writes the reply of ACL GETUSER, a map of the flags, password hashes and permissions of user.
+func writeUser(w protocol.ReplyWriter, user acl.User) {
+	flags := []string{"off"}
+	if user.Enabled {
+		flags[0] = "on"
+	}
+	if user.NoPass {
+		flags = append(flags, "nopass")
+	}
+	keys := make([]string, len(user.Keys))
+	for i, pattern := range user.Keys {
+		keys[i] = "~" + pattern
+	}
+	channels := make([]string, len(user.Channels))
+	for i, pattern := range user.Channels {
+		channels[i] = "&" + pattern
+	}
+
+	w.WriteMapHeader(5)
+	w.WriteBulkString("flags")
+	w.WriteSetHeader(len(flags))
+	for _, flag := range flags {
+		w.WriteBulkString(flag)
+	}
+	w.WriteBulkString("passwords")
+	w.WriteArrayHeader(len(user.Passwords))
+	for _, hash := range user.Passwords {
+		w.WriteBulkString(hash)
+	}
+	w.WriteBulkString("commands")
+	w.WriteBulkString(user.CommandRules())
+	w.WriteBulkString("keys")
+	w.WriteBulkString(strings.Join(keys, " "))
+	w.WriteBulkString("channels")
+	w.WriteBulkString(strings.Join(channels, " "))
+}
+
+// aclLog serves ACL LOG [count | RESET].
+func (c *Client) aclLog(w protocol.ReplyWriter, options []string) {
+	count := 10
+	switch {
+	case len(options) > 1:
+		util.WriteError(w, "wrong number of arguments for 'ACL|log' command")
+		return
+	case len(options) == 1 && strings.EqualFold(options[0], "RESET"):
+		c.server.ACL.ResetLog()
+		util.WriteString(w, "OK")
+		return
+	case len(options) == 1:
+		n, err := strconv.Atoi(options[0])
+		if err != nil || n < 0 {
+			util.WriteErr(w, errACLLogCount)
+			return
+		}
+		count = n
+	}
+	entries := c.server.ACL.Log(count)
+	now := time.Now()
+	w.WriteArrayHeader(len(entries))
+	for _, e := range entries {
+		w.WriteMapHeader(9)
+		w.WriteBulkString("count")
+		w.WriteInteger(int64(e.Count))
+		w.WriteBulkString("reason")
+		w.WriteBulkString(e.Reason)
+		w.WriteBulkString("context")
+		w.WriteBulkString(e.Context)
+		w.WriteBulkString("object")
+		w.WriteBulkString(e.Object)
+		w.WriteBulkString("username")
+		w.WriteBulkString(e.Username)
+		w.WriteBulkString("age-seconds")
+		w.WriteDouble(now.Sub(e.Created).Seconds())
+		w.WriteBulkString("client-info")
+		w.WriteBulkString(e.ClientInfo)
+		w.WriteBulkString("timestamp-created")
+		w.WriteInteger(e.Created.UnixMilli())
+		w.WriteBulkString("timestamp-last-updated")
+		w.WriteInteger(e.Updated.UnixMilli())
+	}
+}
+
+// disconnectOrphans kills the clients authenticated as a user that no longer exists.
+func (c *Client) disconnectOrphans() {
+	for _, client := range c.server.clients.list() {
+		if user, authenticated := client.User(); authenticated {
+			if _, ok := c.server.ACL.User(user); !ok {
+				client.disconnect(c)
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/acl"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const HelloCommand = "HELLO"
+
+var (
+	errNoProto     = errors.New("NOPROTO unsupported protocol version")
+	errProtoNotInt = errors.New("ERR Protocol version is not an integer or out of range")
+	errClientName  = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+)
+
+/*
+Client is a client connection and the protocol it speaks, RESP2 until it
+switches with HELLO.
+
+Replies are buffered until flushed, see protocol.Writer. Until the client
+subscribes they are written to Replies, afterwards to its pubsub.Subscriber
+which then owns the connection, see Subscription.Writer.
+*/
+type Client struct {
+	net.Conn
+	out     *protocol.Writer
+	id      int64
+	created time.Time
+	// version is read by publishers encoding messages for the client.
+	version atomic.Int32
+	server  *Server
+
+	// mu guards what CLIENT LIST reports, read from the connections of other clients.
+	mu              sync.Mutex
+	name            string
+	user            string
+	authenticated   bool
+	command         string
+	lastInteraction time.Time
+	queryBuffer     int
+	outputBuffer    int
+	multi           int
+	subscriptions   int
+	patterns        int
+	noEvict         bool
+	killed          bool
+}
+
+/*
+NewClient registers a client of server connected on conn, Close unregisters
+it. The client is authenticated as the default user when it needs no
+password, otherwise it must AUTH first.
+*/
+func NewClient(conn net.Conn, server *Server) *Client {
+	now := time.Now()
+	c := &Client{
+		Conn:            conn,
+		created:         now,
+		server:          server,
+		user:            acl.DefaultUser,
+		authenticated:   server.ACL.DefaultNoPass(),
+		lastInteraction: now,
+		multi:           -1,
+	}
+	c.version.Store(protocol.RESP2)
+	c.out = protocol.NewWriter(conn, c)
+	server.clients.add(c)
+	server.stats.connections.Add(1)
+	return c
+}
+
+// Close unregisters the client and closes its connection.
+func (c *Client) Close() error {
+	c.server.clients.remove(c)
+	return c.Conn.Close()
+}
+
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+func (c *Client) userName() string {
+	user, _ := c.User()
+	return user
+}
+
+// User returns the user the client runs commands as and whether it authenticated.
+func (c *Client) User() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.user, c.authenticated
+}
+
+/*
+Received records the command in args, which the server is about to serve, for
+CLIENT LIST: queryBuffer is the number of bytes read past it, outputBuffer the
+number of bytes of replies waiting to be sent and multi the number of commands
+queued by MULTI, -1 outside a transaction.
+*/
+func (c *Client) Received(args [][]byte, queryBuffer, outputBuffer, multi int) {
+	command := commandName(args)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.command = command
+	c.lastInteraction = time.Now()
+	c.queryBuffer = queryBuffer
+	c.outputBuffer = outputBuffer
+	c.multi = multi
+}
+
+// Killed reports whether CLIENT KILL disconnected the client. The server stops serving it.
+func (c *Client) Killed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.killed
+}
+
+// setSubscriptions records the number of channels and patterns the client is subscribed to.
+func (c *Client) setSubscriptions(channels, patterns int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions, c.patterns = channels, patterns
+}
+
+// subscribed reports whether the client is in push mode. The caller must hold c.mu.
+func (c *Client) subscribed() bool {
+	return c.subscriptions+c.patterns > 0
+}
+
+// kind returns the type of the client, as the TYPE option of CLIENT LIST and CLIENT KILL names it.
+func (c *Client) kind() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed() {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// validClientName reports whether name can be set with SETNAME: no spaces, newlines or special characters.
+func validClientName(name string) bool {
+	return !strings.ContainsFunc(name, func(r rune) bool { return r <= ' ' || r > '~' })
+}
+
+// Protocol implements protocol.Versioned.
+func (c *Client) Protocol() int {
+	return int(c.version.Load())
+}
+
+// Replies returns the writer replies go to while the client is not subscribed.
+func (c *Client) Replies() *protocol.Writer {
+	return c.out
+}
+
+// Write buffers b, it is how the subscriber of the client sends its messages.
+func (c *Client) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+// Flush sends what was written to the client.
+func (c *Client) Flush() error {
+	return c.out.Flush()
+}
+
+// connectionCommands act on the connection itself, they are served by Client.Handle.
+var connectionCommands = map[string]bool{
+	HelloCommand:  true,
+	ClientCommand: true,
+	AuthCommand:   true,
+	ACLCommand:    true,
+}
+
+// Handle serves the commands acting on the connection itself, HELLO, CLIENT,
+// AUTH and ACL. It reports false when parts is another command.
+func (c *Client) Handle(w protocol.ReplyWriter, parts []string, replManager replication.IManager, clustered bool) bool {
+	switch strings.ToUpper(parts[0]) {
+	case HelloCommand:
+		c.hello(w, parts, replManager, clustered)
+	case ClientCommand:
+		c.handleClient(w, parts)
+	case AuthCommand:
+		c.handleAuth(w, parts)
+	case ACLCommand:
+		c.handleACL(w, parts)
+	default:
+		return false
+	}
+	return true
+}
+
+/*
+hello serves HELLO [protover [AUTH username password] [SETNAME clientname]],
+writing the reply to w in the protocol picked.
+*/
+func (c *Client) hello(w protocol.ReplyWriter, parts []string, replManager replication.IManager, clustered bool) {
+	version := c.Protocol()
+	if len(parts) > 1 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			util.WriteErr(w, errProtoNotInt)
+			return
+		}
+		if n != protocol.RESP2 && n != protocol.RESP3 {
+			util.WriteErr(w, errNoProto)
+			return
+		}
+		version = n
+	}
+	name, setName := "", false
+	var credentials []string
+	for i := 2; i < len(parts); i++ {
+		switch option := strings.ToUpper(parts[i]); {
+		case option == "AUTH" && i+2 < len(parts):
+			credentials = parts[i+1 : i+3]
+			i += 2
+		case option == "SETNAME" && i+1 < len(parts):
+			if !validClientName(parts[i+1]) {
+				util.WriteErr(w, errClientName)
+				return
+			}
+			name, setName = parts[i+1], true
+			i++
+		default:
+			util.WriteError(w, "Syntax error in HELLO option '"+parts[i]+"'")
+			return
+		}
+	}
+	if credentials != nil {
+		if err := c.authenticate(credentials[0], credentials[1]); err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+	} else if _, authenticated := c.User(); !authenticated {
+		util.WriteErr(w, errHelloNoAuth)
+		return
+	}
+	c.version.Store(int32(version))
+	if setName {
+		c.mu.Lock()
+		c.name = name
+		c.mu.Unlock()
+	}
+
+	role, mode := "master", "standalone"
+	if replManager == nil {
+		role = "replica"
+	}
+	if clustered {
+		mode = "cluster"
+	}
+	w.WriteMapHeader(7)
+	w.WriteBulkString("server")
+	w.WriteBulkString("redis")
+	w.WriteBulkString("version")
+	w.WriteBulkString(util.ServerVersion)
+	w.WriteBulkString("proto")
+	w.WriteInteger(int64(version))
+	w.WriteBulkString("id")
+	w.WriteInteger(c.id)
+	w.WriteBulkString("mode")
+	w.WriteBulkString(mode)
+	w.WriteBulkString("role")
+	w.WriteBulkString(role)
+	w.WriteBulkString("modules")
+	w.WriteArrayHeader(0)
+}
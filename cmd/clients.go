@@ -0,0 +1,512 @@
+package cmd
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const ClientCommand = "CLIENT"
+
+var (
+	errNoSuchClient    = errors.New("ERR No such client")
+	errClientID        = errors.New("ERR client-id should be greater than 0")
+	errClientType      = errors.New("ERR Unknown client type")
+	errPauseTimeout    = errors.New("ERR timeout is not an integer or out of range")
+	errNegativeTimeout = errors.New("ERR timeout is negative")
+	errClientSyntax    = errors.New("ERR syntax error")
+)
+
+// writeCommands are the commands CLIENT PAUSE WRITE holds back: every command
+// that may modify the keyspace or be propagated, PUBLISH and EXEC included.
+var writeCommands = map[string]bool{
+	SetCommand: true, DelCommand: true, UnlinkCommand: true, ExpireCommand: true,
+	PExpireCommand: true, ExpireAtCommand: true, PExpireAtCommand: true, PersistCommand: true,
+	FlushDBCommand: true, FlushAllCommand: true, RenameCommand: true, RenameNXCommand: true,
+	CopyCommand: true, IncrCommand: true, DecrCommand: true, IncrByCommand: true,
+	DecrByCommand: true, IncrByFloatCommand: true, AppendCommand: true, SetRangeCommand: true,
+	GetDelCommand: true, GetExCommand: true, GetSetCommand: true, MSetCommand: true,
+	MSetNXCommand: true,
+
+	HSetCommand: true, HSetNXCommand: true, HDelCommand: true, HIncrByCommand: true,
+	HIncrByFloatCommand: true,
+
+	LPushCommand: true, RPushCommand: true, LPushXCommand: true, RPushXCommand: true,
+	LPopCommand: true, RPopCommand: true, LSetCommand: true, LTrimCommand: true,
+	LRemCommand: true, LInsertCommand: true, LMoveCommand: true, RPopLPushCommand: true,
+	BLPopCommand: true, BRPopCommand: true, BLMoveCommand: true, BRPopLPushCommand: true,
+
+	SAddCommand: true, SRemCommand: true, SPopCommand: true, SMoveCommand: true,
+	SInterStoreCommand: true, SUnionStoreCommand: true, SDiffStoreCommand: true,
+
+	ZAddCommand: true, ZIncrByCommand: true, ZRemCommand: true, ZPopMinCommand: true,
+	ZPopMaxCommand: true, BZPopMinCommand: true, BZPopMaxCommand: true,
+	ZUnionStoreCommand: true, ZInterStoreCommand: true,
+
+	XAddCommand: true, XDelCommand: true, XTrimCommand: true, XGroupCommand: true,
+	XReadGroupCommand: true, XAckCommand: true, XClaimCommand: true, XAutoClaimCommand: true,
+
+	PublishCommand: true, ExecCommand: true,
+}
+
+// containerCommands are reported along with their subcommand, client|list for instance.
+var containerCommands = map[string]bool{
+	ClientCommand:  true,
+	ACLCommand:     true,
+	ConfigCommand:  true,
+	PubSubCommand:  true,
+	XGroupCommand:  true,
+	CommandCommand: true,
+}
+
+/*
+Clients is the registry of the connected clients, what CLIENT LIST reports and
+CLIENT KILL searches. It also holds the state of CLIENT PAUSE.
+*/
+type Clients struct {
+	mu      sync.Mutex
+	clients map[int64]*Client
+	// lastID is the ID of the last client registered, IDs are never reused.
+	lastID int64
+	// pausedUntil is the end of the current CLIENT PAUSE, which holds back
+	// every command with pauseAll and only writeCommands otherwise.
+	pausedUntil time.Time
+	pauseAll    bool
+	// unpaused is closed by CLIENT UNPAUSE to release the waiting clients.
+	unpaused chan struct{}
+}
+
+func newClients() *Clients {
+	return &Clients{clients: make(map[int64]*Client)}
+}
+
+// Len returns the number of clients connected, as INFO reports it.
+func (r *Clients) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.clients)
+}
+
+// add gives c the next client ID and registers it.
+func (r *Clients) add(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastID++
+	c.id = r.lastID
+	r.clients[c.id] = c
+}
+
+// clientsInfo is what the clients section of INFO reports.
+type clientsInfo struct {
+	connected int
+	pubsub    int
+	// maxQueryBuffer and maxOutputBuffer are the largest buffers of the
+	// clients, as of their last command.
+	maxQueryBuffer  int
+	maxOutputBuffer int
+}
+
+func (r *Clients) info() clientsInfo {
+	list := r.list()
+	info := clientsInfo{connected: len(list)}
+	for _, c := range list {
+		c.mu.Lock()
+		if c.subscriptions+c.patterns > 0 {
+			info.pubsub++
+		}
+		info.maxQueryBuffer = max(info.maxQueryBuffer, c.queryBuffer)
+		info.maxOutputBuffer = max(info.maxOutputBuffer, c.outputBuffer)
+		c.mu.Unlock()
+	}
+	return info
+}
+
+func (r *Clients) remove(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.id)
+}
+
+// list returns the clients sorted by ID, the order of CLIENT LIST.
+func (r *Clients) list() []*Client {
+	r.mu.Lock()
+	list := make([]*Client, 0, len(r.clients))
+	for _, c := range r.clients {
+		list = append(list, c)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(list, func(a, b *Client) int { return int(a.id - b.id) })
+	return list
+}
+
+// pause replaces any pause in effect by one lasting d.
+func (r *Clients) pause(d time.Duration, all bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pausedUntil = time.Now().Add(d)
+	r.pauseAll = all
+	if r.unpaused == nil {
+		r.unpaused = make(chan struct{})
+	}
+}
+
+func (r *Clients) unpause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pausedUntil = time.Time{}
+	if r.unpaused != nil {
+		close(r.unpaused)
+		r.unpaused = nil
+	}
+}
+
+// paused returns how long a command is still held back and what ends the pause early.
+func (r *Clients) paused(write bool) (time.Duration, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.pauseAll && !write {
+		return 0, nil
+	}
+	return time.Until(r.pausedUntil), r.unpaused
+}
+
+/*
+WaitUnpaused holds the command in parts back while CLIENT PAUSE is in effect
+for it, after sending the replies w buffered. Commands queued by MULTI are not
+held back, their EXEC is. CLIENT itself never waits, so that CLIENT UNPAUSE
+can end the pause.
+*/
+func (c *Client) WaitUnpaused(w protocol.ReplyWriter, parts []string, inMulti bool) {
+	command := strings.ToUpper(parts[0])
+	if command == ClientCommand || (inMulti && command != ExecCommand) {
+		return
+	}
+	for flushed := false; ; flushed = true {
+		wait, unpaused := c.server.clients.paused(writeCommands[command])
+		if wait <= 0 {
+			return
+		}
+		if !flushed {
+			w.Flush()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-unpaused:
+		}
+		timer.Stop()
+	}
+}
+
+// clientFilter is the set of conditions of a CLIENT KILL or CLIENT LIST, every one of them must match.
+type clientFilter struct {
+	ids    []int64
+	addr   string
+	laddr  string
+	user   string
+	kind   string
+	skipMe bool
+}
+
+func (f *clientFilter) match(c, self *Client) bool {
+	switch {
+	case len(f.ids) > 0 && !slices.Contains(f.ids, c.id),
+		f.addr != "" && c.RemoteAddr().String() != f.addr,
+		f.laddr != "" && c.LocalAddr().String() != f.laddr,
+		f.user != "" && f.user != c.userName(),
+		f.kind != "" && f.kind != c.kind(),
+		f.skipMe && c == self:
+		return false
+	}
+	return true
+}
+
+// parseClientType checks a TYPE option. Replicas and masters use their own
+// connections, they are never listed.
+func parseClientType(kind string) (string, error) {
+	switch kind = strings.ToLower(kind); kind {
+	case "normal", "pubsub", "replica", "master":
+		return kind, nil
+	case "slave":
+		return "replica", nil
+	}
+	return "", errClientType
+}
+
+// handleClient serves CLIENT LIST, INFO, ID, SETNAME, GETNAME, KILL, PAUSE, UNPAUSE and NO-EVICT.
+func (c *Client) handleClient(w protocol.ReplyWriter, parts []string) {
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'CLIENT' command")
+		return
+	}
+	subcommand := strings.ToUpper(parts[1])
+	wrongArgs := func() {
+		util.WriteError(w, "wrong number of arguments for 'CLIENT|"+strings.ToLower(subcommand)+"' command")
+	}
+	switch subcommand {
+	case "ID":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		w.WriteInteger(c.id)
+	case "INFO":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		w.WriteVerbatim("txt", c.info()+"\n")
+	case "LIST":
+		c.clientList(w, parts[2:])
+	case "SETNAME":
+		if len(parts) != 3 {
+			wrongArgs()
+			return
+		}
+		if !validClientName(parts[2]) {
+			util.WriteErr(w, errClientName)
+			return
+		}
+		c.mu.Lock()
+		c.name = parts[2]
+		c.mu.Unlock()
+		util.WriteString(w, "OK")
+	case "GETNAME":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		if name := c.Name(); name != "" {
+			w.WriteBulkString(name)
+		} else {
+			w.WriteNull()
+		}
+	case "KILL":
+		if len(parts) < 3 {
+			wrongArgs()
+			return
+		}
+		c.clientKill(w, parts[2:])
+	case "PAUSE":
+		if len(parts) != 3 && len(parts) != 4 {
+			wrongArgs()
+			return
+		}
+		ms, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			util.WriteErr(w, errPauseTimeout)
+			return
+		}
+		if ms < 0 {
+			util.WriteErr(w, errNegativeTimeout)
+			return
+		}
+		all := true
+		if len(parts) == 4 {
+			switch strings.ToUpper(parts[3]) {
+			case "ALL":
+			case "WRITE":
+				all = false
+			default:
+				util.WriteErr(w, errClientSyntax)
+				return
+			}
+		}
+		c.server.clients.pause(time.Duration(ms)*time.Millisecond, all)
+		util.WriteString(w, "OK")
+	case "UNPAUSE":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		c.server.clients.unpause()
+		util.WriteString(w, "OK")
+	case "NO-EVICT":
+		if len(parts) != 3 {
+			wrongArgs()
+			return
+		}
+		switch strings.ToUpper(parts[2]) {
+		case "ON", "OFF":
+			c.mu.Lock()
+			c.noEvict = strings.EqualFold(parts[2], "ON")
+			c.mu.Unlock()
+			util.WriteString(w, "OK")
+		default:
+			util.WriteErr(w, errClientSyntax)
+		}
+	default:
+		util.WriteError(w, "unknown subcommand '"+parts[1]+"'. Try CLIENT HELP.")
+	}
+}
+
+// clientList serves CLIENT LIST [TYPE type] [ID id [id ...]].
+func (c *Client) clientList(w protocol.ReplyWriter, options []string) {
+	var filter clientFilter
+	for i := 0; i < len(options); i++ {
+		switch option := strings.ToUpper(options[i]); {
+		case option == "TYPE" && i+1 < len(options):
+			kind, err := parseClientType(options[i+1])
+			if err != nil {
+				util.WriteError(w, "Unknown client type '"+options[i+1]+"'")
+				return
+			}
+			filter.kind = kind
+			i++
+		case option == "ID" && i+1 < len(options):
+			for i++; i < len(options); i++ {
+				id, err := strconv.ParseInt(options[i], 10, 64)
+				if err != nil || id <= 0 {
+					util.WriteError(w, "Invalid client ID")
+					return
+				}
+				filter.ids = append(filter.ids, id)
+			}
+		default:
+			util.WriteErr(w, errClientSyntax)
+			return
+		}
+	}
+	var b strings.Builder
+	for _, client := range c.server.clients.list() {
+		if filter.match(client, c) {
+			b.WriteString(client.info())
+			b.WriteByte('\n')
+		}
+	}
+	w.WriteVerbatim("txt", b.String())
+}
+
+/*
+clientKill serves CLIENT KILL, either in its old form CLIENT KILL addr, replying OK,
+or with filters, CLIENT KILL [ID id] [ADDR addr] [LADDR addr] [USER username]
+[TYPE type] [SKIPME yes|no], replying with the number of clients killed.
+*/
+func (c *Client) clientKill(w protocol.ReplyWriter, options []string) {
+	if len(options) == 1 {
+		for _, client := range c.server.clients.list() {
+			if client.RemoteAddr().String() == options[0] {
+				client.disconnect(c)
+				util.WriteString(w, "OK")
+				return
+			}
+		}
+		util.WriteErr(w, errNoSuchClient)
+		return
+	}
+	if len(options)%2 != 0 {
+		util.WriteErr(w, errClientSyntax)
+		return
+	}
+	filter := clientFilter{skipMe: true}
+	for i := 0; i < len(options); i += 2 {
+		value := options[i+1]
+		switch strings.ToUpper(options[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				util.WriteErr(w, errClientID)
+				return
+			}
+			filter.ids = append(filter.ids, id)
+		case "ADDR":
+			filter.addr = value
+		case "LADDR":
+			filter.laddr = value
+		case "USER":
+			filter.user = value
+		case "TYPE":
+			kind, err := parseClientType(value)
+			if err != nil {
+				util.WriteError(w, "Unknown client type '"+value+"'")
+				return
+			}
+			filter.kind = kind
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				util.WriteErr(w, errClientSyntax)
+				return
+			}
+		default:
+			util.WriteErr(w, errClientSyntax)
+			return
+		}
+	}
+	killed := 0
+	for _, client := range c.server.clients.list() {
+		if filter.match(client, c) {
+			client.disconnect(c)
+			killed++
+		}
+	}
+	w.WriteInteger(int64(killed))
+}
+
+// disconnect kills the client on behalf of killer. A client killing itself
+// still gets its reply, the server closes the connection afterwards, see Killed.
+func (c *Client) disconnect(killer *Client) {
+	c.mu.Lock()
+	c.killed = true
+	c.mu.Unlock()
+	if c != killer {
+		c.Close()
+	}
+}
+
+// info formats the client the way CLIENT LIST and CLIENT INFO report it.
+func (c *Client) info() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	flags := ""
+	if c.subscribed() {
+		flags += "P"
+	}
+	if c.multi >= 0 {
+		flags += "x"
+	}
+	if c.noEvict {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	fields := []string{
+		"id=" + strconv.FormatInt(c.id, 10),
+		"addr=" + addrString(c.RemoteAddr()),
+		"laddr=" + addrString(c.LocalAddr()),
+		"name=" + c.name,
+		"age=" + strconv.Itoa(int(now.Sub(c.created).Seconds())),
+		"idle=" + strconv.Itoa(int(now.Sub(c.lastInteraction).Seconds())),
+		"flags=" + flags,
+		"db=0",
+		"sub=" + strconv.Itoa(c.subscriptions),
+		"psub=" + strconv.Itoa(c.patterns),
+		"multi=" + strconv.Itoa(c.multi),
+		"qbuf=" + strconv.Itoa(c.queryBuffer),
+		"obl=" + strconv.Itoa(c.outputBuffer),
+		"cmd=" + c.command,
+		"user=" + c.user,
+		"resp=" + strconv.Itoa(c.Protocol()),
+	}
+	return strings.Join(fields, " ")
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
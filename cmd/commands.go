@@ -2,13 +2,12 @@ package cmd
 
 import (
 	"log"
-	"net"
-	"os"
-	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
 	"github.com/PetarGeorgiev-hash/flashdb/replication"
 	internal "github.com/PetarGeorgiev-hash/flashdb/store"
 	"github.com/PetarGeorgiev-hash/flashdb/util"
@@ -18,6 +17,7 @@ const (
 	SetCommand     = "SET"
 	GetCommand     = "GET"
 	DelCommand     = "DEL"
+	UnlinkCommand  = "UNLINK"
 	PingCommand    = "PING"
 	ExistsCommand  = "EXISTS"
 	TTLCommand     = "TTL"
@@ -25,220 +25,330 @@ const (
 	SaveCommand    = "SAVE"
 	InfoCommand    = "INFO"
 	CommandCommand = "COMMAND"
+
+	PTTLCommand        = "PTTL"
+	ExpireTimeCommand  = "EXPIRETIME"
+	PExpireTimeCommand = "PEXPIRETIME"
+	PExpireCommand     = "PEXPIRE"
+	ExpireAtCommand    = "EXPIREAT"
+	PExpireAtCommand   = "PEXPIREAT"
 )
 
-type CommandHandler func(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager)
+type CommandHandler func(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager)
 
 var CommandHandlers = map[string]CommandHandler{
 	SetCommand:     handleSet,
 	GetCommand:     handleGet,
 	DelCommand:     handleDel,
+	UnlinkCommand:  handleDel,
 	PingCommand:    handlePing,
 	ExistsCommand:  handleExists,
 	TTLCommand:     handleTTL,
 	ExpireCommand:  handleExpire,
-	SaveCommand:    handleSave,
-	InfoCommand:    handleInfo,
 	CommandCommand: handleCommand,
-}
 
-func handleSet(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
-	if len(parts) < 3 {
-		util.WriteError(conn, "wrong number of arguments for 'SET' command")
-		return
-	}
-	key := parts[1]
-	value := []byte(parts[2])
-	if len(parts) == 4 {
-		seconds, err := strconv.Atoi(parts[3])
-		if err != nil {
-			util.WriteError(conn, "invalid expire time")
-			return
-		}
-		_, err = store.Set(key, value, time.Duration(seconds)*time.Second)
-		if err != nil {
-			util.WriteError(conn, "failed to set value")
-			return
-		}
+	PTTLCommand:        handleTTL,
+	ExpireTimeCommand:  handleTTL,
+	PExpireTimeCommand: handleTTL,
+	PExpireCommand:     handleExpire,
+	ExpireAtCommand:    handleExpire,
+	PExpireAtCommand:   handleExpire,
 
-		util.WriteString(conn, "OK")
+	IncrCommand:        handleIncr,
+	DecrCommand:        handleIncr,
+	IncrByCommand:      handleIncr,
+	DecrByCommand:      handleIncr,
+	IncrByFloatCommand: handleIncrByFloat,
+	AppendCommand:      handleAppend,
+	StrLenCommand:      handleStrLen,
+	GetRangeCommand:    handleGetRange,
+	SetRangeCommand:    handleSetRange,
+	GetDelCommand:      handleGetDel,
+	GetExCommand:       handleGetEx,
+	GetSetCommand:      handleGetSet,
+	MGetCommand:        handleMGet,
+	MSetCommand:        handleMSet,
+	MSetNXCommand:      handleMSet,
 
-	} else {
-		_, err := store.Set(key, value, 0)
-		if err != nil {
-			util.WriteError(conn, "failed to set value")
-			return
+	DBSizeCommand:    handleDBSize,
+	FlushDBCommand:   handleFlush,
+	FlushAllCommand:  handleFlush,
+	RenameCommand:    handleRename,
+	RenameNXCommand:  handleRename,
+	CopyCommand:      handleCopy,
+	TypeCommand:      handleType,
+	RandomKeyCommand: handleRandomKey,
+	TouchCommand:     handleTouch,
+	PersistCommand:   handlePersist,
+
+	ScanCommand:  handleScan,
+	KeysCommand:  handleKeys,
+	HScanCommand: handleCollectionScan,
+	SScanCommand: handleCollectionScan,
+	ZScanCommand: handleCollectionScan,
+
+	HSetCommand:         handleHSet,
+	HSetNXCommand:       handleHSetNX,
+	HGetCommand:         handleHGet,
+	HMGetCommand:        handleHMGet,
+	HDelCommand:         handleHDel,
+	HLenCommand:         handleHLen,
+	HExistsCommand:      handleHExists,
+	HKeysCommand:        handleHKeys,
+	HValsCommand:        handleHVals,
+	HGetAllCommand:      handleHGetAll,
+	HIncrByCommand:      handleHIncrBy,
+	HIncrByFloatCommand: handleHIncrByFloat,
+
+	LPushCommand:      handlePush,
+	RPushCommand:      handlePush,
+	LPushXCommand:     handlePush,
+	RPushXCommand:     handlePush,
+	LPopCommand:       handlePop,
+	RPopCommand:       handlePop,
+	LLenCommand:       handleLLen,
+	LIndexCommand:     handleLIndex,
+	LSetCommand:       handleLSet,
+	LRangeCommand:     handleLRange,
+	LTrimCommand:      handleLTrim,
+	LRemCommand:       handleLRem,
+	LInsertCommand:    handleLInsert,
+	LPosCommand:       handleLPos,
+	LMoveCommand:      handleLMove,
+	RPopLPushCommand:  handleRPopLPush,
+	BLPopCommand:      handleBlockingPop,
+	BRPopCommand:      handleBlockingPop,
+	BLMoveCommand:     handleBLMove,
+	BRPopLPushCommand: handleBRPopLPush,
+
+	SAddCommand:        handleSAdd,
+	SRemCommand:        handleSRem,
+	SIsMemberCommand:   handleSIsMember,
+	SMIsMemberCommand:  handleSMIsMember,
+	SCardCommand:       handleSCard,
+	SMembersCommand:    handleSMembers,
+	SPopCommand:        handleSPop,
+	SRandMemberCommand: handleSRandMember,
+	SMoveCommand:       handleSMove,
+	SInterCommand:      handleSetAlgebra,
+	SUnionCommand:      handleSetAlgebra,
+	SDiffCommand:       handleSetAlgebra,
+	SInterStoreCommand: handleSetAlgebraStore,
+	SUnionStoreCommand: handleSetAlgebraStore,
+	SDiffStoreCommand:  handleSetAlgebraStore,
+	SInterCardCommand:  handleSInterCard,
+
+	ZAddCommand:             handleZAdd,
+	ZIncrByCommand:          handleZIncrBy,
+	ZRemCommand:             handleZRem,
+	ZScoreCommand:           handleZScore,
+	ZCardCommand:            handleZCard,
+	ZRankCommand:            handleZRank,
+	ZRevRankCommand:         handleZRank,
+	ZRangeCommand:           handleZRange,
+	ZRevRangeCommand:        handleZRange,
+	ZRangeByScoreCommand:    handleZRange,
+	ZRevRangeByScoreCommand: handleZRange,
+	ZRangeByLexCommand:      handleZRange,
+	ZRevRangeByLexCommand:   handleZRange,
+	ZCountCommand:           handleZCount,
+	ZPopMinCommand:          handleZPop,
+	ZPopMaxCommand:          handleZPop,
+	BZPopMinCommand:         handleBZPop,
+	BZPopMaxCommand:         handleBZPop,
+	ZUnionStoreCommand:      handleZStore,
+	ZInterStoreCommand:      handleZStore,
+
+	XAddCommand:       handleXAdd,
+	XLenCommand:       handleXLen,
+	XRangeCommand:     handleXRange,
+	XRevRangeCommand:  handleXRange,
+	XDelCommand:       handleXDel,
+	XTrimCommand:      handleXTrim,
+	XReadCommand:      handleXRead,
+	XGroupCommand:     handleXGroup,
+	XReadGroupCommand: handleXReadGroup,
+	XAckCommand:       handleXAck,
+	XPendingCommand:   handleXPending,
+	XClaimCommand:     handleXClaim,
+	XAutoClaimCommand: handleXAutoClaim,
+}
+
+// propagate records a write command in the AOF and forwards it to the replicas.
+// Both are optional: replicas run without a replication manager.
+func propagate(aofWriter aof.IAOF, replManager replication.IManager, parts ...string) {
+	if aofWriter != nil {
+		if err := aofWriter.AppendCommand(parts...); err != nil {
+			log.Printf("[AOF] failed to append command: %v", err)
 		}
-		log.Println("sending ok")
-		util.WriteString(conn, "OK")
 	}
-	if err := aofWriter.AppendCommand(parts...); err != nil {
-		log.Printf("[AOF] failed to append command: %v", err)
+	if replManager != nil {
+		replManager.Broadcast(parts)
 	}
-	// TODO: check if the command is comming from replica replManager == nil pointer and will crash
-	replManager.Broadcast(parts)
 }
 
-func handleGet(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
-	if len(parts) < 2 {
-		util.WriteError(conn, "wrong number of arguments for 'GET' command")
+/*
+handleSet serves SET key value [NX|XX] [GET] [EX|PX|EXAT|PXAT time|KEEPTTL].
+
+The command is propagated in its resolved form: the expiry as an absolute PXAT,
+and without NX, XX or GET since the condition was already evaluated here.
+*/
+func handleSet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'SET' command")
 		return
 	}
 	key := parts[1]
-	item, err := store.Get(key)
+	opts, err := internal.ParseSetArgs(parts[3:])
 	if err != nil {
-		util.WriteError(conn, "failed to get value")
+		util.WriteErr(w, err)
 		return
 	}
-	if item == nil {
-		conn.Write([]byte("$-1\r\n"))
+	old, written, err := store.SetWithOptions(key, []byte(parts[2]), opts)
+	if err != nil {
+		util.WriteErr(w, err)
 		return
 	}
 
-	conn.Write([]byte("$" + strconv.Itoa(len(item.Value)) + "\r\n"))
-	conn.Write(item.Value)
-	conn.Write([]byte("\r\n"))
-}
-
-func handleDel(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
-	if len(parts) < 2 {
-		util.WriteError(conn, "wrong number of arguments for 'DEL' command")
-		return
+	switch {
+	case opts.Get && old != nil:
+		util.WriteBulk(w, old)
+	case opts.Get || !written:
+		util.WriteNull(w)
+	default:
+		util.WriteString(w, "OK")
 	}
-	key := parts[1]
-	err := store.Delete(key)
-	if err != nil {
-		util.WriteError(conn, "failed to delete key or key mismatch")
-		return
-	}
-	err = aofWriter.AppendCommand(parts...)
-	if err != nil {
-		util.WriteError(conn, "failed to save aof")
+	if !written {
 		return
 	}
-	util.WriteInteger(conn, 1)
-	replManager.Broadcast(parts)
-}
 
-func handlePing(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
-	if len(parts) == 1 {
-		util.WriteString(conn, "PONG")
-	} else {
-		util.WriteString(conn, parts[1])
+	propagated := []string{SetCommand, key, parts[2]}
+	if opts.KeepTTL {
+		propagated = append(propagated, "KEEPTTL")
+	} else if !opts.ExpiresAt.IsZero() {
+		propagated = append(propagated, "PXAT", strconv.FormatInt(opts.ExpiresAt.UnixMilli(), 10))
 	}
+	propagate(aofWriter, replManager, propagated...)
 }
 
-func handleExists(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+func handleGet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
 	if len(parts) < 2 {
-		util.WriteError(conn, "wrong number of arguments for 'EXISTS' command")
+		util.WriteError(w, "wrong number of arguments for 'GET' command")
 		return
 	}
 	key := parts[1]
 	item, err := store.Get(key)
 	if err != nil {
-		util.WriteError(conn, "failed to get value")
+		util.WriteError(w, "failed to get value")
 		return
 	}
 	if item == nil {
-		util.WriteInteger(conn, 0)
+		util.WriteNull(w)
+		return
+	}
+	if item.Type != internal.TypeString {
+		util.WriteErr(w, internal.ErrWrongType)
 		return
 	}
-	util.WriteInteger(conn, 1)
+	util.WriteBulk(w, item.Value)
 }
 
-func handleTTL(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+// handleDel serves DEL and UNLINK. Values are released by the garbage collector
+// either way, so UNLINK is only an alias.
+func handleDel(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
 	if len(parts) < 2 {
-		util.WriteError(conn, "wrong number of arguments for 'TTL' command")
+		util.WriteError(w, "wrong number of arguments for '"+strings.ToUpper(parts[0])+"' command")
 		return
 	}
-	key := parts[1]
-	item, err := store.Get(key)
-	if err != nil {
-		util.WriteError(conn, "failed to get value")
-		return
-	}
-	if item == nil {
-		util.WriteInteger(conn, -2) // Key does not exist
-		return
+	deleted := store.Del(parts[1:])
+	util.WriteInteger(w, deleted)
+	if deleted > 0 {
+		propagate(aofWriter, replManager, append([]string{DelCommand}, parts[1:]...)...)
 	}
+}
 
-	if item.ExpiresAt.IsZero() {
-		util.WriteInteger(conn, -1) // Key exists but has no expiration
-		return
-	}
-	ttl := int(time.Until(item.ExpiresAt).Seconds())
-	if ttl < 0 {
-		util.WriteInteger(conn, -2) // Key has expired
-		return
+func handlePing(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) == 1 {
+		util.WriteString(w, "PONG")
+	} else {
+		util.WriteString(w, parts[1])
 	}
-	util.WriteInteger(conn, ttl) // Return TTL in seconds
-
 }
 
-func handleExpire(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
-	if len(parts) < 3 {
-		util.WriteError(conn, "wrong number of arguments for 'EXPIRE' command")
+func handleExists(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'EXISTS' command")
 		return
 	}
+	util.WriteInteger(w, store.Exists(parts[1:]))
+}
 
-	key := parts[1]
-	seconds, err := strconv.ParseInt(parts[2], 0, 64)
-	if err != nil {
-		util.WriteError(conn, "invalid seconds") // Invalid seconds
+// handleTTL serves TTL, PTTL, EXPIRETIME and PEXPIRETIME: -2 when the key does
+// not exist, -1 when it has no TTL.
+func handleTTL(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
 		return
 	}
-
-	item, err := store.Get(key)
-	if err != nil {
-		util.WriteError(conn, "failed to get value")
+	at, exists := store.ExpireTime(parts[1])
+	if !exists {
+		util.WriteInteger(w, -2)
 		return
 	}
-	if item == nil {
-		util.WriteInteger(conn, 0) // Key does not exist
+	if at.IsZero() {
+		util.WriteInteger(w, -1)
 		return
 	}
-	item.ExpiresAt = time.Now().Add(time.Duration(seconds) * time.Second)
-	err = aofWriter.AppendCommand(parts...)
-	if err != nil {
-		util.WriteError(conn, "failed to save aof")
-		return
+	ms := max(time.Until(at).Milliseconds(), 0)
+	switch command {
+	case TTLCommand:
+		// Rounded like Redis, so a fresh EXPIRE key 10 reads back 10.
+		util.WriteInteger(w, int((ms+500)/1000))
+	case PTTLCommand:
+		util.WriteInteger(w, int(ms))
+	case ExpireTimeCommand:
+		util.WriteInteger(w, int(at.Unix()))
+	case PExpireTimeCommand:
+		util.WriteInteger(w, int(at.UnixMilli()))
 	}
-	util.WriteInteger(conn, 1) // Expiration set successfully
-	replManager.Broadcast(parts)
 }
 
-func handleSave(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
-	err := store.Save(util.FileName)
+/*
+handleExpire serves EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT key time [NX|XX|GT|LT].
+
+Whatever the form, the expiry is propagated as PEXPIREAT with the resolved
+timestamp, so replaying the AOF later or on a lagging replica gives the same
+expiry. A time in the past deletes the key and replays the same way.
+*/
+func handleExpire(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	n, err := strconv.ParseInt(parts[2], 10, 64)
 	if err != nil {
-		util.WriteError(conn, "failed to save data to disk"+err.Error())
+		util.WriteErr(w, internal.ErrNotInt)
 		return
 	}
-	err = aofWriter.Reset()
+	opts, err := internal.ParseExpireOptions(parts[3:])
 	if err != nil {
-		util.WriteError(conn, "failed to reset the aof file"+err.Error())
+		util.WriteErr(w, err)
+		return
+	}
+	ms, ok := internal.ExpireAtMillis(n, command == ExpireCommand || command == ExpireAtCommand, command == ExpireCommand || command == PExpireCommand)
+	if !ok {
+		util.WriteError(w, "invalid expire time in '"+strings.ToLower(command)+"' command")
+		return
+	}
+	set := store.Expire(parts[1], time.UnixMilli(ms), opts)
+	writeBool(w, set)
+	if set {
+		propagate(aofWriter, replManager, PExpireAtCommand, parts[1], strconv.FormatInt(ms, 10))
 	}
-	util.WriteString(conn, "OK")
-}
-
-func handleInfo(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
-	// Simulate Redis INFO output (just minimal subset)
-	uptime := int(time.Since(util.StartTime).Seconds())
-	info := "# Server\r\n" +
-		"redis_version:0.0.1-flashdb\r\n" +
-		"uptime_in_seconds:" + strconv.Itoa(uptime) + "\r\n" +
-		"arch_bits:64\r\n" +
-		"process_id:" + strconv.Itoa(os.Getpid()) + "\r\n" +
-		"go_version:" + runtime.Version() + "\r\n" +
-		"# Memory\r\n" +
-		"mem_allocator:golang\r\n" +
-		"# FlashDB\r\n" +
-		"store_backend:in-memory\r\n"
-
-	conn.Write([]byte("$" + strconv.Itoa(len(info)) + "\r\n"))
-	conn.Write([]byte(info))
-	conn.Write([]byte("\r\n"))
 }
 
-func handleCommand(conn net.Conn, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
-	conn.Write([]byte("*0\r\n"))
+func handleCommand(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	w.WriteArrayHeader(0)
 }
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	HSetCommand         = "HSET"
+	HSetNXCommand       = "HSETNX"
+	HGetCommand         = "HGET"
+	HMGetCommand        = "HMGET"
+	HDelCommand         = "HDEL"
+	HLenCommand         = "HLEN"
+	HExistsCommand      = "HEXISTS"
+	HKeysCommand        = "HKEYS"
+	HValsCommand        = "HVALS"
+	HGetAllCommand      = "HGETALL"
+	HIncrByCommand      = "HINCRBY"
+	HIncrByFloatCommand = "HINCRBYFLOAT"
+)
+
+func handleHSet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 4 || len(parts)%2 != 0 {
+		util.WriteError(w, "wrong number of arguments for 'HSET' command")
+		return
+	}
+	fields := make(map[string][]byte, (len(parts)-2)/2)
+	for i := 2; i < len(parts); i += 2 {
+		fields[parts[i]] = []byte(parts[i+1])
+	}
+	added, err := store.HSet(parts[1], fields)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, added)
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleHSetNX(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'HSETNX' command")
+		return
+	}
+	set, err := store.HSetNX(parts[1], parts[2], []byte(parts[3]))
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if !set {
+		util.WriteInteger(w, 0)
+		return
+	}
+	util.WriteInteger(w, 1)
+	propagate(aofWriter, replManager, "HSET", parts[1], parts[2], parts[3])
+}
+
+func handleHGet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'HGET' command")
+		return
+	}
+	value, err := store.HGet(parts[1], parts[2])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if value == nil {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, value)
+}
+
+func handleHMGet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'HMGET' command")
+		return
+	}
+	values, err := store.HMGet(parts[1], parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteBulkArray(w, values)
+}
+
+func handleHDel(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'HDEL' command")
+		return
+	}
+	removed, err := store.HDel(parts[1], parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, removed)
+	if removed > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleHLen(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'HLEN' command")
+		return
+	}
+	n, err := store.HLen(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+}
+
+func handleHExists(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'HEXISTS' command")
+		return
+	}
+	exists, err := store.HExists(parts[1], parts[2])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if exists {
+		util.WriteInteger(w, 1)
+		return
+	}
+	util.WriteInteger(w, 0)
+}
+
+func handleHKeys(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'HKEYS' command")
+		return
+	}
+	keys, err := store.HKeys(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	items := make([][]byte, len(keys))
+	for i, key := range keys {
+		items[i] = []byte(key)
+	}
+	util.WriteBulkArray(w, items)
+}
+
+func handleHVals(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'HVALS' command")
+		return
+	}
+	values, err := store.HVals(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteBulkArray(w, values)
+}
+
+func handleHGetAll(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'HGETALL' command")
+		return
+	}
+	hash, err := store.HGetAll(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	items := make([][]byte, 0, len(hash)*2)
+	for field, value := range hash {
+		items = append(items, []byte(field), value)
+	}
+	util.WriteBulkMap(w, items)
+}
+
+func handleHIncrBy(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'HINCRBY' command")
+		return
+	}
+	delta, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	value, err := store.HIncrBy(parts[1], parts[2], delta)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, int(value))
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleHIncrByFloat(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'HINCRBYFLOAT' command")
+		return
+	}
+	delta, err := strconv.ParseFloat(parts[3], 64)
+	if err != nil {
+		util.WriteErr(w, internal.ErrNotFloat)
+		return
+	}
+	value, err := store.HIncrByFloat(parts[1], parts[2], delta)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteBulk(w, value)
+	// Propagate the result rather than the increment so replay is not subject to float rounding.
+	propagate(aofWriter, replManager, "HSET", parts[1], parts[2], string(value))
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+// info builds the reply of INFO, one "name:value" line per field.
+type info struct {
+	strings.Builder
+}
+
+func (i *info) field(name string, value any) {
+	fmt.Fprintf(i, "%s:%v\r\n", name, value)
+}
+
+// infoSection is a section of INFO, written by write.
+type infoSection struct {
+	name string
+	// byDefault tells whether INFO without argument reports the section.
+	byDefault bool
+	write     func(s *Server, i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager)
+}
+
+// infoSections lists the sections of INFO in the order they are reported.
+var infoSections = []infoSection{
+	{"Server", true, (*Server).infoServer},
+	{"Clients", true, (*Server).infoClients},
+	{"Memory", true, (*Server).infoMemory},
+	{"Persistence", true, (*Server).infoPersistence},
+	{"Stats", true, (*Server).infoStats},
+	{"Replication", true, (*Server).infoReplication},
+	{"Keyspace", true, (*Server).infoKeyspace},
+	{"Commandstats", false, (*Server).infoCommandStats},
+}
+
+/*
+handleInfo serves INFO [section ...]. Like Redis, sections are named case
+insensitively, "default" or no section gives every section but commandstats,
+and "all" or "everything" gives them all.
+*/
+func (s *Server) handleInfo(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	requested := make(map[string]bool)
+	for _, name := range parts[1:] {
+		requested[strings.ToLower(name)] = true
+	}
+	all := requested["all"] || requested["everything"]
+	byDefault := len(parts) == 1 || requested["default"]
+
+	var i info
+	for _, section := range infoSections {
+		if !all && !requested[strings.ToLower(section.name)] && !(byDefault && section.byDefault) {
+			continue
+		}
+		if i.Len() > 0 {
+			i.WriteString("\r\n")
+		}
+		i.WriteString("# " + section.name + "\r\n")
+		section.write(s, &i, store, aofWriter, replManager)
+	}
+	w.WriteVerbatim("txt", i.String())
+}
+
+func (s *Server) infoServer(i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	uptime := time.Since(s.started)
+	i.field("redis_version", util.ServerVersion)
+	i.field("os", runtime.GOOS+" "+runtime.GOARCH)
+	i.field("arch_bits", strconv.IntSize)
+	i.field("go_version", runtime.Version())
+	i.field("process_id", os.Getpid())
+	i.field("tcp_port", port(s.Config.Current().Addr))
+	i.field("server_time_usec", time.Now().UnixMicro())
+	i.field("uptime_in_seconds", int64(uptime.Seconds()))
+	i.field("uptime_in_days", int64(uptime.Hours()/24))
+	if executable, err := os.Executable(); err == nil {
+		i.field("executable", executable)
+	}
+	i.field("config_file", s.Config.File())
+}
+
+func (s *Server) infoClients(i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	clients := s.clients.info()
+	i.field("connected_clients", clients.connected)
+	i.field("pubsub_clients", clients.pubsub)
+	i.field("client_recent_max_input_buffer", clients.maxQueryBuffer)
+	i.field("client_recent_max_output_buffer", clients.maxOutputBuffer)
+}
+
+// infoMemory reports the memory of the process, as the Go runtime sees it, and
+// the memory of the data set, which maxmemory applies to.
+func (s *Server) infoMemory(i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	var runtimeStats runtime.MemStats
+	runtime.ReadMemStats(&runtimeStats)
+	memory := store.MemoryStats()
+	rss := int64(runtimeStats.Sys - runtimeStats.HeapReleased)
+	i.field("used_memory", runtimeStats.HeapAlloc)
+	i.field("used_memory_human", humanBytes(int64(runtimeStats.HeapAlloc)))
+	i.field("used_memory_rss", rss)
+	i.field("used_memory_rss_human", humanBytes(rss))
+	i.field("used_memory_dataset", memory.UsedMemory)
+	i.field("used_memory_dataset_human", humanBytes(memory.UsedMemory))
+	i.field("maxmemory", memory.MaxMemory)
+	i.field("maxmemory_human", humanBytes(memory.MaxMemory))
+	i.field("maxmemory_policy", memory.Policy)
+	i.field("mem_allocator", "go")
+	i.field("mem_heap_objects", runtimeStats.HeapObjects)
+	i.field("mem_gc_cycles", runtimeStats.NumGC)
+	i.field("mem_gc_pause_total_usec", runtimeStats.PauseTotalNs/uint64(time.Microsecond))
+}
+
+func (s *Server) infoPersistence(i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	cfg := s.Config.Current()
+	lastSave, lastSaveOK, saves := s.stats.lastSaved()
+	status := "ok"
+	if !lastSaveOK {
+		status = "err"
+	}
+	i.field("loading", 0)
+	i.field("rdb_changes_since_last_save", s.stats.changes.Load())
+	i.field("rdb_saves", saves)
+	i.field("rdb_last_save_time", lastSave.Unix())
+	i.field("rdb_last_bgsave_status", status)
+	i.field("rdb_autosave_interval_sec", int64(cfg.AutosaveInterval.Seconds()))
+	if aofWriter == nil {
+		i.field("aof_enabled", 0)
+		return
+	}
+	i.field("aof_enabled", 1)
+	var size int64
+	if file, err := os.Stat(cfg.AppendFilename); err == nil {
+		size = file.Size()
+	}
+	i.field("aof_current_size", size)
+}
+
+func (s *Server) infoStats(i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	keyspace := store.Stats()
+	i.field("total_connections_received", s.stats.connections.Load())
+	i.field("total_commands_processed", s.stats.commands.Load())
+	i.field("instantaneous_ops_per_sec", s.stats.opsPerSec(time.Now()))
+	i.field("keyspace_hits", keyspace.KeyspaceHits)
+	i.field("keyspace_misses", keyspace.KeyspaceMisses)
+	i.field("expired_keys", keyspace.ExpiredKeys)
+	i.field("evicted_keys", store.MemoryStats().EvictedKeys)
+}
+
+// infoReplication uses the names of Redis, where a replica is a "slave".
+func (s *Server) infoReplication(i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	cfg := s.Config.Current()
+	if cfg.Role == "replica" {
+		host, masterPort, _ := net.SplitHostPort(cfg.MasterAddr)
+		status, lastIO, offset := "down", int64(-1), int64(0)
+		if s.Replica != nil {
+			if s.Replica.Connected() {
+				status = "up"
+			}
+			if at := s.Replica.LastIO(); !at.IsZero() {
+				lastIO = int64(time.Since(at).Seconds())
+			}
+			offset = s.Replica.Offset()
+		}
+		i.field("role", "slave")
+		i.field("master_host", host)
+		i.field("master_port", masterPort)
+		i.field("master_link_status", status)
+		i.field("master_last_io_seconds_ago", lastIO)
+		i.field("slave_repl_offset", offset)
+		i.field("connected_slaves", 0)
+		i.field("master_repl_offset", offset)
+		return
+	}
+	var repl replication.Info
+	if replManager != nil {
+		repl = replManager.Info()
+	}
+	i.field("role", "master")
+	i.field("connected_slaves", len(repl.Replicas))
+	for n, addr := range repl.Replicas {
+		host, replicaPort, _ := net.SplitHostPort(addr.String())
+		i.field("slave"+strconv.Itoa(n), "ip="+host+",port="+replicaPort+",state=online")
+	}
+	i.field("master_repl_offset", repl.Offset)
+}
+
+func (s *Server) infoKeyspace(i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	// Like Redis, databases without keys are left out.
+	if stats := store.Stats(); stats.Keys > 0 {
+		i.field("db0", "keys="+strconv.Itoa(stats.Keys)+",expires="+strconv.Itoa(stats.Expires))
+	}
+}
+
+func (s *Server) infoCommandStats(i *info, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	for _, command := range s.stats.commandStats() {
+		perCall := float64(command.usec) / float64(command.calls)
+		i.field("cmdstat_"+command.name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f", command.calls, command.usec, perCall))
+	}
+}
+
+// port returns the port of the listen address addr, e.g. 6379 for ":6379".
+func port(addr string) string {
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return p
+}
+
+// humanBytes formats n the way the *_human fields of Redis do, e.g. 1.50M.
+func humanBytes(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value, unit := float64(n), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + units[unit]
+}
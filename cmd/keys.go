@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec locates the keys of a command: every step-th argument from first to
+// last, a negative last counting from the end of the command.
+type keySpec struct {
+	first, last, step int
+}
+
+var (
+	noKeys      = keySpec{}
+	allKeys     = keySpec{1, -1, 1}
+	pairKeys    = keySpec{1, -1, 2}
+	blockedKeys = keySpec{1, -2, 1}
+	twoKeys     = keySpec{1, 2, 1}
+)
+
+// commandKeySpecs lists the commands whose keys are not just parts[1].
+var commandKeySpecs = map[string]keySpec{
+	PingCommand:    noKeys,
+	SaveCommand:    noKeys,
+	InfoCommand:    noKeys,
+	ConfigCommand:  noKeys,
+	CommandCommand: noKeys,
+	ScanCommand:    noKeys,
+	KeysCommand:    noKeys,
+
+	DBSizeCommand:    noKeys,
+	FlushDBCommand:   noKeys,
+	FlushAllCommand:  noKeys,
+	RandomKeyCommand: noKeys,
+	RenameCommand:    twoKeys,
+	RenameNXCommand:  twoKeys,
+	CopyCommand:      twoKeys,
+	TouchCommand:     allKeys,
+
+	WatchCommand: allKeys,
+
+	HelloCommand:  noKeys,
+	ClientCommand: noKeys,
+	AuthCommand:   noKeys,
+	ACLCommand:    noKeys,
+
+	SubscribeCommand:    noKeys,
+	UnsubscribeCommand:  noKeys,
+	PSubscribeCommand:   noKeys,
+	PUnsubscribeCommand: noKeys,
+	PublishCommand:      noKeys,
+	PubSubCommand:       noKeys,
+
+	DelCommand:    allKeys,
+	UnlinkCommand: allKeys,
+	ExistsCommand: allKeys,
+	MGetCommand:   allKeys,
+	MSetCommand:   pairKeys,
+	MSetNXCommand: pairKeys,
+
+	LMoveCommand:      twoKeys,
+	RPopLPushCommand:  twoKeys,
+	BLMoveCommand:     twoKeys,
+	BRPopLPushCommand: twoKeys,
+	BLPopCommand:      blockedKeys,
+	BRPopCommand:      blockedKeys,
+
+	SMoveCommand:       twoKeys,
+	SInterCommand:      allKeys,
+	SUnionCommand:      allKeys,
+	SDiffCommand:       allKeys,
+	SInterStoreCommand: allKeys,
+	SUnionStoreCommand: allKeys,
+	SDiffStoreCommand:  allKeys,
+
+	BZPopMinCommand: blockedKeys,
+	BZPopMaxCommand: blockedKeys,
+}
+
+/*
+CommandKeys returns the keys a command touches, used by the server to route
+commands in cluster mode and to check ACLs. Commands with a numkeys argument and
+the XREAD family are parsed explicitly, the others are described by
+commandKeySpecs or default to a single key in args[1]. The keys are slices of
+args, taken before the arguments are copied out of the parser.
+*/
+func CommandKeys(args [][]byte) [][]byte {
+	if len(args) < 2 {
+		return nil
+	}
+	command := CommandName(args[0])
+	switch command {
+	case SInterCardCommand:
+		return numKeys(args, 1)
+	case ZUnionStoreCommand, ZInterStoreCommand:
+		return append([][]byte{args[1]}, numKeys(args, 2)...)
+	case XGroupCommand:
+		if len(args) < 3 {
+			return nil
+		}
+		return args[2:3]
+	case XReadCommand, XReadGroupCommand:
+		for i, arg := range args {
+			if strings.EqualFold(string(arg), "STREAMS") {
+				streams := args[i+1:]
+				return streams[:len(streams)/2]
+			}
+		}
+		return nil
+	}
+
+	spec, ok := commandKeySpecs[command]
+	if !ok {
+		return args[1:2]
+	}
+	if spec.step == 0 {
+		return nil
+	}
+	last := spec.last
+	if last < 0 {
+		last += len(args)
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	if last < spec.first {
+		return nil
+	}
+	keys := make([][]byte, 0, (last-spec.first)/spec.step+1)
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// numKeys returns the keys following the numkeys argument found at args[index].
+func numKeys(args [][]byte, index int) [][]byte {
+	if index >= len(args) {
+		return nil
+	}
+	n, err := strconv.Atoi(string(args[index]))
+	if err != nil || n < 0 || index+1+n > len(args) {
+		return nil
+	}
+	return args[index+1 : index+1+n]
+}
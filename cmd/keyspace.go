@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	DBSizeCommand    = "DBSIZE"
+	FlushDBCommand   = "FLUSHDB"
+	FlushAllCommand  = "FLUSHALL"
+	RenameCommand    = "RENAME"
+	RenameNXCommand  = "RENAMENX"
+	CopyCommand      = "COPY"
+	TypeCommand      = "TYPE"
+	RandomKeyCommand = "RANDOMKEY"
+	TouchCommand     = "TOUCH"
+	PersistCommand   = "PERSIST"
+)
+
+func handleDBSize(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 1 {
+		util.WriteError(w, "wrong number of arguments for 'DBSIZE' command")
+		return
+	}
+	util.WriteInteger(w, store.DBSize())
+}
+
+/*
+handleFlush serves FLUSHDB and FLUSHALL [ASYNC|SYNC], which are the same thing
+with a single database. Flushing only swaps the shard maps, so ASYNC and SYNC
+both return at once and leave the freeing to the garbage collector.
+*/
+func handleFlush(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) > 2 {
+		util.WriteError(w, "wrong number of arguments for '"+strings.ToUpper(parts[0])+"' command")
+		return
+	}
+	if len(parts) == 2 && !strings.EqualFold(parts[1], "ASYNC") && !strings.EqualFold(parts[1], "SYNC") {
+		util.WriteErr(w, internal.ErrSyntax)
+		return
+	}
+	store.Flush()
+	util.WriteString(w, "OK")
+	propagate(aofWriter, replManager, FlushAllCommand)
+}
+
+// handleRename serves RENAME and RENAMENX.
+func handleRename(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	nx := command == RenameNXCommand
+	renamed, err := store.Rename(parts[1], parts[2], nx)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if nx {
+		writeBool(w, renamed)
+	} else {
+		util.WriteString(w, "OK")
+	}
+	if renamed {
+		propagate(aofWriter, replManager, command, parts[1], parts[2])
+	}
+}
+
+// handleCopy serves COPY source destination [DB 0] [REPLACE]. Only database 0 exists.
+func handleCopy(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'COPY' command")
+		return
+	}
+	replace := false
+	for i := 3; i < len(parts); i++ {
+		switch strings.ToUpper(parts[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(parts) {
+				util.WriteErr(w, internal.ErrSyntax)
+				return
+			}
+			i++
+			db, err := strconv.Atoi(parts[i])
+			if err != nil {
+				util.WriteErr(w, internal.ErrNotInt)
+				return
+			}
+			if db != 0 {
+				util.WriteError(w, "DB index is out of range")
+				return
+			}
+		default:
+			util.WriteErr(w, internal.ErrSyntax)
+			return
+		}
+	}
+	copied, err := store.Copy(parts[1], parts[2], replace)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	writeBool(w, copied)
+	if copied {
+		args := []string{CopyCommand, parts[1], parts[2]}
+		if replace {
+			args = append(args, "REPLACE")
+		}
+		propagate(aofWriter, replManager, args...)
+	}
+}
+
+func handleType(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'TYPE' command")
+		return
+	}
+	util.WriteString(w, store.Type(parts[1]))
+}
+
+func handleRandomKey(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 1 {
+		util.WriteError(w, "wrong number of arguments for 'RANDOMKEY' command")
+		return
+	}
+	key, ok := store.RandomKey()
+	if !ok {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, []byte(key))
+}
+
+// handleTouch serves TOUCH key [key ...]. It changes no data but is propagated
+// like the writes, so that replicas see the same accesses and evict alike.
+func handleTouch(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'TOUCH' command")
+		return
+	}
+	n := store.Touch(parts[1:])
+	util.WriteInteger(w, n)
+	if n > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handlePersist(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'PERSIST' command")
+		return
+	}
+	persisted := store.Persist(parts[1])
+	writeBool(w, persisted)
+	if persisted {
+		propagate(aofWriter, replManager, PersistCommand, parts[1])
+	}
+}
+
+// writeBool writes the 1 or 0 integer reply of commands answering yes or no.
+func writeBool(w protocol.ReplyWriter, b bool) {
+	if b {
+		util.WriteInteger(w, 1)
+		return
+	}
+	util.WriteInteger(w, 0)
+}
@@ -0,0 +1,473 @@
+package cmd
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	LPushCommand      = "LPUSH"
+	RPushCommand      = "RPUSH"
+	LPushXCommand     = "LPUSHX"
+	RPushXCommand     = "RPUSHX"
+	LPopCommand       = "LPOP"
+	RPopCommand       = "RPOP"
+	LLenCommand       = "LLEN"
+	LIndexCommand     = "LINDEX"
+	LSetCommand       = "LSET"
+	LRangeCommand     = "LRANGE"
+	LTrimCommand      = "LTRIM"
+	LRemCommand       = "LREM"
+	LInsertCommand    = "LINSERT"
+	LPosCommand       = "LPOS"
+	LMoveCommand      = "LMOVE"
+	RPopLPushCommand  = "RPOPLPUSH"
+	BLPopCommand      = "BLPOP"
+	BRPopCommand      = "BRPOP"
+	BLMoveCommand     = "BLMOVE"
+	BRPopLPushCommand = "BRPOPLPUSH"
+)
+
+var (
+	errTimeoutInvalid = errors.New("ERR timeout is not a float or out of range")
+	errTimeoutNeg     = errors.New("ERR timeout is negative")
+)
+
+// parseTimeout parses the timeout of a blocking command, given in seconds with
+// an optional fractional part. Zero means block forever.
+func parseTimeout(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errTimeoutInvalid
+	}
+	if seconds < 0 {
+		return 0, errTimeoutNeg
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parseDirection parses a LEFT/RIGHT argument, reporting true for LEFT.
+func parseDirection(s string) (bool, error) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	}
+	return false, internal.ErrSyntax
+}
+
+func toBytes(values []string) [][]byte {
+	out := make([][]byte, len(values))
+	for i, v := range values {
+		out[i] = []byte(v)
+	}
+	return out
+}
+
+func handlePush(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	values := toBytes(parts[2:])
+	var (
+		n   int
+		err error
+	)
+	switch command {
+	case LPushCommand:
+		n, err = store.LPush(parts[1], values)
+	case RPushCommand:
+		n, err = store.RPush(parts[1], values)
+	case LPushXCommand:
+		n, err = store.LPushX(parts[1], values)
+	case RPushXCommand:
+		n, err = store.RPushX(parts[1], values)
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+	if n > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handlePop(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 2 || len(parts) > 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	count := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 0 {
+			util.WriteError(w, "value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+
+	var (
+		values [][]byte
+		err    error
+	)
+	if command == LPopCommand {
+		values, err = store.LPop(parts[1], count)
+	} else {
+		values, err = store.RPop(parts[1], count)
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+
+	if len(parts) == 3 {
+		if values == nil {
+			util.WriteNullArray(w)
+			return
+		}
+		util.WriteBulkArray(w, values)
+	} else {
+		if len(values) == 0 {
+			util.WriteNull(w)
+			return
+		}
+		util.WriteBulk(w, values[0])
+	}
+	if len(values) > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleLLen(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'LLEN' command")
+		return
+	}
+	n, err := store.LLen(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+}
+
+func handleLIndex(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'LINDEX' command")
+		return
+	}
+	index, err := strconv.Atoi(parts[2])
+	if err != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	value, err := store.LIndex(parts[1], index)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if value == nil {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, value)
+}
+
+func handleLSet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'LSET' command")
+		return
+	}
+	index, err := strconv.Atoi(parts[2])
+	if err != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	if err := store.LSet(parts[1], index, []byte(parts[3])); err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteString(w, "OK")
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleLRange(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'LRANGE' command")
+		return
+	}
+	start, err1 := strconv.Atoi(parts[2])
+	stop, err2 := strconv.Atoi(parts[3])
+	if err1 != nil || err2 != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	values, err := store.LRange(parts[1], start, stop)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteBulkArray(w, values)
+}
+
+func handleLTrim(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'LTRIM' command")
+		return
+	}
+	start, err1 := strconv.Atoi(parts[2])
+	stop, err2 := strconv.Atoi(parts[3])
+	if err1 != nil || err2 != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	if err := store.LTrim(parts[1], start, stop); err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteString(w, "OK")
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleLRem(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'LREM' command")
+		return
+	}
+	count, err := strconv.Atoi(parts[2])
+	if err != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	removed, err := store.LRem(parts[1], count, []byte(parts[3]))
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, removed)
+	if removed > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleLInsert(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 5 {
+		util.WriteError(w, "wrong number of arguments for 'LINSERT' command")
+		return
+	}
+	var before bool
+	switch strings.ToUpper(parts[2]) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+	default:
+		util.WriteErr(w, internal.ErrSyntax)
+		return
+	}
+	n, err := store.LInsert(parts[1], before, []byte(parts[3]), []byte(parts[4]))
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+	if n > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleLPos(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 || len(parts)%2 == 0 {
+		util.WriteError(w, "wrong number of arguments for 'LPOS' command")
+		return
+	}
+	rank, count, maxlen := 1, 1, 0
+	withCount := false
+	for i := 3; i < len(parts); i += 2 {
+		n, err := strconv.Atoi(parts[i+1])
+		if err != nil {
+			util.WriteErr(w, internal.ErrNotInt)
+			return
+		}
+		switch strings.ToUpper(parts[i]) {
+		case "RANK":
+			if n == 0 {
+				util.WriteError(w, "RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+				return
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				util.WriteError(w, "COUNT can't be negative")
+				return
+			}
+			count, withCount = n, true
+		case "MAXLEN":
+			if n < 0 {
+				util.WriteError(w, "MAXLEN can't be negative")
+				return
+			}
+			maxlen = n
+		default:
+			util.WriteErr(w, internal.ErrSyntax)
+			return
+		}
+	}
+
+	positions, err := store.LPos(parts[1], []byte(parts[2]), rank, count, maxlen)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if withCount {
+		util.WriteArrayHeader(w, len(positions))
+		for _, pos := range positions {
+			util.WriteInteger(w, pos)
+		}
+		return
+	}
+	if len(positions) == 0 {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteInteger(w, positions[0])
+}
+
+func handleLMove(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 5 {
+		util.WriteError(w, "wrong number of arguments for 'LMOVE' command")
+		return
+	}
+	fromLeft, err1 := parseDirection(parts[3])
+	toLeft, err2 := parseDirection(parts[4])
+	if err1 != nil || err2 != nil {
+		util.WriteErr(w, internal.ErrSyntax)
+		return
+	}
+	moveList(w, store, parts[1], parts[2], fromLeft, toLeft, aofWriter, replManager)
+}
+
+func handleRPopLPush(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'RPOPLPUSH' command")
+		return
+	}
+	moveList(w, store, parts[1], parts[2], false, true, aofWriter, replManager)
+}
+
+func moveList(w protocol.ReplyWriter, store internal.IStore, source, destination string, fromLeft, toLeft bool, aofWriter aof.IAOF, replManager replication.IManager) {
+	value, err := store.LMove(source, destination, fromLeft, toLeft)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if value == nil {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, value)
+	propagate(aofWriter, replManager, "LMOVE", source, destination, direction(fromLeft), direction(toLeft))
+}
+
+func direction(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+// handleBlockingPop serves BLPOP and BRPOP. The connection stays parked inside
+// the handler until an element can be popped or the timeout elapses.
+func handleBlockingPop(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	timeout, err := parseTimeout(parts[len(parts)-1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	keys := parts[1 : len(parts)-1]
+
+	var (
+		key   string
+		value []byte
+	)
+	if command == BLPopCommand {
+		key, value, err = store.BLPop(keys, timeout)
+	} else {
+		key, value, err = store.BRPop(keys, timeout)
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if value == nil {
+		util.WriteNullArray(w)
+		return
+	}
+	util.WriteBulkArray(w, [][]byte{[]byte(key), value})
+
+	pop := LPopCommand
+	if command == BRPopCommand {
+		pop = RPopCommand
+	}
+	propagate(aofWriter, replManager, pop, key)
+}
+
+func handleBLMove(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 6 {
+		util.WriteError(w, "wrong number of arguments for 'BLMOVE' command")
+		return
+	}
+	fromLeft, err1 := parseDirection(parts[3])
+	toLeft, err2 := parseDirection(parts[4])
+	if err1 != nil || err2 != nil {
+		util.WriteErr(w, internal.ErrSyntax)
+		return
+	}
+	blockingMove(w, store, parts[1], parts[2], fromLeft, toLeft, parts[5], aofWriter, replManager)
+}
+
+func handleBRPopLPush(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'BRPOPLPUSH' command")
+		return
+	}
+	blockingMove(w, store, parts[1], parts[2], false, true, parts[3], aofWriter, replManager)
+}
+
+func blockingMove(w protocol.ReplyWriter, store internal.IStore, source, destination string, fromLeft, toLeft bool, rawTimeout string, aofWriter aof.IAOF, replManager replication.IManager) {
+	timeout, err := parseTimeout(rawTimeout)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	value, err := store.BLMove(source, destination, fromLeft, toLeft, timeout)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if value == nil {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, value)
+	propagate(aofWriter, replManager, "LMOVE", source, destination, direction(fromLeft), direction(toLeft))
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+// denyOOMCommands lists the commands that may grow memory use. They are
+// refused with an OOM error when the store is over maxmemory and nothing can be
+// evicted, while the others, deletions included, keep working.
+var denyOOMCommands = map[string]bool{
+	SetCommand:         true,
+	MSetCommand:        true,
+	MSetNXCommand:      true,
+	IncrCommand:        true,
+	DecrCommand:        true,
+	IncrByCommand:      true,
+	DecrByCommand:      true,
+	IncrByFloatCommand: true,
+	AppendCommand:      true,
+	SetRangeCommand:    true,
+	GetSetCommand:      true,
+	CopyCommand:        true,
+
+	HSetCommand:         true,
+	HSetNXCommand:       true,
+	HIncrByCommand:      true,
+	HIncrByFloatCommand: true,
+
+	LPushCommand:      true,
+	RPushCommand:      true,
+	LPushXCommand:     true,
+	RPushXCommand:     true,
+	LInsertCommand:    true,
+	LSetCommand:       true,
+	RPopLPushCommand:  true,
+	BRPopLPushCommand: true,
+
+	SAddCommand:        true,
+	SInterStoreCommand: true,
+	SUnionStoreCommand: true,
+	SDiffStoreCommand:  true,
+
+	ZAddCommand:        true,
+	ZIncrByCommand:     true,
+	ZUnionStoreCommand: true,
+	ZInterStoreCommand: true,
+
+	XAddCommand:       true,
+	XGroupCommand:     true,
+	XReadGroupCommand: true,
+	XClaimCommand:     true,
+	XAutoClaimCommand: true,
+}
+
+/*
+EnforceMaxMemory runs before every command. When the store is over maxmemory it
+evicts keys according to the eviction policy and propagates their deletion, so
+that the AOF and the replicas drop them too. It returns ErrOOM when command may
+grow memory and the store could not get back under the limit.
+*/
+func EnforceMaxMemory(store internal.IStore, command string, aofWriter aof.IAOF, replManager replication.IManager) error {
+	evicted, err := store.Evict()
+	if len(evicted) > 0 {
+		propagate(aofWriter, replManager, append([]string{DelCommand}, evicted...)...)
+	}
+	if err != nil && denyOOMCommands[command] {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/pubsub"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	SubscribeCommand    = "SUBSCRIBE"
+	UnsubscribeCommand  = "UNSUBSCRIBE"
+	PSubscribeCommand   = "PSUBSCRIBE"
+	PUnsubscribeCommand = "PUNSUBSCRIBE"
+	PublishCommand      = "PUBLISH"
+	PubSubCommand       = "PUBSUB"
+)
+
+// pubsubCommands are served by Subscription rather than CommandHandlers.
+var pubsubCommands = map[string]bool{
+	SubscribeCommand:    true,
+	UnsubscribeCommand:  true,
+	PSubscribeCommand:   true,
+	PUnsubscribeCommand: true,
+	PublishCommand:      true,
+	PubSubCommand:       true,
+}
+
+// pushModeCommands are the only commands a client may send while subscribed.
+var pushModeCommands = map[string]bool{
+	SubscribeCommand:    true,
+	UnsubscribeCommand:  true,
+	PSubscribeCommand:   true,
+	PUnsubscribeCommand: true,
+	PingCommand:         true,
+}
+
+// CommandChannels returns the channels a command publishes or subscribes to,
+// and whether they are the patterns of PSUBSCRIBE.
+func CommandChannels(args [][]byte) (channels [][]byte, patterns bool) {
+	if len(args) < 2 {
+		return nil, false
+	}
+	switch CommandName(args[0]) {
+	case PublishCommand:
+		return args[1:2], false
+	case SubscribeCommand:
+		return args[1:], false
+	case PSubscribeCommand:
+		return args[1:], true
+	}
+	return nil, false
+}
+
+/*
+Subscription is the pub/sub state of a connection.
+
+The first SUBSCRIBE or PSUBSCRIBE puts the connection in push mode: from then
+on every reply goes through a pubsub.Subscriber, see Writer, and until the last
+subscription is dropped a RESP2 client may only send the subscribe commands
+and PING. RESP3 tells messages apart from replies, so it keeps every command.
+*/
+type Subscription struct {
+	client     *Client
+	broker     *pubsub.Broker
+	subscriber *pubsub.Subscriber
+	// out buffers the replies queued to subscriber.
+	out *protocol.Writer
+	// subscribed is the number of channels and patterns the client listens to.
+	subscribed int
+}
+
+func NewSubscription(client *Client, broker *pubsub.Broker) *Subscription {
+	return &Subscription{client: client, broker: broker}
+}
+
+// Writer returns the writer replies must be written to. It changes with the
+// first subscription: the client writer then belongs to the subscriber.
+func (s *Subscription) Writer() protocol.ReplyWriter {
+	if s.out != nil {
+		return s.out
+	}
+	return s.client.Replies()
+}
+
+// Close drops the subscriptions of the connection. The server calls it when the connection closes.
+func (s *Subscription) Close() {
+	if s.subscriber != nil {
+		s.broker.UnsubscribeAll(s.subscriber)
+		s.subscriber.Close()
+	}
+}
+
+/*
+Handle serves the pub/sub commands and, in push mode, PING and the refusal of
+the other commands. It reports false when parts is a command to run otherwise.
+*/
+func (s *Subscription) Handle(parts []string) bool {
+	command := strings.ToUpper(parts[0])
+	if s.subscribed > 0 && !pushModeCommands[command] && s.client.Protocol() < protocol.RESP3 {
+		util.WriteError(s.Writer(), "Can't execute '"+strings.ToLower(command)+"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+		return true
+	}
+	switch command {
+	case SubscribeCommand, PSubscribeCommand:
+		s.subscribe(command, parts)
+		s.record()
+	case UnsubscribeCommand, PUnsubscribeCommand:
+		s.unsubscribe(command, parts)
+		s.record()
+	case PublishCommand:
+		if len(parts) != 3 {
+			util.WriteError(s.Writer(), "wrong number of arguments for 'PUBLISH' command")
+			return true
+		}
+		util.WriteInteger(s.Writer(), s.broker.Publish(parts[1], []byte(parts[2])))
+	case PubSubCommand:
+		s.introspect(parts)
+	case PingCommand:
+		if s.subscribed == 0 || s.client.Protocol() >= protocol.RESP3 {
+			return false
+		}
+		// A subscribed client gets its PING answered in the shape of a message.
+		payload := ""
+		if len(parts) > 1 {
+			payload = parts[1]
+		}
+		util.WriteBulkArray(s.Writer(), [][]byte{[]byte("pong"), []byte(payload)})
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *Subscription) subscribe(command string, parts []string) {
+	if len(parts) < 2 {
+		util.WriteError(s.Writer(), "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	if s.subscriber == nil {
+		// Flush what the client has buffered so far, the subscriber writes to it from now on.
+		s.client.Flush()
+		s.subscriber = s.broker.NewSubscriber(s.client)
+		s.out = protocol.NewWriter(s.subscriber, s.client)
+	}
+	for _, name := range parts[1:] {
+		if command == SubscribeCommand {
+			s.subscribed = s.broker.Subscribe(s.subscriber, name)
+		} else {
+			s.subscribed = s.broker.PSubscribe(s.subscriber, name)
+		}
+		writeSubscription(s.Writer(), strings.ToLower(command), &name, s.subscribed)
+	}
+}
+
+// record reports the number of channels and patterns subscribed to CLIENT LIST.
+func (s *Subscription) record() {
+	if s.subscriber != nil {
+		channels, patterns := s.broker.Subscriptions(s.subscriber)
+		s.client.setSubscriptions(len(channels), len(patterns))
+	}
+}
+
+// unsubscribe drops the given channels or patterns, all of them when none is given.
+func (s *Subscription) unsubscribe(command string, parts []string) {
+	names := parts[1:]
+	if len(names) == 0 && s.subscriber != nil {
+		channels, patterns := s.broker.Subscriptions(s.subscriber)
+		names = channels
+		if command == PUnsubscribeCommand {
+			names = patterns
+		}
+	}
+	kind := strings.ToLower(command)
+	if len(names) == 0 {
+		writeSubscription(s.Writer(), kind, nil, s.subscribed)
+		return
+	}
+	for _, name := range names {
+		if s.subscriber != nil {
+			if command == UnsubscribeCommand {
+				s.subscribed = s.broker.Unsubscribe(s.subscriber, name)
+			} else {
+				s.subscribed = s.broker.PUnsubscribe(s.subscriber, name)
+			}
+		}
+		writeSubscription(s.Writer(), kind, &name, s.subscribed)
+	}
+}
+
+// introspect serves PUBSUB CHANNELS [pattern], PUBSUB NUMSUB [channel ...] and PUBSUB NUMPAT.
+func (s *Subscription) introspect(parts []string) {
+	w := s.Writer()
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'PUBSUB' command")
+		return
+	}
+	subcommand := strings.ToUpper(parts[1])
+	switch {
+	case subcommand == "CHANNELS" && len(parts) <= 3:
+		pattern := ""
+		if len(parts) == 3 {
+			pattern = parts[2]
+		}
+		channels := s.broker.Channels(pattern)
+		items := make([][]byte, len(channels))
+		for i, channel := range channels {
+			items[i] = []byte(channel)
+		}
+		util.WriteBulkArray(w, items)
+	case subcommand == "NUMSUB":
+		w.WriteMapHeader(len(parts[2:]))
+		for _, channel := range parts[2:] {
+			w.WriteBulkString(channel)
+			w.WriteInteger(int64(s.broker.NumSub(channel)))
+		}
+	case subcommand == "NUMPAT" && len(parts) == 2:
+		util.WriteInteger(w, s.broker.NumPat())
+	case subcommand == "CHANNELS" || subcommand == "NUMPAT":
+		util.WriteError(w, "wrong number of arguments for 'PUBSUB|"+strings.ToLower(subcommand)+"' command")
+	default:
+		util.WriteError(w, "unknown subcommand '"+parts[1]+"'. Try PUBSUB HELP.")
+	}
+}
+
+// writeSubscription writes the confirmation of a (P)SUBSCRIBE or (P)UNSUBSCRIBE
+// of name, a null name when there was nothing to unsubscribe from.
+func writeSubscription(w protocol.ReplyWriter, kind string, name *string, count int) {
+	w.WritePushHeader(3)
+	w.WriteBulkString(kind)
+	if name == nil {
+		w.WriteNull()
+	} else {
+		w.WriteBulkString(*name)
+	}
+	w.WriteInteger(int64(count))
+}
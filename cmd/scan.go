@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	ScanCommand  = "SCAN"
+	KeysCommand  = "KEYS"
+	HScanCommand = "HSCAN"
+	SScanCommand = "SSCAN"
+	ZScanCommand = "ZSCAN"
+)
+
+var errInvalidCursor = errors.New("ERR invalid cursor")
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count] [TYPE type], TYPE
+// being accepted only when withType is set.
+func parseScanArgs(args []string, withType bool) (uint64, internal.ScanOptions, error) {
+	var opts internal.ScanOptions
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, opts, errInvalidCursor
+	}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, opts, internal.ErrSyntax
+		}
+		switch option := strings.ToUpper(args[i]); {
+		case option == "MATCH":
+			opts.Match = args[i+1]
+		case option == "COUNT":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return 0, opts, internal.ErrNotInt
+			}
+			if n < 1 {
+				return 0, opts, internal.ErrSyntax
+			}
+			opts.Count = n
+		case option == "TYPE" && withType:
+			opts.Type = strings.ToLower(args[i+1])
+		default:
+			return 0, opts, internal.ErrSyntax
+		}
+	}
+	// A lone * matches everything, skip the pattern matching altogether.
+	if opts.Match == "*" {
+		opts.Match = ""
+	}
+	return cursor, opts, nil
+}
+
+// writeScanReply writes the [cursor, elements] reply shared by the SCAN family.
+func writeScanReply(w protocol.ReplyWriter, cursor uint64, items [][]byte) {
+	util.WriteArrayHeader(w, 2)
+	util.WriteBulk(w, []byte(strconv.FormatUint(cursor, 10)))
+	util.WriteBulkArray(w, items)
+}
+
+func handleScan(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'SCAN' command")
+		return
+	}
+	cursor, opts, err := parseScanArgs(parts[1:], true)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	next, keys := store.Scan(cursor, opts)
+	writeScanReply(w, next, toBytes(keys))
+}
+
+// handleKeys serves KEYS pattern. It walks the whole keyspace, SCAN is the way
+// to go on anything but small datasets.
+func handleKeys(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'KEYS' command")
+		return
+	}
+	opts := internal.ScanOptions{Match: parts[1]}
+	if opts.Match == "*" {
+		opts.Match = ""
+	}
+	keys := [][]byte{}
+	for key := range store.Keys(opts) {
+		keys = append(keys, []byte(key))
+	}
+	util.WriteBulkArray(w, keys)
+}
+
+// handleCollectionScan serves HSCAN, SSCAN and ZSCAN.
+func handleCollectionScan(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	cursor, opts, err := parseScanArgs(parts[2:], false)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+
+	var (
+		next  uint64
+		items [][]byte
+	)
+	switch command {
+	case HScanCommand:
+		next, items, err = store.HScan(parts[1], cursor, opts)
+	case SScanCommand:
+		var members []string
+		next, members, err = store.SScan(parts[1], cursor, opts)
+		items = toBytes(members)
+	case ZScanCommand:
+		var members []internal.ZMember
+		next, members, err = store.ZScan(parts[1], cursor, opts)
+		items = make([][]byte, 0, len(members)*2)
+		for _, m := range members {
+			items = append(items, []byte(m.Member), []byte(internal.FormatScore(m.Score)))
+		}
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	writeScanReply(w, next, items)
+}
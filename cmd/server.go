@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/acl"
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/config"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const ConfigCommand = "CONFIG"
+
+/*
+Server is the state the clients of a server share: its settings, its users,
+the registry of the connected clients and the statistics INFO reports.
+*/
+type Server struct {
+	Config *config.Manager
+	ACL    *acl.ACL
+	// Replica is the link to the master of a replica, nil on a master.
+	Replica *replication.ReplicaStatus
+	clients *Clients
+	stats   *stats
+	started time.Time
+}
+
+func NewServer(settings *config.Manager, users *acl.ACL) *Server {
+	now := time.Now()
+	return &Server{Config: settings, ACL: users, clients: newClients(), stats: newStats(now), started: now}
+}
+
+// ConnectedClients returns the number of clients connected, as INFO reports it.
+func (s *Server) ConnectedClients() int {
+	return s.clients.Len()
+}
+
+// Record counts a call of the command in parts that took elapsed to serve, for INFO.
+func (s *Server) Record(parts []string, elapsed time.Duration) {
+	command := strings.ToUpper(parts[0])
+	// Unknown commands are not counted, their names are whatever clients send.
+	if _, known := commandCategories[command]; !known {
+		return
+	}
+	s.stats.record(command, elapsed)
+}
+
+// Save takes a snapshot of store in dbfilename and starts a new AOF, the way
+// SAVE does, and records the outcome for INFO.
+func (s *Server) Save(store internal.IStore, aofWriter aof.IAOF) error {
+	err := store.Save(s.Config.Current().DBFilename)
+	s.stats.saved(err)
+	if err != nil {
+		return fmt.Errorf("failed to save data to disk: %w", err)
+	}
+	if err := aofWriter.Reset(); err != nil {
+		return fmt.Errorf("failed to reset the aof file: %w", err)
+	}
+	return nil
+}
+
+// serverCommands are the commands that need the Server, see Server.Handler.
+var serverCommands = map[string]func(s *Server, w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager){
+	SaveCommand:   (*Server).handleSave,
+	InfoCommand:   (*Server).handleInfo,
+	ConfigCommand: (*Server).handleConfig,
+}
+
+// Handler returns the handler of command, upper case: one of CommandHandlers,
+// or one of the serverCommands bound to s.
+func (s *Server) Handler(command string) (CommandHandler, bool) {
+	if handler, ok := serverCommands[command]; ok {
+		return func(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+			handler(s, w, store, parts, aofWriter, replManager)
+		}, true
+	}
+	handler, ok := CommandHandlers[command]
+	return handler, ok
+}
+
+func (s *Server) handleSave(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if err := s.Save(store, aofWriter); err != nil {
+		util.WriteError(w, err.Error())
+		return
+	}
+	util.WriteString(w, "OK")
+}
+
+// handleConfig serves CONFIG GET, SET, REWRITE and RESETSTAT.
+func (s *Server) handleConfig(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'config' command")
+		return
+	}
+	subcommand := strings.ToUpper(parts[1])
+	wrongArgs := func() {
+		util.WriteError(w, "wrong number of arguments for 'config|"+strings.ToLower(subcommand)+"' command")
+	}
+	switch subcommand {
+	case "GET":
+		if len(parts) < 3 {
+			wrongArgs()
+			return
+		}
+		settings := s.Config.Get(parts[2:]...)
+		w.WriteMapHeader(len(settings))
+		for _, setting := range settings {
+			w.WriteBulkString(setting.Name)
+			w.WriteBulkString(setting.Value)
+		}
+	case "SET":
+		if len(parts) < 4 || len(parts)%2 != 0 {
+			wrongArgs()
+			return
+		}
+		if err := s.Config.Set(parts[2:]...); err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		util.WriteString(w, "OK")
+	case "REWRITE":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		if err := s.Config.Rewrite(); err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		util.WriteString(w, "OK")
+	case "RESETSTAT":
+		if len(parts) != 2 {
+			wrongArgs()
+			return
+		}
+		store.ResetStats()
+		s.stats.reset()
+		util.WriteString(w, "OK")
+	default:
+		util.WriteError(w, "unknown subcommand '"+parts[1]+"'. Try CONFIG HELP.")
+	}
+}
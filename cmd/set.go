@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	SAddCommand        = "SADD"
+	SRemCommand        = "SREM"
+	SIsMemberCommand   = "SISMEMBER"
+	SMIsMemberCommand  = "SMISMEMBER"
+	SCardCommand       = "SCARD"
+	SMembersCommand    = "SMEMBERS"
+	SPopCommand        = "SPOP"
+	SRandMemberCommand = "SRANDMEMBER"
+	SMoveCommand       = "SMOVE"
+	SInterCommand      = "SINTER"
+	SUnionCommand      = "SUNION"
+	SDiffCommand       = "SDIFF"
+	SInterStoreCommand = "SINTERSTORE"
+	SUnionStoreCommand = "SUNIONSTORE"
+	SDiffStoreCommand  = "SDIFFSTORE"
+	SInterCardCommand  = "SINTERCARD"
+)
+
+func writeStringArray(w protocol.ReplyWriter, values []string) {
+	util.WriteBulkArray(w, toBytes(values))
+}
+
+func handleSAdd(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'SADD' command")
+		return
+	}
+	added, err := store.SAdd(parts[1], parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, added)
+	if added > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleSRem(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'SREM' command")
+		return
+	}
+	removed, err := store.SRem(parts[1], parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, removed)
+	if removed > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleSIsMember(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'SISMEMBER' command")
+		return
+	}
+	exists, err := store.SIsMember(parts[1], parts[2])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if exists {
+		util.WriteInteger(w, 1)
+		return
+	}
+	util.WriteInteger(w, 0)
+}
+
+func handleSMIsMember(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'SMISMEMBER' command")
+		return
+	}
+	result, err := store.SMIsMember(parts[1], parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteArrayHeader(w, len(result))
+	for _, exists := range result {
+		if exists {
+			util.WriteInteger(w, 1)
+		} else {
+			util.WriteInteger(w, 0)
+		}
+	}
+}
+
+func handleSCard(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'SCARD' command")
+		return
+	}
+	n, err := store.SCard(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+}
+
+func handleSMembers(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'SMEMBERS' command")
+		return
+	}
+	members, err := store.SMembers(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteBulkSet(w, toBytes(members))
+}
+
+func handleSPop(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 2 || len(parts) > 3 {
+		util.WriteError(w, "wrong number of arguments for 'SPOP' command")
+		return
+	}
+	count := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 0 {
+			util.WriteError(w, "value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	members, err := store.SPop(parts[1], count)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+
+	if len(parts) == 3 {
+		writeStringArray(w, members)
+	} else if len(members) == 0 {
+		util.WriteNull(w)
+	} else {
+		util.WriteBulk(w, []byte(members[0]))
+	}
+	// Replicas must remove the very members picked here, not random ones of their own.
+	if len(members) > 0 {
+		propagate(aofWriter, replManager, append([]string{"SREM", parts[1]}, members...)...)
+	}
+}
+
+func handleSRandMember(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 2 || len(parts) > 3 {
+		util.WriteError(w, "wrong number of arguments for 'SRANDMEMBER' command")
+		return
+	}
+	count := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			util.WriteErr(w, internal.ErrNotInt)
+			return
+		}
+		count = n
+	}
+	if count < 0 {
+		writeRandomMembers(w, store, parts[1], count)
+		return
+	}
+	members, err := store.SRandMember(parts[1], count)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if len(parts) == 3 {
+		writeStringArray(w, members)
+		return
+	}
+	if len(members) == 0 {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, []byte(members[0]))
+}
+
+/*
+writeRandomMembers replies to SRANDMEMBER with a negative count. Members may
+repeat, so nothing but the count bounds the reply: it is streamed from one copy
+of the set rather than built whole, and given up once the client is gone.
+*/
+func writeRandomMembers(w protocol.ReplyWriter, store internal.IStore, key string, count int) {
+	if count == math.MinInt {
+		util.WriteErr(w, internal.ErrRange)
+		return
+	}
+	members, err := store.SMembers(key)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if len(members) == 0 {
+		w.WriteArrayHeader(0)
+		return
+	}
+	w.WriteArrayHeader(-count)
+	for i := 0; i < -count; i++ {
+		w.WriteBulkString(members[rand.Intn(len(members))])
+		if w.Buffered() >= protocol.WriteBufferSize/2 && w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func handleSMove(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'SMOVE' command")
+		return
+	}
+	moved, err := store.SMove(parts[1], parts[2], parts[3])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if !moved {
+		util.WriteInteger(w, 0)
+		return
+	}
+	util.WriteInteger(w, 1)
+	propagate(aofWriter, replManager, parts...)
+}
+
+// handleSetAlgebra serves SINTER, SUNION and SDIFF.
+func handleSetAlgebra(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	var (
+		members []string
+		err     error
+	)
+	switch command {
+	case SInterCommand:
+		members, err = store.SInter(parts[1:])
+	case SUnionCommand:
+		members, err = store.SUnion(parts[1:])
+	case SDiffCommand:
+		members, err = store.SDiff(parts[1:])
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteBulkSet(w, toBytes(members))
+}
+
+// handleSetAlgebraStore serves SINTERSTORE, SUNIONSTORE and SDIFFSTORE.
+func handleSetAlgebraStore(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	var (
+		n   int
+		err error
+	)
+	switch command {
+	case SInterStoreCommand:
+		n, err = store.SInterStore(parts[1], parts[2:])
+	case SUnionStoreCommand:
+		n, err = store.SUnionStore(parts[1], parts[2:])
+	case SDiffStoreCommand:
+		n, err = store.SDiffStore(parts[1], parts[2:])
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleSInterCard(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'SINTERCARD' command")
+		return
+	}
+	numKeys, err := strconv.Atoi(parts[1])
+	if err != nil || numKeys <= 0 {
+		util.WriteError(w, "numkeys should be greater than 0")
+		return
+	}
+	if numKeys > len(parts)-2 {
+		util.WriteError(w, "Number of keys can't be greater than number of args")
+		return
+	}
+	keys := parts[2 : 2+numKeys]
+	limit := 0
+	rest := parts[2+numKeys:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(rest[0], "LIMIT") {
+			util.WriteErr(w, internal.ErrSyntax)
+			return
+		}
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil || limit < 0 {
+			util.WriteError(w, "LIMIT can't be negative")
+			return
+		}
+	}
+	n, err := store.SInterCard(keys, limit)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+}
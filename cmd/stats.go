@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// opsSampleInterval is the shortest time between two samples of the
+	// commands processed, and opsSamples the number of samples averaged by
+	// instantaneous_ops_per_sec.
+	opsSampleInterval = 100 * time.Millisecond
+	opsSamples        = 16
+)
+
+// commandStats counts the calls of a command and the time spent serving them.
+type commandStats struct {
+	calls atomic.Int64
+	usec  atomic.Int64
+}
+
+// opsSample is the number of commands processed during an interval.
+type opsSample struct {
+	end     time.Time
+	elapsed time.Duration
+	ops     int64
+}
+
+/*
+stats holds the counters of the server INFO reports, CONFIG RESETSTAT zeroes
+them. They are updated for every command, from every connection, so the hot
+ones are atomic and the command counters are created once per command name.
+*/
+type stats struct {
+	commands    atomic.Int64
+	connections atomic.Int64
+	// changes counts the writes since the last snapshot.
+	changes atomic.Int64
+
+	perCommand sync.Map // command name -> *commandStats
+
+	// sampledAt is the unix time in nanoseconds of the last sample, taken by
+	// the first command served past the interval.
+	sampledAt  atomic.Int64
+	mu         sync.Mutex
+	sampledOps int64
+	samples    [opsSamples]opsSample
+	next       int
+
+	saveMu     sync.Mutex
+	lastSave   time.Time
+	lastSaveOK bool
+	saves      int64
+}
+
+func newStats(started time.Time) *stats {
+	st := &stats{lastSave: started, lastSaveOK: true}
+	st.sampledAt.Store(started.UnixNano())
+	return st
+}
+
+// record counts a call of command, upper case, that took elapsed to serve.
+func (st *stats) record(command string, elapsed time.Duration) {
+	st.commands.Add(1)
+	if slices.Contains(commandCategories[command], "write") {
+		st.changes.Add(1)
+	}
+	counters, ok := st.perCommand.Load(command)
+	if !ok {
+		counters, _ = st.perCommand.LoadOrStore(command, &commandStats{})
+	}
+	counters.(*commandStats).calls.Add(1)
+	counters.(*commandStats).usec.Add(elapsed.Microseconds())
+	st.sample(time.Now())
+}
+
+// sample records the commands processed since the last sample, when it is older than opsSampleInterval.
+func (st *stats) sample(now time.Time) {
+	last := st.sampledAt.Load()
+	if now.UnixNano()-last < int64(opsSampleInterval) || !st.sampledAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ops := st.commands.Load()
+	st.samples[st.next] = opsSample{end: now, elapsed: time.Duration(now.UnixNano() - last), ops: ops - st.sampledOps}
+	st.next = (st.next + 1) % opsSamples
+	st.sampledOps = ops
+}
+
+// opsPerSec returns the commands processed per second over the recent samples.
+// Samples older than the samples of a busy server would be are ignored, so that
+// an idle server reports no activity.
+func (st *stats) opsPerSec(now time.Time) int64 {
+	st.sample(now)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var ops int64
+	var elapsed time.Duration
+	for _, sample := range st.samples {
+		if sample.end.IsZero() || now.Sub(sample.end) > opsSamples*opsSampleInterval {
+			continue
+		}
+		ops += sample.ops
+		elapsed += sample.elapsed
+	}
+	if elapsed == 0 {
+		return 0
+	}
+	return ops * int64(time.Second) / int64(elapsed)
+}
+
+// saved records the outcome of a snapshot.
+func (st *stats) saved(err error) {
+	st.saveMu.Lock()
+	defer st.saveMu.Unlock()
+	st.lastSaveOK = err == nil
+	if err == nil {
+		st.lastSave = time.Now()
+		st.saves++
+		st.changes.Store(0)
+	}
+}
+
+// lastSaved returns the time of the last successful snapshot, whether the last
+// attempt succeeded and the number of snapshots taken.
+func (st *stats) lastSaved() (time.Time, bool, int64) {
+	st.saveMu.Lock()
+	defer st.saveMu.Unlock()
+	return st.lastSave, st.lastSaveOK, st.saves
+}
+
+// commandStat is the line of a command in the commandstats section of INFO.
+type commandStat struct {
+	name  string
+	calls int64
+	usec  int64
+}
+
+// commandStats returns the counters of the commands called, sorted by name.
+func (st *stats) commandStats() []commandStat {
+	var list []commandStat
+	st.perCommand.Range(func(name, counters any) bool {
+		c := counters.(*commandStats)
+		if calls := c.calls.Load(); calls > 0 {
+			list = append(list, commandStat{name: strings.ToLower(name.(string)), calls: calls, usec: c.usec.Load()})
+		}
+		return true
+	})
+	slices.SortFunc(list, func(a, b commandStat) int { return strings.Compare(a.name, b.name) })
+	return list
+}
+
+// reset zeroes the counters, for CONFIG RESETSTAT. The time of the last
+// snapshot and the writes since are kept, they are not statistics.
+func (st *stats) reset() {
+	st.commands.Store(0)
+	st.connections.Store(0)
+	st.perCommand.Range(func(_, counters any) bool {
+		counters.(*commandStats).calls.Store(0)
+		counters.(*commandStats).usec.Store(0)
+		return true
+	})
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.sampledOps = 0
+	st.samples = [opsSamples]opsSample{}
+}
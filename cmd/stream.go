@@ -0,0 +1,715 @@
+package cmd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	XAddCommand       = "XADD"
+	XLenCommand       = "XLEN"
+	XRangeCommand     = "XRANGE"
+	XRevRangeCommand  = "XREVRANGE"
+	XDelCommand       = "XDEL"
+	XTrimCommand      = "XTRIM"
+	XReadCommand      = "XREAD"
+	XGroupCommand     = "XGROUP"
+	XReadGroupCommand = "XREADGROUP"
+	XAckCommand       = "XACK"
+	XPendingCommand   = "XPENDING"
+	XClaimCommand     = "XCLAIM"
+	XAutoClaimCommand = "XAUTOCLAIM"
+)
+
+var (
+	errBlockTimeout = errors.New("ERR timeout is not an integer or out of range")
+	errBlockNeg     = errors.New("ERR timeout is negative")
+)
+
+// parseRangeID parses a range end of XRANGE, XPENDING and XAUTOCLAIM: an ID, -,
+// + or an exclusive (ID. A missing sequence number covers the whole millisecond.
+// ok is false when an exclusive bound leaves nothing to match.
+func parseRangeID(s string, start bool) (id internal.StreamID, ok bool, err error) {
+	switch s {
+	case "-":
+		return internal.StreamID{}, true, nil
+	case "+":
+		return internal.MaxStreamID, true, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	var missingSeq uint64
+	if !start {
+		missingSeq = internal.MaxStreamID.Seq
+	}
+	id, err = internal.ParseStreamID(s, missingSeq)
+	if err != nil || !exclusive {
+		return id, true, err
+	}
+	if start {
+		id, ok = id.Next()
+	} else {
+		id, ok = id.Prev()
+	}
+	return id, ok, nil
+}
+
+func parseStreamIDs(args []string) ([]internal.StreamID, error) {
+	ids := make([]internal.StreamID, len(args))
+	for i, arg := range args {
+		id, err := internal.ParseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// parseBlock parses the BLOCK argument of XREAD and XREADGROUP, in milliseconds.
+func parseBlock(s string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errBlockTimeout
+	}
+	if ms < 0 {
+		return 0, errBlockNeg
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// trimArgs renders a trimming clause for propagation. Trimming is always exact
+// so that replaying it removes the same entries.
+func trimArgs(trim *internal.StreamTrim) []string {
+	args := []string{"MAXLEN", "=", strconv.Itoa(trim.MaxLen)}
+	if trim.MinID {
+		args = []string{"MINID", "=", trim.Boundary.String()}
+	}
+	if trim.Limit > 0 {
+		args = append(args, "LIMIT", strconv.Itoa(trim.Limit))
+	}
+	return args
+}
+
+func writeStreamEntry(w protocol.ReplyWriter, entry internal.StreamEntry) {
+	util.WriteArrayHeader(w, 2)
+	util.WriteBulk(w, []byte(entry.ID.String()))
+	if entry.Fields == nil {
+		util.WriteNullArray(w)
+		return
+	}
+	writeStringArray(w, entry.Fields)
+}
+
+func writeStreamEntries(w protocol.ReplyWriter, entries []internal.StreamEntry) {
+	util.WriteArrayHeader(w, len(entries))
+	for _, entry := range entries {
+		writeStreamEntry(w, entry)
+	}
+}
+
+func writeStreamIDs(w protocol.ReplyWriter, ids []internal.StreamID) {
+	items := make([][]byte, len(ids))
+	for i, id := range ids {
+		items[i] = []byte(id.String())
+	}
+	util.WriteBulkArray(w, items)
+}
+
+// writeClaimed writes the entries claimed by XCLAIM or XAUTOCLAIM, only their IDs with JUSTID.
+func writeClaimed(w protocol.ReplyWriter, entries []internal.StreamEntry, justID bool) {
+	if !justID {
+		writeStreamEntries(w, entries)
+		return
+	}
+	ids := make([]internal.StreamID, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	writeStreamIDs(w, ids)
+}
+
+// writeStreamResults writes the reply of XREAD and XREADGROUP, a null array when
+// nothing was read. RESP3 clients get a map from the keys to their entries.
+func writeStreamResults(w protocol.ReplyWriter, results []internal.StreamReadResult) {
+	if len(results) == 0 {
+		util.WriteNullArray(w)
+		return
+	}
+	resp3 := w.Protocol() >= protocol.RESP3
+	if resp3 {
+		w.WriteMapHeader(len(results))
+	} else {
+		util.WriteArrayHeader(w, len(results))
+	}
+	for _, result := range results {
+		if !resp3 {
+			util.WriteArrayHeader(w, 2)
+		}
+		util.WriteBulk(w, []byte(result.Key))
+		writeStreamEntries(w, result.Entries)
+	}
+}
+
+/*
+propagateDeliveries records entries handed to consumers by XREADGROUP, XCLAIM
+and XAUTOCLAIM. Each one is propagated as a forced XCLAIM carrying the delivery
+time and count, so replicas end up with the same PEL without re-reading the stream.
+*/
+func propagateDeliveries(aofWriter aof.IAOF, replManager replication.IManager, key, group string, pending []internal.PendingEntry, lastID internal.StreamID) {
+	for _, p := range pending {
+		propagate(aofWriter, replManager, XClaimCommand, key, group, p.Consumer, "0", p.ID.String(),
+			"TIME", strconv.FormatInt(p.DeliveryTime.UnixMilli(), 10),
+			"RETRYCOUNT", strconv.FormatInt(p.DeliveryCount, 10),
+			"FORCE", "JUSTID", "LASTID", lastID.String())
+	}
+}
+
+// propagateDeleted drops from the replicas' PEL the entries found deleted while claiming.
+func propagateDeleted(aofWriter aof.IAOF, replManager replication.IManager, key, group string, deleted []internal.StreamID) {
+	if len(deleted) == 0 {
+		return
+	}
+	parts := []string{XAckCommand, key, group}
+	for _, id := range deleted {
+		parts = append(parts, id.String())
+	}
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleXAdd(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 5 {
+		util.WriteError(w, "wrong number of arguments for 'XADD' command")
+		return
+	}
+	opts, idSpec, fields, err := internal.ParseXAddArgs(parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	id, ok, err := store.XAdd(parts[1], idSpec, fields, opts)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if !ok {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, []byte(id.String()))
+
+	// The ID is propagated resolved so that replicas never generate their own.
+	propagated := []string{XAddCommand, parts[1]}
+	if opts.Trim != nil {
+		propagated = append(propagated, trimArgs(opts.Trim)...)
+	}
+	propagated = append(propagated, id.String())
+	propagate(aofWriter, replManager, append(propagated, fields...)...)
+}
+
+func handleXLen(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'XLEN' command")
+		return
+	}
+	n, err := store.XLen(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+}
+
+// handleXRange serves XRANGE and XREVRANGE, which takes its bounds end first.
+func handleXRange(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) != 4 && len(parts) != 6 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	reverse := command == XRevRangeCommand
+	low, high := parts[2], parts[3]
+	if reverse {
+		low, high = high, low
+	}
+
+	count := 0
+	if len(parts) == 6 {
+		if !strings.EqualFold(parts[4], "COUNT") {
+			util.WriteErr(w, internal.ErrSyntax)
+			return
+		}
+		n, err := strconv.Atoi(parts[5])
+		if err != nil {
+			util.WriteErr(w, internal.ErrNotInt)
+			return
+		}
+		if n <= 0 {
+			util.WriteArrayHeader(w, 0)
+			return
+		}
+		count = n
+	}
+
+	start, startOk, err := parseRangeID(low, true)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	end, endOk, err := parseRangeID(high, false)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if !startOk || !endOk {
+		util.WriteArrayHeader(w, 0)
+		return
+	}
+	entries, err := store.XRange(parts[1], start, end, count, reverse)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	writeStreamEntries(w, entries)
+}
+
+func handleXDel(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'XDEL' command")
+		return
+	}
+	ids, err := parseStreamIDs(parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	deleted, err := store.XDel(parts[1], ids)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, deleted)
+	if deleted > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleXTrim(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 4 {
+		util.WriteError(w, "wrong number of arguments for 'XTRIM' command")
+		return
+	}
+	trim, n, err := internal.ParseStreamTrim(parts[2:])
+	if err == nil && n != len(parts)-2 {
+		err = internal.ErrSyntax
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	removed, err := store.XTrim(parts[1], *trim)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, removed)
+	if removed > 0 {
+		propagate(aofWriter, replManager, append([]string{XTrimCommand, parts[1]}, trimArgs(trim)...)...)
+	}
+}
+
+// streamReadArgs holds the options shared by XREAD and XREADGROUP.
+type streamReadArgs struct {
+	group, consumer string
+	count           int
+	block           time.Duration
+	noAck           bool
+	keys, ids       []string
+}
+
+/*
+parseStreamReadArgs parses [GROUP group consumer] [COUNT count] [BLOCK ms] [NOACK]
+STREAMS key [key ...] id [id ...]. GROUP and NOACK are only accepted for XREADGROUP.
+Without BLOCK the returned block is negative.
+*/
+func parseStreamReadArgs(command string, args []string) (*streamReadArgs, error) {
+	read := &streamReadArgs{block: -1}
+	group := command == XReadGroupCommand
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "STREAMS" {
+			break
+		}
+		switch {
+		case option == "GROUP" && group && i+2 < len(args):
+			read.group, read.consumer = args[i+1], args[i+2]
+			i += 2
+		case option == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, internal.ErrNotInt
+			}
+			read.count = n
+			i++
+		case option == "BLOCK" && i+1 < len(args):
+			block, err := parseBlock(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			read.block = block
+			i++
+		case option == "NOACK" && group:
+			read.noAck = true
+		default:
+			return nil, internal.ErrSyntax
+		}
+	}
+	if group && read.group == "" {
+		return nil, errors.New("ERR Missing GROUP option for XREADGROUP")
+	}
+
+	streams := args[min(i+1, len(args)):]
+	if i == len(args) || len(streams) == 0 || len(streams)%2 != 0 {
+		return nil, errors.New("ERR Unbalanced '" + strings.ToLower(command) + "' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	read.keys, read.ids = streams[:len(streams)/2], streams[len(streams)/2:]
+	for _, id := range read.ids {
+		if id == ">" && !group {
+			return nil, errors.New("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		}
+		if id == "$" && group {
+			return nil, errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		}
+	}
+	return read, nil
+}
+
+func handleXRead(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 4 {
+		util.WriteError(w, "wrong number of arguments for 'XREAD' command")
+		return
+	}
+	read, err := parseStreamReadArgs(XReadCommand, parts[1:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	results, err := store.XRead(read.keys, read.ids, read.count, read.block)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	writeStreamResults(w, results)
+}
+
+func handleXReadGroup(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 7 {
+		util.WriteError(w, "wrong number of arguments for 'XREADGROUP' command")
+		return
+	}
+	read, err := parseStreamReadArgs(XReadGroupCommand, parts[1:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	results, err := store.XReadGroup(read.group, read.consumer, read.keys, read.ids, read.count, read.block, read.noAck)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	writeStreamResults(w, results)
+
+	for _, result := range results {
+		if len(result.Pending) > 0 {
+			propagateDeliveries(aofWriter, replManager, result.Key, read.group, result.Pending, result.GroupLastID)
+		} else if read.noAck && len(result.Entries) > 0 {
+			propagate(aofWriter, replManager, XGroupCommand, "SETID", result.Key, read.group, result.GroupLastID.String())
+		}
+	}
+}
+
+// handleXGroup serves the CREATE, SETID, DESTROY, CREATECONSUMER and DELCONSUMER subcommands.
+func handleXGroup(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 4 {
+		util.WriteError(w, "wrong number of arguments for 'XGROUP' command")
+		return
+	}
+	subcommand := strings.ToUpper(parts[1])
+	key, group := parts[2], parts[3]
+
+	switch subcommand {
+	case "CREATE", "SETID":
+		if len(parts) < 5 {
+			util.WriteError(w, "wrong number of arguments for 'XGROUP|"+subcommand+"' command")
+			return
+		}
+		mkStream := false
+		for _, option := range parts[5:] {
+			if subcommand != "CREATE" || !strings.EqualFold(option, "MKSTREAM") {
+				util.WriteErr(w, internal.ErrSyntax)
+				return
+			}
+			mkStream = true
+		}
+		var (
+			id  internal.StreamID
+			err error
+		)
+		if subcommand == "CREATE" {
+			id, err = store.XGroupCreate(key, group, parts[4], mkStream)
+		} else {
+			id, err = store.XGroupSetID(key, group, parts[4])
+		}
+		if err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		util.WriteString(w, "OK")
+		propagated := []string{XGroupCommand, subcommand, key, group, id.String()}
+		if mkStream {
+			propagated = append(propagated, "MKSTREAM")
+		}
+		propagate(aofWriter, replManager, propagated...)
+	case "DESTROY":
+		if len(parts) != 4 {
+			util.WriteError(w, "wrong number of arguments for 'XGROUP|DESTROY' command")
+			return
+		}
+		destroyed, err := store.XGroupDestroy(key, group)
+		if err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		if !destroyed {
+			util.WriteInteger(w, 0)
+			return
+		}
+		util.WriteInteger(w, 1)
+		propagate(aofWriter, replManager, parts...)
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(parts) != 5 {
+			util.WriteError(w, "wrong number of arguments for 'XGROUP|"+subcommand+"' command")
+			return
+		}
+		var (
+			n   int
+			err error
+		)
+		if subcommand == "CREATECONSUMER" {
+			var created bool
+			created, err = store.XGroupCreateConsumer(key, group, parts[4])
+			if created {
+				n = 1
+			}
+		} else {
+			n, err = store.XGroupDelConsumer(key, group, parts[4])
+		}
+		if err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		util.WriteInteger(w, n)
+		if subcommand == "DELCONSUMER" || n > 0 {
+			propagate(aofWriter, replManager, parts...)
+		}
+	default:
+		util.WriteError(w, "unknown subcommand '"+parts[1]+"'. Try XGROUP HELP.")
+	}
+}
+
+func handleXAck(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 4 {
+		util.WriteError(w, "wrong number of arguments for 'XACK' command")
+		return
+	}
+	ids, err := parseStreamIDs(parts[3:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	acked, err := store.XAck(parts[1], parts[2], ids)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, acked)
+	if acked > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+/*
+handleXPending serves both forms of XPENDING: the summary of the group PEL and
+the detailed listing XPENDING key group [IDLE min-idle] start end count [consumer].
+*/
+func handleXPending(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'XPENDING' command")
+		return
+	}
+	key, group := parts[1], parts[2]
+
+	if len(parts) == 3 {
+		summary, err := store.XPending(key, group)
+		if err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		util.WriteArrayHeader(w, 4)
+		util.WriteInteger(w, summary.Count)
+		if summary.Count == 0 {
+			util.WriteNull(w)
+			util.WriteNull(w)
+			util.WriteNullArray(w)
+			return
+		}
+		util.WriteBulk(w, []byte(summary.Min.String()))
+		util.WriteBulk(w, []byte(summary.Max.String()))
+		util.WriteArrayHeader(w, len(summary.Consumers))
+		for _, c := range summary.Consumers {
+			writeStringArray(w, []string{c.Name, strconv.Itoa(c.Count)})
+		}
+		return
+	}
+
+	args := parts[3:]
+	var minIdle time.Duration
+	if strings.EqualFold(args[0], "IDLE") && len(args) > 1 {
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			util.WriteErr(w, internal.ErrNotInt)
+			return
+		}
+		minIdle = time.Duration(ms) * time.Millisecond
+		args = args[2:]
+	}
+	if len(args) != 3 && len(args) != 4 {
+		util.WriteErr(w, internal.ErrSyntax)
+		return
+	}
+	start, startOk, err := parseRangeID(args[0], true)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	end, endOk, err := parseRangeID(args[1], false)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	consumer := ""
+	if len(args) == 4 {
+		consumer = args[3]
+	}
+	if !startOk || !endOk || count <= 0 {
+		// Still report a missing group rather than an empty listing.
+		if _, err := store.XPending(key, group); err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		util.WriteArrayHeader(w, 0)
+		return
+	}
+
+	pending, err := store.XPendingRange(key, group, start, end, count, consumer, minIdle)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	now := time.Now()
+	util.WriteArrayHeader(w, len(pending))
+	for _, p := range pending {
+		util.WriteArrayHeader(w, 4)
+		util.WriteBulk(w, []byte(p.ID.String()))
+		util.WriteBulk(w, []byte(p.Consumer))
+		util.WriteInteger(w, int(now.Sub(p.DeliveryTime).Milliseconds()))
+		util.WriteInteger(w, int(p.DeliveryCount))
+	}
+}
+
+func handleXClaim(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 6 {
+		util.WriteError(w, "wrong number of arguments for 'XCLAIM' command")
+		return
+	}
+	minIdle, ids, opts, err := internal.ParseXClaimArgs(parts[4:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	result, err := store.XClaim(parts[1], parts[2], parts[3], minIdle, ids, opts)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	writeClaimed(w, result.Entries, opts.JustID)
+	propagateDeliveries(aofWriter, replManager, parts[1], parts[2], result.Pending, result.GroupLastID)
+	propagateDeleted(aofWriter, replManager, parts[1], parts[2], result.Deleted)
+}
+
+func handleXAutoClaim(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 6 {
+		util.WriteError(w, "wrong number of arguments for 'XAUTOCLAIM' command")
+		return
+	}
+	ms, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		util.WriteError(w, "Invalid min-idle-time argument for XAUTOCLAIM")
+		return
+	}
+	start, ok, err := parseRangeID(parts[5], true)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if !ok {
+		start = internal.MaxStreamID
+	}
+
+	count, justID := 100, false
+	for i := 6; i < len(parts); i++ {
+		switch option := strings.ToUpper(parts[i]); {
+		case option == "COUNT" && i+1 < len(parts):
+			n, err := strconv.Atoi(parts[i+1])
+			if err != nil || n <= 0 {
+				util.WriteError(w, "COUNT must be > 0")
+				return
+			}
+			count = n
+			i++
+		case option == "JUSTID":
+			justID = true
+		default:
+			util.WriteErr(w, internal.ErrSyntax)
+			return
+		}
+	}
+
+	result, err := store.XAutoClaim(parts[1], parts[2], parts[3], time.Duration(ms)*time.Millisecond, start, count, justID)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteArrayHeader(w, 3)
+	util.WriteBulk(w, []byte(result.Next.String()))
+	writeClaimed(w, result.Entries, justID)
+	writeStreamIDs(w, result.Deleted)
+	propagateDeliveries(aofWriter, replManager, parts[1], parts[2], result.Pending, result.GroupLastID)
+	propagateDeleted(aofWriter, replManager, parts[1], parts[2], result.Deleted)
+}
@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	IncrCommand        = "INCR"
+	DecrCommand        = "DECR"
+	IncrByCommand      = "INCRBY"
+	DecrByCommand      = "DECRBY"
+	IncrByFloatCommand = "INCRBYFLOAT"
+	AppendCommand      = "APPEND"
+	StrLenCommand      = "STRLEN"
+	GetRangeCommand    = "GETRANGE"
+	SetRangeCommand    = "SETRANGE"
+	GetDelCommand      = "GETDEL"
+	GetExCommand       = "GETEX"
+	GetSetCommand      = "GETSET"
+	MGetCommand        = "MGET"
+	MSetCommand        = "MSET"
+	MSetNXCommand      = "MSETNX"
+)
+
+var errGetExTime = errors.New("ERR invalid expire time in 'getex' command")
+
+// handleIncr serves INCR, DECR, INCRBY and DECRBY.
+func handleIncr(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	withDelta := command == IncrByCommand || command == DecrByCommand
+	if (withDelta && len(parts) != 3) || (!withDelta && len(parts) != 2) {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+
+	delta := int64(1)
+	if withDelta {
+		n, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			util.WriteErr(w, internal.ErrNotInt)
+			return
+		}
+		delta = n
+	}
+	if command == DecrCommand || command == DecrByCommand {
+		if delta == math.MinInt64 {
+			util.WriteError(w, "decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+
+	value, err := store.IncrBy(parts[1], delta)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, int(value))
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleIncrByFloat(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'INCRBYFLOAT' command")
+		return
+	}
+	delta, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		util.WriteErr(w, internal.ErrNotFloat)
+		return
+	}
+	value, err := store.IncrByFloat(parts[1], delta)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteBulk(w, value)
+	// Like HINCRBYFLOAT, propagate the result so that replaying never depends on float rounding.
+	propagate(aofWriter, replManager, SetCommand, parts[1], string(value), "KEEPTTL")
+}
+
+func handleAppend(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'APPEND' command")
+		return
+	}
+	n, err := store.Append(parts[1], []byte(parts[2]))
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleStrLen(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'STRLEN' command")
+		return
+	}
+	n, err := store.StrLen(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+}
+
+func handleGetRange(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'GETRANGE' command")
+		return
+	}
+	start, err1 := strconv.Atoi(parts[2])
+	end, err2 := strconv.Atoi(parts[3])
+	if err1 != nil || err2 != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	value, err := store.GetRange(parts[1], start, end)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteBulk(w, value)
+}
+
+func handleSetRange(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'SETRANGE' command")
+		return
+	}
+	offset, err := strconv.Atoi(parts[2])
+	if err != nil {
+		util.WriteErr(w, internal.ErrNotInt)
+		return
+	}
+	n, err := store.SetRange(parts[1], offset, []byte(parts[3]))
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+	if len(parts[3]) > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleGetDel(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'GETDEL' command")
+		return
+	}
+	value, err := store.GetDel(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if value == nil {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, value)
+	propagate(aofWriter, replManager, DelCommand, parts[1])
+}
+
+/*
+handleGetEx serves GETEX key [EX seconds|PX milliseconds|EXAT unix-time|PXAT unix-time-ms|PERSIST].
+
+A relative expiry is propagated as an absolute PXAT so that replaying the AOF
+later does not push the deadline back.
+*/
+func handleGetEx(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'GETEX' command")
+		return
+	}
+
+	var expiresAt time.Time
+	persist := false
+	for i := 2; i < len(parts); i++ {
+		option := strings.ToUpper(parts[i])
+		if option == "PERSIST" && expiresAt.IsZero() && !persist {
+			persist = true
+			continue
+		}
+		if i+1 >= len(parts) || persist || !expiresAt.IsZero() {
+			util.WriteErr(w, internal.ErrSyntax)
+			return
+		}
+		n, err := strconv.ParseInt(parts[i+1], 10, 64)
+		if err != nil {
+			util.WriteErr(w, internal.ErrNotInt)
+			return
+		}
+		if n <= 0 {
+			util.WriteErr(w, errGetExTime)
+			return
+		}
+		switch option {
+		case "EX", "PX", "EXAT", "PXAT":
+		default:
+			util.WriteErr(w, internal.ErrSyntax)
+			return
+		}
+		ms, ok := internal.ExpireAtMillis(n, option == "EX" || option == "EXAT", option == "EX" || option == "PX")
+		if !ok {
+			util.WriteErr(w, errGetExTime)
+			return
+		}
+		expiresAt = time.UnixMilli(ms)
+		i++
+	}
+
+	value, err := store.GetEx(parts[1], expiresAt, persist)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if value == nil {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteBulk(w, value)
+
+	switch {
+	case persist:
+		propagate(aofWriter, replManager, GetExCommand, parts[1], "PERSIST")
+	case !expiresAt.IsZero() && !expiresAt.After(time.Now()):
+		propagate(aofWriter, replManager, DelCommand, parts[1])
+	case !expiresAt.IsZero():
+		propagate(aofWriter, replManager, GetExCommand, parts[1], "PXAT", strconv.FormatInt(expiresAt.UnixMilli(), 10))
+	}
+}
+
+func handleGetSet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'GETSET' command")
+		return
+	}
+	old, err := store.GetSet(parts[1], []byte(parts[2]))
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if old == nil {
+		util.WriteNull(w)
+	} else {
+		util.WriteBulk(w, old)
+	}
+	propagate(aofWriter, replManager, SetCommand, parts[1], parts[2])
+}
+
+func handleMGet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 2 {
+		util.WriteError(w, "wrong number of arguments for 'MGET' command")
+		return
+	}
+	util.WriteBulkArray(w, store.MGet(parts[1:]))
+}
+
+// handleMSet serves MSET and MSETNX.
+func handleMSet(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 3 || len(parts)%2 != 1 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	values := make(map[string][]byte, len(parts)/2)
+	for i := 1; i < len(parts); i += 2 {
+		values[parts[i]] = []byte(parts[i+1])
+	}
+
+	if command == MSetCommand {
+		store.MSet(values)
+		util.WriteString(w, "OK")
+	} else {
+		if !store.MSetNX(values) {
+			util.WriteInteger(w, 0)
+			return
+		}
+		util.WriteInteger(w, 1)
+	}
+	propagate(aofWriter, replManager, append([]string{MSetCommand}, parts[1:]...)...)
+}
@@ -0,0 +1,399 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	MultiCommand   = "MULTI"
+	ExecCommand    = "EXEC"
+	DiscardCommand = "DISCARD"
+	WatchCommand   = "WATCH"
+	UnwatchCommand = "UNWATCH"
+)
+
+var (
+	errNestedMulti  = errors.New("ERR MULTI calls can not be nested")
+	errExecNoMulti  = errors.New("ERR EXEC without MULTI")
+	errDiscardMulti = errors.New("ERR DISCARD without MULTI")
+	errWatchInMulti = errors.New("ERR WATCH inside MULTI is not allowed")
+	errExecAbort    = errors.New("EXECABORT Transaction discarded because of previous errors.")
+	errNotInMulti   = errors.New("ERR Command not allowed inside a transaction")
+)
+
+// blockingCommands may wait for another client to write a key, see Run.
+var blockingCommands = map[string]bool{
+	BLPopCommand:      true,
+	BRPopCommand:      true,
+	BLMoveCommand:     true,
+	BRPopLPushCommand: true,
+	BZPopMinCommand:   true,
+	BZPopMaxCommand:   true,
+	XReadCommand:      true,
+	XReadGroupCommand: true,
+}
+
+/*
+Transaction is the MULTI/EXEC state of a connection.
+
+Between MULTI and EXEC commands are queued instead of run. A command that can
+not be queued, unknown or refused for lack of memory, fails the transaction and
+EXEC then discards it. WATCH records the versions of keys in the store and EXEC
+discards the transaction when one of them was written in between.
+*/
+type Transaction struct {
+	// server resolves the commands, CommandHandlers alone when nil.
+	server   *Server
+	active   bool
+	failed   bool
+	queued   [][]string
+	watched  []string
+	versions []uint64
+}
+
+// NewTransaction returns the transaction state of a client of server.
+func NewTransaction(server *Server) *Transaction {
+	return &Transaction{server: server}
+}
+
+func (tx *Transaction) handler(command string) (CommandHandler, bool) {
+	if tx.server != nil {
+		return tx.server.Handler(command)
+	}
+	handler, ok := CommandHandlers[command]
+	return handler, ok
+}
+
+/*
+Handle serves MULTI, EXEC, DISCARD, WATCH and UNWATCH, and queues any other
+command while a transaction is open. It reports false when parts is a command
+to run right away.
+*/
+func (tx *Transaction) Handle(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) bool {
+	command := strings.ToUpper(parts[0])
+	switch command {
+	case MultiCommand:
+		if len(parts) != 1 {
+			util.WriteError(w, "wrong number of arguments for 'MULTI' command")
+		} else if tx.active {
+			util.WriteErr(w, errNestedMulti)
+		} else {
+			tx.active = true
+			util.WriteString(w, "OK")
+		}
+	case ExecCommand:
+		if len(parts) != 1 {
+			util.WriteError(w, "wrong number of arguments for 'EXEC' command")
+			tx.Fail()
+			return true
+		}
+		tx.exec(w, store, aofWriter, replManager)
+	case DiscardCommand:
+		if len(parts) != 1 {
+			util.WriteError(w, "wrong number of arguments for 'DISCARD' command")
+		} else if !tx.active {
+			util.WriteErr(w, errDiscardMulti)
+		} else {
+			tx.Reset(store)
+			util.WriteString(w, "OK")
+		}
+	case WatchCommand:
+		if len(parts) < 2 {
+			util.WriteError(w, "wrong number of arguments for 'WATCH' command")
+		} else if tx.active {
+			util.WriteErr(w, errWatchInMulti)
+		} else {
+			tx.watch(store, parts[1:])
+			util.WriteString(w, "OK")
+		}
+	case UnwatchCommand:
+		if len(parts) != 1 {
+			util.WriteError(w, "wrong number of arguments for 'UNWATCH' command")
+			tx.Fail()
+			return true
+		}
+		// Inside MULTI, UNWATCH is queued like any other command and has no effect.
+		if tx.active {
+			tx.queued = append(tx.queued, parts)
+			util.WriteString(w, "QUEUED")
+			return true
+		}
+		tx.unwatch(store)
+		util.WriteString(w, "OK")
+	default:
+		if !tx.active {
+			return false
+		}
+		// Pub/sub commands and connectionCommands change the state of the connection.
+		if pubsubCommands[command] || connectionCommands[command] {
+			tx.Fail()
+			util.WriteErr(w, errNotInMulti)
+			return true
+		}
+		if _, ok := tx.handler(command); !ok {
+			tx.Fail()
+			w.WriteError("ERR unknown command")
+			return true
+		}
+		tx.queued = append(tx.queued, parts)
+		util.WriteString(w, "QUEUED")
+	}
+	return true
+}
+
+// Active reports whether a MULTI is open.
+func (tx *Transaction) Active() bool {
+	return tx.active
+}
+
+// Queued returns the number of commands queued by the open MULTI, -1 outside of a transaction.
+func (tx *Transaction) Queued() int {
+	if !tx.active {
+		return -1
+	}
+	return len(tx.queued)
+}
+
+// Fail marks the open transaction as failed, EXEC will discard it. It does
+// nothing outside of a transaction.
+func (tx *Transaction) Fail() {
+	if tx.active {
+		tx.failed = true
+	}
+}
+
+// Reset drops the open transaction and the watched keys. The server calls it
+// when the connection closes.
+func (tx *Transaction) Reset(store internal.IStore) {
+	tx.active = false
+	tx.failed = false
+	tx.queued = nil
+	tx.unwatch(store)
+}
+
+func (tx *Transaction) watch(store internal.IStore, keys []string) {
+	var added []string
+	for _, key := range keys {
+		if !slices.Contains(tx.watched, key) && !slices.Contains(added, key) {
+			added = append(added, key)
+		}
+	}
+	tx.watched = append(tx.watched, added...)
+	tx.versions = append(tx.versions, store.Watch(added)...)
+}
+
+func (tx *Transaction) unwatch(store internal.IStore) {
+	if len(tx.watched) > 0 {
+		store.Unwatch(tx.watched)
+	}
+	tx.watched = nil
+	tx.versions = nil
+}
+
+/*
+exec runs the queued commands holding the store exclusively, so that no other
+command sees the transaction half done. Their writes are collected while they
+run and propagated as a single MULTI/EXEC block, which the AOF and the replicas
+apply as one unit.
+*/
+func (tx *Transaction) exec(w protocol.ReplyWriter, store internal.IStore, aofWriter aof.IAOF, replManager replication.IManager) {
+	if !tx.active {
+		util.WriteErr(w, errExecNoMulti)
+		return
+	}
+	queued, failed := tx.queued, tx.failed
+	defer tx.Reset(store)
+	if failed {
+		util.WriteErr(w, errExecAbort)
+		return
+	}
+
+	unlock := store.LockExclusive()
+	defer unlock()
+	if store.Modified(tx.watched, tx.versions) {
+		util.WriteNullArray(w)
+		return
+	}
+
+	txAOF := &transactionAOF{IAOF: aofWriter}
+	var txRepl *transactionReplication
+	var propagateRepl replication.IManager
+	if replManager != nil {
+		txRepl = &transactionReplication{IManager: replManager}
+		propagateRepl = txRepl
+	}
+	nonBlocking := nonBlockingStore{store}
+	util.WriteArrayHeader(w, len(queued))
+	for _, parts := range queued {
+		command := strings.ToUpper(parts[0])
+		if command == UnwatchCommand {
+			util.WriteString(w, "OK")
+			continue
+		}
+		handler, _ := tx.handler(command)
+		handler(w, nonBlocking, parts, txAOF, propagateRepl)
+	}
+
+	if aofWriter != nil && len(txAOF.commands) > 0 {
+		if err := aofWriter.AppendTransaction(txAOF.commands); err != nil {
+			log.Printf("[AOF] failed to append transaction: %v", err)
+		}
+	}
+	if txRepl != nil && len(txRepl.commands) > 0 {
+		replManager.BroadcastTransaction(txRepl.commands)
+	}
+}
+
+/*
+Run calls handler holding the store shared, so that the command never runs in
+the middle of an EXEC. A blocking command lets the lock go while it waits, see
+waitingStore: a client waiting for a key must not hold back the EXEC that may be
+the one to write it.
+*/
+func Run(handler CommandHandler, w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if !blockingCommands[strings.ToUpper(parts[0])] {
+		unlock := store.LockShared()
+		defer unlock()
+		handler(w, store, parts, aofWriter, replManager)
+		return
+	}
+	// The replies to the commands pipelined before must not wait for the key.
+	w.Flush()
+	waiting := &waitingStore{IStore: store, unlock: store.LockShared()}
+	defer func() { waiting.unlock() }()
+	handler(w, waiting, parts, aofWriter, replManager)
+}
+
+// transactionAOF collects the commands an EXEC appends to the AOF. SAVE resets
+// the AOF to the snapshot it takes, which holds the writes collected so far.
+type transactionAOF struct {
+	aof.IAOF
+	commands [][]string
+}
+
+func (a *transactionAOF) AppendCommand(args ...string) error {
+	a.commands = append(a.commands, args)
+	return nil
+}
+
+func (a *transactionAOF) Reset() error {
+	a.commands = nil
+	return a.IAOF.Reset()
+}
+
+// transactionReplication collects the commands an EXEC forwards to the replicas.
+type transactionReplication struct {
+	replication.IManager
+	commands [][]string
+}
+
+func (r *transactionReplication) Broadcast(parts []string) {
+	r.commands = append(r.commands, parts)
+}
+
+// nonBlockingStore runs the blocking commands of a transaction without
+// blocking, as if their timeout had elapsed right away.
+type nonBlockingStore struct {
+	internal.IStore
+}
+
+func (s nonBlockingStore) BLPop(keys []string, timeout time.Duration) (string, []byte, error) {
+	return s.IStore.BLPop(keys, -1)
+}
+
+func (s nonBlockingStore) BRPop(keys []string, timeout time.Duration) (string, []byte, error) {
+	return s.IStore.BRPop(keys, -1)
+}
+
+func (s nonBlockingStore) BLMove(source, destination string, fromLeft, toLeft bool, timeout time.Duration) ([]byte, error) {
+	return s.IStore.BLMove(source, destination, fromLeft, toLeft, -1)
+}
+
+func (s nonBlockingStore) BZPopMin(keys []string, timeout time.Duration) (string, *internal.ZMember, error) {
+	return s.IStore.BZPopMin(keys, -1)
+}
+
+func (s nonBlockingStore) BZPopMax(keys []string, timeout time.Duration) (string, *internal.ZMember, error) {
+	return s.IStore.BZPopMax(keys, -1)
+}
+
+func (s nonBlockingStore) XRead(keys, ids []string, count int, block time.Duration) ([]internal.StreamReadResult, error) {
+	return s.IStore.XRead(keys, ids, count, -1)
+}
+
+func (s nonBlockingStore) XReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) ([]internal.StreamReadResult, error) {
+	return s.IStore.XReadGroup(group, consumer, keys, ids, count, -1, noAck)
+}
+
+/*
+waitingStore runs a blocking command for Run. The lock of Run is let go around
+the store operations that may wait, which take it again themselves around every
+attempt to serve the command, and is taken back once they return.
+
+A client woken by a write is served in the goroutine of the writer, which holds
+the lock until it has propagated the write. Waiting for every running command to
+finish before taking the lock back keeps the served pop from reaching the AOF
+and the replicas ahead of the push that fed it.
+*/
+type waitingStore struct {
+	internal.IStore
+	unlock func()
+}
+
+func (s *waitingStore) wait(timeout time.Duration, operation func()) {
+	if timeout < 0 {
+		operation()
+		return
+	}
+	s.unlock()
+	defer func() {
+		s.IStore.LockExclusive()()
+		s.unlock = s.IStore.LockShared()
+	}()
+	operation()
+}
+
+func (s *waitingStore) BLPop(keys []string, timeout time.Duration) (key string, value []byte, err error) {
+	s.wait(timeout, func() { key, value, err = s.IStore.BLPop(keys, timeout) })
+	return key, value, err
+}
+
+func (s *waitingStore) BRPop(keys []string, timeout time.Duration) (key string, value []byte, err error) {
+	s.wait(timeout, func() { key, value, err = s.IStore.BRPop(keys, timeout) })
+	return key, value, err
+}
+
+func (s *waitingStore) BLMove(source, destination string, fromLeft, toLeft bool, timeout time.Duration) (value []byte, err error) {
+	s.wait(timeout, func() { value, err = s.IStore.BLMove(source, destination, fromLeft, toLeft, timeout) })
+	return value, err
+}
+
+func (s *waitingStore) BZPopMin(keys []string, timeout time.Duration) (key string, member *internal.ZMember, err error) {
+	s.wait(timeout, func() { key, member, err = s.IStore.BZPopMin(keys, timeout) })
+	return key, member, err
+}
+
+func (s *waitingStore) BZPopMax(keys []string, timeout time.Duration) (key string, member *internal.ZMember, err error) {
+	s.wait(timeout, func() { key, member, err = s.IStore.BZPopMax(keys, timeout) })
+	return key, member, err
+}
+
+func (s *waitingStore) XRead(keys, ids []string, count int, block time.Duration) (results []internal.StreamReadResult, err error) {
+	s.wait(block, func() { results, err = s.IStore.XRead(keys, ids, count, block) })
+	return results, err
+}
+
+func (s *waitingStore) XReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (results []internal.StreamReadResult, err error) {
+	s.wait(block, func() { results, err = s.IStore.XReadGroup(group, consumer, keys, ids, count, block, noAck) })
+	return results, err
+}
@@ -0,0 +1,469 @@
+package cmd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	internal "github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+const (
+	ZAddCommand             = "ZADD"
+	ZIncrByCommand          = "ZINCRBY"
+	ZRemCommand             = "ZREM"
+	ZScoreCommand           = "ZSCORE"
+	ZCardCommand            = "ZCARD"
+	ZRankCommand            = "ZRANK"
+	ZRevRankCommand         = "ZREVRANK"
+	ZRangeCommand           = "ZRANGE"
+	ZRevRangeCommand        = "ZREVRANGE"
+	ZRangeByScoreCommand    = "ZRANGEBYSCORE"
+	ZRevRangeByScoreCommand = "ZREVRANGEBYSCORE"
+	ZRangeByLexCommand      = "ZRANGEBYLEX"
+	ZRevRangeByLexCommand   = "ZREVRANGEBYLEX"
+	ZCountCommand           = "ZCOUNT"
+	ZPopMinCommand          = "ZPOPMIN"
+	ZPopMaxCommand          = "ZPOPMAX"
+	BZPopMinCommand         = "BZPOPMIN"
+	BZPopMaxCommand         = "BZPOPMAX"
+	ZUnionStoreCommand      = "ZUNIONSTORE"
+	ZInterStoreCommand      = "ZINTERSTORE"
+)
+
+var (
+	errScoreRange = errors.New("ERR min or max is not a float")
+	errLexRange   = errors.New("ERR min or max not valid string range item")
+)
+
+// parseScoreBound parses a score range end such as 1.5, (1.5, -inf or +inf.
+func parseScoreBound(s string) (internal.ScoreBound, error) {
+	bound := internal.ScoreBound{}
+	if strings.HasPrefix(s, "(") {
+		bound.Exclusive = true
+		s = s[1:]
+	}
+	value, err := internal.ParseScore(s)
+	if err != nil {
+		return bound, errScoreRange
+	}
+	bound.Value = value
+	return bound, nil
+}
+
+// parseLexBound parses a lexicographical range end: [a, (a, - or +.
+func parseLexBound(s string) (internal.LexBound, error) {
+	switch {
+	case s == "-":
+		return internal.LexBound{Inf: -1}, nil
+	case s == "+":
+		return internal.LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "["):
+		return internal.LexBound{Value: s[1:]}, nil
+	case strings.HasPrefix(s, "("):
+		return internal.LexBound{Value: s[1:], Exclusive: true}, nil
+	}
+	return internal.LexBound{}, errLexRange
+}
+
+/*
+writeZMembers writes members, followed by their score with withScores. RESP2
+clients get a flat array alternating members and scores, RESP3 clients an array
+of [member, score] pairs with the scores as doubles.
+*/
+func writeZMembers(w protocol.ReplyWriter, members []internal.ZMember, withScores bool) {
+	if withScores && w.Protocol() >= protocol.RESP3 {
+		w.WriteArrayHeader(len(members))
+		for _, m := range members {
+			w.WriteArrayHeader(2)
+			w.WriteBulkString(m.Member)
+			w.WriteDouble(m.Score)
+		}
+		return
+	}
+	items := make([][]byte, 0, len(members)*2)
+	for _, m := range members {
+		items = append(items, []byte(m.Member))
+		if withScores {
+			items = append(items, []byte(internal.FormatScore(m.Score)))
+		}
+	}
+	util.WriteBulkArray(w, items)
+}
+
+// writeZMember writes a single member and its score as a flat pair, the reply of ZPOPMIN and ZPOPMAX without a count.
+func writeZMember(w protocol.ReplyWriter, m internal.ZMember) {
+	w.WriteArrayHeader(2)
+	w.WriteBulkString(m.Member)
+	w.WriteDouble(m.Score)
+}
+
+func handleZAdd(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 4 {
+		util.WriteError(w, "wrong number of arguments for 'ZADD' command")
+		return
+	}
+	members, opts, incr, err := internal.ParseZAddArgs(parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+
+	if incr {
+		score, ok, err := store.ZAddIncr(parts[1], members[0].Member, members[0].Score, opts)
+		if err != nil {
+			util.WriteErr(w, err)
+			return
+		}
+		if !ok {
+			util.WriteNull(w)
+			return
+		}
+		w.WriteDouble(score)
+		propagate(aofWriter, replManager, "ZADD", parts[1], internal.FormatScore(score), members[0].Member)
+		return
+	}
+
+	changed, err := store.ZAdd(parts[1], members, opts)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, changed)
+	propagate(aofWriter, replManager, parts...)
+}
+
+func handleZIncrBy(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'ZINCRBY' command")
+		return
+	}
+	delta, err := internal.ParseScore(parts[2])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	score, _, err := store.ZAddIncr(parts[1], parts[3], delta, internal.ZAddOptions{})
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	w.WriteDouble(score)
+	propagate(aofWriter, replManager, "ZADD", parts[1], internal.FormatScore(score), parts[3])
+}
+
+func handleZRem(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for 'ZREM' command")
+		return
+	}
+	removed, err := store.ZRem(parts[1], parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, removed)
+	if removed > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+func handleZScore(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for 'ZSCORE' command")
+		return
+	}
+	score, ok, err := store.ZScore(parts[1], parts[2])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if !ok {
+		util.WriteNull(w)
+		return
+	}
+	w.WriteDouble(score)
+}
+
+func handleZCard(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 2 {
+		util.WriteError(w, "wrong number of arguments for 'ZCARD' command")
+		return
+	}
+	n, err := store.ZCard(parts[1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+}
+
+// handleZRank serves ZRANK and ZREVRANK.
+func handleZRank(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) != 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	rank, ok, err := store.ZRank(parts[1], parts[2], command == ZRevRankCommand)
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if !ok {
+		util.WriteNull(w)
+		return
+	}
+	util.WriteInteger(w, rank)
+}
+
+type zrangeBy int
+
+const (
+	zrangeByRank zrangeBy = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+/*
+handleZRange serves ZRANGE and its legacy variants ZREVRANGE, ZRANGEBYSCORE,
+ZREVRANGEBYSCORE, ZRANGEBYLEX and ZREVRANGEBYLEX, which are all mapped onto
+the ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES] form.
+*/
+func handleZRange(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 4 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+
+	by := zrangeByRank
+	reverse := false
+	switch command {
+	case ZRevRangeCommand:
+		reverse = true
+	case ZRangeByScoreCommand:
+		by = zrangeByScore
+	case ZRevRangeByScoreCommand:
+		by, reverse = zrangeByScore, true
+	case ZRangeByLexCommand:
+		by = zrangeByLex
+	case ZRevRangeByLexCommand:
+		by, reverse = zrangeByLex, true
+	}
+
+	withScores, hasLimit := false, false
+	offset, count := 0, -1
+	for i := 4; i < len(parts); i++ {
+		switch option := strings.ToUpper(parts[i]); {
+		case option == "WITHSCORES":
+			withScores = true
+		case option == "LIMIT" && i+2 < len(parts):
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(parts[i+1])
+			count, err2 = strconv.Atoi(parts[i+2])
+			if err1 != nil || err2 != nil {
+				util.WriteErr(w, internal.ErrNotInt)
+				return
+			}
+			hasLimit = true
+			i += 2
+		case option == "BYSCORE" && command == ZRangeCommand:
+			by = zrangeByScore
+		case option == "BYLEX" && command == ZRangeCommand:
+			by = zrangeByLex
+		case option == "REV" && command == ZRangeCommand:
+			reverse = true
+		default:
+			util.WriteErr(w, internal.ErrSyntax)
+			return
+		}
+	}
+	if hasLimit && by == zrangeByRank {
+		util.WriteError(w, "syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+		return
+	}
+	if withScores && by == zrangeByLex {
+		util.WriteError(w, "syntax error, WITHSCORES not supported in combination with BYLEX")
+		return
+	}
+	if offset < 0 {
+		util.WriteArrayHeader(w, 0)
+		return
+	}
+
+	// With REV the range is given from the highest to the lowest end. The legacy
+	// ZREVRANGEBY* commands take it in that order too.
+	low, high := parts[2], parts[3]
+	if reverse && by != zrangeByRank {
+		low, high = high, low
+	}
+
+	var (
+		members []internal.ZMember
+		err     error
+	)
+	switch by {
+	case zrangeByRank:
+		start, err1 := strconv.Atoi(low)
+		stop, err2 := strconv.Atoi(high)
+		if err1 != nil || err2 != nil {
+			util.WriteErr(w, internal.ErrNotInt)
+			return
+		}
+		members, err = store.ZRangeByRank(parts[1], start, stop, reverse)
+	case zrangeByScore:
+		min, err1 := parseScoreBound(low)
+		max, err2 := parseScoreBound(high)
+		if err1 != nil || err2 != nil {
+			util.WriteErr(w, errScoreRange)
+			return
+		}
+		members, err = store.ZRangeByScore(parts[1], internal.ScoreRange{Min: min, Max: max}, reverse, offset, count)
+	case zrangeByLex:
+		min, err1 := parseLexBound(low)
+		max, err2 := parseLexBound(high)
+		if err1 != nil || err2 != nil {
+			util.WriteErr(w, errLexRange)
+			return
+		}
+		members, err = store.ZRangeByLex(parts[1], internal.LexRange{Min: min, Max: max}, reverse, offset, count)
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	writeZMembers(w, members, withScores)
+}
+
+func handleZCount(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	if len(parts) != 4 {
+		util.WriteError(w, "wrong number of arguments for 'ZCOUNT' command")
+		return
+	}
+	min, err1 := parseScoreBound(parts[2])
+	max, err2 := parseScoreBound(parts[3])
+	if err1 != nil || err2 != nil {
+		util.WriteErr(w, errScoreRange)
+		return
+	}
+	n, err := store.ZCount(parts[1], internal.ScoreRange{Min: min, Max: max})
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+}
+
+// handleZPop serves ZPOPMIN and ZPOPMAX.
+func handleZPop(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 2 || len(parts) > 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	count := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 0 {
+			util.WriteError(w, "value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	var (
+		members []internal.ZMember
+		err     error
+	)
+	if command == ZPopMinCommand {
+		members, err = store.ZPopMin(parts[1], count)
+	} else {
+		members, err = store.ZPopMax(parts[1], count)
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if len(parts) == 2 && len(members) == 1 {
+		writeZMember(w, members[0])
+	} else {
+		writeZMembers(w, members, true)
+	}
+	if len(members) > 0 {
+		propagate(aofWriter, replManager, parts...)
+	}
+}
+
+// handleBZPop serves BZPOPMIN and BZPOPMAX, parking the connection until a
+// member can be popped or the timeout elapses.
+func handleBZPop(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 3 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	timeout, err := parseTimeout(parts[len(parts)-1])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	keys := parts[1 : len(parts)-1]
+
+	var (
+		key    string
+		member *internal.ZMember
+	)
+	if command == BZPopMinCommand {
+		key, member, err = store.BZPopMin(keys, timeout)
+	} else {
+		key, member, err = store.BZPopMax(keys, timeout)
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	if member == nil {
+		util.WriteNullArray(w)
+		return
+	}
+	w.WriteArrayHeader(3)
+	w.WriteBulkString(key)
+	w.WriteBulkString(member.Member)
+	w.WriteDouble(member.Score)
+
+	pop := ZPopMinCommand
+	if command == BZPopMaxCommand {
+		pop = ZPopMaxCommand
+	}
+	propagate(aofWriter, replManager, pop, key)
+}
+
+// handleZStore serves ZUNIONSTORE and ZINTERSTORE.
+func handleZStore(w protocol.ReplyWriter, store internal.IStore, parts []string, aofWriter aof.IAOF, replManager replication.IManager) {
+	command := strings.ToUpper(parts[0])
+	if len(parts) < 4 {
+		util.WriteError(w, "wrong number of arguments for '"+command+"' command")
+		return
+	}
+	keys, weights, aggregate, err := internal.ParseZStoreArgs(parts[2:])
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	var n int
+	if command == ZUnionStoreCommand {
+		n, err = store.ZUnionStore(parts[1], keys, weights, aggregate)
+	} else {
+		n, err = store.ZInterStore(parts[1], keys, weights, aggregate)
+	}
+	if err != nil {
+		util.WriteErr(w, err)
+		return
+	}
+	util.WriteInteger(w, n)
+	propagate(aofWriter, replManager, parts...)
+}
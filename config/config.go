@@ -1,7 +1,306 @@
+/*
+Package config holds the settings of the server. They are read from a
+redis.conf-style file, the environment and the command line, see Load, and
+some of them can be changed while the server runs with CONFIG SET, see
+Manager.
+*/
 package config
 
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/pubsub"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/tlsconfig"
+)
+
+// Config holds every setting. A Config shared through a Manager is never
+// modified, CONFIG SET replaces it with a changed copy.
 type Config struct {
 	Addr       string
+	TLSAddr    string
 	Role       string // "master" or "replica"
 	MasterAddr string // use when role == "replica"
+
+	Shards            int
+	DBFilename        string
+	AppendFilename    string
+	ClusterConfigFile string
+	// AutosaveInterval is the time between two snapshots, 0 disables them.
+	AutosaveInterval time.Duration
+
+	ActiveExpireCPU      int
+	MaxMemory            int64
+	MaxMemoryPolicy      store.EvictionPolicy
+	NotifyKeyspaceEvents store.NotifyFlags
+	PubSubOutputLimit    int64
+	ProtoMaxBulkLen      int64
+	ProtoMaxMultiBulkLen int64
+
+	ACLFile     string
+	RequirePass string
+
+	TLSCertFile    string
+	TLSKeyFile     string
+	TLSCACertFile  string
+	TLSAuthClients tls.ClientAuthType
+	TLSReplication bool
+}
+
+// Default returns the settings of a server started without configuration.
+func Default() *Config {
+	limits := protocol.DefaultLimits()
+	return &Config{
+		Addr:                 ":6379",
+		Role:                 "master",
+		Shards:               16,
+		DBFilename:           "snapshot.fdb",
+		AppendFilename:       "appendonly.aof",
+		ClusterConfigFile:    "cluster.json",
+		AutosaveInterval:     5 * time.Minute,
+		ActiveExpireCPU:      store.DefaultExpireCPUPercent,
+		MaxMemoryPolicy:      store.NoEviction,
+		PubSubOutputLimit:    pubsub.DefaultOutputLimit,
+		ProtoMaxBulkLen:      limits.MaxBulkLen,
+		ProtoMaxMultiBulkLen: limits.MaxMultiBulkLen,
+		TLSAuthClients:       tls.NoClientCert,
+	}
+}
+
+// Limits returns the limits requests are parsed with.
+func (c *Config) Limits() protocol.Limits {
+	return protocol.Limits{MaxBulkLen: c.ProtoMaxBulkLen, MaxMultiBulkLen: c.ProtoMaxMultiBulkLen}
+}
+
+// TLSOptions returns the options the TLS certificates are loaded with.
+func (c *Config) TLSOptions() tlsconfig.Options {
+	return tlsconfig.Options{CertFile: c.TLSCertFile, KeyFile: c.TLSKeyFile, CAFile: c.TLSCACertFile, ClientAuth: c.TLSAuthClients}
+}
+
+// TLSEnabled reports whether a certificate is configured.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || c.TLSKeyFile != ""
+}
+
+// Validate checks the settings that depend on each other.
+func (c *Config) Validate() error {
+	switch {
+	case c.Role == "replica" && c.MasterAddr == "":
+		return errors.New("role replica needs master-addr")
+	case c.TLSEnabled() && (c.TLSCertFile == "" || c.TLSKeyFile == ""):
+		return errors.New("tls-cert-file and tls-key-file must be set together")
+	case c.TLSAddr != "" && !c.TLSEnabled():
+		return errors.New("tls-addr needs tls-cert-file and tls-key-file")
+	case c.TLSReplication && !c.TLSEnabled():
+		return errors.New("tls-replication needs tls-cert-file and tls-key-file")
+	case c.TLSAuthClients != tls.NoClientCert && c.TLSCACertFile == "":
+		return errors.New("tls-auth-clients needs tls-ca-cert-file")
+	}
+	return nil
+}
+
+/*
+param is a setting as the file, the environment, the command line and CONFIG
+know it. set parses and checks value before storing it in c, get formats it
+back the way set accepts it. Only mutable params can be changed by CONFIG SET.
+*/
+type param struct {
+	name    string
+	mutable bool
+	get     func(c *Config) string
+	set     func(c *Config, value string) error
+}
+
+// params lists the settings, in the order CONFIG REWRITE appends them.
+var params = []param{
+	stringParam("addr", false, func(c *Config) *string { return &c.Addr }),
+	stringParam("tls-addr", false, func(c *Config) *string { return &c.TLSAddr }),
+	enumParam("role", false, []string{"master", "replica"}, func(c *Config) *string { return &c.Role }),
+	stringParam("master-addr", false, func(c *Config) *string { return &c.MasterAddr }),
+	intParam("shards", false, 1, 1<<16, func(c *Config) *int { return &c.Shards }),
+	stringParam("dbfilename", true, func(c *Config) *string { return &c.DBFilename }),
+	stringParam("appendfilename", false, func(c *Config) *string { return &c.AppendFilename }),
+	stringParam("cluster-config-file", false, func(c *Config) *string { return &c.ClusterConfigFile }),
+	{
+		name:    "autosave-interval",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.Itoa(int(c.AutosaveInterval / time.Second)) },
+		set: func(c *Config, value string) error {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return errors.New("argument must be a number of seconds, 0 to disable")
+			}
+			c.AutosaveInterval = time.Duration(seconds) * time.Second
+			return nil
+		},
+	},
+	intParam("active-expire-cpu", true, 1, 100, func(c *Config) *int { return &c.ActiveExpireCPU }),
+	memoryParam("maxmemory", true, 0, func(c *Config) *int64 { return &c.MaxMemory }),
+	{
+		name:    "maxmemory-policy",
+		mutable: true,
+		get:     func(c *Config) string { return c.MaxMemoryPolicy.String() },
+		set: func(c *Config, value string) error {
+			policy, err := store.ParseEvictionPolicy(value)
+			if err != nil {
+				return errors.New("argument(s) must be one of the following: noeviction, allkeys-lru, volatile-lru, allkeys-lfu, volatile-lfu, allkeys-random, volatile-random, volatile-ttl")
+			}
+			c.MaxMemoryPolicy = policy
+			return nil
+		},
+	},
+	{
+		name:    "notify-keyspace-events",
+		mutable: true,
+		get:     func(c *Config) string { return c.NotifyKeyspaceEvents.String() },
+		set: func(c *Config, value string) error {
+			flags, err := store.ParseNotifyFlags(value)
+			if err != nil {
+				// The reply adds its own ERR code in front of the reason.
+				return errors.New(strings.TrimPrefix(err.Error(), "ERR "))
+			}
+			c.NotifyKeyspaceEvents = flags
+			return nil
+		},
+	},
+	memoryParam("pubsub-output-limit", true, 0, func(c *Config) *int64 { return &c.PubSubOutputLimit }),
+	// Like proto-max-multibulk-len, the request limit can not be lifted.
+	memoryParam("proto-max-bulk-len", true, 1, func(c *Config) *int64 { return &c.ProtoMaxBulkLen }),
+	{
+		name:    "proto-max-multibulk-len",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.FormatInt(c.ProtoMaxMultiBulkLen, 10) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 1 {
+				return errors.New("argument must be a positive integer")
+			}
+			c.ProtoMaxMultiBulkLen = n
+			return nil
+		},
+	},
+	stringParam("aclfile", false, func(c *Config) *string { return &c.ACLFile }),
+	stringParam("requirepass", true, func(c *Config) *string { return &c.RequirePass }),
+	stringParam("tls-cert-file", false, func(c *Config) *string { return &c.TLSCertFile }),
+	stringParam("tls-key-file", false, func(c *Config) *string { return &c.TLSKeyFile }),
+	stringParam("tls-ca-cert-file", false, func(c *Config) *string { return &c.TLSCACertFile }),
+	{
+		name: "tls-auth-clients",
+		get:  func(c *Config) string { return tlsconfig.FormatClientAuth(c.TLSAuthClients) },
+		set: func(c *Config, value string) error {
+			clientAuth, err := tlsconfig.ParseClientAuth(value)
+			if err != nil {
+				return errors.New("argument(s) must be one of the following: no, optional, yes")
+			}
+			c.TLSAuthClients = clientAuth
+			return nil
+		},
+	},
+	{
+		name: "tls-replication",
+		get:  func(c *Config) string { return formatBool(c.TLSReplication) },
+		set: func(c *Config, value string) error {
+			b, err := parseBool(value)
+			c.TLSReplication = b
+			return err
+		},
+	},
+}
+
+// lookup returns the param named name, case-insensitively.
+func lookup(name string) (param, bool) {
+	for _, p := range params {
+		if strings.EqualFold(p.name, name) {
+			return p, true
+		}
+	}
+	return param{}, false
+}
+
+func stringParam(name string, mutable bool, field func(c *Config) *string) param {
+	return param{
+		name:    name,
+		mutable: mutable,
+		get:     func(c *Config) string { return *field(c) },
+		set: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+func enumParam(name string, mutable bool, values []string, field func(c *Config) *string) param {
+	return param{
+		name:    name,
+		mutable: mutable,
+		get:     func(c *Config) string { return *field(c) },
+		set: func(c *Config, value string) error {
+			for _, v := range values {
+				if strings.EqualFold(v, value) {
+					*field(c) = v
+					return nil
+				}
+			}
+			return errors.New("argument(s) must be one of the following: " + strings.Join(values, ", "))
+		},
+	}
+}
+
+func intParam(name string, mutable bool, minimum, maximum int, field func(c *Config) *int) param {
+	return param{
+		name:    name,
+		mutable: mutable,
+		get:     func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < minimum || n > maximum {
+				return fmt.Errorf("argument must be between %d and %d inclusive", minimum, maximum)
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+// memoryParam is a size in bytes of at least minimum, given with the units of store.ParseMemory.
+func memoryParam(name string, mutable bool, minimum int64, field func(c *Config) *int64) param {
+	return param{
+		name:    name,
+		mutable: mutable,
+		get:     func(c *Config) string { return strconv.FormatInt(*field(c), 10) },
+		set: func(c *Config, value string) error {
+			n, err := store.ParseMemory(value)
+			if err != nil {
+				return errors.New("argument must be a memory value")
+			}
+			if n < minimum {
+				return fmt.Errorf("argument must be a memory value of at least %d", minimum)
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
 }
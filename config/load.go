@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+)
+
+/*
+Load returns the settings of a server started with args, the command line
+without the program name, and the environment environ, as os.Environ returns
+it, along with the path of the configuration file, empty when there is none:
+
+	flashdb [/path/to/flashdb.conf] [--name value ...]
+
+Every setting is taken from the first of these to give it: the command line,
+the FLASHDB_<NAME> environment variable, such as FLASHDB_MAXMEMORY_POLICY for
+maxmemory-policy, the file, and the defaults. The result is validated as a
+whole.
+*/
+func Load(args, environ []string) (*Config, string, error) {
+	c := Default()
+	var file string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		file, args = args[0], args[1:]
+		if err := c.readFile(file); err != nil {
+			return nil, "", err
+		}
+	}
+
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+	for _, p := range params {
+		if value, ok := env[EnvName(p.name)]; ok {
+			if err := p.set(c, value); err != nil {
+				return nil, "", fmt.Errorf("invalid %s: %v", EnvName(p.name), err)
+			}
+		}
+	}
+
+	for len(args) > 0 {
+		name, ok := strings.CutPrefix(args[0], "--")
+		if !ok || len(args) < 2 {
+			return nil, "", fmt.Errorf("invalid argument %q, expected --name value", args[0])
+		}
+		if err := c.apply(name, args[1]); err != nil {
+			return nil, "", err
+		}
+		args = args[2:]
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, "", err
+	}
+	return c, file, nil
+}
+
+// EnvName returns the environment variable overriding the setting name.
+func EnvName(name string) string {
+	return "FLASHDB_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// apply sets the setting name, whether mutable or not.
+func (c *Config) apply(name, value string) error {
+	p, ok := lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown setting '%s'", name)
+	}
+	if err := p.set(c, value); err != nil {
+		return fmt.Errorf("invalid %s: %v", p.name, err)
+	}
+	return nil
+}
+
+// readFile applies the settings of a file holding one "name value" per line.
+// Values are quoted like inline commands, blank lines and lines starting with # are skipped.
+func (c *Config) readFile(path string) error {
+	lines, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range lines {
+		args, err := splitLine(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		if args == nil {
+			continue
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("%s:%d: expected a setting name and its value", path, i+1)
+		}
+		if err := c.apply(args[0], args[1]); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the config file: %w", err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the config file: %w", err)
+	}
+	return lines, nil
+}
+
+// splitLine returns the arguments of a line of the file, nil for a blank line or a comment.
+func splitLine(line string) ([]string, error) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return nil, nil
+	}
+	args, err := protocol.SplitArgs(trimmed)
+	if err != nil {
+		return nil, errors.New("unbalanced quotes")
+	}
+	return args, nil
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+var errNoConfigFile = errors.New("ERR The server is running without a config file")
+
+// Setting is a setting as CONFIG GET reports it.
+type Setting struct {
+	Name  string
+	Value string
+}
+
+/*
+Manager holds the settings of a running server. Current returns them, CONFIG
+SET replaces them with Set, which then calls the functions the parts of the
+server registered with OnChange for the settings changed.
+*/
+type Manager struct {
+	file    string
+	current atomic.Pointer[Config]
+
+	// mu orders the changes, and the calls of the OnChange functions with them.
+	mu       sync.Mutex
+	onChange map[string][]func(c *Config)
+}
+
+// NewManager returns a Manager starting with c, read from file, empty when
+// there is none, which Rewrite then writes to.
+func NewManager(c *Config, file string) *Manager {
+	m := &Manager{file: file, onChange: make(map[string][]func(c *Config))}
+	m.current.Store(c)
+	return m
+}
+
+// File returns the path of the configuration file, empty when there is none.
+func (m *Manager) File() string {
+	return m.file
+}
+
+// Current returns the settings in use. They must not be modified.
+func (m *Manager) Current() *Config {
+	return m.current.Load()
+}
+
+// OnChange registers fn to be called with the new settings when Set changes the setting name.
+func (m *Manager) OnChange(name string, fn func(c *Config)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange[name] = append(m.onChange[name], fn)
+}
+
+// Get returns the settings whose name matches one of the glob patterns, sorted by name.
+func (m *Manager) Get(patterns ...string) []Setting {
+	c := m.Current()
+	var settings []Setting
+	for _, p := range params {
+		for _, pattern := range patterns {
+			if util.GlobMatch(strings.ToLower(pattern), p.name) {
+				settings = append(settings, Setting{Name: p.name, Value: p.get(c)})
+				break
+			}
+		}
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	return settings
+}
+
+/*
+Set changes settings given as name, value pairs. Either they all change or none
+does: the error names the first setting refused, unknown, immutable or given an
+invalid value, or reports the settings invalid together.
+*/
+func (m *Manager) Set(pairs ...string) error {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return errors.New("ERR wrong number of arguments for 'config|set' command")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *m.Current()
+	var changed []string
+	for i := 0; i < len(pairs); i += 2 {
+		p, ok := lookup(pairs[i])
+		if !ok {
+			return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i])
+		}
+		if slices.Contains(changed, p.name) {
+			return setError(p.name, "duplicate parameter")
+		}
+		if !p.mutable {
+			return setError(p.name, "can't set immutable config")
+		}
+		if err := p.set(&c, pairs[i+1]); err != nil {
+			return setError(p.name, err.Error())
+		}
+		changed = append(changed, p.name)
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed - %v", err)
+	}
+	m.current.Store(&c)
+	for _, name := range changed {
+		for _, fn := range m.onChange[name] {
+			fn(&c)
+		}
+	}
+	return nil
+}
+
+func setError(name, reason string) error {
+	return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, reason)
+}
+
+/*
+Rewrite writes the settings in use to the configuration file. Like Redis, it
+keeps the comments and the lines it does not know, updates the first line of
+every setting and drops the others, and appends the settings that are not in
+the file and differ from their default.
+*/
+func (m *Manager) Rewrite() error {
+	if m.file == "" {
+		return errNoConfigFile
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, defaults := m.Current(), Default()
+
+	lines, err := readLines(m.file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	written := make(map[string]bool)
+	var out []string
+	for _, line := range lines {
+		args, err := splitLine(line)
+		if err != nil || args == nil {
+			out = append(out, line)
+			continue
+		}
+		p, ok := lookup(args[0])
+		if !ok {
+			out = append(out, line)
+			continue
+		}
+		if !written[p.name] {
+			out = append(out, formatSetting(p.name, p.get(c)))
+			written[p.name] = true
+		}
+	}
+	generated := false
+	for _, p := range params {
+		if written[p.name] || p.get(c) == p.get(defaults) {
+			continue
+		}
+		if !generated {
+			out = append(out, "# Generated by CONFIG REWRITE")
+			generated = true
+		}
+		out = append(out, formatSetting(p.name, p.get(c)))
+	}
+
+	if err := writeFile(m.file, strings.Join(out, "\n")+"\n"); err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	return nil
+}
+
+// formatSetting returns the line of the file setting name to value, quoting the value when needed.
+func formatSetting(name, value string) string {
+	if value != "" && !strings.ContainsFunc(value, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f || r == '"' || r == '\'' || r == '\\'
+	}) {
+		return name + " " + value
+	}
+	var b strings.Builder
+	b.WriteString(name + ` "`)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// writeFile replaces the file at path with data, through a temporary file renamed over it.
+func writeFile(path, data string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,7 +1,17 @@
 package main
 
-import "github.com/PetarGeorgiev-hash/flashdb/server"
+import (
+	"log"
+	"os"
+
+	"github.com/PetarGeorgiev-hash/flashdb/config"
+	"github.com/PetarGeorgiev-hash/flashdb/server"
+)
 
 func main() {
-	server.Start()
+	cfg, file, err := config.Load(os.Args[1:], os.Environ())
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	server.Start(config.NewManager(cfg, file))
 }
@@ -0,0 +1,235 @@
+package protocol
+
+import (
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Protocol versions a client can pick with HELLO. Connections start in RESP2.
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// Versioned is implemented by the connections that know which protocol their client speaks.
+type Versioned interface {
+	Protocol() int
+}
+
+// Version returns the protocol spoken on w, RESP2 unless w tells otherwise.
+func Version(w io.Writer) int {
+	if v, ok := w.(Versioned); ok {
+		return v.Protocol()
+	}
+	return RESP2
+}
+
+/*
+Encoder appends replies to a buffer in the protocol of Version.
+
+The RESP3 types have no RESP2 counterpart and are written the way Redis writes
+them to RESP2 clients: maps and sets as flat arrays, doubles, big numbers and
+verbatim strings as bulk strings, booleans as integers and push messages as
+arrays.
+*/
+type Encoder struct {
+	Version int
+}
+
+func (e Encoder) resp3() bool {
+	return e.Version >= RESP3
+}
+
+func appendHeader(buf []byte, kind byte, n int) []byte {
+	buf = append(buf, kind)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	return append(buf, "\r\n"...)
+}
+
+func (e Encoder) AppendSimpleString(buf []byte, s string) []byte {
+	buf = append(buf, '+')
+	buf = append(buf, s...)
+	return append(buf, "\r\n"...)
+}
+
+// AppendError appends an error reply, msg carries its own code such as ERR or WRONGTYPE.
+func (e Encoder) AppendError(buf []byte, msg string) []byte {
+	buf = append(buf, '-')
+	buf = append(buf, msg...)
+	return append(buf, "\r\n"...)
+}
+
+func (e Encoder) AppendInteger(buf []byte, n int64) []byte {
+	buf = append(buf, ':')
+	buf = strconv.AppendInt(buf, n, 10)
+	return append(buf, "\r\n"...)
+}
+
+func (e Encoder) AppendBulk(buf []byte, b []byte) []byte {
+	buf = appendHeader(buf, '$', len(b))
+	buf = append(buf, b...)
+	return append(buf, "\r\n"...)
+}
+
+func (e Encoder) AppendBulkString(buf []byte, s string) []byte {
+	buf = appendHeader(buf, '$', len(s))
+	buf = append(buf, s...)
+	return append(buf, "\r\n"...)
+}
+
+// AppendBulkError appends an error whose message may span several lines, a
+// simple error with the line breaks replaced by spaces in RESP2.
+func (e Encoder) AppendBulkError(buf []byte, msg string) []byte {
+	if !e.resp3() {
+		return e.AppendError(buf, strings.NewReplacer("\r", " ", "\n", " ").Replace(msg))
+	}
+	buf = appendHeader(buf, '!', len(msg))
+	buf = append(buf, msg...)
+	return append(buf, "\r\n"...)
+}
+
+func (e Encoder) AppendArrayHeader(buf []byte, n int) []byte {
+	return appendHeader(buf, '*', n)
+}
+
+// AppendNull appends a null, the null bulk string in RESP2.
+func (e Encoder) AppendNull(buf []byte) []byte {
+	if e.resp3() {
+		return append(buf, "_\r\n"...)
+	}
+	return append(buf, "$-1\r\n"...)
+}
+
+// AppendNullArray appends a null, the null array in RESP2.
+func (e Encoder) AppendNullArray(buf []byte) []byte {
+	if e.resp3() {
+		return append(buf, "_\r\n"...)
+	}
+	return append(buf, "*-1\r\n"...)
+}
+
+// AppendMapHeader starts a map of n key/value pairs.
+func (e Encoder) AppendMapHeader(buf []byte, n int) []byte {
+	if e.resp3() {
+		return appendHeader(buf, '%', n)
+	}
+	return appendHeader(buf, '*', 2*n)
+}
+
+func (e Encoder) AppendSetHeader(buf []byte, n int) []byte {
+	if e.resp3() {
+		return appendHeader(buf, '~', n)
+	}
+	return appendHeader(buf, '*', n)
+}
+
+// AppendAttributeHeader starts n key/value pairs of auxiliary data about the
+// reply that follows them. RESP2 has no attributes, they must not be written to
+// RESP2 clients.
+func (e Encoder) AppendAttributeHeader(buf []byte, n int) []byte {
+	return appendHeader(buf, '|', n)
+}
+
+// AppendPushHeader starts an out of band message such as a pub/sub message.
+func (e Encoder) AppendPushHeader(buf []byte, n int) []byte {
+	if e.resp3() {
+		return appendHeader(buf, '>', n)
+	}
+	return appendHeader(buf, '*', n)
+}
+
+func (e Encoder) AppendDouble(buf []byte, f float64) []byte {
+	if !e.resp3() {
+		return e.AppendBulkString(buf, FormatDouble(f))
+	}
+	buf = append(buf, ',')
+	buf = append(buf, FormatDouble(f)...)
+	return append(buf, "\r\n"...)
+}
+
+func (e Encoder) AppendBoolean(buf []byte, b bool) []byte {
+	switch {
+	case !e.resp3() && b:
+		return append(buf, ":1\r\n"...)
+	case !e.resp3():
+		return append(buf, ":0\r\n"...)
+	case b:
+		return append(buf, "#t\r\n"...)
+	}
+	return append(buf, "#f\r\n"...)
+}
+
+func (e Encoder) AppendBigNumber(buf []byte, n *big.Int) []byte {
+	if !e.resp3() {
+		return e.AppendBulkString(buf, n.String())
+	}
+	buf = append(buf, '(')
+	buf = n.Append(buf, 10)
+	return append(buf, "\r\n"...)
+}
+
+// AppendVerbatim appends text to be shown as is, format is a three letter
+// type such as txt or mkd.
+func (e Encoder) AppendVerbatim(buf []byte, format, text string) []byte {
+	if !e.resp3() {
+		return e.AppendBulkString(buf, text)
+	}
+	buf = appendHeader(buf, '=', len(format)+1+len(text))
+	buf = append(buf, format...)
+	buf = append(buf, ':')
+	buf = append(buf, text...)
+	return append(buf, "\r\n"...)
+}
+
+// AppendCommand appends a command as clients send it, an array of bulk
+// strings, the same in every protocol version. It is also the format of the AOF
+// and of the stream of writes sent to replicas.
+func AppendCommand(buf []byte, args ...string) []byte {
+	buf = appendHeader(buf, '*', len(args))
+	for _, arg := range args {
+		buf = appendHeader(buf, '$', len(arg))
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// CommandLen returns the length of args encoded by AppendCommand, without encoding them.
+func CommandLen(args [][]byte) int {
+	n := headerLen(len(args))
+	for _, arg := range args {
+		n += headerLen(len(arg)) + len(arg) + 2
+	}
+	return n
+}
+
+// headerLen returns the length of the header of an array or bulk of n elements.
+func headerLen(n int) int {
+	digits := 1
+	for ; n >= 10; n /= 10 {
+		digits++
+	}
+	return 1 + digits + 2
+}
+
+// FormatDouble formats f the way Redis replies with scores: inf, -inf, and the
+// shortest representation of the others, in exponent notation only for very
+// small or very large values.
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	abs := math.Abs(f)
+	if abs != 0 && (abs < 1e-4 || abs >= 1e21) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -2,67 +2,302 @@ package protocol
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"slices"
 	"strconv"
+	"strings"
 )
 
+const (
+	// DefaultMaxBulkLen is the default size limit of one argument, proto-max-bulk-len in Redis.
+	DefaultMaxBulkLen = 512 << 20
+	// DefaultMaxMultiBulkLen is the default limit on the number of arguments of a command.
+	DefaultMaxMultiBulkLen = 1024 * 1024
+	// MaxInlineLen bounds inline commands and the header lines of RESP requests.
+	MaxInlineLen = 64 * 1024
+
+	// bulkChunk is how much of a bulk is read at once, so that a client
+	// announcing a huge argument does not get it allocated before sending it.
+	bulkChunk = 1 << 20
+	// maxRetainedBuffer is the largest buffer kept for the next request.
+	maxRetainedBuffer = 1 << 20
+)
+
+// ErrProtocol is wrapped by the errors caused by a malformed request. The
+// connection can not be trusted to be in sync afterwards and should be closed.
+var ErrProtocol = errors.New("Protocol error")
+
+// Limits bounds the requests a parser accepts, a zero field means no limit.
+type Limits struct {
+	MaxBulkLen      int64
+	MaxMultiBulkLen int64
+}
+
+// DefaultLimits returns the limits applied to client connections unless configured otherwise.
+func DefaultLimits() Limits {
+	return Limits{MaxBulkLen: DefaultMaxBulkLen, MaxMultiBulkLen: DefaultMaxMultiBulkLen}
+}
+
 type Parser interface {
-	ParseRESP(r *bufio.Reader) ([]string, error)
+	ParseRESP(r *bufio.Reader) ([][]byte, error)
 }
 
-type RESPParser struct{}
+/*
+RESPParser reads commands sent as RESP arrays of bulk strings, or as inline
+commands, a line of space separated arguments as typed in telnet.
 
-// ParseRESP implements a proper RESP2 parser.
-func (p *RESPParser) ParseRESP(r *bufio.Reader) ([]string, error) {
-	line, err := readLine(r)
+The arguments returned share a buffer the parser reuses: they are only valid
+until the next call to ParseRESP.
+*/
+type RESPParser struct {
+	limits Limits
+	line   []byte
+	buf    []byte
+	// bounds holds the start and end offsets in buf of every argument.
+	bounds []int
+	args   [][]byte
+}
+
+func NewRESPParser(limits Limits) Parser {
+	return &RESPParser{limits: limits}
+}
+
+// ParseRESP reads one command. An empty command, a blank inline line or *0,
+// is returned as no arguments. io.EOF is only returned between two commands.
+func (p *RESPParser) ParseRESP(r *bufio.Reader) ([][]byte, error) {
+	if cap(p.buf) > maxRetainedBuffer {
+		p.buf = nil
+	}
+	p.buf = p.buf[:0]
+	p.bounds = p.bounds[:0]
+
+	line, err := p.readLine(r)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(line) == 0 || line[0] != '*' {
-		return nil, fmt.Errorf("invalid RESP array start: %q", line)
+	if len(line) > 0 && line[0] == '*' {
+		err = p.parseMultiBulk(r, line)
+	} else {
+		err = p.parseInline(line)
 	}
-
-	n, err := strconv.Atoi(string(line[1:]))
 	if err != nil {
-		return nil, fmt.Errorf("invalid RESP array length: %v", err)
+		return nil, err
 	}
 
-	result := make([]string, 0, n)
-	for i := 0; i < n; i++ {
-		bulkLenLine, err := readLine(r)
+	p.args = p.args[:0]
+	for i := 0; i < len(p.bounds); i += 2 {
+		p.args = append(p.args, p.buf[p.bounds[i]:p.bounds[i+1]:p.bounds[i+1]])
+	}
+	return p.args, nil
+}
+
+func (p *RESPParser) parseMultiBulk(r *bufio.Reader, header []byte) error {
+	n, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || (p.limits.MaxMultiBulkLen > 0 && n > p.limits.MaxMultiBulkLen) {
+		return fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+	for i := int64(0); i < n; i++ {
+		line, err := p.readLine(r)
 		if err != nil {
-			return nil, err
+			return unexpectedEOF(err)
 		}
-		if len(bulkLenLine) == 0 || bulkLenLine[0] != '$' {
-			return nil, fmt.Errorf("expected bulk string, got %q", bulkLenLine)
+		if len(line) == 0 || line[0] != '$' {
+			got := "EOF"
+			if len(line) > 0 {
+				got = string(line[:1])
+			}
+			return fmt.Errorf("%w: expected '$', got '%s'", ErrProtocol, got)
 		}
-
-		length, err := strconv.Atoi(string(bulkLenLine[1:]))
-		if err != nil {
-			return nil, fmt.Errorf("invalid bulk string length: %v", err)
+		length, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		// Whatever the limit, the length and its CRLF must fit an int.
+		if err != nil || length < 0 || length > math.MaxInt-2 || (p.limits.MaxBulkLen > 0 && length > p.limits.MaxBulkLen) {
+			return fmt.Errorf("%w: invalid bulk length", ErrProtocol)
 		}
+		if err := p.readBulk(r, int(length)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		// Read exactly length + CRLF
-		data := make([]byte, length+2)
-		if _, err := r.Read(data); err != nil {
-			return nil, err
+// readBulk appends the next length bytes of r to buf, checking the CRLF that ends them.
+func (p *RESPParser) readBulk(r *bufio.Reader, length int) error {
+	start := len(p.buf)
+	for remaining := length + 2; remaining > 0; {
+		chunk := min(remaining, bulkChunk)
+		n := len(p.buf)
+		p.buf = slices.Grow(p.buf, chunk)[:n+chunk]
+		if _, err := io.ReadFull(r, p.buf[n:]); err != nil {
+			return unexpectedEOF(err)
 		}
-		result = append(result, string(data[:length]))
+		remaining -= chunk
+	}
+	end := start + length
+	if p.buf[end] != '\r' || p.buf[end+1] != '\n' {
+		return fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
 	}
+	p.buf = p.buf[:end]
+	p.bounds = append(p.bounds, start, end)
+	return nil
+}
 
-	return result, nil
+/*
+parseInline splits an inline command into arguments the way redis-cli does:
+arguments are separated by spaces and may be quoted. Double quotes allow the
+escapes \n, \r, \t, \b, \a, \\, \" and \xHH, single quotes only \'.
+*/
+func (p *RESPParser) parseInline(line []byte) error {
+	unbalanced := fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return nil
+		}
+		start := len(p.buf)
+		switch quote := line[i]; quote {
+		case '"', '\'':
+			i++
+			for {
+				if i == len(line) {
+					return unbalanced
+				}
+				c := line[i]
+				if c == quote {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) {
+					if quote == '\'' {
+						if line[i+1] == '\'' {
+							c = '\''
+							i++
+						}
+					} else {
+						var n int
+						c, n = unescape(line[i+1:])
+						i += n
+					}
+				}
+				p.buf = append(p.buf, c)
+				i++
+			}
+			// A closing quote must end the argument.
+			if i < len(line) && !isSpace(line[i]) {
+				return unbalanced
+			}
+		default:
+			for i < len(line) && !isSpace(line[i]) {
+				p.buf = append(p.buf, line[i])
+				i++
+			}
+		}
+		p.bounds = append(p.bounds, start, len(p.buf))
+	}
 }
 
-func readLine(r *bufio.Reader) ([]byte, error) {
-	line, err := r.ReadBytes('\n')
-	if err != nil {
+// SplitArgs splits line into arguments with the quoting rules of inline
+// commands, see parseInline. Configuration files use them too.
+func SplitArgs(line string) ([]string, error) {
+	var p RESPParser
+	if err := p.parseInline([]byte(line)); err != nil {
 		return nil, err
 	}
-	return bytes.TrimSuffix(line, []byte("\r\n")), nil
+	args := make([]string, 0, len(p.bounds)/2)
+	for i := 0; i < len(p.bounds); i += 2 {
+		args = append(args, string(p.buf[p.bounds[i]:p.bounds[i+1]]))
+	}
+	return args, nil
+}
+
+// unescape decodes the escape sequence following a backslash in a double quoted
+// argument and returns the byte it stands for and its length. An unknown escape
+// stands for the escaped character itself.
+func unescape(s []byte) (byte, int) {
+	switch s[0] {
+	case 'n':
+		return '\n', 1
+	case 'r':
+		return '\r', 1
+	case 't':
+		return '\t', 1
+	case 'b':
+		return '\b', 1
+	case 'a':
+		return '\a', 1
+	case 'x':
+		if len(s) >= 3 {
+			if b, err := strconv.ParseUint(string(s[1:3]), 16, 8); err == nil {
+				return byte(b), 3
+			}
+		}
+		return 'x', 1
+	}
+	return s[0], 1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
+
+// readLine reads a line without its line ending into a buffer reused by the next call.
+func (p *RESPParser) readLine(r *bufio.Reader) ([]byte, error) {
+	p.line = p.line[:0]
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(p.line)+len(chunk) > MaxInlineLen {
+			return nil, fmt.Errorf("%w: too big inline request", ErrProtocol)
+		}
+		p.line = append(p.line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(p.line) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line := p.line[:len(p.line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		return line, nil
+	}
+}
+
+// unexpectedEOF turns the end of input in the middle of a command into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
 
-func NewRESPParser() Parser {
-	return &RESPParser{}
+/*
+Strings copies args into strings, which outlive the parser buffer. They share
+one allocation, so that the copy costs the same whatever the number of
+arguments: a string kept by a handler keeps the others of its command alive.
+*/
+func Strings(args [][]byte) []string {
+	n := 0
+	for _, arg := range args {
+		n += len(arg)
+	}
+	var b strings.Builder
+	b.Grow(n)
+	for _, arg := range args {
+		b.Write(arg)
+	}
+	all := b.String()
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i], all = all[:len(arg)], all[len(arg):]
+	}
+	return parts
 }
@@ -0,0 +1,157 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+	"math/big"
+)
+
+// WriteBufferSize is the size of the reply buffer of a connection.
+const WriteBufferSize = 16 * 1024
+
+/*
+ReplyWriter is what command handlers write their replies to, one value at a
+time in the protocol the client speaks. Aggregates are written as a header
+followed by their elements.
+
+Writes are buffered and errors are sticky: they are reported by Flush.
+*/
+type ReplyWriter interface {
+	Versioned
+	WriteSimpleString(s string)
+	// WriteError writes an error reply, msg carries its own code such as ERR or WRONGTYPE.
+	WriteError(msg string)
+	WriteBulkError(msg string)
+	WriteInteger(n int64)
+	WriteBulk(b []byte)
+	WriteBulkString(s string)
+	WriteNull()
+	WriteNullArray()
+	WriteArrayHeader(n int)
+	WriteMapHeader(n int)
+	WriteSetHeader(n int)
+	WritePushHeader(n int)
+	WriteDouble(f float64)
+	WriteBoolean(b bool)
+	WriteBigNumber(n *big.Int)
+	WriteVerbatim(format, text string)
+	// Buffered returns the number of bytes waiting for Flush.
+	Buffered() int
+	// Flush sends what was written so far.
+	Flush() error
+}
+
+// Fixed is a protocol version that never changes, for writers whose client
+// can not switch with HELLO.
+type Fixed int
+
+func (f Fixed) Protocol() int {
+	return int(f)
+}
+
+/*
+Writer is the ReplyWriter of a connection. It buffers replies so that a
+pipeline of commands is answered with as few writes as possible: nothing
+reaches the connection before Flush, which the server calls once it served
+every command it has read.
+
+The protocol is the one reported by version, which may change between replies.
+*/
+type Writer struct {
+	w       *bufio.Writer
+	version Versioned
+}
+
+func NewWriter(w io.Writer, version Versioned) *Writer {
+	return &Writer{w: bufio.NewWriterSize(w, WriteBufferSize), version: version}
+}
+
+// Protocol implements Versioned.
+func (w *Writer) Protocol() int {
+	return w.version.Protocol()
+}
+
+func (w *Writer) encoder() Encoder {
+	return Encoder{Version: w.version.Protocol()}
+}
+
+// Write writes p as is, it must hold whole replies.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Buffered returns the number of bytes waiting for Flush.
+func (w *Writer) Buffered() int {
+	return w.w.Buffered()
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Replies are appended to the free space of the buffer, which Write then copies
+// nothing from as long as the reply fits.
+
+func (w *Writer) WriteSimpleString(s string) {
+	w.w.Write(w.encoder().AppendSimpleString(w.w.AvailableBuffer(), s))
+}
+
+func (w *Writer) WriteError(msg string) {
+	w.w.Write(w.encoder().AppendError(w.w.AvailableBuffer(), msg))
+}
+
+func (w *Writer) WriteBulkError(msg string) {
+	w.w.Write(w.encoder().AppendBulkError(w.w.AvailableBuffer(), msg))
+}
+
+func (w *Writer) WriteInteger(n int64) {
+	w.w.Write(w.encoder().AppendInteger(w.w.AvailableBuffer(), n))
+}
+
+func (w *Writer) WriteBulk(b []byte) {
+	w.w.Write(w.encoder().AppendBulk(w.w.AvailableBuffer(), b))
+}
+
+func (w *Writer) WriteBulkString(s string) {
+	w.w.Write(w.encoder().AppendBulkString(w.w.AvailableBuffer(), s))
+}
+
+func (w *Writer) WriteNull() {
+	w.w.Write(w.encoder().AppendNull(w.w.AvailableBuffer()))
+}
+
+func (w *Writer) WriteNullArray() {
+	w.w.Write(w.encoder().AppendNullArray(w.w.AvailableBuffer()))
+}
+
+func (w *Writer) WriteArrayHeader(n int) {
+	w.w.Write(w.encoder().AppendArrayHeader(w.w.AvailableBuffer(), n))
+}
+
+func (w *Writer) WriteMapHeader(n int) {
+	w.w.Write(w.encoder().AppendMapHeader(w.w.AvailableBuffer(), n))
+}
+
+func (w *Writer) WriteSetHeader(n int) {
+	w.w.Write(w.encoder().AppendSetHeader(w.w.AvailableBuffer(), n))
+}
+
+func (w *Writer) WritePushHeader(n int) {
+	w.w.Write(w.encoder().AppendPushHeader(w.w.AvailableBuffer(), n))
+}
+
+func (w *Writer) WriteDouble(f float64) {
+	w.w.Write(w.encoder().AppendDouble(w.w.AvailableBuffer(), f))
+}
+
+func (w *Writer) WriteBoolean(b bool) {
+	w.w.Write(w.encoder().AppendBoolean(w.w.AvailableBuffer(), b))
+}
+
+func (w *Writer) WriteBigNumber(n *big.Int) {
+	w.w.Write(w.encoder().AppendBigNumber(w.w.AvailableBuffer(), n))
+}
+
+func (w *Writer) WriteVerbatim(format, text string) {
+	w.w.Write(w.encoder().AppendVerbatim(w.w.AvailableBuffer(), format, text))
+}
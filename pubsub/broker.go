@@ -0,0 +1,219 @@
+package pubsub
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+/*
+Broker routes published messages to the subscribers of a channel and to those
+of the glob patterns matching it. Delivery is fire and forget: a message is
+queued on every receiving subscriber and PUBLISH returns, nothing is stored for
+clients subscribing later.
+*/
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[string]map[*Subscriber]struct{}
+
+	outputLimit atomic.Int64
+}
+
+func NewBroker() *Broker {
+	b := &Broker{
+		channels: make(map[string]map[*Subscriber]struct{}),
+		patterns: make(map[string]map[*Subscriber]struct{}),
+	}
+	b.outputLimit.Store(DefaultOutputLimit)
+	return b
+}
+
+// SetOutputLimit sets the output limit of the subscribers created from now on,
+// zero meaning no limit.
+func (b *Broker) SetOutputLimit(limit int64) {
+	b.outputLimit.Store(limit)
+}
+
+// NewSubscriber puts conn in push mode. The subscriber must be passed to
+// UnsubscribeAll and closed when the connection ends.
+func (b *Broker) NewSubscriber(conn net.Conn) *Subscriber {
+	return newSubscriber(conn, b.outputLimit.Load())
+}
+
+// Subscribe adds channel to the subscriptions of s and returns how many it has.
+func (b *Broker) Subscribe(s *Subscriber, channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	add(b.channels, channel, s)
+	s.channels[channel] = struct{}{}
+	return s.count()
+}
+
+// Unsubscribe removes channel from the subscriptions of s and returns how many are left.
+func (b *Broker) Unsubscribe(s *Subscriber, channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remove(b.channels, channel, s)
+	delete(s.channels, channel)
+	return s.count()
+}
+
+// PSubscribe adds pattern to the subscriptions of s and returns how many it has.
+func (b *Broker) PSubscribe(s *Subscriber, pattern string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	add(b.patterns, pattern, s)
+	s.patterns[pattern] = struct{}{}
+	return s.count()
+}
+
+// PUnsubscribe removes pattern from the subscriptions of s and returns how many are left.
+func (b *Broker) PUnsubscribe(s *Subscriber, pattern string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	remove(b.patterns, pattern, s)
+	delete(s.patterns, pattern)
+	return s.count()
+}
+
+// Subscriptions returns the channels and the patterns s is subscribed to, sorted.
+func (b *Broker) Subscriptions(s *Subscriber) (channels, patterns []string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return sortedKeys(s.channels), sortedKeys(s.patterns)
+}
+
+// UnsubscribeAll drops every subscription of s.
+func (b *Broker) UnsubscribeAll(s *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for channel := range s.channels {
+		remove(b.channels, channel, s)
+	}
+	for pattern := range s.patterns {
+		remove(b.patterns, pattern, s)
+	}
+	s.channels = make(map[string]struct{})
+	s.patterns = make(map[string]struct{})
+}
+
+// Publish sends payload to the subscribers of channel and of the patterns
+// matching it, and returns the number of messages queued.
+func (b *Broker) Publish(channel string, payload []byte) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	received := 0
+	if subscribers := b.channels[channel]; len(subscribers) > 0 {
+		message := newMessage("message", "", channel, payload)
+		for s := range subscribers {
+			if s.enqueue(message.encoded(s.Protocol())) {
+				received++
+			}
+		}
+	}
+	for pattern, subscribers := range b.patterns {
+		if !util.GlobMatch(pattern, channel) {
+			continue
+		}
+		message := newMessage("pmessage", pattern, channel, payload)
+		for s := range subscribers {
+			if s.enqueue(message.encoded(s.Protocol())) {
+				received++
+			}
+		}
+	}
+	return received
+}
+
+// Channels returns the channels with at least one subscriber matching pattern,
+// every channel when pattern is empty.
+func (b *Broker) Channels(pattern string) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var channels []string
+	for channel := range b.channels {
+		if pattern == "" || util.GlobMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub returns the number of subscribers of channel, pattern subscribers excluded.
+func (b *Broker) NumSub(channel string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.channels[channel])
+}
+
+// NumPat returns the number of patterns subscribed to by any client.
+func (b *Broker) NumPat() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.patterns)
+}
+
+func add(subscriptions map[string]map[*Subscriber]struct{}, name string, s *Subscriber) {
+	subscribers, ok := subscriptions[name]
+	if !ok {
+		subscribers = make(map[*Subscriber]struct{})
+		subscriptions[name] = subscribers
+	}
+	subscribers[s] = struct{}{}
+}
+
+func remove(subscriptions map[string]map[*Subscriber]struct{}, name string, s *Subscriber) {
+	subscribers := subscriptions[name]
+	delete(subscribers, s)
+	if len(subscribers) == 0 {
+		delete(subscriptions, name)
+	}
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// message is a message as pushed to subscribers: message, channel and payload,
+// preceded by the pattern for pmessage. It is encoded once per protocol.
+type message struct {
+	fields [][]byte
+	resp2  []byte
+	resp3  []byte
+}
+
+func newMessage(kind, pattern, channel string, payload []byte) *message {
+	fields := [][]byte{[]byte(kind), []byte(channel), payload}
+	if kind == "pmessage" {
+		fields = [][]byte{[]byte(kind), []byte(pattern), []byte(channel), payload}
+	}
+	return &message{fields: fields}
+}
+
+// encoded returns the message encoded in the given protocol, a push message in RESP3.
+func (m *message) encoded(version int) []byte {
+	cached := &m.resp2
+	if version >= protocol.RESP3 {
+		cached = &m.resp3
+	}
+	if *cached == nil {
+		e := protocol.Encoder{Version: version}
+		buf := e.AppendPushHeader(nil, len(m.fields))
+		for _, field := range m.fields {
+			buf = e.AppendBulk(buf, field)
+		}
+		*cached = buf
+	}
+	return *cached
+}
@@ -0,0 +1,21 @@
+package pubsub
+
+import "github.com/PetarGeorgiev-hash/flashdb/store"
+
+/*
+PublishKeyspaceEvents publishes the keyspace events of s the way Redis does:
+to __keyspace@0__:<key> with the event as payload when NotifyKeyspace is set,
+and to __keyevent@0__:<event> with the key as payload when NotifyKeyevent is.
+The flags are read for every event, so changing them takes effect at once.
+*/
+func (b *Broker) PublishKeyspaceEvents(s store.INotifyStore) (cancel func()) {
+	return s.OnKeyspaceEvent(func(e store.KeyspaceEvent) {
+		flags := s.NotifyKeyspaceEvents()
+		if flags&store.NotifyKeyspace != 0 {
+			b.Publish("__keyspace@0__:"+e.Key, []byte(e.Event))
+		}
+		if flags&store.NotifyKeyevent != 0 {
+			b.Publish("__keyevent@0__:"+e.Event, []byte(e.Key))
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package pubsub
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+)
+
+// DefaultOutputLimit is the default number of bytes a subscriber may have
+// waiting to be sent before it is disconnected, like the pubsub class of the
+// Redis client-output-buffer-limit.
+const DefaultOutputLimit = 32 << 20
+
+/*
+Subscriber is a connection in push mode. Publishers never write to the socket
+themselves: messages are queued and a goroutine owned by the subscriber sends
+them, so a slow reader can not hold back PUBLISH. When more than the output
+limit is waiting the subscriber is disconnected instead.
+
+Subscriber is a net.Conn whose Write queues, every reply to the connection must
+go through it once it exists so that replies and messages keep their order.
+*/
+type Subscriber struct {
+	net.Conn
+	limit int64
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   [][]byte
+	pending int64
+	closed  bool
+
+	// channels and patterns are guarded by the broker lock.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func newSubscriber(conn net.Conn, limit int64) *Subscriber {
+	s := &Subscriber{
+		Conn:     conn,
+		limit:    limit,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+// Write queues a copy of b to be sent to the client.
+func (s *Subscriber) Write(b []byte) (int, error) {
+	if !s.enqueue(append([]byte(nil), b...)) {
+		return 0, net.ErrClosed
+	}
+	return len(b), nil
+}
+
+// enqueue queues b, which must not be modified afterwards, and reports whether
+// the subscriber is still connected.
+func (s *Subscriber) enqueue(b []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.limit > 0 && s.pending+int64(len(b)) > s.limit {
+		log.Printf("[pubsub] closing %s: output buffer limit of %d bytes reached", s.RemoteAddr(), s.limit)
+		s.closeLocked()
+		return false
+	}
+	s.queue = append(s.queue, b)
+	s.pending += int64(len(b))
+	s.cond.Signal()
+	return true
+}
+
+// Close stops the subscriber and closes the connection. Messages still queued are dropped.
+func (s *Subscriber) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+	return nil
+}
+
+func (s *Subscriber) closeLocked() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.queue = nil
+	s.cond.Signal()
+	// Closing the socket also unblocks the read loop of the connection, which
+	// then unsubscribes the client.
+	s.Conn.Close()
+}
+
+// run sends the queued replies and messages until the subscriber is closed.
+func (s *Subscriber) run() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		batch := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, b := range batch {
+			if _, err := s.Conn.Write(b); err != nil {
+				s.Close()
+				return
+			}
+			s.mu.Lock()
+			s.pending -= int64(len(b))
+			s.mu.Unlock()
+		}
+		// The connection may buffer its writes, the batch is sent at once.
+		if f, ok := s.Conn.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+// Protocol returns the protocol spoken by the client, so that replies written
+// through the subscriber and the messages it receives are encoded for it.
+func (s *Subscriber) Protocol() int {
+	return protocol.Version(s.Conn)
+}
+
+// count returns the number of channels and patterns the subscriber listens to.
+// The caller must hold the broker lock.
+func (s *Subscriber) count() int {
+	return len(s.channels) + len(s.patterns)
+}
@@ -3,20 +3,62 @@ package replication
 import (
 	"bufio"
 	"bytes"
-	"encoding/gob"
+	"crypto/tls"
 	"io"
 	"log"
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/PetarGeorgiev-hash/flashdb/protocol"
 	"github.com/PetarGeorgiev-hash/flashdb/store"
 )
 
-func StartReplica(masterAddr string, s store.IStore) error {
-	conn, err := net.Dial("tcp", masterAddr)
+// ReplicaStatus is the state of the link of a replica to its master, as INFO reports it.
+type ReplicaStatus struct {
+	connected atomic.Bool
+	offset    atomic.Int64
+	// lastIO is the unix time of the last data received from the master.
+	lastIO atomic.Int64
+}
+
+// Connected reports whether the replica is synced with its master.
+func (r *ReplicaStatus) Connected() bool {
+	return r.connected.Load()
+}
+
+// Offset returns the replication offset of the master the replica has applied.
+func (r *ReplicaStatus) Offset() int64 {
+	return r.offset.Load()
+}
+
+// LastIO returns the time the master last sent data, zero if it never did.
+func (r *ReplicaStatus) LastIO() time.Time {
+	if unix := r.lastIO.Load(); unix != 0 {
+		return time.Unix(unix, 0)
+	}
+	return time.Time{}
+}
+
+/*
+StartReplica syncs s with the master at masterAddr and applies its writes,
+over TLS when tlsConfig is not nil. The state of the link is kept in status,
+which may be nil.
+*/
+func StartReplica(masterAddr string, s store.IStore, tlsConfig *tls.Config, status *ReplicaStatus) error {
+	if status == nil {
+		status = &ReplicaStatus{}
+	}
+	defer status.connected.Store(false)
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.Dial("tcp", masterAddr, tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", masterAddr)
+	}
 	if err != nil {
 		return err
 	}
@@ -32,49 +74,49 @@ func StartReplica(masterAddr string, s store.IStore) error {
 	if strings.HasPrefix(line, "+FULLSYNC") {
 		parts := strings.Split(strings.TrimSpace(line), " ")
 		size := 0
-		if len(parts) == 2 {
+		if len(parts) >= 2 {
 			size, _ = strconv.Atoi(parts[1])
 		}
+		// Masters send the replication offset of the snapshot since it is reported by INFO.
+		if len(parts) == 3 {
+			offset, _ := strconv.ParseInt(parts[2], 10, 64)
+			status.offset.Store(offset)
+		}
 		log.Printf("[replica] receiving full sync of %d bytes...", size)
 		data := make([]byte, size)
 		io.ReadFull(reader, data)
 
-		decoder := gob.NewDecoder(bytes.NewReader(data))
-		var snapshot map[string][]byte
-		if err := decoder.Decode(&snapshot); err != nil {
+		if err := s.Restore(bytes.NewReader(data)); err != nil {
 			return err
 		}
-		s.Import(snapshot)
 		log.Println("[replica] full sync completed")
+		status.connected.Store(true)
+		status.lastIO.Store(time.Now().Unix())
 
 		endLine, _ := reader.ReadString('\n')
 		log.Printf("[replica] end marker: %q", strings.TrimSpace(endLine))
 	}
 
 	// Step 2: Listen for live updates
-	parser := protocol.NewRESPParser()
+	parser := protocol.NewRESPParser(protocol.Limits{})
+	replayer := store.NewReplayer(s)
 	for {
 		log.Println("[replica] waiting for broadcasted command...")
-		parts, err := parser.ParseRESP(reader)
+		args, err := parser.ParseRESP(reader)
 		if err != nil {
 			log.Printf("[replica] sync error: %v", err)
+			status.connected.Store(false)
 			time.Sleep(3 * time.Second)
 			continue
 		}
+		status.offset.Add(int64(protocol.CommandLen(args)))
+		parts := protocol.Strings(args)
+		status.lastIO.Store(time.Now().Unix())
+		status.connected.Store(true)
 		log.Printf("[replica] received broadcast command: %v", parts)
-		applyCommand(s, parts)
+		if err := replayer.Apply(parts); err != nil {
+			log.Printf("[replica] failed to apply %v: %v", parts, err)
+		}
 	}
 
 }
-
-func applyCommand(s store.IStore, parts []string) {
-	cmd := strings.ToUpper(parts[0])
-	switch cmd {
-	case "SET":
-		s.Set(parts[1], []byte(parts[2]), 0)
-	case "DEL":
-		s.Delete(parts[1])
-	default:
-		log.Printf("[replica] unknown command %s", cmd)
-	}
-}
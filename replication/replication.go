@@ -3,14 +3,16 @@ package replication
 import (
 	"bufio"
 	"bytes"
-	"encoding/gob"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
 	"github.com/PetarGeorgiev-hash/flashdb/store"
 )
 
@@ -18,12 +20,22 @@ type IManager interface {
 	HandleReplicationConn(conn net.Conn)
 	fullSync(conn net.Conn) error
 	Broadcast(parts []string)
+	BroadcastTransaction(commands [][]string)
+	Info() Info
+}
+
+// Info is the state of a master as INFO reports it.
+type Info struct {
+	// Offset is the number of bytes of commands the master has propagated.
+	Offset   int64
+	Replicas []net.Addr
 }
 
 type Manager struct {
 	mu       sync.Mutex
 	replicas map[net.Conn]struct{}
 	s        store.IStore
+	offset   atomic.Int64
 }
 
 func (m *Manager) HandleReplicationConn(conn net.Conn) {
@@ -62,32 +74,41 @@ func (m *Manager) HandleReplicationConn(conn net.Conn) {
 }
 
 func (m *Manager) fullSync(conn net.Conn) error {
-	data, err := m.s.Export()
-	if err != nil {
-		return fmt.Errorf("export failed: %w", err)
-	}
-
 	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
-	if err := encoder.Encode(data); err != nil {
-		return fmt.Errorf("encode failed: %w", err)
+	if err := m.s.Dump(&buf); err != nil {
+		return fmt.Errorf("snapshot failed: %w", err)
 	}
 
-	length := buf.Len()
-	conn.Write([]byte(fmt.Sprintf("+FULLSYNC %d\r\n", length)))
+	var e protocol.Encoder
+	// The offset lets the replica carry on counting from where the snapshot was taken.
+	conn.Write(e.AppendSimpleString(nil, "FULLSYNC "+strconv.Itoa(buf.Len())+" "+strconv.FormatInt(m.offset.Load(), 10)))
 	conn.Write(buf.Bytes())
-	conn.Write([]byte("+FULLSYNC_END\r\n"))
+	conn.Write(e.AppendSimpleString(nil, "FULLSYNC_END"))
 	return nil
 }
 
 func (m *Manager) Broadcast(parts []string) {
+	m.send(protocol.AppendCommand(nil, parts...))
+}
+
+// BroadcastTransaction forwards the writes of an EXEC wrapped in MULTI and EXEC,
+// so that the replicas apply them as one unit.
+func (m *Manager) BroadcastTransaction(commands [][]string) {
+	block := protocol.AppendCommand(nil, "MULTI")
+	for _, parts := range commands {
+		block = protocol.AppendCommand(block, parts...)
+	}
+	m.send(protocol.AppendCommand(block, "EXEC"))
+}
+
+func (m *Manager) send(cmd []byte) {
+	m.offset.Add(int64(len(cmd)))
 	m.mu.Lock()
 	if len(m.replicas) == 0 {
 		m.mu.Unlock()
 		return
 	}
 
-	cmd := encodeRESP(parts)
 	replicas := make([]net.Conn, 0, len(m.replicas))
 	for conn := range m.replicas {
 		replicas = append(replicas, conn)
@@ -96,7 +117,7 @@ func (m *Manager) Broadcast(parts []string) {
 
 	for _, conn := range replicas {
 		go func(c net.Conn) {
-			_, err := c.Write([]byte(cmd))
+			_, err := c.Write(cmd)
 			if err != nil {
 				log.Printf("[replication] failed to send to replica %s: %v", c.RemoteAddr(), err)
 				m.mu.Lock()
@@ -108,18 +129,20 @@ func (m *Manager) Broadcast(parts []string) {
 	}
 }
 
+// Info returns the replication offset and the addresses of the connected replicas.
+func (m *Manager) Info() Info {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info := Info{Offset: m.offset.Load()}
+	for conn := range m.replicas {
+		info.Replicas = append(info.Replicas, conn.RemoteAddr())
+	}
+	return info
+}
+
 func NewManager(s store.IStore) IManager {
 	return &Manager{
 		replicas: make(map[net.Conn]struct{}),
 		s:        s,
 	}
 }
-
-// TODO: move to protocol/resp.go
-func encodeRESP(parts []string) string {
-	resp := fmt.Sprintf("*%d\r\n", len(parts))
-	for _, p := range parts {
-		resp += fmt.Sprintf("$%d\r\n%s\r\n", len(p), p)
-	}
-	return resp
-}
@@ -3,6 +3,8 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -13,60 +15,94 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/PetarGeorgiev-hash/flashdb/acl"
 	"github.com/PetarGeorgiev-hash/flashdb/aof"
 	"github.com/PetarGeorgiev-hash/flashdb/cluster"
 	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/config"
 	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/pubsub"
 	"github.com/PetarGeorgiev-hash/flashdb/replication"
 	"github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/tlsconfig"
 	"github.com/PetarGeorgiev-hash/flashdb/util"
 )
 
-func Start() {
-
-	addr := os.Getenv("FLASHDB_ADDR")
-	if addr == "" {
-		addr = ":6379"
-	}
-	if !strings.Contains(addr, "127.0.0.1") && strings.HasPrefix(addr, ":") {
-		addr = "127.0.0.1" + addr
-	}
+// Start runs a server with settings until it receives SIGTERM or SIGINT.
+func Start(settings *config.Manager) {
+	cfg := settings.Current()
+	addr := localAddr(cfg.Addr)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("failed to start server: %v", err)
 	}
 
-	store := store.NewStore()
+	// The TLS port, when configured, serves the same clients as the plaintext one.
+	var certs *tlsconfig.Certificates
+	if cfg.TLSEnabled() {
+		if certs, err = tlsconfig.Load(cfg.TLSOptions()); err != nil {
+			log.Fatalf("invalid TLS configuration: %v", err)
+		}
+	}
+	var tlsListener net.Listener
+	if cfg.TLSAddr != "" {
+		tlsListener, err = tls.Listen("tcp", localAddr(cfg.TLSAddr), certs.ServerConfig())
+		if err != nil {
+			log.Fatalf("failed to start TLS server: %v", err)
+		}
+	}
+	var replicationTLS *tlsconfig.Certificates
+	if cfg.TLSReplication {
+		replicationTLS = certs
+	}
 
-	aofWriter, err := aof.NewAOF(util.AppendFile)
+	store := store.NewShardedStore(cfg.Shards)
+	if err := store.Load(cfg.DBFilename); err != nil && !os.IsNotExist(err) {
+		log.Printf("No saved snapshots or failed to load them : %v", err)
+	}
+	configureStore(settings, store)
+
+	aofWriter, err := aof.NewAOF(cfg.AppendFilename)
 	if err != nil {
 		log.Println(err)
 	}
 
-	cfg, err := cluster.LoadConfig("cluster.json")
+	clusterConfig, err := cluster.LoadConfig(cfg.ClusterConfigFile)
 	if err != nil {
 		log.Fatalf("failed to load cluster config: %v", err)
 	}
 
-	clusterManager := cluster.NewManager(cfg, addr)
+	clusterManager := cluster.NewManager(clusterConfig, addr)
+
+	broker := pubsub.NewBroker()
+	configureBroker(settings, broker)
+	broker.PublishKeyspaceEvents(store)
+
+	srv := cmd.NewServer(settings, configureACL(settings))
 
 	var replManager replication.IManager
-	role := os.Getenv("FLASHDB_ROLE")
-	if role == "replica" {
-		masterAddr := os.Getenv("FLASHDB_MASTER_ADDR")
-		go replication.StartReplica(masterAddr, store)
+	if cfg.Role == "replica" {
+		var masterTLS *tls.Config
+		if replicationTLS != nil {
+			masterTLS = replicationTLS.ClientConfig(cfg.MasterAddr)
+		}
+		srv.Replica = &replication.ReplicaStatus{}
+		go replication.StartReplica(cfg.MasterAddr, store, masterTLS, srv.Replica)
 	} else {
 		replManager = replication.NewManager(store)
-		go listenForReplicas(replManager, addr)
+		go listenForReplicas(replManager, addr, replicationTLS)
 	}
-	err = aofWriter.LoadAOF(util.AppendFile, store)
+	err = aofWriter.LoadAOF(cfg.AppendFilename, store)
 	if err != nil {
 		log.Println(err)
 	}
 
-	go autoSave(store, aofWriter)
+	go autoSave(srv, store, aofWriter)
 
 	log.Println("Server is listening on port " + addr)
+	if certs != nil {
+		go reloadOnSIGHUP(certs)
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
@@ -74,10 +110,25 @@ func Start() {
 		<-ctx.Done()
 		log.Println("[server] shutdown signal received")
 		listener.Close()
+		if tlsListener != nil {
+			tlsListener.Close()
+		}
 		store.Close()
 		aofWriter.Close()
 	}()
 
+	handle := func(connection net.Conn) {
+		handleConnection(connection, store, aofWriter, replManager, clusterManager, broker, srv, addr)
+	}
+	if tlsListener != nil {
+		log.Println("Server is listening for TLS on port " + tlsListener.Addr().String())
+		go serve(ctx, tlsListener, handle)
+	}
+	serve(ctx, listener, handle)
+}
+
+// serve accepts connections on listener until ctx is done.
+func serve(ctx context.Context, listener net.Listener, handle func(net.Conn)) {
 	for {
 		connection, err := listener.Accept()
 		if err != nil {
@@ -90,75 +141,239 @@ func Start() {
 				continue
 			}
 		}
-		go handleConnection(connection, store, aofWriter, replManager, clusterManager, addr)
+		go handle(connection)
 	}
-
 }
 
-func handleConnection(conn net.Conn, store store.IStore, aofWriter aof.IAOF, replManager replication.IManager, clusterManager *cluster.Manager, addr string) {
-	defer conn.Close()
+// localAddr binds addresses given as a port alone to the loopback interface.
+func localAddr(addr string) string {
+	if !strings.Contains(addr, "127.0.0.1") && strings.HasPrefix(addr, ":") {
+		return "127.0.0.1" + addr
+	}
+	return addr
+}
 
-	parser := protocol.NewRESPParser()
+func handleConnection(conn net.Conn, store store.IStore, aofWriter aof.IAOF, replManager replication.IManager, clusterManager *cluster.Manager, broker *pubsub.Broker, srv *cmd.Server, addr string) {
+	// Changes to the request limits apply to the connections opened afterwards.
+	parser := protocol.NewRESPParser(srv.Config.Current().Limits())
 	reader := bufio.NewReader(conn)
+	client := cmd.NewClient(conn, srv)
+	defer client.Close()
+	tx := cmd.NewTransaction(srv)
+	defer tx.Reset(store)
+	subscription := cmd.NewSubscription(client, broker)
+	defer subscription.Close()
+	defer func() { subscription.Writer().Flush() }()
 	for {
-		parts, err := parser.ParseRESP(reader)
-		log.Printf("[DEBUG] Parsed command: %#v\n", parts)
+		// A client killed by CLIENT KILL gets the replies to the commands served so far.
+		if client.Killed() {
+			return
+		}
+		// Replies are sent once every pipelined command read so far was served,
+		// before the parser waits for more.
+		if reader.Buffered() == 0 {
+			subscription.Writer().Flush()
+		}
+		args, err := parser.ParseRESP(reader)
 		if err != nil {
 			log.Println("-Error reading from connection:", err.Error())
+			// Like Redis, tell the client why before dropping it.
+			if errors.Is(err, protocol.ErrProtocol) {
+				subscription.Writer().WriteError(err.Error())
+			}
 			return
 
 		}
-		if len(parts) == 0 {
+		if len(args) == 0 {
+			continue
+		}
+		// Once the client subscribed, replies are queued behind its messages.
+		out := subscription.Writer()
+		client.Received(args, reader.Buffered(), out.Buffered(), tx.Queued())
+		// Permissions are checked before anything else looks at the command, a
+		// refused command inside MULTI aborts the transaction like a queuing error.
+		if err := client.Authorize(args, tx.Active()); err != nil {
+			if tx.Active() {
+				tx.Fail()
+			}
+			util.WriteErr(out, err)
 			continue
 		}
 
-		// get the key and compute it then see does this node own it
+		// get the keys and compute their slot then see does this node own it
 		// if not return moved and the owner of the slot
-		if len(parts) > 1 {
-			key := parts[1]
-			slot := clusterManager.GetSlotForKey(key)
+		if keys := cmd.CommandKeys(args); len(keys) > 0 {
+			slot := clusterManager.GetSlotForKey(keys[0])
+			if clusterManager.Enabled() && !sameSlot(clusterManager, keys, slot) {
+				out.WriteError("CROSSSLOT Keys in request don't hash to the same slot")
+				continue
+			}
 			owner := clusterManager.GetOwner(slot)
 			if owner != "" && owner != addr {
 				if !clusterManager.IsLocal(slot) {
-					conn.Write([]byte(fmt.Sprintf("-MOVED %d %s\r\n", slot, owner)))
+					// Clients connected over TLS are sent to the TLS port of the owner.
+					if _, overTLS := conn.(*tls.Conn); overTLS {
+						owner = clusterManager.GetTLSOwner(slot)
+					}
+					out.WriteError(fmt.Sprintf("MOVED %d %s", slot, owner))
 					return
 				}
 			}
 		}
 
-		command := strings.ToUpper(parts[0])
+		command := cmd.CommandName(args[0])
+		// Routed and allowed, the command gets its arguments copied out of the
+		// parser buffer for the handler, which may keep them.
+		parts := protocol.Strings(args)
+		client.WaitUnpaused(out, parts, tx.Active())
+
+		// The time of a command, reported by INFO commandstats, starts once it may run.
+		start := time.Now()
+		dispatch(out, parts, command, client, tx, subscription, store, aofWriter, replManager, clusterManager.Enabled(), srv)
+		srv.Record(parts, time.Since(start))
+	}
+
+}
 
-		if handler, ok := cmd.CommandHandlers[command]; ok {
-			handler(conn, store, parts, aofWriter, replManager)
-		} else {
-			conn.Write([]byte("-ERR unknown command\r\n"))
+// dispatch runs the command in parts, upper case command, for client.
+func dispatch(out protocol.ReplyWriter, parts []string, command string, client *cmd.Client, tx *cmd.Transaction, subscription *cmd.Subscription, store store.IStore, aofWriter aof.IAOF, replManager replication.IManager, clustered bool, srv *cmd.Server) {
+	// Pub/sub commands and HELLO are refused inside MULTI, the transaction handles them.
+	if !tx.Active() && (subscription.Handle(parts) || client.Handle(out, parts, replManager, clustered)) {
+		return
+	}
+	handler, ok := srv.Handler(command)
+	// Replicas leave eviction to their master, which propagates the evicted keys as DEL.
+	if ok && replManager != nil {
+		if err := cmd.EnforceMaxMemory(store, command, aofWriter, replManager); err != nil {
+			tx.Fail()
+			util.WriteErr(out, err)
+			return
 		}
 	}
+	if tx.Handle(out, store, parts, aofWriter, replManager) {
+		return
+	}
+	if ok {
+		cmd.Run(handler, out, store, parts, aofWriter, replManager)
+	} else {
+		util.WriteError(out, "unknown command")
+	}
+}
 
+// configureStore applies the store settings, and again whenever CONFIG SET changes them.
+func configureStore(settings *config.Manager, s store.IStore) {
+	apply := func(c *config.Config) {
+		s.SetExpireCPUPercent(c.ActiveExpireCPU)
+		s.SetMaxMemory(c.MaxMemory, c.MaxMemoryPolicy)
+		s.SetNotifyKeyspaceEvents(c.NotifyKeyspaceEvents)
+	}
+	apply(settings.Current())
+	for _, name := range []string{"active-expire-cpu", "maxmemory", "maxmemory-policy", "notify-keyspace-events"} {
+		settings.OnChange(name, apply)
+	}
+}
+
+// configureACL loads the users from the aclfile, when set, and gives the
+// default user the password of requirepass, now and whenever it changes.
+func configureACL(settings *config.Manager) *acl.ACL {
+	users := cmd.NewACL()
+	cfg := settings.Current()
+	if cfg.ACLFile != "" {
+		users.SetFile(cfg.ACLFile)
+		if _, err := os.Stat(cfg.ACLFile); err == nil {
+			if err := users.Load(); err != nil {
+				log.Fatalf("invalid aclfile: %v", err)
+			}
+		}
+	}
+	if cfg.RequirePass != "" {
+		if err := users.SetUser(acl.DefaultUser, "resetpass", ">"+cfg.RequirePass); err != nil {
+			log.Fatalf("invalid requirepass: %v", err)
+		}
+	}
+	settings.OnChange("requirepass", func(c *config.Config) {
+		rules := []string{"nopass"}
+		if c.RequirePass != "" {
+			rules = []string{"resetpass", ">" + c.RequirePass}
+		}
+		if err := users.SetUser(acl.DefaultUser, rules...); err != nil {
+			log.Printf("[acl] failed to apply requirepass: %v", err)
+		}
+	})
+	return users
 }
 
-func autoSave(s store.IStore, aof aof.IAOF) {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+// reloadOnSIGHUP reloads the certificates on every SIGHUP, for the connections opened afterwards.
+func reloadOnSIGHUP(certs *tlsconfig.Certificates) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := certs.Reload(); err != nil {
+			log.Printf("[tls] keeping the current certificates: %v", err)
+			continue
+		}
+		log.Println("[tls] certificates reloaded")
+	}
+}
+
+// configureBroker applies the pub/sub settings, and again whenever CONFIG SET changes them.
+func configureBroker(settings *config.Manager, b *pubsub.Broker) {
+	apply := func(c *config.Config) {
+		b.SetOutputLimit(c.PubSubOutputLimit)
+	}
+	apply(settings.Current())
+	settings.OnChange("pubsub-output-limit", apply)
+}
+
+// sameSlot reports whether every key hashes to slot.
+func sameSlot(clusterManager *cluster.Manager, keys [][]byte, slot int) bool {
+	for _, key := range keys {
+		if clusterManager.GetSlotForKey(key) != slot {
+			return false
+		}
+	}
+	return true
+}
+
+// autoSave takes a snapshot every autosave-interval, following the changes CONFIG SET makes to it.
+func autoSave(srv *cmd.Server, s store.IStore, aof aof.IAOF) {
+	changed := make(chan struct{}, 1)
+	srv.Config.OnChange("autosave-interval", func(*config.Config) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
 	for {
+		var tick <-chan time.Time
+		var timer *time.Timer
+		if interval := srv.Config.Current().AutosaveInterval; interval > 0 {
+			timer = time.NewTimer(interval)
+			tick = timer.C
+		}
 		select {
 		case <-s.StopChan():
 			return
-		case <-ticker.C:
-			if err := s.Save(util.FileName); err != nil {
-				log.Printf("[autosave] snapshot save failed: %v", err)
-			}
-			if err := aof.Reset(); err != nil {
-				log.Printf("[autosave] AOF reset failed: %v", err)
+		case <-changed:
+		case <-tick:
+			if err := srv.Save(s, aof); err != nil {
+				log.Printf("[autosave] %v", err)
 			}
 		}
+		if timer != nil {
+			timer.Stop()
+		}
 	}
 }
 
-func listenForReplicas(m replication.IManager, addr string) {
+// listenForReplicas serves the replication port, over TLS when certs is not nil.
+func listenForReplicas(m replication.IManager, addr string, certs *tlsconfig.Certificates) {
 	replicationPort := 10000 + extractPort(addr)
 	listenAddr := fmt.Sprintf(":%d", replicationPort)
 	ln, err := net.Listen("tcp", listenAddr)
+	if err == nil && certs != nil {
+		ln = tls.NewListener(ln, certs.ServerConfig())
+	}
 
 	if err != nil {
 		log.Printf("replication listener failed: %v", err)
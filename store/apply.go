@@ -0,0 +1,489 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ApplyCommand replays a write command that was recorded in the AOF or received
+from the master over the replication link.
+
+Commands are expected in the form the server propagates them, which is not
+always the form the client sent: HINCRBYFLOAT for example is propagated as an
+HSET of the resulting value so that replaying it never depends on float rounding.
+It takes no transaction lock, the Replayer holds it around the call.
+*/
+func ApplyCommand(s IStore, parts []string) error {
+	if len(parts) == 0 {
+		return nil
+	}
+	command := strings.ToUpper(parts[0])
+	args := parts[1:]
+	switch command {
+	case "SET":
+		if len(args) < 2 {
+			return errArgs(command)
+		}
+		opts, err := ParseSetArgs(args[2:])
+		if err != nil {
+			return err
+		}
+		_, _, err = s.SetWithOptions(args[0], []byte(args[1]), opts)
+		return err
+	case "DEL":
+		if len(args) < 1 {
+			return errArgs(command)
+		}
+		s.Del(args)
+	case "EXPIRE", "PEXPIREAT":
+		if len(args) != 2 {
+			return errArgs(command)
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		// EXPIRE with relative seconds is only found in AOF files written by older versions.
+		at := time.UnixMilli(n)
+		if command == "EXPIRE" {
+			at = time.Now().Add(time.Duration(n) * time.Second)
+		}
+		s.Expire(args[0], at, ExpireOptions{})
+	case "MSET":
+		if len(args) < 2 || len(args)%2 != 0 {
+			return errArgs(command)
+		}
+		values := make(map[string][]byte, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			values[args[i]] = []byte(args[i+1])
+		}
+		s.MSet(values)
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		if len(args) < 1 {
+			return errArgs(command)
+		}
+		delta := int64(1)
+		if len(args) > 1 {
+			n, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			delta = n
+		}
+		if command[0] == 'D' {
+			delta = -delta
+		}
+		_, err := s.IncrBy(args[0], delta)
+		return err
+	case "INCRBYFLOAT":
+		if len(args) != 2 {
+			return errArgs(command)
+		}
+		delta, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return err
+		}
+		_, err = s.IncrByFloat(args[0], delta)
+		return err
+	case "APPEND":
+		if len(args) != 2 {
+			return errArgs(command)
+		}
+		_, err := s.Append(args[0], []byte(args[1]))
+		return err
+	case "SETRANGE":
+		if len(args) != 3 {
+			return errArgs(command)
+		}
+		offset, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		_, err = s.SetRange(args[0], offset, []byte(args[2]))
+		return err
+	case "GETEX":
+		if len(args) < 2 {
+			return errArgs(command)
+		}
+		if strings.EqualFold(args[1], "PERSIST") {
+			_, err := s.GetEx(args[0], time.Time{}, true)
+			return err
+		}
+		if len(args) != 3 || !strings.EqualFold(args[1], "PXAT") {
+			return ErrSyntax
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		_, err = s.GetEx(args[0], time.UnixMilli(ms), false)
+		return err
+	case "FLUSHALL":
+		s.Flush()
+	case "RENAME", "RENAMENX":
+		if len(args) != 2 {
+			return errArgs(command)
+		}
+		_, err := s.Rename(args[0], args[1], command == "RENAMENX")
+		return err
+	case "COPY":
+		if len(args) < 2 {
+			return errArgs(command)
+		}
+		_, err := s.Copy(args[0], args[1], len(args) > 2 && strings.EqualFold(args[2], "REPLACE"))
+		return err
+	case "PERSIST":
+		if len(args) != 1 {
+			return errArgs(command)
+		}
+		s.Persist(args[0])
+	case "TOUCH":
+		if len(args) < 1 {
+			return errArgs(command)
+		}
+		s.Touch(args)
+	case "HSET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return errArgs(command)
+		}
+		fields := make(map[string][]byte, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			fields[args[i]] = []byte(args[i+1])
+		}
+		_, err := s.HSet(args[0], fields)
+		return err
+	case "HDEL":
+		if len(args) < 2 {
+			return errArgs(command)
+		}
+		_, err := s.HDel(args[0], args[1:])
+		return err
+	case "HINCRBY":
+		if len(args) != 3 {
+			return errArgs(command)
+		}
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		_, err = s.HIncrBy(args[0], args[1], delta)
+		return err
+	case "LPUSH", "RPUSH", "LPUSHX", "RPUSHX":
+		if len(args) < 2 {
+			return errArgs(command)
+		}
+		values := make([][]byte, len(args)-1)
+		for i, arg := range args[1:] {
+			values[i] = []byte(arg)
+		}
+		var err error
+		if command[0] == 'L' {
+			_, err = s.LPush(args[0], values)
+		} else {
+			_, err = s.RPush(args[0], values)
+		}
+		return err
+	case "LPOP", "RPOP":
+		if len(args) < 1 {
+			return errArgs(command)
+		}
+		count := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return err
+			}
+			count = n
+		}
+		var err error
+		if command == "LPOP" {
+			_, err = s.LPop(args[0], count)
+		} else {
+			_, err = s.RPop(args[0], count)
+		}
+		return err
+	case "LSET":
+		if len(args) != 3 {
+			return errArgs(command)
+		}
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		return s.LSet(args[0], index, []byte(args[2]))
+	case "LTRIM":
+		if len(args) != 3 {
+			return errArgs(command)
+		}
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return ErrNotInt
+		}
+		return s.LTrim(args[0], start, stop)
+	case "LREM":
+		if len(args) != 3 {
+			return errArgs(command)
+		}
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		_, err = s.LRem(args[0], count, []byte(args[2]))
+		return err
+	case "LINSERT":
+		if len(args) != 4 {
+			return errArgs(command)
+		}
+		before := strings.EqualFold(args[1], "BEFORE")
+		_, err := s.LInsert(args[0], before, []byte(args[2]), []byte(args[3]))
+		return err
+	case "LMOVE":
+		if len(args) != 4 {
+			return errArgs(command)
+		}
+		_, err := s.LMove(args[0], args[1], strings.EqualFold(args[2], "LEFT"), strings.EqualFold(args[3], "LEFT"))
+		return err
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			return errArgs(command)
+		}
+		var err error
+		if command == "SADD" {
+			_, err = s.SAdd(args[0], args[1:])
+		} else {
+			_, err = s.SRem(args[0], args[1:])
+		}
+		return err
+	case "SMOVE":
+		if len(args) != 3 {
+			return errArgs(command)
+		}
+		_, err := s.SMove(args[0], args[1], args[2])
+		return err
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		if len(args) < 2 {
+			return errArgs(command)
+		}
+		var err error
+		switch command {
+		case "SINTERSTORE":
+			_, err = s.SInterStore(args[0], args[1:])
+		case "SUNIONSTORE":
+			_, err = s.SUnionStore(args[0], args[1:])
+		case "SDIFFSTORE":
+			_, err = s.SDiffStore(args[0], args[1:])
+		}
+		return err
+	case "ZADD":
+		if len(args) < 3 {
+			return errArgs(command)
+		}
+		members, opts, incr, err := ParseZAddArgs(args[1:])
+		if err != nil {
+			return err
+		}
+		if incr {
+			_, _, err = s.ZAddIncr(args[0], members[0].Member, members[0].Score, opts)
+		} else {
+			_, err = s.ZAdd(args[0], members, opts)
+		}
+		return err
+	case "ZREM":
+		if len(args) < 2 {
+			return errArgs(command)
+		}
+		_, err := s.ZRem(args[0], args[1:])
+		return err
+	case "ZPOPMIN", "ZPOPMAX":
+		if len(args) < 1 {
+			return errArgs(command)
+		}
+		count := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return err
+			}
+			count = n
+		}
+		var err error
+		if command == "ZPOPMIN" {
+			_, err = s.ZPopMin(args[0], count)
+		} else {
+			_, err = s.ZPopMax(args[0], count)
+		}
+		return err
+	case "ZUNIONSTORE", "ZINTERSTORE":
+		if len(args) < 3 {
+			return errArgs(command)
+		}
+		keys, weights, aggregate, err := ParseZStoreArgs(args[1:])
+		if err != nil {
+			return err
+		}
+		if command == "ZUNIONSTORE" {
+			_, err = s.ZUnionStore(args[0], keys, weights, aggregate)
+		} else {
+			_, err = s.ZInterStore(args[0], keys, weights, aggregate)
+		}
+		return err
+	case "XADD":
+		if len(args) < 4 {
+			return errArgs(command)
+		}
+		opts, id, fields, err := ParseXAddArgs(args[1:])
+		if err != nil {
+			return err
+		}
+		_, _, err = s.XAdd(args[0], id, fields, opts)
+		return err
+	case "XDEL", "XACK":
+		minArgs := 2
+		if command == "XACK" {
+			minArgs = 3
+		}
+		if len(args) < minArgs {
+			return errArgs(command)
+		}
+		ids := make([]StreamID, 0, len(args))
+		for _, arg := range args[minArgs-1:] {
+			id, err := ParseStreamID(arg, 0)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		var err error
+		if command == "XDEL" {
+			_, err = s.XDel(args[0], ids)
+		} else {
+			_, err = s.XAck(args[0], args[1], ids)
+		}
+		return err
+	case "XTRIM":
+		if len(args) < 3 {
+			return errArgs(command)
+		}
+		trim, _, err := ParseStreamTrim(args[1:])
+		if err != nil {
+			return err
+		}
+		_, err = s.XTrim(args[0], *trim)
+		return err
+	case "XGROUP":
+		if len(args) < 3 {
+			return errArgs(command)
+		}
+		key, group := args[1], args[2]
+		var err error
+		switch strings.ToUpper(args[0]) {
+		case "CREATE":
+			if len(args) < 4 {
+				return errArgs(command)
+			}
+			mkStream := len(args) > 4 && strings.EqualFold(args[4], "MKSTREAM")
+			_, err = s.XGroupCreate(key, group, args[3], mkStream)
+		case "SETID":
+			if len(args) < 4 {
+				return errArgs(command)
+			}
+			_, err = s.XGroupSetID(key, group, args[3])
+		case "DESTROY":
+			_, err = s.XGroupDestroy(key, group)
+		case "CREATECONSUMER", "DELCONSUMER":
+			if len(args) < 4 {
+				return errArgs(command)
+			}
+			if strings.EqualFold(args[0], "CREATECONSUMER") {
+				_, err = s.XGroupCreateConsumer(key, group, args[3])
+			} else {
+				_, err = s.XGroupDelConsumer(key, group, args[3])
+			}
+		default:
+			return fmt.Errorf("unknown XGROUP subcommand %s", args[0])
+		}
+		return err
+	case "XCLAIM":
+		if len(args) < 5 {
+			return errArgs(command)
+		}
+		minIdle, ids, opts, err := ParseXClaimArgs(args[3:])
+		if err != nil {
+			return err
+		}
+		_, err = s.XClaim(args[0], args[1], args[2], minIdle, ids, opts)
+		return err
+	default:
+		return fmt.Errorf("unknown command %s", command)
+	}
+	return nil
+}
+
+func errArgs(command string) error {
+	return fmt.Errorf("wrong number of arguments for '%s' command", command)
+}
+
+/*
+Replayer applies a stream of propagated commands, the AOF or the replication
+link. The writes of a transaction are propagated between MULTI and EXEC, the
+replayer holds them back until the EXEC and applies them under LockExclusive,
+so that a transaction is applied whole or not at all, and the other commands
+under LockShared, like the commands of a client. A MULTI block cut short
+by a crash is left Pending and never applied.
+*/
+type Replayer struct {
+	s      IStore
+	multi  bool
+	queued [][]string
+}
+
+func NewReplayer(s IStore) *Replayer {
+	return &Replayer{s: s}
+}
+
+// Apply applies parts, or queues it while a MULTI block is open.
+func (r *Replayer) Apply(parts []string) error {
+	if len(parts) == 0 {
+		return nil
+	}
+	switch strings.ToUpper(parts[0]) {
+	case "MULTI":
+		r.multi = true
+		r.queued = nil
+		return nil
+	case "EXEC":
+		if !r.multi {
+			return errors.New("EXEC without MULTI")
+		}
+		queued := r.queued
+		r.multi = false
+		r.queued = nil
+		unlock := r.s.LockExclusive()
+		defer unlock()
+		var errs []error
+		for _, command := range queued {
+			if err := ApplyCommand(r.s, command); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", command[0], err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	if r.multi {
+		r.queued = append(r.queued, parts)
+		return nil
+	}
+	// Like a client command, it never runs in the middle of an EXEC.
+	unlock := r.s.LockShared()
+	defer unlock()
+	return ApplyCommand(r.s, parts)
+}
+
+// Pending returns the number of commands queued in an unfinished MULTI block.
+func (r *Replayer) Pending() int {
+	return len(r.queued)
+}
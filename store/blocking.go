@@ -0,0 +1,167 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+waiter is a client parked on one or more keys by a blocking command such as BLPOP.
+
+serve tries to satisfy the client from the given key and reports whether it did.
+It is always called without any shard lock held and under w.mu, so a waiter is
+served at most once even when several of its keys become ready at the same time.
+*/
+type waiter struct {
+	mu    sync.Mutex
+	done  bool
+	keys  []string
+	ready chan struct{}
+	serve func(key string) bool
+}
+
+// try attempts to serve the waiter from key. It returns false only when the key
+// had nothing to offer, a waiter that already finished counts as handled.
+func (w *waiter) try(key string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return true
+	}
+	if !w.serve(key) {
+		return false
+	}
+	w.done = true
+	close(w.ready)
+	return true
+}
+
+// cancel marks the waiter as finished and reports whether it had not been served yet.
+func (w *waiter) cancel() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return false
+	}
+	w.done = true
+	return true
+}
+
+/*
+blockingRegistry keeps, for every key, the queue of clients blocked on it.
+
+Clients are served in the order they blocked: when a key receives new data
+signal walks its queue from the front and serves waiters until one of them
+finds nothing left to take.
+*/
+type blockingRegistry struct {
+	mu      sync.Mutex
+	waiters map[string][]*waiter
+}
+
+func newBlockingRegistry() *blockingRegistry {
+	return &blockingRegistry{waiters: make(map[string][]*waiter)}
+}
+
+func (r *blockingRegistry) register(w *waiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range w.keys {
+		r.waiters[key] = append(r.waiters[key], w)
+	}
+}
+
+func (r *blockingRegistry) unregister(w *waiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range w.keys {
+		queue := r.waiters[key]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(r.waiters, key)
+		} else {
+			r.waiters[key] = queue
+		}
+	}
+}
+
+func (r *blockingRegistry) signal(key string) {
+	r.mu.Lock()
+	queue := append([]*waiter(nil), r.waiters[key]...)
+	r.mu.Unlock()
+
+	for _, w := range queue {
+		if !w.try(key) {
+			return
+		}
+		r.unregister(w)
+	}
+}
+
+// signalKey wakes the clients blocked on key. Must be called after the shard lock is released.
+func (s *Store) signalKey(key string) {
+	s.blocked.signal(key)
+}
+
+/*
+lockToWait takes the transaction lock for the attempts a blocking command makes
+before waiting, when timeout lets it wait: the command then runs without the
+lock of Run, so that a client waiting for a key never holds back the EXEC that
+may write it. With a negative timeout the command never waits and the caller's
+lock, the one of Run or of EXEC, already covers it. The returned function may be
+called more than once.
+*/
+func (s *Store) lockToWait(timeout time.Duration) func() {
+	if timeout < 0 {
+		return func() {}
+	}
+	return sync.OnceFunc(s.LockShared())
+}
+
+/*
+block parks the caller until serve succeeds for one of keys, the timeout elapses
+or the store is closed. A zero timeout blocks forever and a negative one only
+tries serve once, which is how blocking commands behave inside a transaction.
+
+The waiter is registered before the first attempt so that a push landing between
+the attempt and the registration can never be missed.
+*/
+func (s *Store) block(keys []string, timeout time.Duration, serve func(key string) bool) bool {
+	w := &waiter{
+		keys:  keys,
+		ready: make(chan struct{}),
+		serve: serve,
+	}
+	s.blocked.register(w)
+	defer s.blocked.unregister(w)
+
+	unlock := s.lockToWait(timeout)
+	for _, key := range keys {
+		w.try(key)
+	}
+	unlock()
+	if timeout < 0 {
+		return !w.cancel()
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-w.ready:
+		return true
+	case <-expired:
+	case <-s.Stop:
+	}
+	// serve may have won the race against the timeout.
+	return !w.cancel()
+}
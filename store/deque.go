@@ -0,0 +1,112 @@
+package store
+
+// List is a double ended queue of values backed by a growable ring buffer.
+// Pushes and pops at both ends are amortised O(1) and indexing is O(1),
+// which keeps LINDEX/LRANGE cheap compared to a linked list.
+type List struct {
+	buf  [][]byte
+	head int
+	size int
+}
+
+func NewList() *List {
+	return &List{buf: make([][]byte, 8)}
+}
+
+func (l *List) Len() int {
+	return l.size
+}
+
+func (l *List) grow() {
+	if l.size < len(l.buf) {
+		return
+	}
+	buf := make([][]byte, len(l.buf)*2)
+	for i := 0; i < l.size; i++ {
+		buf[i] = l.buf[(l.head+i)%len(l.buf)]
+	}
+	l.buf = buf
+	l.head = 0
+}
+
+func (l *List) pos(i int) int {
+	return (l.head + i) % len(l.buf)
+}
+
+func (l *List) PushFront(v []byte) {
+	l.grow()
+	l.head = (l.head - 1 + len(l.buf)) % len(l.buf)
+	l.buf[l.head] = v
+	l.size++
+}
+
+func (l *List) PushBack(v []byte) {
+	l.grow()
+	l.buf[l.pos(l.size)] = v
+	l.size++
+}
+
+func (l *List) PopFront() []byte {
+	if l.size == 0 {
+		return nil
+	}
+	v := l.buf[l.head]
+	l.buf[l.head] = nil
+	l.head = l.pos(1)
+	l.size--
+	return v
+}
+
+func (l *List) PopBack() []byte {
+	if l.size == 0 {
+		return nil
+	}
+	p := l.pos(l.size - 1)
+	v := l.buf[p]
+	l.buf[p] = nil
+	l.size--
+	return v
+}
+
+// Index returns the i-th element counting from the head, 0 <= i < Len().
+func (l *List) Index(i int) []byte {
+	return l.buf[l.pos(i)]
+}
+
+func (l *List) Set(i int, v []byte) {
+	l.buf[l.pos(i)] = v
+}
+
+// Range returns a copy of the elements between start and stop inclusive.
+func (l *List) Range(start, stop int) [][]byte {
+	out := make([][]byte, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		out = append(out, l.Index(i))
+	}
+	return out
+}
+
+// Insert places v before the i-th element, shifting the tail by one.
+func (l *List) Insert(i int, v []byte) {
+	l.PushBack(nil)
+	for j := l.size - 1; j > i; j-- {
+		l.Set(j, l.Index(j-1))
+	}
+	l.Set(i, v)
+}
+
+// Filter keeps only the elements for which keep returns true, preserving order.
+func (l *List) Filter(keep func(i int, v []byte) bool) {
+	n := 0
+	for i := 0; i < l.size; i++ {
+		v := l.Index(i)
+		if keep(i, v) {
+			l.Set(n, v)
+			n++
+		}
+	}
+	for i := n; i < l.size; i++ {
+		l.Set(i, nil)
+	}
+	l.size = n
+}
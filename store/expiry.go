@@ -0,0 +1,152 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+)
+
+/*
+Active expiration
+
+Keys are removed lazily when a command finds them expired, and actively by a
+background cycle so that keys nobody reads do not hold on to memory. Each shard
+keeps its keys with a TTL in a min-heap ordered by expiry time, indexed by key
+so that changing or removing a TTL is O(log n). The cycle only ever looks at the
+top of the heaps, it never walks the keyspace.
+
+Every ExpireCycleInterval the cycle pops due keys from the shards in turn, in
+batches of expireBatch per lock acquisition, until nothing is due or its CPU
+budget, a percentage of the interval, is spent. Whatever is left over is picked
+up on the next run.
+*/
+const (
+	ExpireCycleInterval     = 100 * time.Millisecond
+	DefaultExpireCPUPercent = 25
+	expireBatch             = 64
+)
+
+type expiryEntry struct {
+	key   string
+	at    time.Time
+	index int
+}
+
+// expiryIndex is a min-heap of the keys of a shard that have a TTL.
+type expiryIndex struct {
+	entries []*expiryEntry
+	byKey   map[string]*expiryEntry
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{byKey: make(map[string]*expiryEntry)}
+}
+
+func (x *expiryIndex) Len() int           { return len(x.entries) }
+func (x *expiryIndex) Less(i, j int) bool { return x.entries[i].at.Before(x.entries[j].at) }
+
+func (x *expiryIndex) Swap(i, j int) {
+	x.entries[i], x.entries[j] = x.entries[j], x.entries[i]
+	x.entries[i].index = i
+	x.entries[j].index = j
+}
+
+func (x *expiryIndex) Push(v any) {
+	e := v.(*expiryEntry)
+	e.index = len(x.entries)
+	x.entries = append(x.entries, e)
+}
+
+func (x *expiryIndex) Pop() any {
+	n := len(x.entries) - 1
+	e := x.entries[n]
+	x.entries[n] = nil
+	x.entries = x.entries[:n]
+	return e
+}
+
+// set records that key expires at the given time, a zero time removing it from the index.
+func (x *expiryIndex) set(key string, at time.Time) {
+	if at.IsZero() {
+		x.remove(key)
+		return
+	}
+	if e, ok := x.byKey[key]; ok {
+		e.at = at
+		heap.Fix(x, e.index)
+		return
+	}
+	e := &expiryEntry{key: key, at: at}
+	x.byKey[key] = e
+	heap.Push(x, e)
+}
+
+func (x *expiryIndex) remove(key string) {
+	if e, ok := x.byKey[key]; ok {
+		heap.Remove(x, e.index)
+		delete(x.byKey, key)
+	}
+}
+
+// due returns the key expiring first if it has expired by now.
+func (x *expiryIndex) due(now time.Time) (string, bool) {
+	if len(x.entries) == 0 || x.entries[0].at.After(now) {
+		return "", false
+	}
+	return x.entries[0].key, true
+}
+
+// expireDue removes up to max expired keys and reports whether more are due.
+// The caller must hold the shard write lock.
+func (sh *shard) expireDue(now time.Time, max int) bool {
+	for i := 0; i < max; i++ {
+		key, ok := sh.expires.due(now)
+		if !ok {
+			return false
+		}
+		sh.expire(key)
+	}
+	_, more := sh.expires.due(now)
+	return more
+}
+
+// SetExpireCPUPercent sets the share of each ExpireCycleInterval the active
+// expiration cycle may use, between 1 and 100.
+func (s *Store) SetExpireCPUPercent(percent int) {
+	s.expireCPUPercent.Store(int32(min(max(percent, 1), 100)))
+}
+
+/*
+activeExpireCycle runs in a background goroutine until the store is closed and
+removes the expired keys nobody reads. See the top of this file.
+*/
+func activeExpireCycle(s *Store) {
+	ticker := time.NewTicker(ExpireCycleInterval)
+	defer ticker.Stop()
+	next := 0
+	for {
+		select {
+		case <-s.Stop:
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		deadline := start.Add(ExpireCycleInterval * time.Duration(s.expireCPUPercent.Load()) / 100)
+		// Go round the shards until a full round finds nothing due or the budget is spent.
+		idle := 0
+		for idle < len(s.shards) && time.Now().Before(deadline) {
+			shard := s.shards[next]
+			next = (next + 1) % len(s.shards)
+			// Keys never expire in the middle of an EXEC.
+			unlock := s.LockShared()
+			shard.mu.Lock()
+			more := shard.expireDue(start, expireBatch)
+			shard.unlock()
+			unlock()
+			if more {
+				idle = 0
+			} else {
+				idle++
+			}
+		}
+	}
+}
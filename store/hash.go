@@ -0,0 +1,290 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+var (
+	ErrHashNotInt   = errors.New("ERR hash value is not an integer")
+	ErrHashNotFloat = errors.New("ERR hash value is not a float")
+	ErrNaNOrInf     = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+// IHashStore groups the operations on hash values. Every method runs atomically
+// under the lock of the shard owning the key and returns ErrWrongType when the
+// key holds something other than a hash.
+type IHashStore interface {
+	HSet(key string, fields map[string][]byte) (int, error)
+	HSetNX(key, field string, value []byte) (bool, error)
+	HGet(key, field string) ([]byte, error)
+	HMGet(key string, fields []string) ([][]byte, error)
+	HDel(key string, fields []string) (int, error)
+	HLen(key string) (int, error)
+	HExists(key, field string) (bool, error)
+	HKeys(key string) ([]string, error)
+	HVals(key string) ([][]byte, error)
+	HGetAll(key string) (map[string][]byte, error)
+	HIncrBy(key, field string, delta int64) (int64, error)
+	HIncrByFloat(key, field string, delta float64) ([]byte, error)
+}
+
+// readHash returns the hash stored under key or nil when the key does not exist.
+// The caller must hold at least the shard read lock.
+func (sh *shard) readHash(key string) (map[string][]byte, error) {
+	item := sh.peek(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != TypeHash {
+		return nil, ErrWrongType
+	}
+	return item.Hash, nil
+}
+
+// writeHash returns the hash item stored under key, creating an empty one when
+// the key does not exist. The caller must hold the shard write lock.
+func (sh *shard) writeHash(key string) (*Item, error) {
+	item := sh.lookup(key)
+	if item == nil {
+		item = &Item{Key: key, Type: TypeHash, Hash: make(map[string][]byte)}
+		sh.put(item)
+	}
+	if item.Type != TypeHash {
+		return nil, ErrWrongType
+	}
+	return item, nil
+}
+
+// setField sets field of the hash item and reports whether the field is new.
+// Every write of a hash goes through setField and delField so that its scan
+// index stays in sync.
+func (i *Item) setField(field string, value []byte) bool {
+	_, exists := i.Hash[field]
+	i.Hash[field] = value
+	if !exists {
+		if i.members == nil {
+			i.members = &scanIndex{}
+		}
+		i.members.add(field)
+	}
+	return !exists
+}
+
+// delField removes field from the hash item and reports whether it was there.
+func (i *Item) delField(field string) bool {
+	if _, exists := i.Hash[field]; !exists {
+		return false
+	}
+	delete(i.Hash, field)
+	i.members.remove(field)
+	return true
+}
+
+func (s *Store) HSet(key string, fields map[string][]byte) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeHash(key)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for field, value := range fields {
+		if item.setField(field, value) {
+			added++
+		}
+	}
+	shard.notify(NotifyHash, "hset", key)
+	return added, nil
+}
+
+func (s *Store) HSetNX(key, field string, value []byte) (bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeHash(key)
+	if err != nil {
+		return false, err
+	}
+	if _, exists := item.Hash[field]; exists {
+		return false, nil
+	}
+	item.setField(field, value)
+	shard.notify(NotifyHash, "hset", key)
+	return true, nil
+}
+
+func (s *Store) HGet(key, field string) ([]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	hash, err := shard.readHash(key)
+	if err != nil {
+		return nil, err
+	}
+	return hash[field], nil
+}
+
+// HMGet returns the values of fields in order, with nil for the missing ones.
+func (s *Store) HMGet(key string, fields []string) ([][]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	hash, err := shard.readHash(key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(fields))
+	for i, field := range fields {
+		values[i] = hash[field]
+	}
+	return values, nil
+}
+
+// HDel removes fields from the hash and deletes the key once the hash is empty.
+func (s *Store) HDel(key string, fields []string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item := shard.lookup(key)
+	if item == nil {
+		return 0, nil
+	}
+	if item.Type != TypeHash {
+		return 0, ErrWrongType
+	}
+	removed := 0
+	for _, field := range fields {
+		if item.delField(field) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		shard.notify(NotifyHash, "hdel", key)
+	}
+	if len(item.Hash) == 0 {
+		shard.drop(key)
+	}
+	return removed, nil
+}
+
+func (s *Store) HLen(key string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	hash, err := shard.readHash(key)
+	return len(hash), err
+}
+
+func (s *Store) HExists(key, field string) (bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	hash, err := shard.readHash(key)
+	if err != nil {
+		return false, err
+	}
+	_, exists := hash[field]
+	return exists, nil
+}
+
+func (s *Store) HKeys(key string) ([]string, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	hash, err := shard.readHash(key)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(hash))
+	for field := range hash {
+		keys = append(keys, field)
+	}
+	return keys, nil
+}
+
+func (s *Store) HVals(key string) ([][]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	hash, err := shard.readHash(key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, len(hash))
+	for _, value := range hash {
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// HGetAll returns a copy of the hash so the caller can use it after the lock is released.
+func (s *Store) HGetAll(key string) (map[string][]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	hash, err := shard.readHash(key)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]byte, len(hash))
+	for field, value := range hash {
+		result[field] = value
+	}
+	return result, nil
+}
+
+func (s *Store) HIncrBy(key, field string, delta int64) (int64, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeHash(key)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if raw, exists := item.Hash[field]; exists {
+		current, err = strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return 0, ErrHashNotInt
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	current += delta
+	item.setField(field, strconv.AppendInt(nil, current, 10))
+	shard.notify(NotifyHash, "hincrby", key)
+	return current, nil
+}
+
+// HIncrByFloat returns the new value formatted the same way it is stored.
+func (s *Store) HIncrByFloat(key, field string, delta float64) ([]byte, error) {
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return nil, ErrNaNOrInf
+	}
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeHash(key)
+	if err != nil {
+		return nil, err
+	}
+	var current float64
+	if raw, exists := item.Hash[field]; exists {
+		current, err = strconv.ParseFloat(string(raw), 64)
+		if err != nil || math.IsNaN(current) {
+			return nil, ErrHashNotFloat
+		}
+	}
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return nil, ErrNaNOrInf
+	}
+	value := strconv.AppendFloat(nil, current, 'f', -1, 64)
+	item.setField(field, value)
+	shard.notify(NotifyHash, "hincrbyfloat", key)
+	return value, nil
+}
@@ -0,0 +1,368 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+var ErrSameObject = errors.New("ERR source and destination objects are the same")
+
+// ExpireOptions carries the NX/XX/GT/LT conditions of the EXPIRE family. For
+// GT and LT a key without TTL counts as expiring never.
+type ExpireOptions struct {
+	NX, XX, GT, LT bool
+}
+
+// IKeyspaceStore groups the operations acting on whole keys whatever their type.
+type IKeyspaceStore interface {
+	DBSize() int
+	Flush()
+	Rename(src, dst string, nx bool) (bool, error)
+	Copy(src, dst string, replace bool) (bool, error)
+	Type(key string) string
+	RandomKey() (string, bool)
+	Touch(keys []string) int
+	Expire(key string, at time.Time, opts ExpireOptions) bool
+	ExpireTime(key string) (time.Time, bool)
+	Persist(key string) bool
+	Stats() KeyspaceStats
+	ResetStats()
+}
+
+// KeyspaceStats holds the keyspace counters reported by INFO.
+type KeyspaceStats struct {
+	Keys int
+	// Expires is the number of keys with a TTL.
+	Expires int
+	// ExpiredKeys counts the keys removed because their TTL ran out, whether a
+	// command found them expired or the active expiration cycle did.
+	ExpiredKeys int64
+	// KeyspaceHits and KeyspaceMisses count the lookups of read commands that
+	// found the key, or did not.
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+}
+
+// ParseExpireOptions parses the conditions following the time of EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT.
+func ParseExpireOptions(args []string) (ExpireOptions, error) {
+	var opts ExpireOptions
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		default:
+			return opts, errors.New("ERR Unsupported option " + arg)
+		}
+	}
+	if opts.NX && (opts.XX || opts.GT || opts.LT) {
+		return opts, errors.New("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if opts.GT && opts.LT {
+		return opts, errors.New("ERR GT and LT options at the same time are not compatible")
+	}
+	return opts, nil
+}
+
+/*
+ExpireAtMillis resolves the time argument of an expiry option or command to a
+unix timestamp in milliseconds: n counts seconds or milliseconds, and is relative
+to now or absolute. It returns false when the timestamp does not fit in 64 bits.
+
+Commands propagate the resolved timestamp, which must replay to the same expiry.
+*/
+func ExpireAtMillis(n int64, seconds, relative bool) (int64, bool) {
+	if seconds {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if relative {
+		now := time.Now().UnixMilli()
+		if n > math.MaxInt64-now {
+			return 0, false
+		}
+		n += now
+	}
+	return n, true
+}
+
+// ResetStats zeroes the counters of expired and evicted keys and of keyspace
+// hits and misses, for CONFIG RESETSTAT.
+func (s *Store) ResetStats() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		shard.expired = 0
+		shard.hits.Store(0)
+		shard.misses.Store(0)
+		shard.mu.Unlock()
+	}
+	s.evicted.Store(0)
+}
+
+/*
+DBSize returns the number of keys in the store. Like in Redis, keys that have
+expired but were not removed yet are counted.
+*/
+func (s *Store) DBSize() int {
+	n := 0
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		n += len(shard.data)
+		shard.mu.RUnlock()
+	}
+	return n
+}
+
+func (s *Store) Stats() KeyspaceStats {
+	var stats KeyspaceStats
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		stats.Keys += len(shard.data)
+		stats.Expires += shard.expires.Len()
+		stats.ExpiredKeys += shard.expired
+		stats.KeyspaceHits += shard.hits.Load()
+		stats.KeyspaceMisses += shard.misses.Load()
+		shard.mu.RUnlock()
+	}
+	return stats
+}
+
+/*
+Flush removes every key. Each shard gets a fresh map and the old ones are left
+to the garbage collector, so flushing never walks the keyspace.
+*/
+func (s *Store) Flush() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		shard.data = make(map[string]*Item)
+		shard.expires = newExpiryIndex()
+		shard.keys = scanIndex{}
+		shard.used.Store(0)
+		for _, w := range shard.watched {
+			w.version++
+		}
+		shard.unlock()
+	}
+}
+
+/*
+Rename moves the value of src to dst, overwriting dst unless nx is set, in
+which case false is returned when dst exists. The item keeps its type and
+expiry. ErrNoSuchKey is returned when src does not exist.
+*/
+func (s *Store) Rename(src, dst string, nx bool) (bool, error) {
+	unlock := s.lockKeys(src, dst)
+	srcShard, dstShard := s.shardFor(src), s.shardFor(dst)
+	item := srcShard.lookup(src)
+	if item == nil {
+		unlock()
+		return false, ErrNoSuchKey
+	}
+	if nx && dstShard.lookup(dst) != nil {
+		unlock()
+		return false, nil
+	}
+	if src != dst {
+		moved := *item
+		moved.Key = dst
+		srcShard.remove(src)
+		dstShard.put(&moved)
+	}
+	srcShard.notify(NotifyGeneric, "rename_from", src)
+	dstShard.notify(NotifyGeneric, "rename_to", dst)
+	unlock()
+	s.signalKey(dst)
+	return true, nil
+}
+
+/*
+Copy stores a deep copy of src, expiry included, under dst. It returns false
+when src does not exist or when dst exists and replace is not set.
+*/
+func (s *Store) Copy(src, dst string, replace bool) (bool, error) {
+	if src == dst {
+		return false, ErrSameObject
+	}
+	unlock := s.lockKeys(src, dst)
+	srcShard, dstShard := s.shardFor(src), s.shardFor(dst)
+	item := srcShard.lookup(src)
+	if item == nil || (!replace && dstShard.lookup(dst) != nil) {
+		unlock()
+		return false, nil
+	}
+	copied := cloneItem(item)
+	copied.Key = dst
+	dstShard.put(copied)
+	dstShard.notify(NotifyGeneric, "copy_to", dst)
+	unlock()
+	s.signalKey(dst)
+	return true, nil
+}
+
+// Type returns the type name of the value stored at key, "none" if it does not exist.
+func (s *Store) Type(key string) string {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	item := shard.peek(key)
+	if item == nil {
+		return "none"
+	}
+	return item.Type.String()
+}
+
+/*
+RandomKey returns a random live key, false if the store is empty. The shard to
+start from is picked at random and map iteration does the rest, so keys are not
+drawn with exactly the same probability.
+*/
+func (s *Store) RandomKey() (string, bool) {
+	start := rand.Intn(len(s.shards))
+	for i := range s.shards {
+		shard := s.shards[(start+i)%len(s.shards)]
+		shard.mu.RLock()
+		for key, item := range shard.data {
+			if !item.IsExpired() {
+				shard.mu.RUnlock()
+				return key, true
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return "", false
+}
+
+// Touch returns how many of keys exist, removing the expired ones on the way.
+func (s *Store) Touch(keys []string) int {
+	n := 0
+	for _, key := range keys {
+		shard := s.shardFor(key)
+		shard.mu.Lock()
+		if shard.lookup(key) != nil {
+			n++
+		}
+		shard.unlock()
+	}
+	return n
+}
+
+/*
+Expire makes key expire at the given time if the conditions of opts hold and
+reports whether it did. A time that is already past deletes the key at once.
+*/
+func (s *Store) Expire(key string, at time.Time, opts ExpireOptions) bool {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item := shard.lookup(key)
+	if item == nil {
+		return false
+	}
+	current := item.ExpiresAt
+	switch {
+	case opts.NX && !current.IsZero(),
+		opts.XX && current.IsZero(),
+		opts.GT && (current.IsZero() || !at.After(current)),
+		opts.LT && !current.IsZero() && !at.Before(current):
+		return false
+	}
+	if !at.After(time.Now()) {
+		shard.drop(key)
+		return true
+	}
+	updated := *item
+	updated.ExpiresAt = at
+	shard.put(&updated)
+	shard.notify(NotifyGeneric, "expire", key)
+	return true
+}
+
+// ExpireTime returns the time key expires at, zero if it has no TTL, and false if it does not exist.
+func (s *Store) ExpireTime(key string) (time.Time, bool) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	item := shard.peek(key)
+	if item == nil {
+		return time.Time{}, false
+	}
+	return item.ExpiresAt, true
+}
+
+// Persist removes the expiry of key and reports whether it had one.
+func (s *Store) Persist(key string) bool {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item := shard.lookup(key)
+	if item == nil || item.ExpiresAt.IsZero() {
+		return false
+	}
+	persisted := *item
+	persisted.ExpiresAt = time.Time{}
+	shard.put(&persisted)
+	shard.notify(NotifyGeneric, "persist", key)
+	return true
+}
+
+// cloneItem returns a copy of item sharing no mutable state with it.
+func cloneItem(item *Item) *Item {
+	c := &Item{Key: item.Key, Type: item.Type, ExpiresAt: item.ExpiresAt}
+	switch item.Type {
+	case TypeString:
+		// String values are replaced, never modified in place.
+		c.Value = item.Value
+	case TypeHash:
+		c.Hash = make(map[string][]byte, len(item.Hash))
+		for field, value := range item.Hash {
+			c.setField(field, value)
+		}
+	case TypeList:
+		c.List = NewList()
+		for i := 0; i < item.List.Len(); i++ {
+			c.List.PushBack(item.List.Index(i))
+		}
+	case TypeSet:
+		c.Set = make(map[string]struct{}, len(item.Set))
+		for member := range item.Set {
+			c.addMember(member)
+		}
+	case TypeZSet:
+		c.ZSet = NewZSet()
+		item.ZSet.Each(c.ZSet.Set)
+	case TypeStream:
+		c.Stream = item.Stream.clone()
+	}
+	return c
+}
+
+// clone copies the stream with its consumer groups. Entries are never modified
+// once appended, so their fields are shared.
+func (st *Stream) clone() *Stream {
+	c := NewStream()
+	c.lastID = st.lastID
+	c.entries = append([]StreamEntry(nil), st.entries...)
+	for name, g := range st.groups {
+		cg := newConsumerGroup(g.lastID)
+		for _, consumer := range g.consumers {
+			cg.consumer(consumer.Name).SeenTime = consumer.SeenTime
+		}
+		for id, p := range g.pending {
+			cp := cg.assign(id, cg.consumer(p.Consumer))
+			cp.DeliveryTime = p.DeliveryTime
+			cp.DeliveryCount = p.DeliveryCount
+		}
+		c.groups[name] = cg
+	}
+	return c
+}
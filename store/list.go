@@ -0,0 +1,473 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoSuchKey     = errors.New("ERR no such key")
+	ErrIndexOutRange = errors.New("ERR index out of range")
+)
+
+// IListStore groups the operations on list values. Blocking variants park the
+// calling goroutine until another client pushes to one of the keys or the
+// timeout elapses, clients blocked on the same key are served in FIFO order.
+type IListStore interface {
+	LPush(key string, values [][]byte) (int, error)
+	RPush(key string, values [][]byte) (int, error)
+	LPushX(key string, values [][]byte) (int, error)
+	RPushX(key string, values [][]byte) (int, error)
+	LPop(key string, count int) ([][]byte, error)
+	RPop(key string, count int) ([][]byte, error)
+	LLen(key string) (int, error)
+	LIndex(key string, index int) ([]byte, error)
+	LSet(key string, index int, value []byte) error
+	LRange(key string, start, stop int) ([][]byte, error)
+	LTrim(key string, start, stop int) error
+	LRem(key string, count int, value []byte) (int, error)
+	LInsert(key string, before bool, pivot, value []byte) (int, error)
+	LPos(key string, value []byte, rank, count, maxlen int) ([]int, error)
+	LMove(source, destination string, fromLeft, toLeft bool) ([]byte, error)
+	BLPop(keys []string, timeout time.Duration) (string, []byte, error)
+	BRPop(keys []string, timeout time.Duration) (string, []byte, error)
+	BLMove(source, destination string, fromLeft, toLeft bool, timeout time.Duration) ([]byte, error)
+}
+
+/*
+normalizeRange converts Redis style inclusive indexes, where negative values
+count from the end, into bounds inside [0, length).
+
+ok is false when the resulting range is empty.
+*/
+func normalizeRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// readList returns the list stored under key or nil when the key does not exist.
+// The caller must hold at least the shard read lock.
+func (sh *shard) readList(key string) (*List, error) {
+	item := sh.peek(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != TypeList {
+		return nil, ErrWrongType
+	}
+	return item.List, nil
+}
+
+// writeList is readList for writers, it removes the key if it has expired.
+// The caller must hold the shard write lock.
+func (sh *shard) writeList(key string) (*List, error) {
+	item := sh.lookup(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != TypeList {
+		return nil, ErrWrongType
+	}
+	return item.List, nil
+}
+
+func (sh *shard) createList(key string) *List {
+	list := NewList()
+	sh.put(&Item{Key: key, Type: TypeList, List: list})
+	return list
+}
+
+func (s *Store) push(key string, values [][]byte, left, onlyExisting bool) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	list, err := shard.writeList(key)
+	if err != nil || (list == nil && onlyExisting) {
+		shard.unlock()
+		return 0, err
+	}
+	if list == nil {
+		list = shard.createList(key)
+	}
+	for _, value := range values {
+		if left {
+			list.PushFront(value)
+		} else {
+			list.PushBack(value)
+		}
+	}
+	shard.notify(NotifyList, pushEvent(left), key)
+	n := list.Len()
+	shard.unlock()
+
+	s.signalKey(key)
+	return n, nil
+}
+
+// pushEvent and popEvent name the keyspace event of a push or pop at one end of a list.
+func pushEvent(left bool) string {
+	if left {
+		return "lpush"
+	}
+	return "rpush"
+}
+
+func popEvent(left bool) string {
+	if left {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+func (s *Store) LPush(key string, values [][]byte) (int, error) {
+	return s.push(key, values, true, false)
+}
+
+func (s *Store) RPush(key string, values [][]byte) (int, error) {
+	return s.push(key, values, false, false)
+}
+
+func (s *Store) LPushX(key string, values [][]byte) (int, error) {
+	return s.push(key, values, true, true)
+}
+
+func (s *Store) RPushX(key string, values [][]byte) (int, error) {
+	return s.push(key, values, false, true)
+}
+
+// pop removes up to count elements from one end of the list. A nil result means
+// the key does not exist, the key is deleted once its last element is popped.
+func (s *Store) pop(key string, count int, left bool) ([][]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	list, err := shard.writeList(key)
+	if err != nil || list == nil {
+		return nil, err
+	}
+	if count > list.Len() {
+		count = list.Len()
+	}
+	values := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if left {
+			values = append(values, list.PopFront())
+		} else {
+			values = append(values, list.PopBack())
+		}
+	}
+	if count > 0 {
+		shard.notify(NotifyList, popEvent(left), key)
+	}
+	if list.Len() == 0 {
+		shard.drop(key)
+	}
+	return values, nil
+}
+
+func (s *Store) LPop(key string, count int) ([][]byte, error) {
+	return s.pop(key, count, true)
+}
+
+func (s *Store) RPop(key string, count int) ([][]byte, error) {
+	return s.pop(key, count, false)
+}
+
+func (s *Store) LLen(key string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	list, err := shard.readList(key)
+	if err != nil || list == nil {
+		return 0, err
+	}
+	return list.Len(), nil
+}
+
+func (s *Store) LIndex(key string, index int) ([]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	list, err := shard.readList(key)
+	if err != nil || list == nil {
+		return nil, err
+	}
+	if index < 0 {
+		index += list.Len()
+	}
+	if index < 0 || index >= list.Len() {
+		return nil, nil
+	}
+	return list.Index(index), nil
+}
+
+func (s *Store) LSet(key string, index int, value []byte) error {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	list, err := shard.writeList(key)
+	if err != nil {
+		return err
+	}
+	if list == nil {
+		return ErrNoSuchKey
+	}
+	if index < 0 {
+		index += list.Len()
+	}
+	if index < 0 || index >= list.Len() {
+		return ErrIndexOutRange
+	}
+	list.Set(index, value)
+	shard.notify(NotifyList, "lset", key)
+	return nil
+}
+
+func (s *Store) LRange(key string, start, stop int) ([][]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	list, err := shard.readList(key)
+	if err != nil || list == nil {
+		return [][]byte{}, err
+	}
+	start, stop, ok := normalizeRange(start, stop, list.Len())
+	if !ok {
+		return [][]byte{}, nil
+	}
+	return list.Range(start, stop), nil
+}
+
+func (s *Store) LTrim(key string, start, stop int) error {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	list, err := shard.writeList(key)
+	if err != nil || list == nil {
+		return err
+	}
+	start, stop, ok := normalizeRange(start, stop, list.Len())
+	shard.notify(NotifyList, "ltrim", key)
+	if !ok {
+		shard.drop(key)
+		return nil
+	}
+	list.Filter(func(i int, _ []byte) bool {
+		return i >= start && i <= stop
+	})
+	return nil
+}
+
+// LRem removes occurrences of value: the first count ones when count is positive,
+// the last -count ones when it is negative and all of them when it is zero.
+func (s *Store) LRem(key string, count int, value []byte) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	list, err := shard.writeList(key)
+	if err != nil || list == nil {
+		return 0, err
+	}
+
+	remove := make(map[int]bool)
+	if count >= 0 {
+		for i := 0; i < list.Len() && (count == 0 || len(remove) < count); i++ {
+			if bytes.Equal(list.Index(i), value) {
+				remove[i] = true
+			}
+		}
+	} else {
+		for i := list.Len() - 1; i >= 0 && len(remove) < -count; i-- {
+			if bytes.Equal(list.Index(i), value) {
+				remove[i] = true
+			}
+		}
+	}
+	if len(remove) > 0 {
+		list.Filter(func(i int, _ []byte) bool {
+			return !remove[i]
+		})
+		shard.notify(NotifyList, "lrem", key)
+	}
+	if list.Len() == 0 {
+		shard.drop(key)
+	}
+	return len(remove), nil
+}
+
+// LInsert returns the new length of the list, -1 when pivot was not found and 0
+// when the key does not exist.
+func (s *Store) LInsert(key string, before bool, pivot, value []byte) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	list, err := shard.writeList(key)
+	if err != nil || list == nil {
+		return 0, err
+	}
+	for i := 0; i < list.Len(); i++ {
+		if bytes.Equal(list.Index(i), pivot) {
+			if !before {
+				i++
+			}
+			list.Insert(i, value)
+			shard.notify(NotifyList, "linsert", key)
+			return list.Len(), nil
+		}
+	}
+	return -1, nil
+}
+
+/*
+LPos returns the indexes of the elements equal to value.
+
+rank selects which match to start from: 1 is the first one, -1 the last one and
+negative ranks scan from the tail. count limits the number of matches (0 means all)
+and maxlen limits the number of compared elements (0 means the whole list).
+*/
+func (s *Store) LPos(key string, value []byte, rank, count, maxlen int) ([]int, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	list, err := shard.readList(key)
+	if err != nil || list == nil {
+		return nil, err
+	}
+
+	positions := []int{}
+	n := list.Len()
+	skip := rank - 1
+	step, i := 1, 0
+	if rank < 0 {
+		skip = -rank - 1
+		step, i = -1, n-1
+	}
+	for compared := 0; i >= 0 && i < n; i += step {
+		if maxlen > 0 && compared == maxlen {
+			break
+		}
+		compared++
+		if !bytes.Equal(list.Index(i), value) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		positions = append(positions, i)
+		if count > 0 && len(positions) == count {
+			break
+		}
+	}
+	return positions, nil
+}
+
+// lmove pops an element from source and pushes it to destination atomically.
+// It does not signal blocked clients, that is left to the caller.
+func (s *Store) lmove(source, destination string, fromLeft, toLeft bool) ([]byte, error) {
+	unlock := s.lockKeys(source, destination)
+	defer unlock()
+
+	srcShard := s.shardFor(source)
+	dstShard := s.shardFor(destination)
+	src, err := srcShard.writeList(source)
+	if err != nil || src == nil {
+		return nil, err
+	}
+	dst, err := dstShard.writeList(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	var value []byte
+	if fromLeft {
+		value = src.PopFront()
+	} else {
+		value = src.PopBack()
+	}
+	if dst == nil {
+		dst = dstShard.createList(destination)
+	}
+	if toLeft {
+		dst.PushFront(value)
+	} else {
+		dst.PushBack(value)
+	}
+	srcShard.notify(NotifyList, popEvent(fromLeft), source)
+	dstShard.notify(NotifyList, pushEvent(toLeft), destination)
+	if src.Len() == 0 {
+		srcShard.drop(source)
+	}
+	return value, nil
+}
+
+func (s *Store) LMove(source, destination string, fromLeft, toLeft bool) ([]byte, error) {
+	value, err := s.lmove(source, destination, fromLeft, toLeft)
+	if value != nil {
+		s.signalKey(destination)
+	}
+	return value, err
+}
+
+func (s *Store) blockingPop(keys []string, left bool, timeout time.Duration) (string, []byte, error) {
+	var (
+		key   string
+		value []byte
+		err   error
+	)
+	s.block(keys, timeout, func(k string) bool {
+		values, popErr := s.pop(k, 1, left)
+		if popErr != nil {
+			err = popErr
+			return true
+		}
+		if len(values) == 0 {
+			return false
+		}
+		key, value = k, values[0]
+		return true
+	})
+	return key, value, err
+}
+
+// BLPop pops from the head of the first non-empty list among keys. It returns
+// an empty key when the timeout elapses first.
+func (s *Store) BLPop(keys []string, timeout time.Duration) (string, []byte, error) {
+	return s.blockingPop(keys, true, timeout)
+}
+
+// BRPop is BLPop popping from the tail.
+func (s *Store) BRPop(keys []string, timeout time.Duration) (string, []byte, error) {
+	return s.blockingPop(keys, false, timeout)
+}
+
+// BLMove is LMove waiting for source to receive an element. It returns nil when
+// the timeout elapses first.
+func (s *Store) BLMove(source, destination string, fromLeft, toLeft bool, timeout time.Duration) ([]byte, error) {
+	var (
+		value []byte
+		err   error
+	)
+	s.block([]string{source}, timeout, func(string) bool {
+		value, err = s.lmove(source, destination, fromLeft, toLeft)
+		return value != nil || err != nil
+	})
+	if value != nil {
+		s.signalKey(destination)
+	}
+	return value, err
+}
@@ -0,0 +1,386 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+/*
+Memory accounting
+
+Every item knows its approximate size and every shard the sum of the sizes of
+its items. Sizes are estimated from the Go layout of the structures holding the
+value plus the payload bytes. For collections the payload is the average of a
+few sampled elements times their count, like Redis' MEMORY USAGE, so that
+accounting a write stays O(1) whatever the size of the collection.
+
+Sizes are refreshed when a shard is unlocked after a write: put and lookup
+record the items a writer may have changed and unlock recomputes them.
+*/
+const (
+	// sizeSamples is the number of elements sampled to estimate the size of a collection.
+	sizeSamples = 8
+	// mapEntryOverhead approximates what a map entry costs besides its key and value.
+	mapEntryOverhead = 16
+	stringHeader     = int64(unsafe.Sizeof(""))
+	sliceHeader      = int64(unsafe.Sizeof([]byte(nil)))
+	// scanEntryOverhead is what an element costs in the scan index of its shard or collection.
+	scanEntryOverhead = int64(unsafe.Sizeof(scanEntry{}))
+
+	hashEntryOverhead   = stringHeader + sliceHeader + mapEntryOverhead + scanEntryOverhead
+	setEntryOverhead    = stringHeader + mapEntryOverhead + scanEntryOverhead
+	listEntryOverhead   = sliceHeader
+	zsetEntryOverhead   = 2*stringHeader + 8 + mapEntryOverhead + int64(unsafe.Sizeof(skiplistNode{})) + 2*8 + scanEntryOverhead
+	streamEntryOverhead = int64(unsafe.Sizeof(StreamEntry{}))
+	pendingOverhead     = int64(unsafe.Sizeof(PendingEntry{})) + 2*(int64(unsafe.Sizeof(StreamID{}))+8+mapEntryOverhead)
+	consumerOverhead    = int64(unsafe.Sizeof(StreamConsumer{})) + stringHeader + 8 + mapEntryOverhead
+)
+
+// itemOverhead is the cost of an item and its entries in the shard map and scan
+// index, besides its key and value.
+var itemOverhead = int64(unsafe.Sizeof(Item{})) + stringHeader + 8 + mapEntryOverhead + scanEntryOverhead
+
+// memoryUsage returns the estimated number of bytes used by the item.
+// The caller must hold at least the shard read lock.
+func (i *Item) memoryUsage() int64 {
+	size := itemOverhead + int64(len(i.Key))
+	switch i.Type {
+	case TypeString:
+		size += int64(len(i.Value))
+	case TypeHash:
+		n, payload := 0, int64(0)
+		for field, value := range i.Hash {
+			if n == sizeSamples {
+				break
+			}
+			payload += int64(len(field) + len(value))
+			n++
+		}
+		size += int64(len(i.Hash)) * (hashEntryOverhead + average(payload, n))
+	case TypeList:
+		size += int64(i.List.Len()) * (listEntryOverhead + average(sampleSpread(i.List.Len(), func(j int) int {
+			return len(i.List.Index(j))
+		})))
+	case TypeSet:
+		n, payload := 0, int64(0)
+		for member := range i.Set {
+			if n == sizeSamples {
+				break
+			}
+			payload += int64(len(member))
+			n++
+		}
+		size += int64(len(i.Set)) * (setEntryOverhead + average(payload, n))
+	case TypeZSet:
+		n, payload := 0, int64(0)
+		for member := range i.ZSet.dict {
+			if n == sizeSamples {
+				break
+			}
+			payload += int64(len(member))
+			n++
+		}
+		size += int64(i.ZSet.Len()) * (zsetEntryOverhead + average(payload, n))
+	case TypeStream:
+		st := i.Stream
+		size += int64(len(st.entries)) * (streamEntryOverhead + average(sampleSpread(len(st.entries), func(j int) int {
+			fields := st.entries[j].Fields
+			n := len(fields) * int(stringHeader)
+			for _, field := range fields {
+				n += len(field)
+			}
+			return n
+		})))
+		for name, g := range st.groups {
+			size += int64(len(name)) + int64(unsafe.Sizeof(ConsumerGroup{})) + int64(len(g.pending))*pendingOverhead
+			for consumer := range g.consumers {
+				size += consumerOverhead + int64(len(consumer))
+			}
+		}
+	}
+	return size
+}
+
+// sampleSpread returns the total size of up to sizeSamples of the n elements,
+// picked evenly spaced, and how many were sampled.
+func sampleSpread(n int, size func(int) int) (int64, int) {
+	if n == 0 {
+		return 0, 0
+	}
+	step := max(n/sizeSamples, 1)
+	total, sampled := int64(0), 0
+	for j := 0; j < n && sampled < sizeSamples; j += step {
+		total += int64(size(j))
+		sampled++
+	}
+	return total, sampled
+}
+
+func average(total int64, n int) int64 {
+	if n == 0 {
+		return 0
+	}
+	return total / int64(n)
+}
+
+/*
+Access tracking
+
+Each item records when it was last accessed, for the LRU policies, and a
+logarithmic access counter, for the LFU ones. As in Redis the counter saturates
+at 255, grows more slowly the higher it is and decays by one for every minute
+the item is not accessed. New items start at lfuInitValue so that they are not
+evicted before they had a chance to be accessed.
+*/
+const (
+	lfuInitValue = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// touch records an access to the item. It is called with the shard read lock
+// held, concurrently with other readers, hence the atomics.
+func (i *Item) touch(now int64) {
+	freq := lfuIncrement(i.frequency(now))
+	atomic.StoreUint32(&i.freq, freq)
+	atomic.StoreInt64(&i.accessed, now)
+}
+
+// frequency returns the access counter of the item, decayed by the time since its last access.
+func (i *Item) frequency(now int64) uint32 {
+	freq := atomic.LoadUint32(&i.freq)
+	periods := (now - atomic.LoadInt64(&i.accessed)) / lfuDecayTime.Milliseconds()
+	if periods >= int64(freq) {
+		return 0
+	}
+	return freq - uint32(periods)
+}
+
+func lfuIncrement(freq uint32) uint32 {
+	if freq == math.MaxUint8 {
+		return freq
+	}
+	base := float64(max(int(freq)-lfuInitValue, 0))
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		freq++
+	}
+	return freq
+}
+
+/*
+Eviction
+
+Once the memory used goes over maxmemory, every command that may grow memory
+first evicts keys until the store is back under the limit. Like Redis, the
+approximate LRU and LFU policies do not track an exact order: each eviction
+samples EvictionSamples keys, from the whole keyspace or only from the keys
+with a TTL for the volatile policies, and evicts the best candidate among them.
+*/
+type EvictionPolicy int32
+
+const (
+	NoEviction EvictionPolicy = iota
+	AllKeysLRU
+	VolatileLRU
+	AllKeysLFU
+	VolatileLFU
+	AllKeysRandom
+	VolatileRandom
+	VolatileTTL
+)
+
+const EvictionSamples = 5
+
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+var evictionPolicyNames = [...]string{
+	NoEviction:     "noeviction",
+	AllKeysLRU:     "allkeys-lru",
+	VolatileLRU:    "volatile-lru",
+	AllKeysLFU:     "allkeys-lfu",
+	VolatileLFU:    "volatile-lfu",
+	AllKeysRandom:  "allkeys-random",
+	VolatileRandom: "volatile-random",
+	VolatileTTL:    "volatile-ttl",
+}
+
+func (p EvictionPolicy) String() string {
+	return evictionPolicyNames[p]
+}
+
+// volatile reports whether the policy only evicts keys with a TTL.
+func (p EvictionPolicy) volatile() bool {
+	return p == VolatileLRU || p == VolatileLFU || p == VolatileRandom || p == VolatileTTL
+}
+
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	for p, n := range evictionPolicyNames {
+		if strings.EqualFold(name, n) {
+			return EvictionPolicy(p), nil
+		}
+	}
+	return NoEviction, errors.New("ERR invalid maxmemory-policy " + name)
+}
+
+/*
+ParseMemory parses a memory size in bytes, optionally followed by one of the
+units k, kb, m, mb, g and gb like in redis.conf: k is 1000 bytes, kb is 1024.
+*/
+func ParseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	lower := strings.ToLower(s)
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, factor = strings.TrimSuffix(lower, unit.suffix), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/factor {
+		return 0, errors.New("ERR invalid memory size " + s)
+	}
+	return n * factor, nil
+}
+
+// MemoryStats holds the memory figures reported by INFO.
+type MemoryStats struct {
+	UsedMemory  int64
+	MaxMemory   int64
+	Policy      EvictionPolicy
+	EvictedKeys int64
+}
+
+// IMemoryStore groups the memory limit and eviction operations.
+type IMemoryStore interface {
+	SetMaxMemory(limit int64, policy EvictionPolicy)
+	UsedMemory() int64
+	Evict() ([]string, error)
+	MemoryStats() MemoryStats
+}
+
+// SetMaxMemory sets the memory limit in bytes, 0 meaning no limit, and the eviction policy.
+func (s *Store) SetMaxMemory(limit int64, policy EvictionPolicy) {
+	s.maxMemory.Store(limit)
+	s.policy.Store(int32(policy))
+}
+
+func (s *Store) UsedMemory() int64 {
+	var used int64
+	for _, shard := range s.shards {
+		used += shard.used.Load()
+	}
+	return used
+}
+
+func (s *Store) MemoryStats() MemoryStats {
+	return MemoryStats{
+		UsedMemory:  s.UsedMemory(),
+		MaxMemory:   s.maxMemory.Load(),
+		Policy:      EvictionPolicy(s.policy.Load()),
+		EvictedKeys: s.evicted.Load(),
+	}
+}
+
+/*
+Evict removes keys according to the eviction policy until the memory used is
+back under maxmemory and returns the evicted keys, so that the caller can
+propagate their deletion. ErrOOM is returned when the store is over the limit
+and nothing can be evicted, always the case with noeviction. Keys are never
+evicted in the middle of an EXEC.
+*/
+func (s *Store) Evict() ([]string, error) {
+	limit := s.maxMemory.Load()
+	if limit <= 0 {
+		return nil, nil
+	}
+	unlock := s.LockShared()
+	defer unlock()
+	policy := EvictionPolicy(s.policy.Load())
+	var evicted []string
+	for s.UsedMemory() > limit {
+		if policy == NoEviction {
+			return evicted, ErrOOM
+		}
+		key, ok := s.evictionCandidate(policy)
+		if !ok {
+			return evicted, ErrOOM
+		}
+		shard := s.shardFor(key)
+		shard.mu.Lock()
+		// The candidate was picked under the read lock, it may be gone already.
+		if _, exists := shard.data[key]; exists {
+			shard.remove(key)
+			shard.notify(NotifyEvicted, "evicted", key)
+			evicted = append(evicted, key)
+			s.evicted.Add(1)
+		}
+		shard.unlock()
+	}
+	return evicted, nil
+}
+
+// evictionCandidate samples keys starting from a random shard and returns the best one to evict.
+func (s *Store) evictionCandidate(policy EvictionPolicy) (string, bool) {
+	now := time.Now().UnixMilli()
+	start := rand.Intn(len(s.shards))
+	best, bestScore, found := "", int64(math.MinInt64), 0
+	for i := 0; i < len(s.shards) && found < EvictionSamples; i++ {
+		shard := s.shards[(start+i)%len(s.shards)]
+		shard.mu.RLock()
+		shard.sample(policy.volatile(), EvictionSamples-found, func(item *Item) {
+			found++
+			if score := evictionScore(policy, item, now); score > bestScore {
+				best, bestScore = item.Key, score
+			}
+		})
+		shard.mu.RUnlock()
+	}
+	return best, found > 0
+}
+
+// evictionScore rates how good a candidate item is for eviction, higher is better.
+func evictionScore(policy EvictionPolicy, item *Item, now int64) int64 {
+	switch policy {
+	case AllKeysLRU, VolatileLRU:
+		return now - atomic.LoadInt64(&item.accessed)
+	case AllKeysLFU, VolatileLFU:
+		return math.MaxUint8 - int64(item.frequency(now))
+	case VolatileTTL:
+		return -item.ExpiresAt.UnixMilli()
+	}
+	return 0
+}
+
+// sample calls fn with up to n items of the shard, only items with a TTL when volatile is set.
+// The caller must hold at least the shard read lock.
+func (sh *shard) sample(volatile bool, n int, fn func(*Item)) {
+	if volatile {
+		entries := sh.expires.entries
+		for i := 0; i < n && len(entries) > 0; i++ {
+			fn(sh.data[entries[rand.Intn(len(entries))].key])
+		}
+		return
+	}
+	// Map iteration starts at a random position.
+	for _, item := range sh.data {
+		if n == 0 {
+			return
+		}
+		fn(item)
+		n--
+	}
+}
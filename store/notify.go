@@ -0,0 +1,204 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+Keyspace notifications
+
+Writers report what they did to a key with shard.notify, an event named after
+the command as in Redis: set, del, lpush, expired... Events are held by the
+shard until the writer releases its lock and only then handed to the callbacks,
+so a callback may call back into the store. An operation locking several shards
+delivers its events once all of them are released, in the order they happened.
+
+Nothing is recorded for the classes not enabled with SetNotifyKeyspaceEvents.
+No class is enabled by default, so notifications cost nothing until enabled.
+*/
+
+// NotifyFlags is a set of event classes, the notify-keyspace-events setting.
+type NotifyFlags uint32
+
+const (
+	// NotifyKeyspace and NotifyKeyevent select the pub/sub channels events are
+	// published to, __keyspace@0__:<key> and __keyevent@0__:<event>.
+	NotifyKeyspace NotifyFlags = 1 << iota
+	NotifyKeyevent
+	NotifyGeneric
+	NotifyString
+	NotifyList
+	NotifySet
+	NotifyHash
+	NotifyZSet
+	NotifyExpired
+	NotifyEvicted
+	NotifyStream
+	NotifyNew
+
+	// NotifyAll is the A alias, every class but NotifyNew.
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash |
+		NotifyZSet | NotifyExpired | NotifyEvicted | NotifyStream
+)
+
+var ErrNotifyFlags = errors.New("ERR Invalid event class character. Use 'Ag$lshzxetKEn'.")
+
+// notifyClasses maps the characters of notify-keyspace-events to their class, in the order String writes them.
+var notifyClasses = []struct {
+	char  byte
+	class NotifyFlags
+}{
+	{'g', NotifyGeneric},
+	{'$', NotifyString},
+	{'l', NotifyList},
+	{'s', NotifySet},
+	{'h', NotifyHash},
+	{'z', NotifyZSet},
+	{'x', NotifyExpired},
+	{'e', NotifyEvicted},
+	{'t', NotifyStream},
+	{'K', NotifyKeyspace},
+	{'E', NotifyKeyevent},
+	{'n', NotifyNew},
+}
+
+// ParseNotifyFlags parses a notify-keyspace-events string such as "KEA" or "Kx".
+func ParseNotifyFlags(s string) (NotifyFlags, error) {
+	var flags NotifyFlags
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			flags |= NotifyAll
+			continue
+		}
+		found := false
+		for _, c := range notifyClasses {
+			if c.char == s[i] {
+				flags |= c.class
+				found = true
+			}
+		}
+		if !found {
+			return 0, ErrNotifyFlags
+		}
+	}
+	return flags, nil
+}
+
+// String returns the notify-keyspace-events string of f, using A when every class it stands for is set.
+func (f NotifyFlags) String() string {
+	var b strings.Builder
+	if f&NotifyAll == NotifyAll {
+		b.WriteByte('A')
+	}
+	for _, c := range notifyClasses {
+		if f&c.class != 0 && (f&NotifyAll != NotifyAll || c.class&NotifyAll == 0) {
+			b.WriteByte(c.char)
+		}
+	}
+	return b.String()
+}
+
+// KeyspaceEvent is a change made to a key: Event is the name Redis gives it,
+// such as set, lpush or expired, and Class the class it belongs to.
+type KeyspaceEvent struct {
+	Class NotifyFlags
+	Event string
+	Key   string
+}
+
+// INotifyStore lets embedders follow the changes made to the keyspace.
+type INotifyStore interface {
+	SetNotifyKeyspaceEvents(flags NotifyFlags)
+	NotifyKeyspaceEvents() NotifyFlags
+	OnKeyspaceEvent(fn func(KeyspaceEvent)) (cancel func())
+}
+
+// pendingEvent is an event waiting for its shard to be released, seq orders
+// the events of an operation spanning several shards.
+type pendingEvent struct {
+	seq uint64
+	KeyspaceEvent
+}
+
+// notifier holds the callbacks, it is shared by the shards of a store.
+type notifier struct {
+	flags atomic.Uint32
+	seq   atomic.Uint64
+
+	mu        sync.RWMutex
+	next      int
+	callbacks map[int]func(KeyspaceEvent)
+}
+
+func newNotifier() *notifier {
+	return &notifier{callbacks: make(map[int]func(KeyspaceEvent))}
+}
+
+// dispatch delivers events, collected from one shard or several, in the order they happened.
+func (n *notifier) dispatch(events []pendingEvent) {
+	if len(events) == 0 {
+		return
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].seq < events[j].seq })
+	n.mu.RLock()
+	callbacks := make([]func(KeyspaceEvent), 0, len(n.callbacks))
+	for _, fn := range n.callbacks {
+		callbacks = append(callbacks, fn)
+	}
+	n.mu.RUnlock()
+	for _, e := range events {
+		for _, fn := range callbacks {
+			fn(e.KeyspaceEvent)
+		}
+	}
+}
+
+// notify records that event happened to key, if its class is enabled. The
+// caller must hold the shard write lock.
+func (sh *shard) notify(class NotifyFlags, event, key string) {
+	if NotifyFlags(sh.notifier.flags.Load())&class == 0 {
+		return
+	}
+	sh.events = append(sh.events, pendingEvent{
+		seq:           sh.notifier.seq.Add(1),
+		KeyspaceEvent: KeyspaceEvent{Class: class, Event: event, Key: key},
+	})
+}
+
+/*
+SetNotifyKeyspaceEvents selects the classes of events reported, see NotifyFlags.
+Callbacks receive the events of every selected class, NotifyKeyspace and
+NotifyKeyevent only matter for publishing them over pub/sub.
+*/
+func (s *Store) SetNotifyKeyspaceEvents(flags NotifyFlags) {
+	s.notifier.flags.Store(uint32(flags))
+}
+
+func (s *Store) NotifyKeyspaceEvents() NotifyFlags {
+	return NotifyFlags(s.notifier.flags.Load())
+}
+
+/*
+OnKeyspaceEvent calls fn for every event of the classes enabled with
+SetNotifyKeyspaceEvents, until the returned cancel function is called.
+
+fn runs in the goroutine of the writer, once its locks are released, and should
+return quickly: the writer waits for it.
+*/
+func (s *Store) OnKeyspaceEvent(fn func(KeyspaceEvent)) (cancel func()) {
+	n := s.notifier
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	id := n.next
+	n.next++
+	n.callbacks[id] = fn
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.callbacks, id)
+	}
+}
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"hash/fnv"
+	"iter"
+
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+/*
+SCAN cursors
+
+Go maps have no stable iteration order, so cursors cannot point into them.
+Instead every key is given a fixed position, the top 60 bits of its FNV-64a
+hash, and each call returns the keys whose position falls in the next range
+[lo, hi), ending after about COUNT keys. A SCAN cursor is shard<<60 | lo and the
+collection cursors of HSCAN, SSCAN and ZSCAN are just lo.
+
+Since the ranges partition the position space, every key present for the whole
+scan is returned exactly once, whatever is written in the meantime. To find
+their range without walking everything, each shard and each hash, set and
+sorted set keeps a scanIndex of its elements by position, so a call costs about
+COUNT whatever the size.
+*/
+const (
+	scanPositionBits = 60
+	scanPositions    = uint64(1) << scanPositionBits
+
+	DefaultScanCount = 10
+	// keysBatch is the number of keys Keys fetches per step when no COUNT is given.
+	keysBatch = 1000
+)
+
+// ScanOptions carries the MATCH, COUNT and TYPE options of the SCAN family.
+// Empty Match and Type match everything, a Count <= 0 means DefaultScanCount.
+type ScanOptions struct {
+	Match string
+	Count int
+	Type  string
+}
+
+func (o ScanOptions) count() int {
+	if o.Count <= 0 {
+		return DefaultScanCount
+	}
+	return o.Count
+}
+
+func (o ScanOptions) match(s string) bool {
+	return o.Match == "" || util.GlobMatch(o.Match, s)
+}
+
+// IScanStore groups the cursor based iteration over keys and collections.
+type IScanStore interface {
+	Scan(cursor uint64, opts ScanOptions) (uint64, []string)
+	Keys(opts ScanOptions) iter.Seq[string]
+	HScan(key string, cursor uint64, opts ScanOptions) (uint64, [][]byte, error)
+	SScan(key string, cursor uint64, opts ScanOptions) (uint64, []string, error)
+	ZScan(key string, cursor uint64, opts ScanOptions) (uint64, []ZMember, error)
+}
+
+func scanPosition(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64() >> (64 - scanPositionBits)
+}
+
+// scanEntry is an element of a scanIndex with its position.
+type scanEntry struct {
+	pos uint64
+	key string
+}
+
+/*
+scanIndex orders elements by scan position. It is a hash table whose buckets
+are ranges of positions, bucket b holding the elements whose position starts
+with the bits of b, so a scan seeks to the bucket of its cursor and goes on from
+there. The table doubles when it holds more elements than buckets and halves
+below a quarter, which bounds the empty buckets a scan walks past.
+*/
+type scanIndex struct {
+	buckets [][]scanEntry
+	bits    int
+	n       int
+}
+
+func (x *scanIndex) bucket(pos uint64) int {
+	return int(pos >> (scanPositionBits - x.bits))
+}
+
+// add indexes key, which must not be indexed already.
+func (x *scanIndex) add(key string) {
+	if x.n >= len(x.buckets) {
+		x.resize(x.bits + 1)
+	}
+	pos := scanPosition(key)
+	b := x.bucket(pos)
+	x.buckets[b] = append(x.buckets[b], scanEntry{pos: pos, key: key})
+	x.n++
+}
+
+func (x *scanIndex) remove(key string) {
+	if x.n == 0 {
+		return
+	}
+	b := x.bucket(scanPosition(key))
+	bucket := x.buckets[b]
+	for i, e := range bucket {
+		if e.key == key {
+			last := len(bucket) - 1
+			bucket[i] = bucket[last]
+			bucket[last] = scanEntry{}
+			x.buckets[b] = bucket[:last]
+			x.n--
+			break
+		}
+	}
+	if x.bits > 1 && x.n < len(x.buckets)/4 {
+		x.resize(x.bits - 1)
+	}
+}
+
+func (x *scanIndex) resize(bits int) {
+	old := x.buckets
+	x.buckets = make([][]scanEntry, 1<<bits)
+	x.bits = bits
+	for _, bucket := range old {
+		for _, e := range bucket {
+			b := x.bucket(e.pos)
+			x.buckets[b] = append(x.buckets[b], e)
+		}
+	}
+}
+
+/*
+scan calls examine for the elements from position lo on, a whole bucket at a
+time, until count of them have been examined. It returns the position to go on
+from, scanPositions once the end is reached. examine must not modify the index.
+*/
+func (x *scanIndex) scan(lo uint64, count int, examine func(key string)) uint64 {
+	if x.n == 0 {
+		return scanPositions
+	}
+	examined := 0
+	for b := x.bucket(lo); b < len(x.buckets); b++ {
+		for _, e := range x.buckets[b] {
+			if e.pos >= lo {
+				examine(e.key)
+				examined++
+			}
+		}
+		if examined >= count {
+			return uint64(b+1) << (scanPositionBits - x.bits)
+		}
+	}
+	return scanPositions
+}
+
+/*
+Scan returns the next batch of keys and the cursor to continue from, 0 once
+every shard has been scanned.
+
+COUNT bounds the number of keys examined rather than returned: MATCH and TYPE
+are applied afterwards, so a batch may come back empty while the scan goes on.
+*/
+func (s *Store) Scan(cursor uint64, opts ScanOptions) (uint64, []string) {
+	count := opts.count()
+	index := int(cursor >> scanPositionBits)
+	lo := cursor & (scanPositions - 1)
+	keys := []string{}
+
+	for examined := 0; index < len(s.shards) && examined < count; {
+		shard := s.shards[index]
+		shard.mu.RLock()
+		hi := shard.keys.scan(lo, count-examined, func(key string) {
+			examined++
+			item := shard.data[key]
+			if item.IsExpired() || (opts.Type != "" && item.Type.String() != opts.Type) || !opts.match(key) {
+				return
+			}
+			keys = append(keys, key)
+		})
+		shard.mu.RUnlock()
+
+		if hi == scanPositions {
+			index, lo = index+1, 0
+		} else {
+			lo = hi
+		}
+	}
+	if index >= len(s.shards) {
+		return 0, keys
+	}
+	return uint64(index)<<scanPositionBits | lo, keys
+}
+
+/*
+Keys iterates over the keys matching opts with the guarantees of Scan. Batches
+are fetched with the shard locks released in between, so the loop body may use
+the store freely.
+*/
+func (s *Store) Keys(opts ScanOptions) iter.Seq[string] {
+	if opts.Count <= 0 {
+		opts.Count = keysBatch
+	}
+	return func(yield func(string) bool) {
+		var cursor uint64
+		for {
+			next, keys := s.Scan(cursor, opts)
+			for _, key := range keys {
+				if !yield(key) {
+					return
+				}
+			}
+			if next == 0 {
+				return
+			}
+			cursor = next
+		}
+	}
+}
+
+// scanMembers walks the range of index following cursor and returns the cursor of the next one.
+func scanMembers(index *scanIndex, cursor uint64, opts ScanOptions, emit func(string)) uint64 {
+	lo := cursor & (scanPositions - 1)
+	hi := index.scan(lo, opts.count(), func(member string) {
+		if opts.match(member) {
+			emit(member)
+		}
+	})
+	if hi == scanPositions {
+		return 0
+	}
+	return hi
+}
+
+// HScan returns the next batch of fields as alternating fields and values.
+func (s *Store) HScan(key string, cursor uint64, opts ScanOptions) (uint64, [][]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	hash, err := shard.readHash(key)
+	if err != nil || hash == nil {
+		return 0, [][]byte{}, err
+	}
+	pairs := [][]byte{}
+	next := scanMembers(shard.data[key].members, cursor, opts, func(field string) {
+		pairs = append(pairs, []byte(field), hash[field])
+	})
+	return next, pairs, nil
+}
+
+func (s *Store) SScan(key string, cursor uint64, opts ScanOptions) (uint64, []string, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	set, err := shard.readSet(key)
+	if err != nil || set == nil {
+		return 0, []string{}, err
+	}
+	members := []string{}
+	next := scanMembers(shard.data[key].members, cursor, opts, func(member string) {
+		members = append(members, member)
+	})
+	return next, members, nil
+}
+
+func (s *Store) ZScan(key string, cursor uint64, opts ScanOptions) (uint64, []ZMember, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	z, err := shard.readZSet(key)
+	if err != nil || z == nil {
+		return 0, []ZMember{}, err
+	}
+	members := []ZMember{}
+	next := scanMembers(&z.members, cursor, opts, func(member string) {
+		members = append(members, ZMember{Member: member, Score: z.dict[member]})
+	})
+	return next, members, nil
+}
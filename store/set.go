@@ -0,0 +1,417 @@
+package store
+
+import (
+	"math"
+	"math/rand"
+)
+
+// ISetStore groups the operations on set values. Commands reading several keys
+// lock all the shards involved, so they observe a consistent view of the sets.
+type ISetStore interface {
+	SAdd(key string, members []string) (int, error)
+	SRem(key string, members []string) (int, error)
+	SIsMember(key, member string) (bool, error)
+	SMIsMember(key string, members []string) ([]bool, error)
+	SCard(key string) (int, error)
+	SMembers(key string) ([]string, error)
+	SPop(key string, count int) ([]string, error)
+	SRandMember(key string, count int) ([]string, error)
+	SMove(source, destination, member string) (bool, error)
+	SInter(keys []string) ([]string, error)
+	SUnion(keys []string) ([]string, error)
+	SDiff(keys []string) ([]string, error)
+	SInterStore(destination string, keys []string) (int, error)
+	SUnionStore(destination string, keys []string) (int, error)
+	SDiffStore(destination string, keys []string) (int, error)
+	SInterCard(keys []string, limit int) (int, error)
+}
+
+type setOp int
+
+const (
+	setInter setOp = iota
+	setUnion
+	setDiff
+)
+
+// storeEvent names the keyspace event of storing the result of op.
+func (op setOp) storeEvent() string {
+	switch op {
+	case setInter:
+		return "sinterstore"
+	case setUnion:
+		return "sunionstore"
+	}
+	return "sdiffstore"
+}
+
+// readSet returns the set stored under key or nil when the key does not exist.
+// The caller must hold at least the shard read lock.
+func (sh *shard) readSet(key string) (map[string]struct{}, error) {
+	item := sh.peek(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != TypeSet {
+		return nil, ErrWrongType
+	}
+	return item.Set, nil
+}
+
+// writeSet returns the set item stored under key, creating it when create is
+// set. The caller must hold the shard write lock.
+func (sh *shard) writeSet(key string, create bool) (*Item, error) {
+	item := sh.lookup(key)
+	if item == nil {
+		if !create {
+			return nil, nil
+		}
+		item = &Item{Key: key, Type: TypeSet, Set: make(map[string]struct{})}
+		sh.put(item)
+	}
+	if item.Type != TypeSet {
+		return nil, ErrWrongType
+	}
+	return item, nil
+}
+
+// addMember adds member to the set item and reports whether it is new. Every
+// write of a set goes through addMember and removeMember so that its scan index
+// stays in sync.
+func (i *Item) addMember(member string) bool {
+	if _, exists := i.Set[member]; exists {
+		return false
+	}
+	i.Set[member] = struct{}{}
+	if i.members == nil {
+		i.members = &scanIndex{}
+	}
+	i.members.add(member)
+	return true
+}
+
+// removeMember removes member from the set item and reports whether it was there.
+func (i *Item) removeMember(member string) bool {
+	if _, exists := i.Set[member]; !exists {
+		return false
+	}
+	delete(i.Set, member)
+	i.members.remove(member)
+	return true
+}
+
+func setMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	return members
+}
+
+func (s *Store) SAdd(key string, members []string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	set, err := shard.writeSet(key, true)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, member := range members {
+		if set.addMember(member) {
+			added++
+		}
+	}
+	if added > 0 {
+		shard.notify(NotifySet, "sadd", key)
+	}
+	return added, nil
+}
+
+func (s *Store) SRem(key string, members []string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	set, err := shard.writeSet(key, false)
+	if err != nil || set == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if set.removeMember(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		shard.notify(NotifySet, "srem", key)
+	}
+	if len(set.Set) == 0 {
+		shard.drop(key)
+	}
+	return removed, nil
+}
+
+func (s *Store) SIsMember(key, member string) (bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	set, err := shard.readSet(key)
+	if err != nil {
+		return false, err
+	}
+	_, exists := set[member]
+	return exists, nil
+}
+
+func (s *Store) SMIsMember(key string, members []string) ([]bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	set, err := shard.readSet(key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]bool, len(members))
+	for i, member := range members {
+		_, result[i] = set[member]
+	}
+	return result, nil
+}
+
+func (s *Store) SCard(key string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	set, err := shard.readSet(key)
+	return len(set), err
+}
+
+func (s *Store) SMembers(key string) ([]string, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	set, err := shard.readSet(key)
+	if err != nil {
+		return nil, err
+	}
+	return setMembers(set), nil
+}
+
+// SPop removes and returns up to count random members. A nil result means the key does not exist.
+func (s *Store) SPop(key string, count int) ([]string, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	set, err := shard.writeSet(key, false)
+	if err != nil || set == nil {
+		return nil, err
+	}
+	popped := make([]string, 0, min(count, len(set.Set)))
+	// Map iteration starts at a random position, which is good enough to pick random members.
+	for member := range set.Set {
+		if len(popped) == count {
+			break
+		}
+		popped = append(popped, member)
+		set.removeMember(member)
+	}
+	if len(popped) > 0 {
+		shard.notify(NotifySet, "spop", key)
+	}
+	if len(set.Set) == 0 {
+		shard.drop(key)
+	}
+	return popped, nil
+}
+
+/*
+SRandMember returns random members without removing them.
+
+A positive count returns up to count distinct members, a negative count returns
+exactly -count members that may repeat, as in Redis. The smallest int has no
+positive counterpart and is refused with ErrRange.
+*/
+func (s *Store) SRandMember(key string, count int) ([]string, error) {
+	if count == math.MinInt {
+		return nil, ErrRange
+	}
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	set, err := shard.readSet(key)
+	if err != nil || set == nil {
+		return nil, err
+	}
+	members := setMembers(set)
+	if count < 0 {
+		result := make([]string, -count)
+		for i := range result {
+			result[i] = members[rand.Intn(len(members))]
+		}
+		return result, nil
+	}
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if count < len(members) {
+		members = members[:count]
+	}
+	return members, nil
+}
+
+func (s *Store) SMove(source, destination, member string) (bool, error) {
+	unlock := s.lockKeys(source, destination)
+	defer unlock()
+
+	srcShard := s.shardFor(source)
+	dstShard := s.shardFor(destination)
+	src, err := srcShard.writeSet(source, false)
+	if err != nil {
+		return false, err
+	}
+	if item := dstShard.lookup(destination); item != nil && item.Type != TypeSet {
+		return false, ErrWrongType
+	}
+	if src == nil {
+		return false, nil
+	}
+	if _, exists := src.Set[member]; !exists {
+		return false, nil
+	}
+	if source == destination {
+		return true, nil
+	}
+
+	src.removeMember(member)
+	srcShard.notify(NotifySet, "srem", source)
+	if len(src.Set) == 0 {
+		srcShard.drop(source)
+	}
+	dst, _ := dstShard.writeSet(destination, true)
+	dst.addMember(member)
+	dstShard.notify(NotifySet, "sadd", destination)
+	return true, nil
+}
+
+// combine computes the intersection, union or difference of the sets at keys.
+// The caller must hold the locks of every shard involved.
+func (s *Store) combine(op setOp, keys []string) (map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		set, err := s.shardFor(key).writeSet(key, false)
+		if err != nil {
+			return nil, err
+		}
+		if set != nil {
+			sets[i] = set.Set
+		}
+	}
+
+	result := make(map[string]struct{})
+	switch op {
+	case setInter:
+		smallest := sets[0]
+		for _, set := range sets {
+			if len(set) < len(smallest) {
+				smallest = set
+			}
+		}
+	members:
+		for member := range smallest {
+			for _, set := range sets {
+				if _, ok := set[member]; !ok {
+					continue members
+				}
+			}
+			result[member] = struct{}{}
+		}
+	case setUnion:
+		for _, set := range sets {
+			for member := range set {
+				result[member] = struct{}{}
+			}
+		}
+	case setDiff:
+		for member := range sets[0] {
+			result[member] = struct{}{}
+		}
+		for _, set := range sets[1:] {
+			for member := range set {
+				delete(result, member)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *Store) combineMembers(op setOp, keys []string) ([]string, error) {
+	unlock := s.lockKeys(keys...)
+	defer unlock()
+	result, err := s.combine(op, keys)
+	if err != nil {
+		return nil, err
+	}
+	return setMembers(result), nil
+}
+
+// combineStore stores the result of op in destination, replacing whatever it held.
+func (s *Store) combineStore(op setOp, destination string, keys []string) (int, error) {
+	unlock := s.lockKeys(append([]string{destination}, keys...)...)
+	defer unlock()
+	result, err := s.combine(op, keys)
+	if err != nil {
+		return 0, err
+	}
+	shard := s.shardFor(destination)
+	if len(result) == 0 {
+		if shard.lookup(destination) != nil {
+			shard.drop(destination)
+		}
+		return 0, nil
+	}
+	item := &Item{Key: destination, Type: TypeSet, Set: make(map[string]struct{}, len(result))}
+	for member := range result {
+		item.addMember(member)
+	}
+	shard.put(item)
+	shard.notify(NotifySet, op.storeEvent(), destination)
+	return len(result), nil
+}
+
+func (s *Store) SInter(keys []string) ([]string, error) {
+	return s.combineMembers(setInter, keys)
+}
+
+func (s *Store) SUnion(keys []string) ([]string, error) {
+	return s.combineMembers(setUnion, keys)
+}
+
+func (s *Store) SDiff(keys []string) ([]string, error) {
+	return s.combineMembers(setDiff, keys)
+}
+
+func (s *Store) SInterStore(destination string, keys []string) (int, error) {
+	return s.combineStore(setInter, destination, keys)
+}
+
+func (s *Store) SUnionStore(destination string, keys []string) (int, error) {
+	return s.combineStore(setUnion, destination, keys)
+}
+
+func (s *Store) SDiffStore(destination string, keys []string) (int, error) {
+	return s.combineStore(setDiff, destination, keys)
+}
+
+// SInterCard returns the cardinality of the intersection, capped at limit when limit is positive.
+func (s *Store) SInterCard(keys []string, limit int) (int, error) {
+	unlock := s.lockKeys(keys...)
+	defer unlock()
+	result, err := s.combine(setInter, keys)
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 && len(result) > limit {
+		return limit, nil
+	}
+	return len(result), nil
+}
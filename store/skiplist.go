@@ -0,0 +1,299 @@
+package store
+
+import "math/rand"
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+/*
+skiplist keeps the members of a sorted set ordered by score, then by member.
+
+It follows the Redis design: every forward pointer records how many nodes it
+jumps over (its span), which lets rank lookups and rank based access run in
+O(log N) alongside the usual O(log N) insert, delete and range start search.
+*/
+type skiplist struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether n sorts before the (score, member) pair.
+func (n *skiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func (sl *skiplist) insert(score float64, member string) *skiplistNode {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+	return x
+}
+
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// rank returns the 1-based rank of the member, or 0 when it is not in the list.
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && (next.before(score, member) || (next.score == score && next.member == member)); next = x.level[i].forward {
+			rank += x.level[i].span
+			x = next
+		}
+		if x != sl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the given 1-based rank.
+func (sl *skiplist) byRank(rank int) *skiplistNode {
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// ScoreBound is one end of a score range, "(1.5" in Redis syntax is an exclusive bound.
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+type ScoreRange struct {
+	Min, Max ScoreBound
+}
+
+func (r ScoreRange) aboveMin(score float64) bool {
+	if r.Min.Exclusive {
+		return score > r.Min.Value
+	}
+	return score >= r.Min.Value
+}
+
+func (r ScoreRange) belowMax(score float64) bool {
+	if r.Max.Exclusive {
+		return score < r.Max.Value
+	}
+	return score <= r.Max.Value
+}
+
+func (r ScoreRange) empty() bool {
+	return r.Min.Value > r.Max.Value || (r.Min.Value == r.Max.Value && (r.Min.Exclusive || r.Max.Exclusive))
+}
+
+// LexBound is one end of a lexicographical range. Inf is -1 for "-" and 1 for "+",
+// in which case Value is ignored.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+type LexRange struct {
+	Min, Max LexBound
+}
+
+func (r LexRange) aboveMin(member string) bool {
+	switch {
+	case r.Min.Inf < 0:
+		return true
+	case r.Min.Inf > 0:
+		return false
+	case r.Min.Exclusive:
+		return member > r.Min.Value
+	}
+	return member >= r.Min.Value
+}
+
+func (r LexRange) belowMax(member string) bool {
+	switch {
+	case r.Max.Inf > 0:
+		return true
+	case r.Max.Inf < 0:
+		return false
+	case r.Max.Exclusive:
+		return member < r.Max.Value
+	}
+	return member <= r.Max.Value
+}
+
+// firstMatch returns the first node for which stillBelow is false, given that
+// stillBelow holds for a prefix of the list.
+func (sl *skiplist) firstMatch(stillBelow func(*skiplistNode) bool) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && stillBelow(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// lastMatch returns the last node for which inside is true, given that inside
+// holds for a prefix of the list.
+func (sl *skiplist) lastMatch(inside func(*skiplistNode) bool) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && inside(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.header {
+		return nil
+	}
+	return x
+}
+
+func (sl *skiplist) firstInScoreRange(r ScoreRange) *skiplistNode {
+	if r.empty() {
+		return nil
+	}
+	x := sl.firstMatch(func(n *skiplistNode) bool { return !r.aboveMin(n.score) })
+	if x == nil || !r.belowMax(x.score) {
+		return nil
+	}
+	return x
+}
+
+func (sl *skiplist) lastInScoreRange(r ScoreRange) *skiplistNode {
+	if r.empty() {
+		return nil
+	}
+	x := sl.lastMatch(func(n *skiplistNode) bool { return r.belowMax(n.score) })
+	if x == nil || !r.aboveMin(x.score) {
+		return nil
+	}
+	return x
+}
+
+func (sl *skiplist) firstInLexRange(r LexRange) *skiplistNode {
+	x := sl.firstMatch(func(n *skiplistNode) bool { return !r.aboveMin(n.member) })
+	if x == nil || !r.belowMax(x.member) {
+		return nil
+	}
+	return x
+}
+
+func (sl *skiplist) lastInLexRange(r LexRange) *skiplistNode {
+	x := sl.lastMatch(func(n *skiplistNode) bool { return r.belowMax(n.member) })
+	if x == nil || !r.aboveMin(x.member) {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,334 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+/*
+Snapshot layout (FDB2):
+
+	"FDB2" header
+	repeated entries of:
+		type      uint8  (0xFF marks the end of the snapshot)
+		key       uint32 length + bytes
+		expiresAt int64 unix nanoseconds, 0 when the key has no TTL
+		payload   type specific, see writeItem
+
+All integers are little endian. Snapshots written by the previous FDB1 format
+(string values only, prefixed by an item count) can still be loaded.
+*/
+const (
+	legacyFileVersion = "FDB1"
+	snapshotEOF       = 0xFF
+)
+
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (sw *snapshotWriter) write(v any) {
+	if sw.err == nil {
+		sw.err = binary.Write(sw.w, binary.LittleEndian, v)
+	}
+}
+
+func (sw *snapshotWriter) writeBytes(b []byte) {
+	sw.write(uint32(len(b)))
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *snapshotWriter) writeString(s string) {
+	sw.writeBytes([]byte(s))
+}
+
+func (sw *snapshotWriter) writeFloat(f float64) {
+	sw.write(math.Float64bits(f))
+}
+
+func (sw *snapshotWriter) writeItem(item *Item) {
+	sw.write(uint8(item.Type))
+	sw.writeString(item.Key)
+	var exp int64
+	if !item.ExpiresAt.IsZero() {
+		exp = item.ExpiresAt.UnixNano()
+	}
+	sw.write(exp)
+
+	switch item.Type {
+	case TypeString:
+		sw.writeBytes(item.Value)
+	case TypeHash:
+		sw.write(uint32(len(item.Hash)))
+		for field, value := range item.Hash {
+			sw.writeString(field)
+			sw.writeBytes(value)
+		}
+	case TypeList:
+		sw.write(uint32(item.List.Len()))
+		for i := 0; i < item.List.Len(); i++ {
+			sw.writeBytes(item.List.Index(i))
+		}
+	case TypeSet:
+		sw.write(uint32(len(item.Set)))
+		for member := range item.Set {
+			sw.writeString(member)
+		}
+	case TypeZSet:
+		sw.write(uint32(item.ZSet.Len()))
+		item.ZSet.Each(func(member string, score float64) {
+			sw.writeString(member)
+			sw.writeFloat(score)
+		})
+	case TypeStream:
+		sw.writeStream(item.Stream)
+	}
+}
+
+func (sw *snapshotWriter) writeID(id StreamID) {
+	sw.write(id.Ms)
+	sw.write(id.Seq)
+}
+
+// writeStream stores the entries, the last ID and every consumer group with its
+// PEL and consumers. Delivery and seen times are kept in milliseconds.
+func (sw *snapshotWriter) writeStream(st *Stream) {
+	sw.writeID(st.lastID)
+	sw.write(uint32(len(st.entries)))
+	for _, entry := range st.entries {
+		sw.writeID(entry.ID)
+		sw.write(uint32(len(entry.Fields)))
+		for _, field := range entry.Fields {
+			sw.writeString(field)
+		}
+	}
+	sw.write(uint32(len(st.groups)))
+	for name, g := range st.groups {
+		sw.writeString(name)
+		sw.writeID(g.lastID)
+		sw.write(uint32(len(g.consumers)))
+		for _, c := range g.consumers {
+			sw.writeString(c.Name)
+			sw.write(c.SeenTime.UnixMilli())
+		}
+		sw.write(uint32(len(g.pending)))
+		for _, p := range g.pending {
+			sw.writeID(p.ID)
+			sw.writeString(p.Consumer)
+			sw.write(p.DeliveryTime.UnixMilli())
+			sw.write(p.DeliveryCount)
+		}
+	}
+}
+
+type snapshotReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *snapshotReader) read(v any) {
+	if sr.err == nil {
+		sr.err = binary.Read(sr.r, binary.LittleEndian, v)
+	}
+}
+
+func (sr *snapshotReader) readUint32() uint32 {
+	var n uint32
+	sr.read(&n)
+	return n
+}
+
+func (sr *snapshotReader) readInt64() int64 {
+	var n int64
+	sr.read(&n)
+	return n
+}
+
+func (sr *snapshotReader) readFloat() float64 {
+	var bits uint64
+	sr.read(&bits)
+	return math.Float64frombits(bits)
+}
+
+func (sr *snapshotReader) readBytes() []byte {
+	n := sr.readUint32()
+	if sr.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, sr.err = io.ReadFull(sr.r, b)
+	return b
+}
+
+func (sr *snapshotReader) readString() string {
+	return string(sr.readBytes())
+}
+
+func (sr *snapshotReader) readItem(t ItemType) *Item {
+	item := &Item{Type: t}
+	item.Key = sr.readString()
+	if exp := sr.readInt64(); exp > 0 {
+		item.ExpiresAt = time.Unix(0, exp)
+	}
+
+	switch t {
+	case TypeString:
+		item.Value = sr.readBytes()
+	case TypeHash:
+		n := sr.readUint32()
+		item.Hash = make(map[string][]byte, n)
+		for i := uint32(0); i < n && sr.err == nil; i++ {
+			field := sr.readString()
+			item.setField(field, sr.readBytes())
+		}
+	case TypeList:
+		n := sr.readUint32()
+		item.List = NewList()
+		for i := uint32(0); i < n && sr.err == nil; i++ {
+			item.List.PushBack(sr.readBytes())
+		}
+	case TypeSet:
+		n := sr.readUint32()
+		item.Set = make(map[string]struct{}, n)
+		for i := uint32(0); i < n && sr.err == nil; i++ {
+			item.addMember(sr.readString())
+		}
+	case TypeZSet:
+		n := sr.readUint32()
+		item.ZSet = NewZSet()
+		for i := uint32(0); i < n && sr.err == nil; i++ {
+			member := sr.readString()
+			item.ZSet.Set(member, sr.readFloat())
+		}
+	case TypeStream:
+		item.Stream = sr.readStream()
+	default:
+		if sr.err == nil {
+			sr.err = fmt.Errorf("unknown value type %d in snapshot", t)
+		}
+	}
+	return item
+}
+
+func (sr *snapshotReader) readID() StreamID {
+	var id StreamID
+	sr.read(&id.Ms)
+	sr.read(&id.Seq)
+	return id
+}
+
+func (sr *snapshotReader) readStream() *Stream {
+	st := NewStream()
+	st.lastID = sr.readID()
+	n := sr.readUint32()
+	for i := uint32(0); i < n && sr.err == nil; i++ {
+		entry := StreamEntry{ID: sr.readID()}
+		fields := sr.readUint32()
+		for j := uint32(0); j < fields && sr.err == nil; j++ {
+			entry.Fields = append(entry.Fields, sr.readString())
+		}
+		st.entries = append(st.entries, entry)
+	}
+
+	groups := sr.readUint32()
+	for i := uint32(0); i < groups && sr.err == nil; i++ {
+		name := sr.readString()
+		g := newConsumerGroup(sr.readID())
+		consumers := sr.readUint32()
+		for j := uint32(0); j < consumers && sr.err == nil; j++ {
+			c := g.consumer(sr.readString())
+			c.SeenTime = time.UnixMilli(sr.readInt64())
+		}
+		pending := sr.readUint32()
+		for j := uint32(0); j < pending && sr.err == nil; j++ {
+			id := sr.readID()
+			p := g.assign(id, g.consumer(sr.readString()))
+			p.DeliveryTime = time.UnixMilli(sr.readInt64())
+			p.DeliveryCount = sr.readInt64()
+		}
+		st.groups[name] = g
+	}
+	return st
+}
+
+// Dump writes every live key of the store to w in the snapshot format.
+func (s *Store) Dump(w io.Writer) error {
+	sw := &snapshotWriter{w: w}
+	if _, err := w.Write([]byte(util.FileVersion)); err != nil {
+		return err
+	}
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for _, item := range shard.data {
+			if !item.IsExpired() {
+				sw.writeItem(item)
+			}
+		}
+		shard.mu.RUnlock()
+		if sw.err != nil {
+			return sw.err
+		}
+	}
+	sw.write(uint8(snapshotEOF))
+	return sw.err
+}
+
+// Restore loads keys from a snapshot previously produced by Dump, skipping
+// the ones that expired in the meantime.
+func (s *Store) Restore(r io.Reader) error {
+	version := make([]byte, len(util.FileVersion))
+	if _, err := io.ReadFull(r, version); err != nil {
+		return err
+	}
+
+	sr := &snapshotReader{r: r}
+	switch string(version) {
+	case util.FileVersion:
+		for {
+			var t uint8
+			sr.read(&t)
+			if sr.err != nil || t == snapshotEOF {
+				break
+			}
+			item := sr.readItem(ItemType(t))
+			if sr.err != nil {
+				break
+			}
+			s.restoreItem(item)
+		}
+	case legacyFileVersion:
+		count := sr.readUint32()
+		for i := uint32(0); i < count && sr.err == nil; i++ {
+			item := &Item{Type: TypeString}
+			item.Key = sr.readString()
+			item.Value = sr.readBytes()
+			if exp := sr.readInt64(); exp > 0 {
+				item.ExpiresAt = time.Unix(0, exp)
+			}
+			if sr.err == nil {
+				s.restoreItem(item)
+			}
+		}
+	default:
+		return fmt.Errorf("incompatible snapshot version")
+	}
+	return sr.err
+}
+
+func (s *Store) restoreItem(item *Item) {
+	if item.IsExpired() {
+		return
+	}
+	shard := s.shardFor(item.Key)
+	shard.mu.Lock()
+	shard.put(item)
+	shard.unlock()
+}
@@ -1,15 +1,54 @@
 package store
 
 import (
-	"encoding/binary"
+	"bufio"
+	"errors"
 	"fmt"
 	"hash/fnv"
-	"log"
+	"io"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+)
+
+// ItemType identifies the kind of value an Item holds.
+type ItemType uint8
+
+const (
+	TypeString ItemType = iota
+	TypeHash
+	TypeList
+	TypeSet
+	TypeZSet
+	TypeStream
+)
+
+func (t ItemType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeHash:
+		return "hash"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	case TypeStream:
+		return "stream"
+	}
+	return "none"
+}
 
-	"github.com/PetarGeorgiev-hash/flashdb/util"
+var (
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNotInt    = errors.New("ERR value is not an integer or out of range")
+	ErrNotFloat  = errors.New("ERR value is not a valid float")
+	ErrOverflow  = errors.New("ERR increment or decrement would overflow")
+	ErrRange     = errors.New("ERR value is out of range")
 )
 
 /*
@@ -17,11 +56,34 @@ import (
 
 If the TTL is set, the item will expire after the specified duration.
 If not set, the item will persist indefinitely.
+
+Type tells which of the value fields is in use: Value for strings, Hash for hashes,
+List for lists, Set for sets, ZSet for sorted sets and Stream for streams.
+
+Get hands out the *Item itself and readers keep using it once the shard lock is
+released. Writers changing the key, the expiry or a string value therefore store
+an updated copy in place of the item rather than modify it.
 */
 type Item struct {
 	Key       string
+	Type      ItemType
 	Value     []byte
+	Hash      map[string][]byte
+	List      *List
+	Set       map[string]struct{}
+	ZSet      *ZSet
+	Stream    *Stream
 	ExpiresAt time.Time
+
+	// members orders the fields of a hash or the members of a set for HSCAN
+	// and SSCAN, see setField and addMember.
+	members *scanIndex
+	// size is the estimated memory used by the item, see memory.go.
+	size int64
+	// accessed is the unix time in milliseconds of the last access and freq the
+	// logarithmic access counter, used by the eviction policies.
+	accessed int64
+	freq     uint32
 }
 
 func (i *Item) IsExpired() bool {
@@ -36,17 +98,164 @@ type IStore interface {
 	Set(key string, value []byte, ttl time.Duration) (*Item, error)
 	Get(key string) (*Item, error)
 	Delete(key string) error
+	Del(keys []string) int
+	Exists(keys []string) int
 	Save(filename string) error
 	Load(filename string) error
+	Dump(w io.Writer) error
+	Restore(r io.Reader) error
+	IStringStore
+	IHashStore
+	IListStore
+	ISetStore
+	IZSetStore
+	IStreamStore
+	IScanStore
+	IKeyspaceStore
+	IMemoryStore
+	ITransactionStore
+	INotifyStore
 	Import(data map[string][]byte)
 	Export() (map[string][]byte, error)
+	SetExpireCPUPercent(percent int)
 	StopChan() <-chan struct{}
 	Close()
 }
 
 type shard struct {
-	data map[string]*Item
-	mu   sync.RWMutex
+	data    map[string]*Item
+	expires *expiryIndex
+	// keys orders the keys by scan position for SCAN, see scan.go.
+	keys scanIndex
+	// expired counts the keys removed because their TTL ran out.
+	expired int64
+	// hits and misses count the lookups of readers, which only hold the read lock.
+	hits   atomic.Int64
+	misses atomic.Int64
+	// used is the memory used by the items of the shard. It is only written
+	// under the write lock but read without locking when checking maxmemory.
+	used atomic.Int64
+	// touched holds the items a writer may have modified, their size is
+	// refreshed by unlock.
+	touched []*Item
+	// watched holds the versions of the keys under WATCH, see transaction.go.
+	watched map[string]*watchedKey
+	// events holds the keyspace events of the writer, delivered by unlock.
+	events   []pendingEvent
+	notifier *notifier
+	mu       sync.RWMutex
+}
+
+func newShard(n *notifier) *shard {
+	return &shard{
+		data:     make(map[string]*Item),
+		expires:  newExpiryIndex(),
+		watched:  make(map[string]*watchedKey),
+		notifier: n,
+	}
+}
+
+/*
+put stores item under its key, replacing any previous item. Every write of the
+shard map goes through put and remove so that the expiry and scan indexes and
+the memory accounting stay in sync. The caller must hold the shard write lock.
+*/
+func (sh *shard) put(item *Item) {
+	if old, exists := sh.data[item.Key]; exists {
+		sh.used.Add(-old.size)
+	} else {
+		sh.keys.add(item.Key)
+		sh.notify(NotifyNew, "new", item.Key)
+	}
+	if item.accessed == 0 {
+		item.accessed = time.Now().UnixMilli()
+		item.freq = lfuInitValue
+	}
+	// The size is computed by unlock, once the writer is done with the item.
+	item.size = 0
+	sh.data[item.Key] = item
+	sh.touched = append(sh.touched, item)
+	sh.expires.set(item.Key, item.ExpiresAt)
+	sh.modified(item.Key)
+}
+
+// remove deletes key from the shard. The caller must hold the shard write lock.
+func (sh *shard) remove(key string) {
+	if item, exists := sh.data[key]; exists {
+		sh.used.Add(-item.size)
+		sh.keys.remove(key)
+	}
+	delete(sh.data, key)
+	sh.expires.remove(key)
+	sh.modified(key)
+}
+
+// drop removes key once the collection it holds is empty. The caller must hold the shard write lock.
+func (sh *shard) drop(key string) {
+	sh.remove(key)
+	sh.notify(NotifyGeneric, "del", key)
+}
+
+// unlock releases the write lock and delivers the keyspace events of the writer.
+func (sh *shard) unlock() {
+	sh.notifier.dispatch(sh.release())
+}
+
+// release refreshes the size of the items touched by the writer, bumps their
+// version if they are watched and releases the write lock. It returns the
+// keyspace events of the writer for the caller to deliver.
+func (sh *shard) release() []pendingEvent {
+	for i, item := range sh.touched {
+		sh.modified(item.Key)
+		if sh.data[item.Key] == item {
+			size := item.memoryUsage()
+			sh.used.Add(size - item.size)
+			item.size = size
+		}
+		sh.touched[i] = nil
+	}
+	sh.touched = sh.touched[:0]
+	events := sh.events
+	sh.events = nil
+	sh.mu.Unlock()
+	return events
+}
+
+// lookup returns the live item stored under key, removing it if it has expired.
+// The caller must hold the shard write lock.
+func (sh *shard) lookup(key string) *Item {
+	item, exists := sh.data[key]
+	if !exists {
+		return nil
+	}
+	if item.IsExpired() {
+		sh.expire(key)
+		return nil
+	}
+	item.touch(time.Now().UnixMilli())
+	sh.touched = append(sh.touched, item)
+	return item
+}
+
+// expire removes key because its TTL ran out. The caller must hold the shard write lock.
+func (sh *shard) expire(key string) {
+	sh.remove(key)
+	sh.expired++
+	sh.notify(NotifyExpired, "expired", key)
+}
+
+// peek returns the live item stored under key without modifying the shard,
+// counting the lookup as a keyspace hit or miss.
+// The caller must hold at least the shard read lock.
+func (sh *shard) peek(key string) *Item {
+	item, exists := sh.data[key]
+	if !exists || item.IsExpired() {
+		sh.misses.Add(1)
+		return nil
+	}
+	sh.hits.Add(1)
+	item.touch(time.Now().UnixMilli())
+	return item
 }
 
 /*
@@ -54,46 +263,128 @@ Store is an in-memory implementation of the IStore interface.
 
 Consists of multiple shards to reduce lock contention and improve concurrency.
 Each shard is a separate map with its own mutex for thread-safe access.
-Including a background goroutine to periodically clean up expired items.
-And a stop channel to signal the cleanup goroutine to stop when the store is closed.
+Including a background goroutine removing expired items, see expiry.go.
+And a stop channel to signal the expiry goroutine to stop when the store is closed.
 */
 type Store struct {
-	shards []*shard
-	Stop   chan struct{}
+	shards           []*shard
+	blocked          *blockingRegistry
+	expireCPUPercent atomic.Int32
+	maxMemory        atomic.Int64
+	policy           atomic.Int32
+	evicted          atomic.Int64
+	// txMu keeps commands from interleaving with an EXEC, see transaction.go.
+	txMu     sync.RWMutex
+	notifier *notifier
+	Stop     chan struct{}
 }
 
 func (s *Store) Close() {
 	close(s.Stop)
 }
 
+// shardFor returns the shard responsible for key.
+func (s *Store) shardFor(key string) *shard {
+	return s.shards[s.GetShardIndex(key)]
+}
+
+/*
+lockKeys write-locks every shard owning one of keys and returns the function releasing them.
+
+Shards are always locked in ascending index order, so two multi-key operations
+can never deadlock waiting on each other.
+*/
+func (s *Store) lockKeys(keys ...string) func() {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		index := s.GetShardIndex(key)
+		if !seen[index] {
+			seen[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		s.shards[index].mu.Lock()
+	}
+	return func() {
+		var events []pendingEvent
+		for i := len(indexes) - 1; i >= 0; i-- {
+			events = append(events, s.shards[indexes[i]].release()...)
+		}
+		s.notifier.dispatch(events)
+	}
+}
+
 func (s *Store) Delete(key string) error {
 	index := s.GetShardIndex(key)
 	shard := s.shards[index]
 	shard.mu.Lock()
-	defer shard.mu.Unlock()
+	defer shard.unlock()
 	if _, exists := shard.data[key]; !exists {
 		return fmt.Errorf("key not found")
 	}
-	delete(shard.data, key)
+	shard.remove(key)
+	shard.notify(NotifyGeneric, "del", key)
 	return nil
 }
 
+// Del removes every key of keys atomically and returns how many existed.
+func (s *Store) Del(keys []string) int {
+	unlock := s.lockKeys(keys...)
+	defer unlock()
+	deleted := 0
+	for _, key := range keys {
+		shard := s.shardFor(key)
+		if shard.lookup(key) != nil {
+			shard.remove(key)
+			shard.notify(NotifyGeneric, "del", key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Exists returns how many of keys exist, counting a key repeated in keys once per occurrence.
+func (s *Store) Exists(keys []string) int {
+	n := 0
+	for _, key := range keys {
+		shard := s.shardFor(key)
+		shard.mu.RLock()
+		if shard.peek(key) != nil {
+			n++
+		}
+		shard.mu.RUnlock()
+	}
+	return n
+}
+
 func (s *Store) Get(key string) (*Item, error) {
 	index := s.GetShardIndex(key)
 	shard := s.shards[index]
 	shard.mu.RLock()
 	item, exists := shard.data[key]
 	if !exists {
+		shard.misses.Add(1)
 		shard.mu.RUnlock()
 		return nil, nil
 	}
 	if item.IsExpired() {
 		shard.mu.RUnlock()
+		// The key may have been written in between, lookup checks again under the write lock.
 		shard.mu.Lock()
-		delete(shard.data, key)
-		shard.mu.Unlock()
-		return nil, nil
+		item = shard.lookup(key)
+		shard.unlock()
+		if item == nil {
+			shard.misses.Add(1)
+		} else {
+			shard.hits.Add(1)
+		}
+		return item, nil
 	}
+	shard.hits.Add(1)
+	item.touch(time.Now().UnixMilli())
 	shard.mu.RUnlock()
 	return item, nil
 }
@@ -103,7 +394,7 @@ func (s *Store) Set(key string, value []byte, ttl time.Duration) (*Item, error)
 	shard := s.shards[index]
 
 	shard.mu.Lock()
-	defer shard.mu.Unlock()
+	defer shard.unlock()
 	item := &Item{
 		Key:   key,
 		Value: value,
@@ -111,20 +402,20 @@ func (s *Store) Set(key string, value []byte, ttl time.Duration) (*Item, error)
 	if ttl > 0 {
 		item.ExpiresAt = time.Now().Add(ttl)
 	}
-	shard.data[key] = item
+	shard.put(item)
+	shard.notify(NotifyString, "set", key)
+	if ttl > 0 {
+		shard.notify(NotifyGeneric, "expire", key)
+	}
 	return item, nil
 }
 
 /*
 Save persists the current state of the store to a file.
 
-By iterating over all shards and writing non-expired items to the file in a binary format.
-
-Adding a file version header for compatibility checks during loading.
-Capturing the number of items and writing each item's key, value, and expiration timestamp
-to the file in a structured manner allowing for efficient loading later.
-
-After writing all items, it flushes the file to ensure data integrity.
+The snapshot is written to a temporary file first and then renamed over the
+previous one, so a crash in the middle of a save never leaves a truncated snapshot behind.
+The binary layout is described in snapshot.go.
 */
 func (s *Store) Save(filename string) error {
 	tmp := filename + ".tmp"
@@ -133,37 +424,19 @@ func (s *Store) Save(filename string) error {
 		return err
 	}
 	defer file.Close()
-	file.Write([]byte(util.FileVersion))
 
-	items := []*Item{}
-	for _, shard := range s.shards {
-		shard.mu.RLock()
-		for _, item := range shard.data {
-			if !item.IsExpired() {
-				items = append(items, item)
-			}
-		}
-		shard.mu.RUnlock()
+	w := bufio.NewWriter(file)
+	if err := s.Dump(w); err != nil {
+		return err
 	}
-
-	binary.Write(file, binary.LittleEndian, uint32(len(items)))
-	for _, item := range items {
-		keyBytes := []byte(item.Key)
-		valBytes := item.Value
-		exp := item.ExpiresAt.UnixNano()
-
-		binary.Write(file, binary.LittleEndian, uint32(len(keyBytes)))
-		file.Write(keyBytes)
-		binary.Write(file, binary.LittleEndian, uint32(len(valBytes)))
-		file.Write(valBytes)
-		binary.Write(file, binary.LittleEndian, exp)
+	if err := w.Flush(); err != nil {
+		return err
 	}
 	if err := file.Sync(); err != nil {
 		return err
 	}
 
 	return os.Rename(tmp, filename)
-
 }
 
 func (s *Store) Load(filename string) error {
@@ -172,72 +445,7 @@ func (s *Store) Load(filename string) error {
 		return err
 	}
 	defer file.Close()
-
-	version := make([]byte, len(util.FileVersion))
-	_, err = file.Read(version)
-	if err != nil {
-		return err
-	}
-
-	if string(version) != util.FileVersion {
-		return fmt.Errorf("incompatible snapshot version")
-	}
-
-	var count uint32
-	binary.Read(file, binary.LittleEndian, &count)
-	for i := 0; i < int(count); i++ {
-
-		var keyLen uint32
-		binary.Read(file, binary.LittleEndian, &keyLen)
-		keyBytes := make([]byte, keyLen)
-		file.Read(keyBytes) // read the key
-
-		var valLen uint32
-		binary.Read(file, binary.LittleEndian, &valLen)
-		valBytes := make([]byte, valLen)
-		file.Read(valBytes) // read the value
-
-		var exp int64
-		binary.Read(file, binary.LittleEndian, &exp)
-		expiresAt := time.Unix(0, exp)
-		if exp > 0 {
-			if time.Now().After(expiresAt) {
-				continue
-			}
-			s.Set(string(keyBytes), valBytes, time.Until(expiresAt))
-		} else {
-			s.Set(string(keyBytes), valBytes, 0)
-		}
-
-	}
-	return nil
-}
-
-/*
-cleanupExpiredItems runs in a background goroutine to periodically remove expired items from the store.
-
-ticker triggers every minute to check each shard for expired items.
-and waits for a stop signal to terminate the goroutine when the store is closed.
-*/
-func cleanupExpiredItems(s *Store) {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-s.Stop:
-			return
-		case <-ticker.C:
-			for _, shard := range s.shards {
-				shard.mu.Lock()
-				for key, item := range shard.data {
-					if item.IsExpired() {
-						delete(shard.data, key)
-					}
-				}
-				shard.mu.Unlock()
-			}
-		}
-	}
+	return s.Restore(bufio.NewReader(file))
 }
 
 func (s *Store) Export() (map[string][]byte, error) {
@@ -251,7 +459,7 @@ func (s *Store) Export() (map[string][]byte, error) {
 				result[key] = item.Value
 			}
 		}
-		shard.mu.Unlock()
+		shard.unlock()
 	}
 	return result, nil
 }
@@ -261,8 +469,8 @@ func (s *Store) Import(data map[string][]byte) {
 		index := s.GetShardIndex(key)
 		shard := s.shards[index]
 		shard.mu.Lock()
-		shard.data[key] = &Item{Value: item}
-		shard.mu.Unlock()
+		shard.put(&Item{Key: key, Value: item})
+		shard.unlock()
 	}
 }
 
@@ -274,21 +482,26 @@ func (s *Store) StopChan() <-chan struct{} {
 	return s.Stop
 }
 
+// DefaultShards is the number of shards of a store created by NewStore.
+const DefaultShards = 16
+
 func NewStore() IStore {
+	return NewShardedStore(DefaultShards)
+}
+
+// NewShardedStore returns an empty store whose keys are spread over the given number of shards.
+func NewShardedStore(shards int) IStore {
 	store := &Store{
-		shards: make([]*shard, util.NumShards),
-		Stop:   make(chan struct{}),
+		shards:   make([]*shard, shards),
+		blocked:  newBlockingRegistry(),
+		notifier: newNotifier(),
+		Stop:     make(chan struct{}),
 	}
+	store.SetExpireCPUPercent(DefaultExpireCPUPercent)
 	for i := range store.shards {
-		store.shards[i] = &shard{
-			data: make(map[string]*Item),
-		}
-	}
-	if err := store.Load(util.FileName); err != nil && !os.IsNotExist(err) {
-		log.Printf("No saved snapshots or failed to load them : %v", err)
+		store.shards[i] = newShard(store.notifier)
 	}
-
-	go cleanupExpiredItems(store)
+	go activeExpireCycle(store)
 	return store
 }
 
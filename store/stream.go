@@ -0,0 +1,532 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrStreamID       = errors.New("ERR Invalid stream ID specified as stream command argument")
+	ErrStreamIDSmall  = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	ErrStreamIDZero   = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	ErrStreamExhaust  = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+	ErrBusyGroup      = errors.New("BUSYGROUP Consumer Group name already exists")
+	ErrXGroupNoStream = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+)
+
+// StreamID identifies a stream entry: the creation time in milliseconds and a
+// sequence number for entries created within the same millisecond.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+func (id StreamID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// Next returns the smallest ID greater than id. ok is false when id is the maximum ID.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev returns the largest ID smaller than id. ok is false when id is 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseStreamID parses "ms-seq" or "ms", in which case the sequence is set to missingSeq.
+func ParseStreamID(s string, missingSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// StreamEntry is a stream record. Fields holds alternating field names and values,
+// it is nil for entries that were deleted while still pending in a consumer group.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// StreamTrim describes the MAXLEN/MINID trimming of XADD and XTRIM. A zero Limit
+// means no limit on the number of evicted entries.
+type StreamTrim struct {
+	MinID    bool
+	MaxLen   int
+	Boundary StreamID
+	Limit    int
+}
+
+// XAddOptions carries the options of XADD.
+type XAddOptions struct {
+	NoMkStream bool
+	Trim       *StreamTrim
+}
+
+/*
+Stream is an append-only log of entries ordered by ID.
+
+Entries live in a slice sorted by ID: appends are amortised O(1), lookups and
+range starts are binary searches, and trimming the oldest entries only reslices.
+*/
+type Stream struct {
+	entries []StreamEntry
+	lastID  StreamID
+	groups  map[string]*ConsumerGroup
+}
+
+func NewStream() *Stream {
+	return &Stream{groups: make(map[string]*ConsumerGroup)}
+}
+
+func (st *Stream) Len() int {
+	return len(st.entries)
+}
+
+func (st *Stream) LastID() StreamID {
+	return st.lastID
+}
+
+// search returns the index of the first entry whose ID is not lower than id.
+func (st *Stream) search(id StreamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].ID.Less(id)
+	})
+}
+
+func (st *Stream) get(id StreamID) (StreamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.entries) && st.entries[i].ID == id {
+		return st.entries[i], true
+	}
+	return StreamEntry{}, false
+}
+
+// nextID resolves the ID argument of XADD: "*", "ms-*" or an explicit "ms-seq".
+func (st *Stream) nextID(spec string) (StreamID, error) {
+	last := st.lastID
+	if spec == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > last.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+		id, ok := last.Next()
+		if !ok {
+			return id, ErrStreamExhaust
+		}
+		return id, nil
+	}
+
+	var id StreamID
+	if msPart, ok := strings.CutSuffix(spec, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return id, ErrStreamID
+		}
+		switch {
+		case ms > last.Ms:
+			id = StreamID{Ms: ms}
+		case ms == last.Ms && last.Seq < math.MaxUint64:
+			id = StreamID{Ms: ms, Seq: last.Seq + 1}
+		default:
+			return id, ErrStreamIDSmall
+		}
+	} else {
+		var err error
+		if id, err = ParseStreamID(spec, 0); err != nil {
+			return id, err
+		}
+	}
+	if id.IsZero() {
+		return id, ErrStreamIDZero
+	}
+	if !last.Less(id) {
+		return id, ErrStreamIDSmall
+	}
+	return id, nil
+}
+
+func (st *Stream) append(id StreamID, fields []string) {
+	st.entries = append(st.entries, StreamEntry{ID: id, Fields: fields})
+	st.lastID = id
+}
+
+// rangeEntries returns the entries between start and end inclusive, walking
+// backwards from end when reverse is set. A count <= 0 means no limit.
+func (st *Stream) rangeEntries(start, end StreamID, count int, reverse bool) []StreamEntry {
+	result := []StreamEntry{}
+	if end.Less(start) {
+		return result
+	}
+	if reverse {
+		i := st.search(end)
+		if i == len(st.entries) || end.Less(st.entries[i].ID) {
+			i--
+		}
+		for ; i >= 0 && !st.entries[i].ID.Less(start); i-- {
+			if count > 0 && len(result) == count {
+				break
+			}
+			result = append(result, st.entries[i])
+		}
+		return result
+	}
+	for i := st.search(start); i < len(st.entries) && !end.Less(st.entries[i].ID); i++ {
+		if count > 0 && len(result) == count {
+			break
+		}
+		result = append(result, st.entries[i])
+	}
+	return result
+}
+
+// trim evicts the oldest entries according to t and returns how many were removed.
+func (st *Stream) trim(t StreamTrim) int {
+	n := 0
+	if t.MinID {
+		n = st.search(t.Boundary)
+	} else if len(st.entries) > t.MaxLen {
+		n = len(st.entries) - t.MaxLen
+	}
+	if t.Limit > 0 && n > t.Limit {
+		n = t.Limit
+	}
+	for i := 0; i < n; i++ {
+		st.entries[i] = StreamEntry{}
+	}
+	st.entries = st.entries[n:]
+	return n
+}
+
+func (st *Stream) delete(ids []StreamID) int {
+	deleted := 0
+	for _, id := range ids {
+		i := st.search(id)
+		if i < len(st.entries) && st.entries[i].ID == id {
+			st.entries = append(st.entries[:i], st.entries[i+1:]...)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+/*
+ParseStreamTrim parses a trimming clause: MAXLEN|MINID [=|~] threshold [LIMIT count].
+It returns the number of arguments consumed.
+
+Approximate trimming (~) is accepted for compatibility but performed exactly,
+the slice backed stream has no nodes to align the cut with.
+*/
+func ParseStreamTrim(args []string) (*StreamTrim, int, error) {
+	if len(args) < 2 {
+		return nil, 0, ErrSyntax
+	}
+	t := &StreamTrim{}
+	switch strings.ToUpper(args[0]) {
+	case "MAXLEN":
+	case "MINID":
+		t.MinID = true
+	default:
+		return nil, 0, ErrSyntax
+	}
+	i := 1
+	if args[i] == "=" || args[i] == "~" {
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, ErrSyntax
+	}
+	if t.MinID {
+		id, err := ParseStreamID(args[i], 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		t.Boundary = id
+	} else {
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			return nil, 0, ErrNotInt
+		}
+		if n < 0 {
+			return nil, 0, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		t.MaxLen = n
+	}
+	i++
+	if i+1 < len(args) && strings.EqualFold(args[i], "LIMIT") {
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 0 {
+			return nil, 0, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+		t.Limit = n
+		i += 2
+	}
+	return t, i, nil
+}
+
+/*
+ParseXAddArgs parses the arguments of XADD that follow the key:
+[NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...].
+
+It is shared by the command handler and the AOF/replication replay.
+*/
+func ParseXAddArgs(args []string) (XAddOptions, string, []string, error) {
+	var opts XAddOptions
+	i := 0
+	for i < len(args) {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			opts.NoMkStream = true
+			i++
+			continue
+		case "MAXLEN", "MINID":
+			trim, n, err := ParseStreamTrim(args[i:])
+			if err != nil {
+				return opts, "", nil, err
+			}
+			opts.Trim = trim
+			i += n
+			continue
+		}
+		break
+	}
+	if i >= len(args) || (len(args)-i-1) == 0 || (len(args)-i-1)%2 != 0 {
+		return opts, "", nil, errors.New("ERR wrong number of arguments for 'XADD' command")
+	}
+	return opts, args[i], args[i+1:], nil
+}
+
+// IStreamStore groups the operations on streams and their consumer groups.
+// Readers blocked on a stream are woken up by XAdd.
+type IStreamStore interface {
+	XAdd(key, id string, fields []string, opts XAddOptions) (StreamID, bool, error)
+	XLen(key string) (int, error)
+	XRange(key string, start, end StreamID, count int, reverse bool) ([]StreamEntry, error)
+	XDel(key string, ids []StreamID) (int, error)
+	XTrim(key string, trim StreamTrim) (int, error)
+	XRead(keys, ids []string, count int, block time.Duration) ([]StreamReadResult, error)
+	XGroupCreate(key, group, id string, mkStream bool) (StreamID, error)
+	XGroupSetID(key, group, id string) (StreamID, error)
+	XGroupDestroy(key, group string) (bool, error)
+	XGroupCreateConsumer(key, group, consumer string) (bool, error)
+	XGroupDelConsumer(key, group, consumer string) (int, error)
+	XReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) ([]StreamReadResult, error)
+	XAck(key, group string, ids []StreamID) (int, error)
+	XPending(key, group string) (*XPendingSummary, error)
+	XPendingRange(key, group string, start, end StreamID, count int, consumer string, minIdle time.Duration) ([]PendingEntry, error)
+	XClaim(key, group, consumer string, minIdle time.Duration, ids []StreamID, opts XClaimOptions) (*StreamClaimResult, error)
+	XAutoClaim(key, group, consumer string, minIdle time.Duration, start StreamID, count int, justID bool) (*StreamClaimResult, error)
+}
+
+// readStream returns the stream stored under key, nil if there is none.
+// The caller must hold at least the shard read lock.
+func (sh *shard) readStream(key string) (*Stream, error) {
+	item := sh.peek(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != TypeStream {
+		return nil, ErrWrongType
+	}
+	return item.Stream, nil
+}
+
+// writeStream returns the stream stored under key, creating it when create is set.
+// The caller must hold the shard write lock.
+func (sh *shard) writeStream(key string, create bool) (*Stream, error) {
+	item := sh.lookup(key)
+	if item == nil {
+		if !create {
+			return nil, nil
+		}
+		item = &Item{Key: key, Type: TypeStream, Stream: NewStream()}
+		sh.put(item)
+	}
+	if item.Type != TypeStream {
+		return nil, ErrWrongType
+	}
+	return item.Stream, nil
+}
+
+/*
+XAdd appends an entry to the stream at key and returns its ID. The boolean is
+false when NOMKSTREAM was given and the stream does not exist.
+
+Unlike lists and sets, a stream is not removed when trimming empties it: it
+keeps its last ID and its consumer groups.
+*/
+func (s *Store) XAdd(key, id string, fields []string, opts XAddOptions) (StreamID, bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	st, err := shard.writeStream(key, !opts.NoMkStream)
+	if err != nil || st == nil {
+		shard.unlock()
+		return StreamID{}, false, err
+	}
+	newID, err := st.nextID(id)
+	if err != nil {
+		shard.unlock()
+		return StreamID{}, false, err
+	}
+	st.append(newID, fields)
+	shard.notify(NotifyStream, "xadd", key)
+	if opts.Trim != nil && st.trim(*opts.Trim) > 0 {
+		shard.notify(NotifyStream, "xtrim", key)
+	}
+	shard.unlock()
+
+	s.signalKey(key)
+	return newID, true, nil
+}
+
+func (s *Store) XLen(key string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	st, err := shard.readStream(key)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	return st.Len(), nil
+}
+
+// XRange returns the entries between start and end inclusive. A count <= 0 means no limit.
+func (s *Store) XRange(key string, start, end StreamID, count int, reverse bool) ([]StreamEntry, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	st, err := shard.readStream(key)
+	if err != nil || st == nil {
+		return []StreamEntry{}, err
+	}
+	return st.rangeEntries(start, end, count, reverse), nil
+}
+
+func (s *Store) XDel(key string, ids []StreamID) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, err := shard.writeStream(key, false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	deleted := st.delete(ids)
+	if deleted > 0 {
+		shard.notify(NotifyStream, "xdel", key)
+	}
+	return deleted, nil
+}
+
+func (s *Store) XTrim(key string, trim StreamTrim) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, err := shard.writeStream(key, false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	trimmed := st.trim(trim)
+	if trimmed > 0 {
+		shard.notify(NotifyStream, "xtrim", key)
+	}
+	return trimmed, nil
+}
+
+/*
+XRead returns the entries added after ids to the streams at keys, where the
+ID $ stands for the last entry of the stream at the time of the call.
+
+When no stream has new entries the call blocks for up to block, waking up on
+the next XAdd to one of the keys. A negative block means no blocking at all.
+*/
+func (s *Store) XRead(keys, ids []string, count int, block time.Duration) ([]StreamReadResult, error) {
+	unlock := s.lockToWait(block)
+	defer unlock()
+	after := make([]StreamID, len(keys))
+	for i, key := range keys {
+		if ids[i] != "$" {
+			id, err := ParseStreamID(ids[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			after[i] = id
+			continue
+		}
+		shard := s.shardFor(key)
+		shard.mu.RLock()
+		st, err := shard.readStream(key)
+		if st != nil {
+			after[i] = st.lastID
+		}
+		shard.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	read := func() ([]StreamReadResult, error) {
+		var results []StreamReadResult
+		for i, key := range keys {
+			start, ok := after[i].Next()
+			if !ok {
+				continue
+			}
+			entries, err := s.XRange(key, start, MaxStreamID, count, false)
+			if err != nil {
+				return nil, err
+			}
+			if len(entries) > 0 {
+				results = append(results, StreamReadResult{Key: key, Entries: entries})
+			}
+		}
+		return results, nil
+	}
+
+	results, err := read()
+	if err != nil || len(results) > 0 || block < 0 {
+		return results, err
+	}
+	unlock()
+	s.block(keys, block, func(string) bool {
+		results, err = read()
+		return err != nil || len(results) > 0
+	})
+	return results, err
+}
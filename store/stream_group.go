@@ -0,0 +1,621 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PendingEntry is an entry delivered to a consumer and not acknowledged yet.
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  time.Time
+	DeliveryCount int64
+}
+
+// StreamConsumer is a member of a consumer group with the entries it has pending.
+type StreamConsumer struct {
+	Name     string
+	SeenTime time.Time
+	pending  map[StreamID]*PendingEntry
+}
+
+/*
+ConsumerGroup tracks the last entry delivered to the group and the pending
+entries list (PEL): every entry delivered to one of its consumers that has not
+been acknowledged with XACK yet. Each pending entry is indexed both group wide
+and in the PEL of the consumer owning it.
+*/
+type ConsumerGroup struct {
+	lastID    StreamID
+	pending   map[StreamID]*PendingEntry
+	consumers map[string]*StreamConsumer
+}
+
+func newConsumerGroup(lastID StreamID) *ConsumerGroup {
+	return &ConsumerGroup{
+		lastID:    lastID,
+		pending:   make(map[StreamID]*PendingEntry),
+		consumers: make(map[string]*StreamConsumer),
+	}
+}
+
+// consumer returns the named consumer, creating it when needed.
+func (g *ConsumerGroup) consumer(name string) *StreamConsumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &StreamConsumer{Name: name, pending: make(map[StreamID]*PendingEntry)}
+		g.consumers[name] = c
+	}
+	return c
+}
+
+// assign hands the entry id to consumer c, moving it from its previous owner if needed.
+func (g *ConsumerGroup) assign(id StreamID, c *StreamConsumer) *PendingEntry {
+	p, ok := g.pending[id]
+	if !ok {
+		p = &PendingEntry{ID: id}
+		g.pending[id] = p
+	} else if prev, ok := g.consumers[p.Consumer]; ok {
+		delete(prev.pending, id)
+	}
+	p.Consumer = c.Name
+	c.pending[id] = p
+	return p
+}
+
+func (g *ConsumerGroup) ack(id StreamID) bool {
+	p, ok := g.pending[id]
+	if !ok {
+		return false
+	}
+	delete(g.pending, id)
+	if c, ok := g.consumers[p.Consumer]; ok {
+		delete(c.pending, id)
+	}
+	return true
+}
+
+func sortPending(pending map[StreamID]*PendingEntry) []*PendingEntry {
+	sorted := make([]*PendingEntry, 0, len(pending))
+	for _, p := range pending {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID.Less(sorted[j].ID)
+	})
+	return sorted
+}
+
+// StreamReadResult is the reply of XREAD and XREADGROUP for one stream. Pending
+// lists the deliveries made by XREADGROUP so that they can be propagated.
+type StreamReadResult struct {
+	Key         string
+	Entries     []StreamEntry
+	Pending     []PendingEntry
+	GroupLastID StreamID
+}
+
+// XPendingSummary is the reply of the short form of XPENDING.
+type XPendingSummary struct {
+	Count     int
+	Min, Max  StreamID
+	Consumers []ConsumerPending
+}
+
+type ConsumerPending struct {
+	Name  string
+	Count int
+}
+
+// XClaimOptions carries the options of XCLAIM. Nil pointers mean the option was not given.
+type XClaimOptions struct {
+	Idle       *time.Duration
+	Time       *time.Time
+	RetryCount *int64
+	Force      bool
+	JustID     bool
+	LastID     *StreamID
+}
+
+// StreamClaimResult is the outcome of XCLAIM and XAUTOCLAIM. Deleted lists the
+// pending entries dropped because they no longer exist in the stream, Next is
+// the XAUTOCLAIM cursor.
+type StreamClaimResult struct {
+	Entries     []StreamEntry
+	Pending     []PendingEntry
+	Deleted     []StreamID
+	Next        StreamID
+	GroupLastID StreamID
+}
+
+func errNoGroup(key, group string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+/*
+ParseXClaimArgs parses the arguments of XCLAIM that follow the consumer name:
+min-idle-time id [id ...] [IDLE ms] [TIME ms] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID id].
+
+It is shared by the command handler and the AOF/replication replay.
+*/
+func ParseXClaimArgs(args []string) (time.Duration, []StreamID, XClaimOptions, error) {
+	var opts XClaimOptions
+	if len(args) < 2 {
+		return 0, nil, opts, ErrSyntax
+	}
+	minIdle, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, nil, opts, errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+
+	i := 1
+	ids := []StreamID{}
+	for ; i < len(args); i++ {
+		id, err := ParseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return 0, nil, opts, ErrStreamID
+	}
+
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "FORCE":
+			opts.Force = true
+			continue
+		case "JUSTID":
+			opts.JustID = true
+			continue
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+		default:
+			return 0, nil, opts, fmt.Errorf("ERR Unrecognized XCLAIM option '%s'", args[i])
+		}
+		if i+1 >= len(args) {
+			return 0, nil, opts, ErrSyntax
+		}
+		i++
+		if option == "LASTID" {
+			id, err := ParseStreamID(args[i], 0)
+			if err != nil {
+				return 0, nil, opts, err
+			}
+			opts.LastID = &id
+			continue
+		}
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return 0, nil, opts, ErrNotInt
+		}
+		switch option {
+		case "IDLE":
+			idle := time.Duration(n) * time.Millisecond
+			opts.Idle = &idle
+		case "TIME":
+			t := time.UnixMilli(n)
+			opts.Time = &t
+		case "RETRYCOUNT":
+			opts.RetryCount = &n
+		}
+	}
+	return time.Duration(minIdle) * time.Millisecond, ids, opts, nil
+}
+
+/*
+streamGroup returns the stream and the consumer group, or NOGROUP when either is
+missing. Writers set write and must hold the shard write lock, readers need at
+least the read lock.
+*/
+func (sh *shard) streamGroup(key, group string, write bool) (*Stream, *ConsumerGroup, error) {
+	var (
+		st  *Stream
+		err error
+	)
+	if write {
+		st, err = sh.writeStream(key, false)
+	} else {
+		st, err = sh.readStream(key)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if st == nil {
+		return nil, nil, errNoGroup(key, group)
+	}
+	g, ok := st.groups[group]
+	if !ok {
+		return nil, nil, errNoGroup(key, group)
+	}
+	return st, g, nil
+}
+
+// resolveGroupID parses the ID of XGROUP CREATE and SETID, where $ means the last entry.
+func resolveGroupID(st *Stream, id string) (StreamID, error) {
+	if id == "$" {
+		return st.lastID, nil
+	}
+	return ParseStreamID(id, 0)
+}
+
+// XGroupCreate creates a consumer group and returns its resolved last delivered ID.
+func (s *Store) XGroupCreate(key, group, id string, mkStream bool) (StreamID, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, err := shard.writeStream(key, mkStream)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if st == nil {
+		return StreamID{}, ErrXGroupNoStream
+	}
+	lastID, err := resolveGroupID(st, id)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if _, exists := st.groups[group]; exists {
+		return StreamID{}, ErrBusyGroup
+	}
+	st.groups[group] = newConsumerGroup(lastID)
+	shard.notify(NotifyStream, "xgroup-create", key)
+	return lastID, nil
+}
+
+// XGroupSetID moves the last delivered ID of a group and returns its resolved value.
+func (s *Store) XGroupSetID(key, group, id string) (StreamID, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, g, err := shard.streamGroup(key, group, true)
+	if err != nil {
+		return StreamID{}, err
+	}
+	lastID, err := resolveGroupID(st, id)
+	if err != nil {
+		return StreamID{}, err
+	}
+	g.lastID = lastID
+	shard.notify(NotifyStream, "xgroup-setid", key)
+	return lastID, nil
+}
+
+func (s *Store) XGroupDestroy(key, group string) (bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, err := shard.writeStream(key, false)
+	if err != nil {
+		return false, err
+	}
+	if st == nil {
+		return false, ErrXGroupNoStream
+	}
+	if _, exists := st.groups[group]; !exists {
+		return false, nil
+	}
+	delete(st.groups, group)
+	shard.notify(NotifyStream, "xgroup-destroy", key)
+	return true, nil
+}
+
+func (s *Store) XGroupCreateConsumer(key, group, consumer string) (bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	_, g, err := shard.streamGroup(key, group, true)
+	if err != nil {
+		return false, err
+	}
+	if _, exists := g.consumers[consumer]; exists {
+		return false, nil
+	}
+	g.consumer(consumer).SeenTime = time.Now()
+	shard.notify(NotifyStream, "xgroup-createconsumer", key)
+	return true, nil
+}
+
+// XGroupDelConsumer removes a consumer and returns the number of entries it had pending.
+func (s *Store) XGroupDelConsumer(key, group, consumer string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	_, g, err := shard.streamGroup(key, group, true)
+	if err != nil {
+		return 0, err
+	}
+	c, exists := g.consumers[consumer]
+	if !exists {
+		return 0, nil
+	}
+	n := len(c.pending)
+	for id := range c.pending {
+		delete(g.pending, id)
+	}
+	delete(g.consumers, consumer)
+	shard.notify(NotifyStream, "xgroup-delconsumer", key)
+	return n, nil
+}
+
+// readGroup serves one stream of XREADGROUP. With ">" it delivers new entries to
+// the consumer, otherwise it replays the consumer's own pending entries after id.
+func (s *Store) readGroup(key, id, group, consumer string, count int, noAck bool) (*StreamReadResult, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, g, err := shard.streamGroup(key, group, true)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c := g.consumer(consumer)
+	c.SeenTime = now
+	result := &StreamReadResult{Key: key}
+
+	if id != ">" {
+		after, err := ParseStreamID(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		result.Entries = []StreamEntry{}
+		for _, p := range sortPending(c.pending) {
+			if count > 0 && len(result.Entries) == count {
+				break
+			}
+			if !after.Less(p.ID) {
+				continue
+			}
+			entry, ok := st.get(p.ID)
+			if !ok {
+				entry = StreamEntry{ID: p.ID}
+			}
+			result.Entries = append(result.Entries, entry)
+		}
+		return result, nil
+	}
+
+	start, ok := g.lastID.Next()
+	if !ok {
+		return result, nil
+	}
+	result.Entries = st.rangeEntries(start, MaxStreamID, count, false)
+	for _, entry := range result.Entries {
+		g.lastID = entry.ID
+		if noAck {
+			continue
+		}
+		p := g.assign(entry.ID, c)
+		p.DeliveryTime = now
+		p.DeliveryCount = 1
+		result.Pending = append(result.Pending, *p)
+	}
+	result.GroupLastID = g.lastID
+	return result, nil
+}
+
+/*
+XReadGroup reads from the streams at keys on behalf of a consumer of group.
+
+When every ID is ">" and no stream has new entries, the call blocks for up to
+block, a negative block means no blocking at all. Consumers waiting on the
+same stream are served in the order they blocked.
+*/
+func (s *Store) XReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) ([]StreamReadResult, error) {
+	unlock := s.lockToWait(block)
+	defer unlock()
+	read := func() ([]StreamReadResult, error) {
+		var results []StreamReadResult
+		for i, key := range keys {
+			result, err := s.readGroup(key, ids[i], group, consumer, count, noAck)
+			if err != nil {
+				return nil, err
+			}
+			if ids[i] != ">" || len(result.Entries) > 0 {
+				results = append(results, *result)
+			}
+		}
+		return results, nil
+	}
+
+	onlyNew := true
+	for _, id := range ids {
+		if id != ">" {
+			onlyNew = false
+		}
+	}
+
+	results, err := read()
+	if err != nil || len(results) > 0 || !onlyNew || block < 0 {
+		return results, err
+	}
+	unlock()
+	s.block(keys, block, func(string) bool {
+		results, err = read()
+		return err != nil || len(results) > 0
+	})
+	return results, err
+}
+
+func (s *Store) XAck(key, group string, ids []StreamID) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, err := shard.writeStream(key, false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	g, ok := st.groups[group]
+	if !ok {
+		return 0, nil
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+func (s *Store) XPending(key, group string) (*XPendingSummary, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	_, g, err := shard.streamGroup(key, group, false)
+	if err != nil {
+		return nil, err
+	}
+	summary := &XPendingSummary{Count: len(g.pending)}
+	first := true
+	for id := range g.pending {
+		if first || id.Less(summary.Min) {
+			summary.Min = id
+		}
+		if first || summary.Max.Less(id) {
+			summary.Max = id
+		}
+		first = false
+	}
+	for name, c := range g.consumers {
+		if len(c.pending) > 0 {
+			summary.Consumers = append(summary.Consumers, ConsumerPending{Name: name, Count: len(c.pending)})
+		}
+	}
+	sort.Slice(summary.Consumers, func(i, j int) bool {
+		return summary.Consumers[i].Name < summary.Consumers[j].Name
+	})
+	return summary, nil
+}
+
+// XPendingRange is the extended form of XPENDING. An empty consumer means every
+// consumer of the group.
+func (s *Store) XPendingRange(key, group string, start, end StreamID, count int, consumer string, minIdle time.Duration) ([]PendingEntry, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	_, g, err := shard.streamGroup(key, group, false)
+	if err != nil {
+		return nil, err
+	}
+	pending := g.pending
+	if consumer != "" {
+		c, ok := g.consumers[consumer]
+		if !ok {
+			return []PendingEntry{}, nil
+		}
+		pending = c.pending
+	}
+
+	now := time.Now()
+	result := []PendingEntry{}
+	for _, p := range sortPending(pending) {
+		if len(result) == count {
+			break
+		}
+		if p.ID.Less(start) || end.Less(p.ID) || now.Sub(p.DeliveryTime) < minIdle {
+			continue
+		}
+		result = append(result, *p)
+	}
+	return result, nil
+}
+
+// claim transfers one pending entry to consumer c and records the outcome in result.
+func claim(st *Stream, g *ConsumerGroup, c *StreamConsumer, id StreamID, minIdle time.Duration, opts XClaimOptions, now time.Time, result *StreamClaimResult) {
+	entry, exists := st.get(id)
+	p, pending := g.pending[id]
+	if !exists {
+		if pending {
+			g.ack(id)
+			result.Deleted = append(result.Deleted, id)
+		}
+		return
+	}
+	if !pending {
+		if !opts.Force {
+			return
+		}
+	} else if minIdle > 0 && now.Sub(p.DeliveryTime) < minIdle {
+		return
+	}
+
+	p = g.assign(id, c)
+	switch {
+	case opts.Time != nil:
+		p.DeliveryTime = *opts.Time
+	case opts.Idle != nil:
+		p.DeliveryTime = now.Add(-*opts.Idle)
+	default:
+		p.DeliveryTime = now
+	}
+	if opts.RetryCount != nil {
+		p.DeliveryCount = *opts.RetryCount
+	} else if !opts.JustID {
+		p.DeliveryCount++
+	}
+	result.Entries = append(result.Entries, entry)
+	result.Pending = append(result.Pending, *p)
+}
+
+func (s *Store) XClaim(key, group, consumer string, minIdle time.Duration, ids []StreamID, opts XClaimOptions) (*StreamClaimResult, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, g, err := shard.streamGroup(key, group, true)
+	if err != nil {
+		return nil, err
+	}
+	if opts.LastID != nil && g.lastID.Less(*opts.LastID) {
+		g.lastID = *opts.LastID
+	}
+	now := time.Now()
+	c := g.consumer(consumer)
+	c.SeenTime = now
+	result := &StreamClaimResult{}
+	for _, id := range ids {
+		claim(st, g, c, id, minIdle, opts, now, result)
+	}
+	result.GroupLastID = g.lastID
+	return result, nil
+}
+
+/*
+XAutoClaim claims up to count pending entries idle for at least minIdle,
+scanning the PEL from start. Next is the ID to resume the scan from, 0-0 once
+the whole PEL has been scanned.
+*/
+func (s *Store) XAutoClaim(key, group, consumer string, minIdle time.Duration, start StreamID, count int, justID bool) (*StreamClaimResult, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	st, g, err := shard.streamGroup(key, group, true)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c := g.consumer(consumer)
+	c.SeenTime = now
+	result := &StreamClaimResult{Entries: []StreamEntry{}}
+	opts := XClaimOptions{JustID: justID}
+
+	// Like Redis, bound the work done by a single call to ten times count.
+	attempts := count * 10
+	sorted := sortPending(g.pending)
+	i := sort.Search(len(sorted), func(i int) bool { return !sorted[i].ID.Less(start) })
+	for ; i < len(sorted) && attempts > 0 && len(result.Entries) < count; i++ {
+		attempts--
+		claim(st, g, c, sorted[i].ID, minIdle, opts, now, result)
+	}
+	if i < len(sorted) {
+		result.Next = sorted[i].ID
+	}
+	result.GroupLastID = g.lastID
+	return result, nil
+}
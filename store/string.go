@@ -0,0 +1,434 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxStringLength is the largest string SETRANGE and APPEND may produce, 512MB like Redis.
+const MaxStringLength = 512 * 1024 * 1024
+
+var (
+	ErrSetExpireTime = errors.New("ERR invalid expire time in 'set' command")
+	ErrStringTooLong = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	ErrOffsetRange   = errors.New("ERR offset is out of range")
+)
+
+/*
+IStringStore groups the read-modify-write operations on strings. Each of them
+runs under the shard lock, so concurrent INCRs on the same key never lose an update.
+
+Unless stated otherwise the key keeps its TTL.
+*/
+type IStringStore interface {
+	SetWithOptions(key string, value []byte, opts SetOptions) ([]byte, bool, error)
+	MGet(keys []string) [][]byte
+	MSet(values map[string][]byte)
+	MSetNX(values map[string][]byte) bool
+	IncrBy(key string, delta int64) (int64, error)
+	IncrByFloat(key string, delta float64) ([]byte, error)
+	Append(key string, value []byte) (int, error)
+	StrLen(key string) (int, error)
+	GetRange(key string, start, end int) ([]byte, error)
+	SetRange(key string, offset int, value []byte) (int, error)
+	GetDel(key string) ([]byte, error)
+	GetEx(key string, expiresAt time.Time, persist bool) ([]byte, error)
+	GetSet(key string, value []byte) ([]byte, error)
+}
+
+// SetOptions carries the options of SET. A zero ExpiresAt without KeepTTL
+// clears any previous TTL.
+type SetOptions struct {
+	NX        bool
+	XX        bool
+	KeepTTL   bool
+	Get       bool
+	ExpiresAt time.Time
+}
+
+/*
+ParseSetArgs parses the options of SET that follow the value:
+[NX|XX] [GET] [EX seconds|PX milliseconds|EXAT unix-time|PXAT unix-time-ms|KEEPTTL].
+
+Relative expiries are resolved against the current time. For compatibility with
+older clients and AOF files a single bare integer is read as EX seconds.
+*/
+func ParseSetArgs(args []string) (SetOptions, error) {
+	var opts SetOptions
+	if len(args) == 1 {
+		if seconds, err := strconv.ParseInt(args[0], 10, 64); err == nil {
+			millis, ok := ExpireAtMillis(seconds, true, true)
+			if seconds <= 0 || !ok {
+				return opts, ErrSetExpireTime
+			}
+			opts.ExpiresAt = time.UnixMilli(millis)
+			return opts, nil
+		}
+	}
+
+	hasExpiry := false
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "NX", "XX":
+			if opts.NX || opts.XX {
+				return opts, ErrSyntax
+			}
+			opts.NX, opts.XX = option == "NX", option == "XX"
+			continue
+		case "GET":
+			opts.Get = true
+			continue
+		case "KEEPTTL":
+			if hasExpiry {
+				return opts, ErrSyntax
+			}
+			opts.KeepTTL, hasExpiry = true, true
+			continue
+		case "EX", "PX", "EXAT", "PXAT":
+		default:
+			return opts, ErrSyntax
+		}
+		if hasExpiry || i+1 >= len(args) {
+			return opts, ErrSyntax
+		}
+		hasExpiry = true
+		i++
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return opts, ErrNotInt
+		}
+		millis, ok := ExpireAtMillis(n, option == "EX" || option == "EXAT", option == "EX" || option == "PX")
+		if n <= 0 || !ok {
+			return opts, ErrSetExpireTime
+		}
+		opts.ExpiresAt = time.UnixMilli(millis)
+	}
+	return opts, nil
+}
+
+/*
+SetWithOptions implements SET. It returns the previous string value, only
+looked up when opts.Get is set, and whether the value was written, which NX
+and XX may prevent.
+
+With GET the key must hold a string or be missing, otherwise nothing is written.
+*/
+func (s *Store) SetWithOptions(key string, value []byte, opts SetOptions) ([]byte, bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	old := shard.lookup(key)
+	var previous []byte
+	if opts.Get && old != nil {
+		if old.Type != TypeString {
+			return nil, false, ErrWrongType
+		}
+		previous = old.Value
+	}
+	if (opts.NX && old != nil) || (opts.XX && old == nil) {
+		return previous, false, nil
+	}
+
+	item := &Item{Key: key, Type: TypeString, Value: value, ExpiresAt: opts.ExpiresAt}
+	if opts.KeepTTL && old != nil {
+		item.ExpiresAt = old.ExpiresAt
+	}
+	shard.put(item)
+	shard.notify(NotifyString, "set", key)
+	if !opts.ExpiresAt.IsZero() {
+		shard.notify(NotifyGeneric, "expire", key)
+	}
+	return previous, true, nil
+}
+
+// MGet returns the value of each key, nil for keys that are missing or hold another type.
+func (s *Store) MGet(keys []string) [][]byte {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		shard := s.shardFor(key)
+		shard.mu.RLock()
+		if item, err := shard.readString(key); err == nil && item != nil {
+			values[i] = item.Value
+		}
+		shard.mu.RUnlock()
+	}
+	return values
+}
+
+// MSet stores every pair of values atomically, discarding the TTLs like SET does.
+func (s *Store) MSet(values map[string][]byte) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	unlock := s.lockKeys(keys...)
+	defer unlock()
+	for key, value := range values {
+		shard := s.shardFor(key)
+		shard.replaceString(key, value, nil)
+		shard.notify(NotifyString, "set", key)
+	}
+}
+
+/*
+MSetNX is MSet writing nothing at all when one of the keys already exists.
+
+Every shard involved is locked up front, in the order of lockKeys, so the
+check and the writes happen as a single step.
+*/
+func (s *Store) MSetNX(values map[string][]byte) bool {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	unlock := s.lockKeys(keys...)
+	defer unlock()
+	for _, key := range keys {
+		if s.shardFor(key).lookup(key) != nil {
+			return false
+		}
+	}
+	for key, value := range values {
+		shard := s.shardFor(key)
+		shard.replaceString(key, value, nil)
+		shard.notify(NotifyString, "set", key)
+	}
+	return true
+}
+
+// readString returns the string item stored under key, nil if there is none.
+// The caller must hold at least the shard read lock.
+func (sh *shard) readString(key string) (*Item, error) {
+	item := sh.peek(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != TypeString {
+		return nil, ErrWrongType
+	}
+	return item, nil
+}
+
+// writeString is readString for callers holding the shard write lock, it drops
+// the key when it has expired.
+func (sh *shard) writeString(key string) (*Item, error) {
+	item := sh.lookup(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != TypeString {
+		return nil, ErrWrongType
+	}
+	return item, nil
+}
+
+// replaceString stores value under key in a new item, see Item, carrying over the TTL of old.
+func (sh *shard) replaceString(key string, value []byte, old *Item) {
+	item := &Item{Key: key, Type: TypeString, Value: value}
+	if old != nil {
+		item.ExpiresAt = old.ExpiresAt
+	}
+	sh.put(item)
+}
+
+// IncrBy implements INCR, DECR, INCRBY and DECRBY. A missing key counts as 0.
+func (s *Store) IncrBy(key string, delta int64) (int64, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeString(key)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if item != nil {
+		current, err = strconv.ParseInt(string(item.Value), 10, 64)
+		if err != nil {
+			return 0, ErrNotInt
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	current += delta
+	shard.replaceString(key, strconv.AppendInt(nil, current, 10), item)
+	shard.notify(NotifyString, "incrby", key)
+	return current, nil
+}
+
+// IncrByFloat returns the new value formatted the same way it is stored.
+func (s *Store) IncrByFloat(key string, delta float64) ([]byte, error) {
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return nil, ErrNaNOrInf
+	}
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeString(key)
+	if err != nil {
+		return nil, err
+	}
+	var current float64
+	if item != nil {
+		current, err = strconv.ParseFloat(string(item.Value), 64)
+		if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+			return nil, ErrNotFloat
+		}
+	}
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return nil, ErrNaNOrInf
+	}
+	value := strconv.AppendFloat(nil, current, 'f', -1, 64)
+	shard.replaceString(key, value, item)
+	shard.notify(NotifyString, "incrbyfloat", key)
+	return value, nil
+}
+
+// Append returns the length of the string after the append.
+func (s *Store) Append(key string, value []byte) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeString(key)
+	if err != nil {
+		return 0, err
+	}
+	var current []byte
+	if item != nil {
+		current = item.Value
+	}
+	if len(current)+len(value) > MaxStringLength {
+		return 0, ErrStringTooLong
+	}
+	joined := make([]byte, 0, len(current)+len(value))
+	joined = append(append(joined, current...), value...)
+	shard.replaceString(key, joined, item)
+	shard.notify(NotifyString, "append", key)
+	return len(joined), nil
+}
+
+func (s *Store) StrLen(key string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	item, err := shard.readString(key)
+	if err != nil || item == nil {
+		return 0, err
+	}
+	return len(item.Value), nil
+}
+
+// GetRange returns the substring between start and end inclusive. Negative
+// offsets count from the end of the string.
+func (s *Store) GetRange(key string, start, end int) ([]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	item, err := shard.readString(key)
+	if err != nil || item == nil {
+		return []byte{}, err
+	}
+	start, end, ok := normalizeRange(start, end, len(item.Value))
+	if !ok {
+		return []byte{}, nil
+	}
+	return append([]byte{}, item.Value[start:end+1]...), nil
+}
+
+// SetRange overwrites the string from offset on, padding it with zero bytes
+// when it is shorter. It returns the length of the resulting string.
+func (s *Store) SetRange(key string, offset int, value []byte) (int, error) {
+	if offset < 0 {
+		return 0, ErrOffsetRange
+	}
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeString(key)
+	if err != nil {
+		return 0, err
+	}
+	var current []byte
+	if item != nil {
+		current = item.Value
+	}
+	if len(value) == 0 {
+		return len(current), nil
+	}
+	// Compared without adding, a huge offset would overflow the sum.
+	if offset > MaxStringLength-len(value) {
+		return 0, ErrStringTooLong
+	}
+	updated := make([]byte, max(len(current), offset+len(value)))
+	copy(updated, current)
+	copy(updated[offset:], value)
+	shard.replaceString(key, updated, item)
+	shard.notify(NotifyString, "setrange", key)
+	return len(updated), nil
+}
+
+// GetDel returns the string stored under key and deletes the key.
+func (s *Store) GetDel(key string) ([]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeString(key)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	shard.remove(key)
+	shard.notify(NotifyGeneric, "del", key)
+	return item.Value, nil
+}
+
+/*
+GetEx returns the string stored under key and updates its TTL: persist removes
+it, a non-zero expiresAt replaces it and an expiresAt in the past deletes the
+key. With neither the TTL is left alone.
+*/
+func (s *Store) GetEx(key string, expiresAt time.Time, persist bool) ([]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeString(key)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	switch {
+	case persist:
+		shard.put(&Item{Key: key, Type: TypeString, Value: item.Value})
+		if !item.ExpiresAt.IsZero() {
+			shard.notify(NotifyGeneric, "persist", key)
+		}
+	case !expiresAt.IsZero() && !expiresAt.After(time.Now()):
+		shard.remove(key)
+		shard.notify(NotifyGeneric, "del", key)
+	case !expiresAt.IsZero():
+		shard.put(&Item{Key: key, Type: TypeString, Value: item.Value, ExpiresAt: expiresAt})
+		shard.notify(NotifyGeneric, "expire", key)
+	}
+	return item.Value, nil
+}
+
+// GetSet stores value under key and returns the previous string. Like SET it
+// discards the TTL of the key.
+func (s *Store) GetSet(key string, value []byte) ([]byte, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	item, err := shard.writeString(key)
+	if err != nil {
+		return nil, err
+	}
+	shard.replaceString(key, value, nil)
+	shard.notify(NotifyString, "set", key)
+	if item == nil {
+		return nil, nil
+	}
+	return item.Value, nil
+}
@@ -0,0 +1,113 @@
+package store
+
+/*
+Transactions
+
+MULTI/EXEC runs a batch of commands without any other command interleaving.
+Every command runs with the store held shared, see LockShared, while an EXEC
+holds it exclusively for the whole batch.
+
+WATCH is optimistic locking. Each shard keeps a version counter for the keys
+some client watches, bumped by every write reaching the key: put and remove,
+and unlock for the items a writer modified in place. EXEC compares the versions
+recorded by WATCH with the current ones and discards the transaction when one
+moved. A write leaving the key unchanged, such as an HDEL of a missing field,
+may still bump the version, a change is never missed though.
+
+Only watched keys have a counter, so the versions cost nothing to the other keys.
+*/
+
+// ITransactionStore groups the operations backing MULTI/EXEC and WATCH.
+type ITransactionStore interface {
+	Watch(keys []string) []uint64
+	Unwatch(keys []string)
+	Modified(keys []string, versions []uint64) bool
+	LockShared() func()
+	LockExclusive() func()
+}
+
+type watchedKey struct {
+	version uint64
+	// watchers is the number of clients watching the key, the counter is
+	// dropped along with the last of them.
+	watchers int
+}
+
+// modified bumps the version of key if it is watched. The caller must hold the shard write lock.
+func (sh *shard) modified(key string) {
+	if w, ok := sh.watched[key]; ok {
+		w.version++
+	}
+}
+
+// expireWatched removes key if its TTL ran out, so that a watched key expiring
+// counts as a modification. The caller must hold the shard write lock.
+func (sh *shard) expireWatched(key string) {
+	if item, exists := sh.data[key]; exists && item.IsExpired() {
+		sh.expire(key)
+	}
+}
+
+// Watch starts tracking the versions of keys and returns their current values.
+// Every call must be matched by an Unwatch of the same keys.
+func (s *Store) Watch(keys []string) []uint64 {
+	versions := make([]uint64, len(keys))
+	for i, key := range keys {
+		shard := s.shardFor(key)
+		shard.mu.Lock()
+		shard.expireWatched(key)
+		w, ok := shard.watched[key]
+		if !ok {
+			w = &watchedKey{}
+			shard.watched[key] = w
+		}
+		w.watchers++
+		versions[i] = w.version
+		shard.unlock()
+	}
+	return versions
+}
+
+// Unwatch stops tracking keys for one of their watchers.
+func (s *Store) Unwatch(keys []string) {
+	for _, key := range keys {
+		shard := s.shardFor(key)
+		shard.mu.Lock()
+		if w, ok := shard.watched[key]; ok {
+			w.watchers--
+			if w.watchers <= 0 {
+				delete(shard.watched, key)
+			}
+		}
+		shard.unlock()
+	}
+}
+
+// Modified reports whether any of keys was written since Watch returned versions.
+func (s *Store) Modified(keys []string, versions []uint64) bool {
+	for i, key := range keys {
+		shard := s.shardFor(key)
+		shard.mu.Lock()
+		shard.expireWatched(key)
+		w, ok := shard.watched[key]
+		changed := !ok || w.version != versions[i]
+		shard.unlock()
+		if changed {
+			return true
+		}
+	}
+	return false
+}
+
+// LockShared is taken by every command and returns the function releasing it.
+func (s *Store) LockShared() func() {
+	s.txMu.RLock()
+	return s.txMu.RUnlock
+}
+
+// LockExclusive waits for the running commands to finish and keeps any other
+// from starting until the returned function is called.
+func (s *Store) LockExclusive() func() {
+	s.txMu.Lock()
+	return s.txMu.Unlock
+}
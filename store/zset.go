@@ -0,0 +1,761 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+)
+
+var (
+	ErrSyntax   = errors.New("ERR syntax error")
+	ErrScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+	ErrWeight   = errors.New("ERR weight value is not a float")
+)
+
+// ZMember is a member of a sorted set together with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZAddOptions carries the NX/XX/GT/LT/CH flags of ZADD.
+type ZAddOptions struct {
+	NX, XX, GT, LT, CH bool
+}
+
+// ZAggregate tells ZUNIONSTORE and ZINTERSTORE how to combine the scores of a member.
+type ZAggregate int
+
+const (
+	ZAggregateSum ZAggregate = iota
+	ZAggregateMin
+	ZAggregateMax
+)
+
+// IZSetStore groups the operations on sorted sets. Range queries locate their
+// first element through the skiplist, so they cost O(log N + M).
+type IZSetStore interface {
+	ZAdd(key string, members []ZMember, opts ZAddOptions) (int, error)
+	ZAddIncr(key, member string, delta float64, opts ZAddOptions) (float64, bool, error)
+	ZRem(key string, members []string) (int, error)
+	ZScore(key, member string) (float64, bool, error)
+	ZCard(key string) (int, error)
+	ZRank(key, member string, reverse bool) (int, bool, error)
+	ZRangeByRank(key string, start, stop int, reverse bool) ([]ZMember, error)
+	ZRangeByScore(key string, r ScoreRange, reverse bool, offset, count int) ([]ZMember, error)
+	ZRangeByLex(key string, r LexRange, reverse bool, offset, count int) ([]ZMember, error)
+	ZCount(key string, r ScoreRange) (int, error)
+	ZPopMin(key string, count int) ([]ZMember, error)
+	ZPopMax(key string, count int) ([]ZMember, error)
+	BZPopMin(keys []string, timeout time.Duration) (string, *ZMember, error)
+	BZPopMax(keys []string, timeout time.Duration) (string, *ZMember, error)
+	ZUnionStore(destination string, keys []string, weights []float64, aggregate ZAggregate) (int, error)
+	ZInterStore(destination string, keys []string, weights []float64, aggregate ZAggregate) (int, error)
+}
+
+// ZSet is a sorted set: a dictionary from member to score for O(1) lookups plus
+// a skiplist keeping the members ordered for ranks and ranges.
+type ZSet struct {
+	dict map[string]float64
+	zsl  *skiplist
+	// members orders the members by scan position for ZSCAN.
+	members scanIndex
+}
+
+func NewZSet() *ZSet {
+	return &ZSet{dict: make(map[string]float64), zsl: newSkiplist()}
+}
+
+func (z *ZSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Set inserts member or moves it to its new score.
+func (z *ZSet) Set(member string, score float64) {
+	if old, ok := z.dict[member]; ok {
+		if old == score {
+			return
+		}
+		z.zsl.delete(old, member)
+	} else {
+		z.members.add(member)
+	}
+	z.dict[member] = score
+	z.zsl.insert(score, member)
+}
+
+func (z *ZSet) Remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	delete(z.dict, member)
+	z.zsl.delete(score, member)
+	z.members.remove(member)
+	return true
+}
+
+// Rank returns the 0-based position of member, counting from the highest score when reverse is set.
+func (z *ZSet) Rank(member string, reverse bool) (int, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.zsl.length - rank, true
+	}
+	return rank - 1, true
+}
+
+// Each calls fn for every member in ascending order.
+func (z *ZSet) Each(fn func(member string, score float64)) {
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		fn(x.member, x.score)
+	}
+}
+
+// collect walks from node in the given direction, taking members while inRange
+// holds, at most count of them when count is not negative.
+func collect(node *skiplistNode, reverse bool, count int, inRange func(*skiplistNode) bool) []ZMember {
+	result := []ZMember{}
+	for node != nil && count != 0 && inRange(node) {
+		result = append(result, ZMember{Member: node.member, Score: node.score})
+		count--
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return result
+}
+
+func (z *ZSet) rangeByRank(start, stop int, reverse bool) []ZMember {
+	start, stop, ok := normalizeRange(start, stop, z.Len())
+	if !ok {
+		return []ZMember{}
+	}
+	rank := start + 1
+	if reverse {
+		rank = z.Len() - start
+	}
+	return collect(z.zsl.byRank(rank), reverse, stop-start+1, func(*skiplistNode) bool { return true })
+}
+
+// skip moves offset positions away from node in the walking direction, using
+// ranks so that large offsets do not cost a linear walk.
+func (z *ZSet) skip(node *skiplistNode, reverse bool, offset int) *skiplistNode {
+	if node == nil || offset == 0 {
+		return node
+	}
+	rank := z.zsl.rank(node.score, node.member)
+	if reverse {
+		rank -= offset
+	} else {
+		rank += offset
+	}
+	if rank < 1 || rank > z.zsl.length {
+		return nil
+	}
+	return z.zsl.byRank(rank)
+}
+
+func (z *ZSet) rangeByScore(r ScoreRange, reverse bool, offset, count int) []ZMember {
+	if reverse {
+		start := z.skip(z.zsl.lastInScoreRange(r), true, offset)
+		return collect(start, true, count, func(n *skiplistNode) bool { return r.aboveMin(n.score) })
+	}
+	start := z.skip(z.zsl.firstInScoreRange(r), false, offset)
+	return collect(start, false, count, func(n *skiplistNode) bool { return r.belowMax(n.score) })
+}
+
+func (z *ZSet) rangeByLex(r LexRange, reverse bool, offset, count int) []ZMember {
+	if reverse {
+		start := z.skip(z.zsl.lastInLexRange(r), true, offset)
+		return collect(start, true, count, func(n *skiplistNode) bool { return r.aboveMin(n.member) })
+	}
+	start := z.skip(z.zsl.firstInLexRange(r), false, offset)
+	return collect(start, false, count, func(n *skiplistNode) bool { return r.belowMax(n.member) })
+}
+
+func (z *ZSet) count(r ScoreRange) int {
+	first := z.zsl.firstInScoreRange(r)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.lastInScoreRange(r)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+/*
+FormatScore formats a score the way Redis replies with it: the shortest
+representation that parses back to the same value, without an exponent for
+ordinary magnitudes, and inf/-inf for infinities.
+*/
+func FormatScore(score float64) string {
+	return protocol.FormatDouble(score)
+}
+
+// ParseScore parses a score, accepting inf, +inf and -inf but not NaN.
+func ParseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, ErrNotFloat
+	}
+	return score, nil
+}
+
+/*
+ParseZAddArgs parses the arguments of ZADD that follow the key:
+[NX|XX] [GT|LT] [CH] [INCR] score member [score member ...].
+
+It is shared by the command handler and the AOF/replication replay.
+*/
+func ParseZAddArgs(args []string) ([]ZMember, ZAddOptions, bool, error) {
+	var opts ZAddOptions
+	incr := false
+	i := 0
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			opts.CH = true
+		case "INCR":
+			incr = true
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, opts, false, ErrSyntax
+	}
+	if opts.NX && opts.XX {
+		return nil, opts, false, errors.New("ERR XX and NX options at the same time are not compatible")
+	}
+	if (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)) {
+		return nil, opts, false, errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) != 2 {
+		return nil, opts, false, errors.New("ERR INCR option supports a single increment-element pair")
+	}
+
+	members := make([]ZMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := ParseScore(pairs[j])
+		if err != nil {
+			return nil, opts, false, err
+		}
+		members = append(members, ZMember{Member: pairs[j+1], Score: score})
+	}
+	return members, opts, incr, nil
+}
+
+/*
+ParseZStoreArgs parses the arguments of ZUNIONSTORE and ZINTERSTORE that follow
+the destination key: numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX].
+
+It is shared by the command handlers and the AOF/replication replay.
+*/
+func ParseZStoreArgs(args []string) ([]string, []float64, ZAggregate, error) {
+	if len(args) < 2 {
+		return nil, nil, 0, ErrSyntax
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, 0, ErrNotInt
+	}
+	if numKeys <= 0 {
+		return nil, nil, 0, errors.New("ERR at least 1 input key is needed for this command")
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, 0, ErrSyntax
+	}
+	keys := args[1 : 1+numKeys]
+	var weights []float64
+	aggregate := ZAggregateSum
+
+	for i := 1 + numKeys; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WEIGHTS":
+			if i+numKeys >= len(args) {
+				return nil, nil, 0, ErrSyntax
+			}
+			weights = make([]float64, numKeys)
+			for j := range weights {
+				w, err := ParseScore(args[i+1+j])
+				if err != nil {
+					return nil, nil, 0, ErrWeight
+				}
+				weights[j] = w
+			}
+			i += numKeys
+		case "AGGREGATE":
+			if i+1 >= len(args) {
+				return nil, nil, 0, ErrSyntax
+			}
+			i++
+			switch strings.ToUpper(args[i]) {
+			case "SUM":
+				aggregate = ZAggregateSum
+			case "MIN":
+				aggregate = ZAggregateMin
+			case "MAX":
+				aggregate = ZAggregateMax
+			default:
+				return nil, nil, 0, ErrSyntax
+			}
+		default:
+			return nil, nil, 0, ErrSyntax
+		}
+	}
+	return keys, weights, aggregate, nil
+}
+
+// readZSet returns the sorted set stored under key or nil when the key does not exist.
+// The caller must hold at least the shard read lock.
+func (sh *shard) readZSet(key string) (*ZSet, error) {
+	item := sh.peek(key)
+	if item == nil {
+		return nil, nil
+	}
+	if item.Type != TypeZSet {
+		return nil, ErrWrongType
+	}
+	return item.ZSet, nil
+}
+
+// writeZSet returns the sorted set stored under key, creating it when create is set.
+// The caller must hold the shard write lock.
+func (sh *shard) writeZSet(key string, create bool) (*ZSet, error) {
+	item := sh.lookup(key)
+	if item == nil {
+		if !create {
+			return nil, nil
+		}
+		item = &Item{Key: key, Type: TypeZSet, ZSet: NewZSet()}
+		sh.put(item)
+	}
+	if item.Type != TypeZSet {
+		return nil, ErrWrongType
+	}
+	return item.ZSet, nil
+}
+
+type zaddResult int
+
+const (
+	zaddSkipped zaddResult = iota
+	zaddAdded
+	zaddUpdated
+	zaddUnchanged
+)
+
+// zadd applies a single ZADD element, with incr set the score is added to the current one.
+func zadd(z *ZSet, member string, score float64, incr bool, opts ZAddOptions) (float64, zaddResult, error) {
+	current, exists := z.Score(member)
+	if !exists {
+		if opts.XX {
+			return 0, zaddSkipped, nil
+		}
+		z.Set(member, score)
+		return score, zaddAdded, nil
+	}
+	if opts.NX {
+		return current, zaddSkipped, nil
+	}
+	if incr {
+		score += current
+		if math.IsNaN(score) {
+			return 0, zaddSkipped, ErrScoreNaN
+		}
+	}
+	if (opts.GT && score <= current) || (opts.LT && score >= current) {
+		return current, zaddSkipped, nil
+	}
+	if score == current {
+		return score, zaddUnchanged, nil
+	}
+	z.Set(member, score)
+	return score, zaddUpdated, nil
+}
+
+// ZAdd returns the number of added members, or of added and updated members when CH is set.
+func (s *Store) ZAdd(key string, members []ZMember, opts ZAddOptions) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	z, err := shard.writeZSet(key, !opts.XX)
+	if err != nil || z == nil {
+		shard.unlock()
+		return 0, err
+	}
+	changed, modified := 0, false
+	for _, m := range members {
+		_, result, _ := zadd(z, m.Member, m.Score, false, opts)
+		if result == zaddAdded || (opts.CH && result == zaddUpdated) {
+			changed++
+		}
+		modified = modified || result == zaddAdded || result == zaddUpdated
+	}
+	if modified {
+		shard.notify(NotifyZSet, "zadd", key)
+	}
+	n := z.Len()
+	if n == 0 {
+		shard.remove(key)
+	}
+	shard.unlock()
+
+	if n > 0 {
+		s.signalKey(key)
+	}
+	return changed, nil
+}
+
+// ZAddIncr implements ZADD INCR and ZINCRBY. The boolean is false when the
+// update was skipped because of NX/XX/GT/LT.
+func (s *Store) ZAddIncr(key, member string, delta float64, opts ZAddOptions) (float64, bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	z, err := shard.writeZSet(key, !opts.XX)
+	if err != nil || z == nil {
+		shard.unlock()
+		return 0, false, err
+	}
+	score, result, err := zadd(z, member, delta, true, opts)
+	if result == zaddAdded || result == zaddUpdated {
+		shard.notify(NotifyZSet, "zincr", key)
+	}
+	if z.Len() == 0 {
+		shard.remove(key)
+	}
+	shard.unlock()
+
+	if result == zaddAdded || result == zaddUpdated {
+		s.signalKey(key)
+	}
+	return score, result != zaddSkipped, err
+}
+
+func (s *Store) ZRem(key string, members []string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	z, err := shard.writeZSet(key, false)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if z.Remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		shard.notify(NotifyZSet, "zrem", key)
+	}
+	if z.Len() == 0 {
+		shard.drop(key)
+	}
+	return removed, nil
+}
+
+func (s *Store) ZScore(key, member string) (float64, bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	z, err := shard.readZSet(key)
+	if err != nil || z == nil {
+		return 0, false, err
+	}
+	score, ok := z.Score(member)
+	return score, ok, nil
+}
+
+func (s *Store) ZCard(key string) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	z, err := shard.readZSet(key)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	return z.Len(), nil
+}
+
+func (s *Store) ZRank(key, member string, reverse bool) (int, bool, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	z, err := shard.readZSet(key)
+	if err != nil || z == nil {
+		return 0, false, err
+	}
+	rank, ok := z.Rank(member, reverse)
+	return rank, ok, nil
+}
+
+func (s *Store) ZRangeByRank(key string, start, stop int, reverse bool) ([]ZMember, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	z, err := shard.readZSet(key)
+	if err != nil || z == nil {
+		return []ZMember{}, err
+	}
+	return z.rangeByRank(start, stop, reverse), nil
+}
+
+// ZRangeByScore returns the members within r. With reverse set the walk starts
+// from the highest score. A negative count means no limit.
+func (s *Store) ZRangeByScore(key string, r ScoreRange, reverse bool, offset, count int) ([]ZMember, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	z, err := shard.readZSet(key)
+	if err != nil || z == nil {
+		return []ZMember{}, err
+	}
+	return z.rangeByScore(r, reverse, offset, count), nil
+}
+
+// ZRangeByLex is ZRangeByScore for sorted sets whose members all share the same score.
+func (s *Store) ZRangeByLex(key string, r LexRange, reverse bool, offset, count int) ([]ZMember, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	z, err := shard.readZSet(key)
+	if err != nil || z == nil {
+		return []ZMember{}, err
+	}
+	return z.rangeByLex(r, reverse, offset, count), nil
+}
+
+func (s *Store) ZCount(key string, r ScoreRange) (int, error) {
+	shard := s.shardFor(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	z, err := shard.readZSet(key)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	return z.count(r), nil
+}
+
+// zpop removes up to count members with the lowest, or highest when max is set, scores.
+// A nil result means the key does not exist.
+func (s *Store) zpop(key string, count int, max bool) ([]ZMember, error) {
+	// rangeByRank would read a count below 1 as counting from the end.
+	if count <= 0 {
+		return nil, nil
+	}
+	shard := s.shardFor(key)
+	shard.mu.Lock()
+	defer shard.unlock()
+	z, err := shard.writeZSet(key, false)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	popped := z.rangeByRank(0, count-1, max)
+	for _, m := range popped {
+		z.Remove(m.Member)
+	}
+	if len(popped) > 0 {
+		event := "zpopmin"
+		if max {
+			event = "zpopmax"
+		}
+		shard.notify(NotifyZSet, event, key)
+	}
+	if z.Len() == 0 {
+		shard.drop(key)
+	}
+	return popped, nil
+}
+
+func (s *Store) ZPopMin(key string, count int) ([]ZMember, error) {
+	return s.zpop(key, count, false)
+}
+
+func (s *Store) ZPopMax(key string, count int) ([]ZMember, error) {
+	return s.zpop(key, count, true)
+}
+
+func (s *Store) blockingZPop(keys []string, max bool, timeout time.Duration) (string, *ZMember, error) {
+	var (
+		key    string
+		member *ZMember
+		err    error
+	)
+	s.block(keys, timeout, func(k string) bool {
+		popped, popErr := s.zpop(k, 1, max)
+		if popErr != nil {
+			err = popErr
+			return true
+		}
+		if len(popped) == 0 {
+			return false
+		}
+		key, member = k, &popped[0]
+		return true
+	})
+	return key, member, err
+}
+
+// BZPopMin pops the lowest scored member of the first non-empty sorted set among
+// keys, waiting up to timeout for one to be populated. A nil member means the timeout elapsed.
+func (s *Store) BZPopMin(keys []string, timeout time.Duration) (string, *ZMember, error) {
+	return s.blockingZPop(keys, false, timeout)
+}
+
+// BZPopMax is BZPopMin for the highest scored member.
+func (s *Store) BZPopMax(keys []string, timeout time.Duration) (string, *ZMember, error) {
+	return s.blockingZPop(keys, true, timeout)
+}
+
+/*
+zstore computes the union or intersection of the inputs at keys into destination.
+
+Inputs may be sorted sets or plain sets, whose members count with a score of 1.
+Scores are multiplied by their weight before being aggregated.
+*/
+func (s *Store) zstore(destination string, keys []string, weights []float64, aggregate ZAggregate, inter bool) (int, error) {
+	unlock := s.lockKeys(append([]string{destination}, keys...)...)
+	result, err := s.zcombine(keys, weights, aggregate, inter)
+	n := 0
+	if err == nil {
+		shard := s.shardFor(destination)
+		n = result.Len()
+		if n == 0 {
+			if shard.lookup(destination) != nil {
+				shard.drop(destination)
+			}
+		} else {
+			shard.put(&Item{Key: destination, Type: TypeZSet, ZSet: result})
+			event := "zunionstore"
+			if inter {
+				event = "zinterstore"
+			}
+			shard.notify(NotifyZSet, event, destination)
+		}
+	}
+	unlock()
+
+	if n > 0 {
+		s.signalKey(destination)
+	}
+	return n, err
+}
+
+// zcombine does the work of zstore. The caller must hold the locks of every shard involved.
+func (s *Store) zcombine(keys []string, weights []float64, aggregate ZAggregate, inter bool) (*ZSet, error) {
+	inputs := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		item := s.shardFor(key).lookup(key)
+		if item == nil {
+			continue
+		}
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+		scores := make(map[string]float64)
+		switch item.Type {
+		case TypeZSet:
+			for member, score := range item.ZSet.dict {
+				scores[member] = weightScore(score, weight)
+			}
+		case TypeSet:
+			for member := range item.Set {
+				scores[member] = weight
+			}
+		default:
+			return nil, ErrWrongType
+		}
+		inputs[i] = scores
+	}
+
+	combined := make(map[string]float64)
+	if inter {
+		for member, score := range inputs[0] {
+			matched := true
+			for _, other := range inputs[1:] {
+				otherScore, ok := other[member]
+				if !ok {
+					matched = false
+					break
+				}
+				score = aggregateScores(score, otherScore, aggregate)
+			}
+			if matched {
+				combined[member] = score
+			}
+		}
+	} else {
+		for _, input := range inputs {
+			for member, score := range input {
+				if current, ok := combined[member]; ok {
+					score = aggregateScores(current, score, aggregate)
+				}
+				combined[member] = score
+			}
+		}
+	}
+
+	z := NewZSet()
+	for member, score := range combined {
+		z.Set(member, score)
+	}
+	return z, nil
+}
+
+func weightScore(score, weight float64) float64 {
+	result := score * weight
+	// inf * 0 is NaN, Redis treats it as 0.
+	if math.IsNaN(result) {
+		return 0
+	}
+	return result
+}
+
+func aggregateScores(a, b float64, aggregate ZAggregate) float64 {
+	switch aggregate {
+	case ZAggregateMin:
+		return math.Min(a, b)
+	case ZAggregateMax:
+		return math.Max(a, b)
+	}
+	sum := a + b
+	// inf + -inf is NaN, Redis treats it as 0.
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+func (s *Store) ZUnionStore(destination string, keys []string, weights []float64, aggregate ZAggregate) (int, error) {
+	return s.zstore(destination, keys, weights, aggregate, false)
+}
+
+func (s *Store) ZInterStore(destination string, keys []string, weights []float64, aggregate ZAggregate) (int, error) {
+	return s.zstore(destination, keys, weights, aggregate, true)
+}
@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PetarGeorgiev-hash/flashdb/acl"
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/config"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+// aclSession returns a function sending a command through a client of users
+// the way the server does, permissions checked first, and returning the reply.
+func aclSession(t *testing.T, users *acl.ACL) func(parts ...string) string {
+	t.Helper()
+	s := newTestStore(t)
+	conn, server := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	srv := cmd.NewServer(config.NewManager(config.Default(), ""), users)
+	client := cmd.NewClient(server, srv)
+	t.Cleanup(func() { client.Close() })
+	return func(parts ...string) string {
+		var out strings.Builder
+		w := protocol.NewWriter(&out, client)
+		if err := client.Authorize(byteArgs(parts...), false); err != nil {
+			util.WriteErr(w, err)
+		} else if handler, ok := srv.Handler(strings.ToUpper(parts[0])); ok {
+			cmd.Run(handler, w, s, parts, nil, nil)
+		} else if !client.Handle(w, parts, nil, false) {
+			util.WriteError(w, "unknown command")
+		}
+		w.Flush()
+		return out.String()
+	}
+}
+
+func TestACLCheck(t *testing.T) {
+	users := cmd.NewACL()
+	if err := users.SetUser("alice", "on", ">secret", "~cache:*", "&news.*", "+@read", "-strlen", "+client|id"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		command  string
+		keys     []string
+		channels []string
+		patterns bool
+		reason   string
+	}{
+		{command: "get", keys: []string{"cache:1"}},
+		{command: "strlen", keys: []string{"cache:1"}, reason: "command"},
+		{command: "set", keys: []string{"cache:1"}, reason: "command"},
+		{command: "get", keys: []string{"users:1"}, reason: "key"},
+		{command: "client|id"},
+		{command: "client|kill", reason: "command"},
+		{command: "get", channels: []string{"news.sport"}},
+		{command: "get", channels: []string{"sport"}, reason: "channel"},
+		{command: "get", channels: []string{"news.*"}, patterns: true},
+		{command: "get", channels: []string{"news.s*"}, patterns: true, reason: "channel"},
+	}
+	for _, tt := range tests {
+		err := users.Check("alice", tt.command, byteArgs(tt.keys...), byteArgs(tt.channels...), tt.patterns)
+		var denied *acl.DeniedError
+		switch {
+		case tt.reason == "" && err != nil:
+			t.Errorf("%s %v %v: expected to be allowed, got %v", tt.command, tt.keys, tt.channels, err)
+		case tt.reason != "" && (!errors.As(err, &denied) || denied.Reason != tt.reason):
+			t.Errorf("%s %v %v: expected a %s denial, got %v", tt.command, tt.keys, tt.channels, tt.reason, err)
+		}
+	}
+
+	if err := users.SetUser("alice", "+nosuchcommand"); err == nil || !strings.Contains(err.Error(), "'+nosuchcommand'") {
+		t.Errorf("expected an unknown command to be refused, got %v", err)
+	}
+	if user, _ := users.User("alice"); len(user.Passwords) != 1 || user.Passwords[0] != acl.HashPassword("secret") {
+		t.Errorf("expected a failed SETUSER to leave the user untouched, got %+v", user)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	users := cmd.NewACL()
+	if err := users.SetUser(acl.DefaultUser, "resetpass", ">adminpass"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetUser("reader", "on", ">readpass", "allkeys", "+@read", "+acl|whoami", "+hello"); err != nil {
+		t.Fatal(err)
+	}
+	run := aclSession(t, users)
+
+	steps := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"GET", "k"}, "-NOAUTH Authentication required.\r\n"},
+		{[]string{"HELLO", "3"}, "-NOAUTH HELLO must be called with the client already authenticated"},
+		{[]string{"AUTH", "reader", "wrong"}, "-WRONGPASS"},
+		{[]string{"AUTH", "reader", "readpass"}, "+OK\r\n"},
+		{[]string{"ACL", "WHOAMI"}, "$6\r\nreader\r\n"},
+		{[]string{"GET", "k"}, "$-1\r\n"},
+		{[]string{"SET", "k", "v"}, "-NOPERM User reader has no permissions to run the 'set' command\r\n"},
+		{[]string{"NOSUCHCOMMAND"}, "-ERR unknown command"},
+		{[]string{"HELLO", "2", "AUTH", "default", "adminpass"}, "*14\r\n"},
+		{[]string{"SET", "k", "v"}, "+OK\r\n"},
+	}
+	for _, step := range steps {
+		if got := run(step.parts...); !strings.HasPrefix(got, step.want) {
+			t.Errorf("%v: expected a reply starting with %q, got %q", step.parts, step.want, got)
+		}
+	}
+
+	log := users.Log(-1)
+	if len(log) != 2 || log[0].Reason != "command" || log[0].Object != "set" || log[1].Reason != "auth" || log[1].Username != "reader" {
+		t.Errorf("expected ACL LOG to hold the denied SET then the failed AUTH, got %+v", log)
+	}
+	if got := run("ACL", "LOG", "RESET"); got != "+OK\r\n" || len(users.Log(-1)) != 0 {
+		t.Errorf("expected ACL LOG RESET to empty the log, got %q", got)
+	}
+}
+
+func TestACLCommand(t *testing.T) {
+	run := aclSession(t, cmd.NewACL())
+	steps := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"ACL", "SETUSER", "bob", "on", "#" + acl.HashPassword("pw"), "~k*", "+get"}, "+OK\r\n"},
+		{[]string{"ACL", "USERS"}, "*2\r\n$3\r\nbob\r\n$7\r\ndefault\r\n"},
+		{[]string{"ACL", "LIST"}, "*2\r\n$"},
+		{[]string{"ACL", "SETUSER", "bob", "badrule"}, "-ERR Error in ACL SETUSER modifier 'badrule': Syntax error\r\n"},
+		{[]string{"ACL", "CAT", "nosuchcategory"}, "-ERR Unknown category 'nosuchcategory'\r\n"},
+		{[]string{"ACL", "DELUSER", "bob", "nobody"}, ":1\r\n"},
+		{[]string{"ACL", "DELUSER", "default"}, "-ERR The 'default' user cannot be removed\r\n"},
+		{[]string{"ACL", "SAVE"}, "-ERR This FlashDB instance is not configured to use an ACL file."},
+	}
+	for _, step := range steps {
+		if got := run(step.parts...); !strings.HasPrefix(got, step.want) {
+			t.Errorf("%v: expected a reply starting with %q, got %q", step.parts, step.want, got)
+		}
+	}
+	if got := run("ACL", "CAT", "string"); !strings.Contains(got, "\r\nget\r\n") {
+		t.Errorf("expected GET in the string category, got %q", got)
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	users := cmd.NewACL()
+	users.SetFile(path)
+	if err := users.SetUser("carol", "on", ">pw", "~app:*", "&*", "+@all", "-@dangerous"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), ">pw") || !strings.Contains(string(data), "#"+acl.HashPassword("pw")) {
+		t.Errorf("expected the file to hold the password hash only, got %q", data)
+	}
+
+	loaded := cmd.NewACL()
+	loaded.SetFile(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(loaded.List(), "\n"), strings.Join(users.List(), "\n"); got != want {
+		t.Errorf("expected the loaded users to match the saved ones:\n%s\ngot\n%s", want, got)
+	}
+	if err := loaded.Authenticate("carol", "pw"); err != nil {
+		t.Errorf("expected the loaded password to authenticate, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("user dave on\nuser dave +bogus\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("expected the error to name the second line, got %v", err)
+	}
+	if _, ok := loaded.User("carol"); !ok {
+		t.Error("expected a failed load to keep the users")
+	}
+}
+
+func TestEveryCommandHasCategories(t *testing.T) {
+	users := cmd.NewACL()
+	commands := []string{cmd.SaveCommand, cmd.InfoCommand, cmd.ConfigCommand}
+	for command := range cmd.CommandHandlers {
+		commands = append(commands, command)
+	}
+	srv := newTestServer()
+	for _, command := range commands {
+		if _, ok := srv.Handler(command); !ok {
+			t.Errorf("%s: no handler", command)
+		}
+		if err := users.SetUser("probe", "+"+command); err != nil {
+			t.Errorf("%s: %v", command, err)
+		}
+	}
+}
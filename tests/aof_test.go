@@ -2,12 +2,13 @@ package tests
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
 	"github.com/PetarGeorgiev-hash/flashdb/store"
-	"github.com/PetarGeorgiev-hash/flashdb/util"
 )
 
 func TestAppendAndResetAOF(t *testing.T) {
@@ -42,8 +43,8 @@ func TestAppendAndResetAOF(t *testing.T) {
 
 func TestAOFReplay(t *testing.T) {
 	s := store.NewStore()
-	a, _ := aof.NewAOF(util.AppendFile)
-	defer os.Remove(util.AppendFile)
+	a, _ := aof.NewAOF("appendonly.aof")
+	defer os.Remove("appendonly.aof")
 
 	// Write commands
 	s.Set("foo", []byte("bar"), 0)
@@ -51,7 +52,7 @@ func TestAOFReplay(t *testing.T) {
 
 	// Simulate restart
 	s2 := store.NewStore()
-	err := a.LoadAOF(util.AppendFile, s2)
+	err := a.LoadAOF("appendonly.aof", s2)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,3 +62,25 @@ func TestAOFReplay(t *testing.T) {
 		t.Fatalf("expected bar, got %s", item.Value)
 	}
 }
+
+func TestTouchIsPropagated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "touch.aof")
+	a, err := aof.NewAOF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	s := newTestStore(t)
+	s.Set("k", []byte("v"), 0)
+
+	if reply := runTransaction(t, s, &cmd.Transaction{}, a, []string{"TOUCH", "k", "missing"}); reply != ":1\r\n" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "TOUCH") {
+		t.Errorf("expected TOUCH in the AOF, got %q", data)
+	}
+	if err := a.LoadAOF(path, newTestStore(t)); err != nil {
+		t.Errorf("expected TOUCH to replay, got %v", err)
+	}
+}
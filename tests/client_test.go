@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/config"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+)
+
+// newTestServer returns a server with the default settings and users.
+func newTestServer() *cmd.Server {
+	return cmd.NewServer(config.NewManager(config.Default(), ""), cmd.NewACL())
+}
+
+// newPipeClient registers a client of srv whose replies are discarded.
+func newPipeClient(t *testing.T, srv *cmd.Server) *cmd.Client {
+	t.Helper()
+	conn, server := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	client := cmd.NewClient(server, srv)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientNameAndInfo(t *testing.T) {
+	s := newTestStore(t)
+	replies := runClient(t, s,
+		[]string{"CLIENT", "GETNAME"},
+		[]string{"CLIENT", "SETNAME", "worker"},
+		[]string{"CLIENT", "SETNAME", "two words"},
+		[]string{"CLIENT", "GETNAME"},
+		[]string{"CLIENT", "NO-EVICT", "on"},
+		[]string{"CLIENT", "INFO"},
+	)
+	for _, want := range []string{
+		"$-1\r\n+OK\r\n-ERR Client names cannot contain spaces",
+		"$6\r\nworker\r\n+OK\r\n",
+		" name=worker ", " flags=e ", " multi=-1 ", " resp=2\n",
+	} {
+		if !strings.Contains(replies, want) {
+			t.Errorf("expected the replies to contain %q, got %q", want, replies)
+		}
+	}
+}
+
+func TestClientListAndKill(t *testing.T) {
+	srv := newTestServer()
+	self, other := newPipeClient(t, srv), newPipeClient(t, srv)
+	if n := srv.ConnectedClients(); n != 2 {
+		t.Fatalf("expected 2 connected clients, got %d", n)
+	}
+	other.Received(byteArgs("GET", "k"), 0, 0, -1)
+
+	run := func(parts ...string) string {
+		var out strings.Builder
+		w := protocol.NewWriter(&out, self)
+		self.Handle(w, parts, nil, false)
+		w.Flush()
+		return out.String()
+	}
+	selfID := idOf(t, run("CLIENT", "ID"))
+	// Clients are numbered in the order they connect.
+	otherID := strconv.FormatInt(selfID+1, 10)
+	list := run("CLIENT", "LIST", "ID", otherID)
+	if !strings.Contains(list, "id="+otherID+" ") || !strings.Contains(list, " cmd=get ") || strings.Count(list, "id=") != 1 {
+		t.Errorf("expected CLIENT LIST ID to report the other client only, got %q", list)
+	}
+
+	if got := run("CLIENT", "KILL", "ID", strconv.FormatInt(selfID, 10)); got != ":0\r\n" {
+		t.Errorf("expected SKIPME to spare the client itself, got %q", got)
+	}
+	if got := run("CLIENT", "KILL", "ID", otherID); got != ":1\r\n" {
+		t.Errorf("expected one client to be killed, got %q", got)
+	}
+	if !other.Killed() || self.Killed() {
+		t.Error("expected only the other client to be killed")
+	}
+	if n := srv.ConnectedClients(); n != 1 {
+		t.Errorf("expected the killed client to be unregistered, got %d clients", n)
+	}
+	if got := run("CLIENT", "KILL", "ID", otherID); got != ":0\r\n" {
+		t.Errorf("expected no client left to kill, got %q", got)
+	}
+	if got := run("CLIENT", "KILL", "ID", strconv.FormatInt(selfID, 10), "SKIPME", "no"); got != ":1\r\n" || !self.Killed() {
+		t.Errorf("expected the client to kill itself and still get its reply, got %q", got)
+	}
+}
+
+// idOf returns the integer at the start of a reply.
+func idOf(t *testing.T, reply string) int64 {
+	t.Helper()
+	end := strings.Index(reply, "\r\n")
+	if !strings.HasPrefix(reply, ":") || end < 0 {
+		t.Fatalf("expected an integer reply, got %q", reply)
+	}
+	id, _ := strconv.ParseInt(reply[1:end], 10, 64)
+	return id
+}
+
+func TestClientPause(t *testing.T) {
+	srv := newTestServer()
+	admin, client := newPipeClient(t, srv), newPipeClient(t, srv)
+	var discard strings.Builder
+	w := protocol.NewWriter(&discard, admin)
+
+	admin.Handle(w, []string{"CLIENT", "PAUSE", "5000", "WRITE"}, nil, false)
+	defer admin.Handle(w, []string{"CLIENT", "UNPAUSE"}, nil, false)
+
+	start := time.Now()
+	client.WaitUnpaused(w, []string{"GET", "k"}, false)
+	client.WaitUnpaused(w, []string{"SET", "k", "v"}, true)
+	if time.Since(start) > time.Second {
+		t.Fatal("expected reads and commands queued in MULTI to run during CLIENT PAUSE WRITE")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.WaitUnpaused(protocol.NewWriter(&strings.Builder{}, client), []string{"SET", "k", "v"}, false)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected SET to wait for the pause to end")
+	case <-time.After(50 * time.Millisecond):
+	}
+	admin.Handle(w, []string{"CLIENT", "UNPAUSE"}, nil, false)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected CLIENT UNPAUSE to release the waiting SET")
+	}
+
+	admin.Handle(w, []string{"CLIENT", "PAUSE", "20"}, nil, false)
+	start = time.Now()
+	client.WaitUnpaused(w, []string{"GET", "k"}, false)
+	if waited := time.Since(start); waited < 10*time.Millisecond || waited > time.Second {
+		t.Errorf("expected GET to wait for CLIENT PAUSE ALL to time out, waited %v", waited)
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/config"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "flashdb.conf")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigLoad(t *testing.T) {
+	path := writeConfigFile(t, `# flashdb test configuration
+shards 8
+dbfilename "from file.fdb"
+  maxmemory 1mb
+maxmemory-policy allkeys-lru
+`)
+	environ := []string{"FLASHDB_MAXMEMORY_POLICY=volatile-lru", "FLASHDB_SHARDS=4", "PATH=/bin"}
+	c, file, err := config.Load([]string{path, "--shards", "2"}, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file != path {
+		t.Errorf("expected the file %s, got %s", path, file)
+	}
+	if c.Shards != 2 {
+		t.Errorf("expected the command line to win, got %d shards", c.Shards)
+	}
+	if c.MaxMemoryPolicy != store.VolatileLRU {
+		t.Errorf("expected the environment to override the file, got %s", c.MaxMemoryPolicy)
+	}
+	if c.MaxMemory != 1<<20 || c.DBFilename != "from file.fdb" {
+		t.Errorf("expected the file settings, got maxmemory %d and dbfilename %q", c.MaxMemory, c.DBFilename)
+	}
+	if c.AppendFilename != config.Default().AppendFilename {
+		t.Errorf("expected the default appendfilename, got %q", c.AppendFilename)
+	}
+}
+
+func TestConfigLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		args    []string
+		environ []string
+		want    string
+	}{
+		{name: "unknown setting", file: "shards 4\nsnapshots 2\n", want: "flashdb.conf:2: unknown setting 'snapshots'"},
+		{name: "invalid value", file: "shards many\n", want: "flashdb.conf:1: invalid shards"},
+		{name: "unbalanced quotes", file: "dbfilename \"open\n", want: "unbalanced quotes"},
+		{name: "missing value", args: []string{"--shards"}, want: "expected --name value"},
+		{name: "invalid environment", environ: []string{"FLASHDB_MAXMEMORY=lots"}, want: "invalid FLASHDB_MAXMEMORY"},
+		{name: "replica without master", args: []string{"--role", "replica"}, want: "role replica needs master-addr"},
+		{name: "tls without key", args: []string{"--tls-cert-file", "server.crt"}, want: "must be set together"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				args = append([]string{writeConfigFile(t, tc.file)}, args...)
+			}
+			_, _, err := config.Load(args, tc.environ)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestConfigGetAndSet(t *testing.T) {
+	settings := config.NewManager(config.Default(), "")
+	got := settings.Get("MAXMEMORY*", "shards")
+	want := []config.Setting{{Name: "maxmemory", Value: "0"}, {Name: "maxmemory-policy", Value: "noeviction"}, {Name: "shards", Value: "16"}}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	}
+
+	var changes []int64
+	settings.OnChange("maxmemory", func(c *config.Config) { changes = append(changes, c.MaxMemory) })
+	if err := settings.Set("maxmemory", "2mb", "maxmemory-policy", "allkeys-lfu"); err != nil {
+		t.Fatal(err)
+	}
+	if c := settings.Current(); c.MaxMemory != 2<<20 || c.MaxMemoryPolicy != store.AllKeysLFU {
+		t.Errorf("expected the settings to change, got %d %s", c.MaxMemory, c.MaxMemoryPolicy)
+	}
+	if len(changes) != 1 || changes[0] != 2<<20 {
+		t.Errorf("expected one change notified, got %v", changes)
+	}
+
+	errors := []struct {
+		pairs []string
+		want  string
+	}{
+		{[]string{"shards", "4"}, "can't set immutable config"},
+		{[]string{"snapshots", "2"}, "Unknown option"},
+		{[]string{"active-expire-cpu", "200"}, "argument must be between 1 and 100"},
+		{[]string{"proto-max-bulk-len", "0"}, "argument must be a memory value of at least 1"},
+		{[]string{"maxmemory", "1mb", "maxmemory", "3mb"}, "duplicate parameter"},
+		// A refused setting leaves the valid ones before it unchanged.
+		{[]string{"maxmemory", "1mb", "maxmemory-policy", "sometimes"}, "argument(s) must be one of the following"},
+	}
+	for _, tc := range errors {
+		if err := settings.Set(tc.pairs...); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: expected an error containing %q, got %v", tc.pairs, tc.want, err)
+		}
+	}
+	if c := settings.Current(); c.MaxMemory != 2<<20 || len(changes) != 1 {
+		t.Errorf("expected refused changes to be discarded, got maxmemory %d", c.MaxMemory)
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	path := writeConfigFile(t, "# kept\nmaxmemory 1mb\n\nmaxmemory 2mb\n")
+	c, file, err := config.Load([]string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := config.NewManager(c, file)
+	if err := settings.Set("maxmemory", "3mb", "requirepass", `two "words"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# kept\nmaxmemory 3145728\n\n# Generated by CONFIG REWRITE\nrequirepass \"two \\\"words\\\"\"\n"
+	if string(data) != want {
+		t.Errorf("expected %q, got %q", want, data)
+	}
+
+	reloaded, _, err := config.Load([]string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.MaxMemory != 3<<20 || reloaded.RequirePass != `two "words"` {
+		t.Errorf("expected the rewritten settings to load back, got %d %q", reloaded.MaxMemory, reloaded.RequirePass)
+	}
+
+	if err := config.NewManager(config.Default(), "").Rewrite(); err == nil {
+		t.Error("expected REWRITE to fail without a config file")
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	s := newTestStore(t)
+	srv := newTestServer()
+	handler, ok := srv.Handler("CONFIG")
+	if !ok {
+		t.Fatal("expected a CONFIG handler")
+	}
+	run := func(parts ...string) string {
+		var out bytes.Buffer
+		w := protocol.NewWriter(&out, protocol.Fixed(protocol.RESP2))
+		handler(w, s, parts, nil, nil)
+		w.Flush()
+		return out.String()
+	}
+
+	tests := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"CONFIG", "SET", "notify-keyspace-events", "Ex"}, "+OK\r\n"},
+		{[]string{"CONFIG", "GET", "notify-*"}, "*2\r\n$22\r\nnotify-keyspace-events\r\n$2\r\nxE\r\n"},
+		{[]string{"CONFIG", "SET", "notify-keyspace-events", "Em"}, "-ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - Invalid event class character. Use 'Ag$lshzxetKEn'.\r\n"},
+		{[]string{"CONFIG", "GET", "missing"}, "*0\r\n"},
+		{[]string{"CONFIG", "SET", "maxmemory"}, "-ERR wrong number of arguments for 'config|set' command\r\n"},
+		{[]string{"CONFIG", "SET", "addr", ":7000"}, "-ERR CONFIG SET failed (possibly related to argument 'addr') - can't set immutable config\r\n"},
+		{[]string{"CONFIG", "REWRITE"}, "-ERR The server is running without a config file\r\n"},
+		{[]string{"CONFIG", "RESETSTAT"}, "+OK\r\n"},
+		{[]string{"CONFIG", "NOPE"}, "-ERR unknown subcommand 'NOPE'. Try CONFIG HELP.\r\n"},
+	}
+	s.Set("short", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	s.Get("short")
+	if s.Stats().ExpiredKeys != 1 {
+		t.Fatalf("expected one expired key, got %d", s.Stats().ExpiredKeys)
+	}
+	for _, tc := range tests {
+		if got := run(tc.parts...); got != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.parts, tc.want, got)
+		}
+	}
+	if s.Stats().ExpiredKeys != 0 {
+		t.Errorf("expected RESETSTAT to reset the expired keys, got %d", s.Stats().ExpiredKeys)
+	}
+}
@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+// waitFor polls cond until it holds or the timeout elapses.
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestActiveExpiration(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 5000; i++ {
+		s.Set(fmt.Sprintf("short:%d", i), []byte("v"), 50*time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("long:%d", i), []byte("v"), time.Hour)
+		s.Set(fmt.Sprintf("plain:%d", i), []byte("v"), 0)
+	}
+	if stats := s.Stats(); stats.Keys != 5200 || stats.Expires != 5100 {
+		t.Fatalf("unexpected stats before expiry: %+v", stats)
+	}
+
+	// Nobody reads the short keys, the background cycle has to remove them.
+	if !waitFor(2*time.Second, func() bool { return s.DBSize() == 200 }) {
+		t.Fatalf("expected the expired keys to be reclaimed, %d keys left", s.DBSize())
+	}
+	stats := s.Stats()
+	if stats.Expires != 100 || stats.ExpiredKeys != 5000 {
+		t.Errorf("unexpected stats after expiry: %+v", stats)
+	}
+}
+
+func TestExpiryIndexFollowsWrites(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("persisted", []byte("v"), 50*time.Millisecond)
+	s.Persist("persisted")
+	s.Set("extended", []byte("v"), 50*time.Millisecond)
+	s.Expire("extended", time.Now().Add(time.Hour), store.ExpireOptions{})
+	s.Set("overwritten", []byte("v"), 50*time.Millisecond)
+	s.Set("overwritten", []byte("v"), 0)
+	s.Set("renamed", []byte("v"), 50*time.Millisecond)
+	s.Rename("renamed", "moved", false)
+	s.Set("deleted", []byte("v"), time.Hour)
+	s.Del([]string{"deleted"})
+
+	if stats := s.Stats(); stats.Expires != 2 {
+		t.Errorf("expected extended and moved to have a TTL, got %d keys", stats.Expires)
+	}
+	if !waitFor(2*time.Second, func() bool { return s.DBSize() == 3 }) {
+		t.Fatalf("expected only moved to expire, %d keys left", s.DBSize())
+	}
+	if s.Exists([]string{"persisted", "extended", "overwritten"}) != 3 {
+		t.Error("a key whose TTL was removed or extended expired")
+	}
+}
+
+func TestExpiredKeyIsCountedOnce(t *testing.T) {
+	s := newTestStore(t)
+	// Whether Get or the active cycle removes the key, it is counted once.
+	s.Set("k", []byte("v"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if item, _ := s.Get("k"); item != nil {
+		t.Fatal("expected the key to be expired")
+	}
+	if stats := s.Stats(); stats.ExpiredKeys != 1 || stats.Keys != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
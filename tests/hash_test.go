@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func TestHashSetAndGet(t *testing.T) {
+	s := newTestStore(t)
+
+	added, err := s.HSet("user:1", map[string][]byte{"name": []byte("ana"), "age": []byte("30")})
+	if err != nil {
+		t.Fatalf("HSet failed: %v", err)
+	}
+	if added != 2 {
+		t.Errorf("expected 2 new fields, got %d", added)
+	}
+
+	added, _ = s.HSet("user:1", map[string][]byte{"age": []byte("31")})
+	if added != 0 {
+		t.Errorf("expected 0 new fields on overwrite, got %d", added)
+	}
+
+	value, _ := s.HGet("user:1", "age")
+	if string(value) != "31" {
+		t.Errorf("expected '31', got '%s'", value)
+	}
+
+	values, _ := s.HMGet("user:1", []string{"name", "missing"})
+	if string(values[0]) != "ana" || values[1] != nil {
+		t.Errorf("unexpected HMGet result %q", values)
+	}
+
+	if n, _ := s.HLen("user:1"); n != 2 {
+		t.Errorf("expected length 2, got %d", n)
+	}
+}
+
+func TestHashDeleteRemovesEmptyKey(t *testing.T) {
+	s := newTestStore(t)
+	s.HSet("h", map[string][]byte{"f": []byte("v")})
+
+	removed, _ := s.HDel("h", []string{"f", "missing"})
+	if removed != 1 {
+		t.Errorf("expected 1 removed field, got %d", removed)
+	}
+	if item, _ := s.Get("h"); item != nil {
+		t.Errorf("expected key to be removed with its last field, got %v", item)
+	}
+}
+
+func TestHashIncr(t *testing.T) {
+	s := newTestStore(t)
+
+	if v, err := s.HIncrBy("h", "n", 5); err != nil || v != 5 {
+		t.Fatalf("expected 5, got %d (%v)", v, err)
+	}
+	if v, _ := s.HIncrBy("h", "n", -7); v != -2 {
+		t.Errorf("expected -2, got %d", v)
+	}
+
+	s.HSet("h", map[string][]byte{"text": []byte("abc")})
+	if _, err := s.HIncrBy("h", "text", 1); err != store.ErrHashNotInt {
+		t.Errorf("expected ErrHashNotInt, got %v", err)
+	}
+
+	f, err := s.HIncrByFloat("h", "f", 10.5)
+	if err != nil || string(f) != "10.5" {
+		t.Fatalf("expected 10.5, got %s (%v)", f, err)
+	}
+	f, _ = s.HIncrByFloat("h", "f", 0.1)
+	if string(f) != "10.6" {
+		t.Errorf("expected 10.6, got %s", f)
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("str", []byte("v"), 0)
+
+	if _, err := s.HSet("str", map[string][]byte{"f": []byte("v")}); err != store.ErrWrongType {
+		t.Errorf("expected ErrWrongType from HSet, got %v", err)
+	}
+	if _, err := s.HGet("str", "f"); err != store.ErrWrongType {
+		t.Errorf("expected ErrWrongType from HGet, got %v", err)
+	}
+}
+
+func TestHashSnapshotAndReplay(t *testing.T) {
+	s := newTestStore(t)
+	s.HSet("h", map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+	s.Set("str", []byte("v"), 0)
+
+	var buf bytes.Buffer
+	if err := s.Dump(&buf); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+
+	s2 := newTestStore(t)
+	if err := s2.Restore(&buf); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	all, _ := s2.HGetAll("h")
+	if len(all) != 2 || string(all["b"]) != "2" {
+		t.Errorf("unexpected restored hash %q", all)
+	}
+	if item, _ := s2.Get("str"); item == nil || string(item.Value) != "v" {
+		t.Errorf("expected restored string value, got %v", item)
+	}
+
+	if err := store.ApplyCommand(s2, []string{"HDEL", "h", "a"}); err != nil {
+		t.Fatalf("ApplyCommand failed: %v", err)
+	}
+	if err := store.ApplyCommand(s2, []string{"HINCRBY", "h", "b", "3"}); err != nil {
+		t.Fatalf("ApplyCommand failed: %v", err)
+	}
+	if v, _ := s2.HGet("h", "b"); string(v) != "5" {
+		t.Errorf("expected 5 after replay, got %s", v)
+	}
+	if ok, _ := s2.HExists("h", "a"); ok {
+		t.Error("expected field a to be deleted by replay")
+	}
+}
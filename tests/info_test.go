@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/config"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+// runServerCommand sends a command served by srv and returns the reply.
+func runServerCommand(t *testing.T, srv *cmd.Server, s store.IStore, aofWriter aof.IAOF, replManager replication.IManager, parts ...string) string {
+	t.Helper()
+	handler, ok := srv.Handler(strings.ToUpper(parts[0]))
+	if !ok {
+		t.Fatalf("expected a handler for %s", parts[0])
+	}
+	var out bytes.Buffer
+	w := protocol.NewWriter(&out, protocol.Fixed(protocol.RESP2))
+	handler(w, s, parts, aofWriter, replManager)
+	w.Flush()
+	return out.String()
+}
+
+func TestInfoSections(t *testing.T) {
+	s := newTestStore(t)
+	srv := newTestServer()
+	info := func(parts ...string) string {
+		return runServerCommand(t, srv, s, nil, nil, append([]string{"INFO"}, parts...)...)
+	}
+
+	defaults := info()
+	for _, section := range []string{"# Server", "# Clients", "# Memory", "# Persistence", "# Stats", "# Replication", "# Keyspace"} {
+		if !strings.Contains(defaults, section+"\r\n") {
+			t.Errorf("expected INFO to report %s, got %q", section, defaults)
+		}
+	}
+	if strings.Contains(defaults, "# Commandstats") {
+		t.Error("expected INFO to leave commandstats out by default")
+	}
+	if got := info("all"); !strings.Contains(got, "# Commandstats\r\n") {
+		t.Errorf("expected INFO all to report commandstats, got %q", got)
+	}
+	got := info("STATS", "keyspace")
+	if !strings.Contains(got, "# Stats\r\n") || !strings.Contains(got, "# Keyspace\r\n") || strings.Contains(got, "# Server") {
+		t.Errorf("expected only the requested sections, got %q", got)
+	}
+	if got := info("nope"); got != "$0\r\n\r\n" {
+		t.Errorf("expected an unknown section to be empty, got %q", got)
+	}
+	if !strings.Contains(defaults, "role:master\r\nconnected_slaves:0\r\nmaster_repl_offset:0\r\n") {
+		t.Errorf("expected the replication section of a master, got %q", defaults)
+	}
+}
+
+func TestInfoStats(t *testing.T) {
+	s := newTestStore(t)
+	srv := newTestServer()
+	info := func(section string) string {
+		return runServerCommand(t, srv, s, nil, nil, "INFO", section)
+	}
+
+	s.Set("k", []byte("v"), 0)
+	s.Get("k")
+	s.Get("missing")
+	srv.Record([]string{"get", "k"}, 1500*time.Microsecond)
+	srv.Record([]string{"GET", "missing"}, 500*time.Microsecond)
+	srv.Record([]string{"SET", "k", "v"}, time.Millisecond)
+	srv.Record([]string{"NOSUCHCOMMAND"}, time.Millisecond)
+
+	stats := info("stats")
+	for _, want := range []string{"total_commands_processed:3\r\n", "keyspace_hits:1\r\n", "keyspace_misses:1\r\n", "expired_keys:0\r\n"} {
+		if !strings.Contains(stats, want) {
+			t.Errorf("expected the stats to contain %q, got %q", want, stats)
+		}
+	}
+	commandstats := info("commandstats")
+	want := "# Commandstats\r\ncmdstat_get:calls=2,usec=2000,usec_per_call=1000.00\r\ncmdstat_set:calls=1,usec=1000,usec_per_call=1000.00\r\n"
+	if !strings.Contains(commandstats, want) {
+		t.Errorf("expected %q, got %q", want, commandstats)
+	}
+	if keyspace := info("keyspace"); !strings.Contains(keyspace, "db0:keys=1,expires=0\r\n") {
+		t.Errorf("expected the key counts, got %q", keyspace)
+	}
+	if persistence := info("persistence"); !strings.Contains(persistence, "rdb_changes_since_last_save:1\r\n") {
+		t.Errorf("expected the write to count as a change, got %q", persistence)
+	}
+
+	if got := runServerCommand(t, srv, s, nil, nil, "CONFIG", "RESETSTAT"); got != "+OK\r\n" {
+		t.Fatalf("expected RESETSTAT to succeed, got %q", got)
+	}
+	stats = info("stats")
+	for _, want := range []string{"total_commands_processed:0\r\n", "keyspace_hits:0\r\n", "keyspace_misses:0\r\n"} {
+		if !strings.Contains(stats, want) {
+			t.Errorf("expected RESETSTAT to zero the stats, got %q", stats)
+		}
+	}
+	if got := info("commandstats"); got != "$16\r\n# Commandstats\r\n\r\n" {
+		t.Errorf("expected RESETSTAT to zero the command stats, got %q", got)
+	}
+}
+
+func TestInfoPersistence(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.DBFilename = filepath.Join(dir, "dump.fdb")
+	cfg.AppendFilename = filepath.Join(dir, "appendonly.aof")
+	srv := cmd.NewServer(config.NewManager(cfg, ""), cmd.NewACL())
+	s := newTestStore(t)
+	aofWriter, err := aof.NewAOF(cfg.AppendFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { aofWriter.Close() })
+
+	aofWriter.AppendCommand("SET", "k", "v")
+	srv.Record([]string{"SET", "k", "v"}, 0)
+	before := runServerCommand(t, srv, s, aofWriter, nil, "INFO", "persistence")
+	for _, want := range []string{"rdb_changes_since_last_save:1\r\n", "rdb_saves:0\r\n", "aof_enabled:1\r\n", "aof_current_size:27\r\n"} {
+		if !strings.Contains(before, want) {
+			t.Errorf("expected %q, got %q", want, before)
+		}
+	}
+	if got := runServerCommand(t, srv, s, aofWriter, nil, "SAVE"); got != "+OK\r\n" {
+		t.Fatalf("expected SAVE to succeed, got %q", got)
+	}
+	after := runServerCommand(t, srv, s, aofWriter, nil, "INFO", "persistence")
+	for _, want := range []string{"rdb_changes_since_last_save:0\r\n", "rdb_saves:1\r\n", "rdb_last_bgsave_status:ok\r\n", "aof_current_size:0\r\n"} {
+		if !strings.Contains(after, want) {
+			t.Errorf("expected %q, got %q", want, after)
+		}
+	}
+}
+
+func TestInfoReplication(t *testing.T) {
+	s := newTestStore(t)
+	srv := newTestServer()
+	manager := replication.NewManager(s)
+	manager.Broadcast([]string{"SET", "k", "v"})
+	got := runServerCommand(t, srv, s, nil, manager, "INFO", "replication")
+	if !strings.Contains(got, "role:master\r\nconnected_slaves:0\r\nmaster_repl_offset:27\r\n") {
+		t.Errorf("expected the offset to count the propagated bytes, got %q", got)
+	}
+
+	cfg := config.Default()
+	cfg.Role, cfg.MasterAddr = "replica", "10.0.0.1:6379"
+	replica := cmd.NewServer(config.NewManager(cfg, ""), cmd.NewACL())
+	replica.Replica = &replication.ReplicaStatus{}
+	got = runServerCommand(t, replica, s, nil, nil, "INFO", "replication")
+	want := "role:slave\r\nmaster_host:10.0.0.1\r\nmaster_port:6379\r\nmaster_link_status:down\r\nmaster_last_io_seconds_ago:-1\r\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func TestRenameKeepsTypeAndTTL(t *testing.T) {
+	s := newTestStore(t)
+	// Enough keys that some renames cross shards.
+	for i := 0; i < 32; i++ {
+		src, dst := fmt.Sprintf("src:%d", i), fmt.Sprintf("dst:%d", i)
+		s.ZAdd(src, []store.ZMember{{Member: "m", Score: float64(i)}}, store.ZAddOptions{})
+		s.Set(src+":ttl", []byte("v"), time.Hour)
+		for _, key := range []string{src, src + ":ttl"} {
+			if ok, err := s.Rename(key, "dst"+key[3:], false); !ok || err != nil {
+				t.Fatalf("Rename %s failed: %v", key, err)
+			}
+		}
+		if s.Exists([]string{src, src + ":ttl"}) != 0 {
+			t.Errorf("%s still exists after the rename", src)
+		}
+		if typ := s.Type(dst); typ != "zset" {
+			t.Fatalf("expected a zset under %s, got %s", dst, typ)
+		}
+		if score, _, _ := s.ZScore(dst, "m"); score != float64(i) {
+			t.Errorf("expected score %d, got %v", i, score)
+		}
+		item, _ := s.Get(dst + ":ttl")
+		if item == nil || item.Key != dst+":ttl" || item.ExpiresAt.IsZero() {
+			t.Fatalf("unexpected item after rename: %+v", item)
+		}
+	}
+
+	if _, err := s.Rename("missing", "x", false); err != store.ErrNoSuchKey {
+		t.Errorf("expected ErrNoSuchKey, got %v", err)
+	}
+	s.Set("a", []byte("1"), 0)
+	s.Set("b", []byte("2"), 0)
+	if ok, _ := s.Rename("a", "b", true); ok {
+		t.Error("RENAMENX must not overwrite an existing key")
+	}
+	if ok, _ := s.Rename("a", "a", false); !ok {
+		t.Error("renaming a key onto itself should succeed")
+	}
+}
+
+func TestRenameWakesBlockedClient(t *testing.T) {
+	s := newTestStore(t)
+	done := make(chan []byte)
+	go func() {
+		_, value, _ := s.BLPop([]string{"queue"}, time.Second)
+		done <- value
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.RPush("staging", [][]byte{[]byte("job")})
+	s.Rename("staging", "queue", false)
+	if value := <-done; string(value) != "job" {
+		t.Errorf("expected the blocked pop to get job, got %q", value)
+	}
+}
+
+func TestCopyIsDeep(t *testing.T) {
+	s := newTestStore(t)
+	s.RPush("list", [][]byte{[]byte("a"), []byte("b")})
+	s.HSet("hash", map[string][]byte{"f": []byte("v")})
+	s.XAdd("stream", "1-1", []string{"f", "v"}, store.XAddOptions{})
+	s.XGroupCreate("stream", "g", "0", false)
+	s.XReadGroup("g", "alice", []string{"stream"}, []string{">"}, 0, -1, false)
+
+	for _, key := range []string{"list", "hash", "stream"} {
+		if ok, err := s.Copy(key, key+":copy", false); !ok || err != nil {
+			t.Fatalf("Copy %s failed: %v", key, err)
+		}
+	}
+	s.RPush("list", [][]byte{[]byte("c")})
+	s.HSet("hash", map[string][]byte{"f": []byte("changed")})
+	s.XAck("stream", "g", []store.StreamID{{Ms: 1, Seq: 1}})
+
+	if values, _ := s.LRange("list:copy", 0, -1); len(values) != 2 {
+		t.Errorf("the copied list changed with its source: %q", values)
+	}
+	if value, _ := s.HGet("hash:copy", "f"); string(value) != "v" {
+		t.Errorf("the copied hash changed with its source: %q", value)
+	}
+	if summary, _ := s.XPending("stream:copy", "g"); summary == nil || summary.Count != 1 {
+		t.Errorf("expected the copied group to keep its pending entry, got %+v", summary)
+	}
+
+	if ok, _ := s.Copy("list", "hash", false); ok {
+		t.Error("COPY must not overwrite without REPLACE")
+	}
+	if ok, _ := s.Copy("list", "hash", true); !ok || s.Type("hash") != "list" {
+		t.Error("COPY REPLACE should overwrite the destination")
+	}
+	if _, err := s.Copy("list", "list", true); err != store.ErrSameObject {
+		t.Errorf("expected ErrSameObject, got %v", err)
+	}
+}
+
+func TestKeyspaceAdmin(t *testing.T) {
+	s := newTestStore(t)
+	if _, ok := s.RandomKey(); ok {
+		t.Error("expected no random key in an empty store")
+	}
+	s.Set("str", []byte("v"), 0)
+	s.SAdd("set", []string{"m"})
+	s.Set("ttl", []byte("v"), time.Hour)
+
+	if n := s.DBSize(); n != 3 {
+		t.Errorf("expected 3 keys, got %d", n)
+	}
+	if typ := s.Type("set"); typ != "set" {
+		t.Errorf("expected set, got %s", typ)
+	}
+	if typ := s.Type("missing"); typ != "none" {
+		t.Errorf("expected none, got %s", typ)
+	}
+	if key, ok := s.RandomKey(); !ok || s.Exists([]string{key}) != 1 {
+		t.Errorf("unexpected random key %q", key)
+	}
+	if n := s.Touch([]string{"str", "set", "missing"}); n != 2 {
+		t.Errorf("expected 2 touched keys, got %d", n)
+	}
+
+	if !s.Persist("ttl") {
+		t.Error("expected PERSIST to remove the TTL")
+	}
+	if item, _ := s.Get("ttl"); item == nil || !item.ExpiresAt.IsZero() {
+		t.Error("the key should no longer expire")
+	}
+	if s.Persist("ttl") || s.Persist("str") || s.Persist("missing") {
+		t.Error("PERSIST on a key without TTL should report 0")
+	}
+
+	s.Flush()
+	if n := s.DBSize(); n != 0 {
+		t.Errorf("expected an empty store after FLUSHALL, got %d keys", n)
+	}
+}
+
+func TestKeyspaceReplay(t *testing.T) {
+	s := newTestStore(t)
+	for _, parts := range [][]string{
+		{"SET", "a", "1", "PXAT", fmt.Sprint(time.Now().Add(time.Hour).UnixMilli())},
+		{"RENAME", "a", "b"},
+		{"COPY", "b", "c"},
+		{"PERSIST", "c"},
+		{"SET", "d", "4"},
+		{"RENAMENX", "d", "c"},
+	} {
+		if err := store.ApplyCommand(s, parts); err != nil {
+			t.Fatalf("ApplyCommand %v failed: %v", parts, err)
+		}
+	}
+	b, _ := s.Get("b")
+	c, _ := s.Get("c")
+	if b == nil || b.ExpiresAt.IsZero() || c == nil || !c.ExpiresAt.IsZero() || string(c.Value) != "1" {
+		t.Fatalf("unexpected state after replay: b=%+v c=%+v", b, c)
+	}
+	if err := store.ApplyCommand(s, []string{"FLUSHALL"}); err != nil || s.DBSize() != 0 {
+		t.Errorf("FLUSHALL replay failed: %v", err)
+	}
+}
+
+func TestExpireConditions(t *testing.T) {
+	s := newTestStore(t)
+	s.HSet("h", map[string][]byte{"f": []byte("v")})
+	soon, later := time.Now().Add(time.Minute), time.Now().Add(time.Hour)
+
+	if s.Expire("h", soon, store.ExpireOptions{XX: true}) {
+		t.Error("XX must not set a TTL on a key without one")
+	}
+	if s.Expire("h", soon, store.ExpireOptions{GT: true}) {
+		t.Error("GT must fail on a key without TTL, which never expires")
+	}
+	if !s.Expire("h", later, store.ExpireOptions{LT: true}) {
+		t.Error("LT should succeed on a key without TTL")
+	}
+	if s.Expire("h", soon, store.ExpireOptions{NX: true}) {
+		t.Error("NX must not replace an existing TTL")
+	}
+	if !s.Expire("h", soon, store.ExpireOptions{LT: true}) {
+		t.Error("LT should lower the TTL")
+	}
+	if s.Expire("h", later, store.ExpireOptions{LT: true}) {
+		t.Error("LT must not raise the TTL")
+	}
+	if at, ok := s.ExpireTime("h"); !ok || !at.Equal(soon) {
+		t.Errorf("expected the key to expire at %v, got %v", soon, at)
+	}
+	if s.Expire("missing", later, store.ExpireOptions{}) {
+		t.Error("EXPIRE on a missing key should report 0")
+	}
+
+	// A time in the past deletes the key.
+	if !s.Expire("h", time.Now().Add(-time.Second), store.ExpireOptions{}) {
+		t.Error("expected EXPIRE in the past to succeed")
+	}
+	if _, ok := s.ExpireTime("h"); ok {
+		t.Error("the key should have been deleted")
+	}
+
+	for _, args := range [][]string{{"NX", "XX"}, {"GT", "LT"}, {"NX", "GT"}, {"FOO"}} {
+		if _, err := store.ParseExpireOptions(args); err == nil {
+			t.Errorf("expected %v to be rejected", args)
+		}
+	}
+	if opts, err := store.ParseExpireOptions([]string{"xx", "gt"}); err != nil || !opts.XX || !opts.GT {
+		t.Errorf("expected XX GT to be accepted, got %+v, %v", opts, err)
+	}
+}
+
+func TestExpireReplayIsAbsolute(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("k", []byte("v"), 0)
+	at := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	if err := store.ApplyCommand(s, []string{"PEXPIREAT", "k", fmt.Sprint(at.UnixMilli())}); err != nil {
+		t.Fatalf("ApplyCommand failed: %v", err)
+	}
+	if got, _ := s.ExpireTime("k"); !got.Equal(at) {
+		t.Errorf("expected the key to expire at %v, got %v", at, got)
+	}
+	past := fmt.Sprint(time.Now().Add(-time.Hour).UnixMilli())
+	if err := store.ApplyCommand(s, []string{"PEXPIREAT", "k", past}); err != nil || s.Exists([]string{"k"}) != 0 {
+		t.Errorf("expected a past PEXPIREAT to delete the key, err %v", err)
+	}
+}
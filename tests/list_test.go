@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func values(vs ...string) [][]byte {
+	out := make([][]byte, len(vs))
+	for i, v := range vs {
+		out[i] = []byte(v)
+	}
+	return out
+}
+
+func assertList(t *testing.T, s store.IStore, key string, want ...string) {
+	t.Helper()
+	got, err := s.LRange(key, 0, -1)
+	if err != nil {
+		t.Fatalf("LRange failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestListPushPop(t *testing.T) {
+	s := newTestStore(t)
+
+	s.RPush("l", values("b", "c"))
+	n, _ := s.LPush("l", values("a"))
+	if n != 3 {
+		t.Errorf("expected length 3, got %d", n)
+	}
+	assertList(t, s, "l", "a", "b", "c")
+
+	popped, _ := s.RPop("l", 2)
+	if len(popped) != 2 || string(popped[0]) != "c" || string(popped[1]) != "b" {
+		t.Errorf("unexpected RPop result %q", popped)
+	}
+	s.LPop("l", 1)
+	if item, _ := s.Get("l"); item != nil {
+		t.Error("expected empty list to be removed")
+	}
+
+	if n, _ := s.LPushX("l", values("x")); n != 0 {
+		t.Errorf("expected LPushX on missing key to be a no-op, got %d", n)
+	}
+}
+
+func TestListRangeTrimRemInsert(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 20; i++ {
+		s.RPush("l", values(string(rune('a'+i))))
+	}
+
+	got, _ := s.LRange("l", -3, -1)
+	if len(got) != 3 || string(got[0]) != "r" {
+		t.Errorf("unexpected negative range %q", got)
+	}
+
+	s.LTrim("l", 1, 3)
+	assertList(t, s, "l", "b", "c", "d")
+
+	s.RPush("l", values("b"))
+	removed, _ := s.LRem("l", -1, []byte("b"))
+	if removed != 1 {
+		t.Errorf("expected 1 removed element, got %d", removed)
+	}
+	assertList(t, s, "l", "b", "c", "d")
+
+	s.LInsert("l", true, []byte("c"), []byte("x"))
+	s.LInsert("l", false, []byte("d"), []byte("y"))
+	assertList(t, s, "l", "b", "x", "c", "d", "y")
+
+	if n, _ := s.LInsert("l", true, []byte("missing"), []byte("z")); n != -1 {
+		t.Errorf("expected -1 for missing pivot, got %d", n)
+	}
+
+	if err := s.LSet("l", 10, []byte("z")); err != store.ErrIndexOutRange {
+		t.Errorf("expected ErrIndexOutRange, got %v", err)
+	}
+
+	pos, _ := s.LPos("l", []byte("c"), 1, 0, 0)
+	if len(pos) != 1 || pos[0] != 2 {
+		t.Errorf("unexpected LPos result %v", pos)
+	}
+}
+
+func TestListMove(t *testing.T) {
+	s := newTestStore(t)
+	s.RPush("src", values("a", "b"))
+
+	value, err := s.LMove("src", "dst", false, true)
+	if err != nil || string(value) != "b" {
+		t.Fatalf("expected b, got %s (%v)", value, err)
+	}
+	assertList(t, s, "src", "a")
+	assertList(t, s, "dst", "b")
+
+	s.Set("str", []byte("v"), 0)
+	if _, err := s.LMove("src", "str", true, true); err != store.ErrWrongType {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+	assertList(t, s, "src", "a")
+}
+
+func TestBlockingPopTimeout(t *testing.T) {
+	s := newTestStore(t)
+
+	start := time.Now()
+	key, value, err := s.BLPop([]string{"empty"}, 100*time.Millisecond)
+	if err != nil || key != "" || value != nil {
+		t.Fatalf("expected timeout, got %q %q %v", key, value, err)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("BLPop returned before the timeout")
+	}
+}
+
+func TestBlockingPopServesClientsInOrder(t *testing.T) {
+	s := newTestStore(t)
+
+	results := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		name := string(rune('A' + i))
+		go func() {
+			_, value, _ := s.BLPop([]string{"other", "queue"}, 5*time.Second)
+			results <- name + "=" + string(value)
+		}()
+		// Give the client time to park before starting the next one.
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s.RPush("queue", values("1", "2"))
+	s.RPush("queue", values("3"))
+
+	got := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case result := <-results:
+			got[result] = true
+		case <-time.After(2 * time.Second):
+			t.Fatal("blocked client was not served")
+		}
+	}
+	for _, want := range []string{"A=1", "B=2", "C=3"} {
+		if !got[want] {
+			t.Errorf("expected %s among results %v", want, got)
+		}
+	}
+	if n, _ := s.LLen("queue"); n != 0 {
+		t.Errorf("expected queue to be drained, got %d elements", n)
+	}
+}
+
+func TestBlockingMove(t *testing.T) {
+	s := newTestStore(t)
+
+	done := make(chan []byte)
+	go func() {
+		value, _ := s.BLMove("jobs", "processing", true, false, 5*time.Second)
+		done <- value
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.LPush("jobs", values("job-1"))
+
+	select {
+	case value := <-done:
+		if string(value) != "job-1" {
+			t.Errorf("expected job-1, got %s", value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("BLMove was not woken up")
+	}
+	assertList(t, s, "processing", "job-1")
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func TestMemoryAccounting(t *testing.T) {
+	s := newTestStore(t)
+	if used := s.UsedMemory(); used != 0 {
+		t.Fatalf("expected an empty store to use no memory, got %d", used)
+	}
+	s.Set("str", make([]byte, 1000), 0)
+	afterString := s.UsedMemory()
+	if afterString < 1000 {
+		t.Errorf("expected at least the 1000 byte value to be accounted, got %d", afterString)
+	}
+
+	fields := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		fields[fmt.Sprintf("field:%d", i)] = make([]byte, 100)
+	}
+	s.HSet("hash", fields)
+	afterHash := s.UsedMemory()
+	if afterHash-afterString < 100*1000 {
+		t.Errorf("expected the hash to account for at least 100KB, got %d", afterHash-afterString)
+	}
+
+	// Collections modified in place are accounted too.
+	var half []string
+	for i := 0; i < 500; i++ {
+		half = append(half, fmt.Sprintf("field:%d", i))
+	}
+	s.HDel("hash", half)
+	if used := s.UsedMemory(); used >= afterHash-40*1000 {
+		t.Errorf("expected HDEL to lower the memory used, %d >= %d", used, afterHash)
+	}
+	s.Rename("hash", "renamed", false)
+	s.Del([]string{"str", "renamed"})
+	if used := s.UsedMemory(); used != 0 {
+		t.Errorf("expected no memory used once every key is deleted, got %d", used)
+	}
+}
+
+func TestEvictionLRU(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 1000; i++ {
+		s.Set(fmt.Sprintf("cold:%d", i), make([]byte, 100), 0)
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("hot:%d", i), make([]byte, 100), 0)
+	}
+
+	s.SetMaxMemory(s.UsedMemory()/2, store.AllKeysLRU)
+	evicted, err := s.Evict()
+	if err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	if s.UsedMemory() > s.MemoryStats().MaxMemory {
+		t.Errorf("still over the limit after eviction")
+	}
+	if len(evicted) < 500 || s.MemoryStats().EvictedKeys != int64(len(evicted)) {
+		t.Errorf("expected about half the keys to be evicted, got %d", len(evicted))
+	}
+	hot := 0
+	for i := 0; i < 100; i++ {
+		hot += s.Exists([]string{fmt.Sprintf("hot:%d", i)})
+	}
+	if hot < 95 {
+		t.Errorf("expected the recently used keys to survive, only %d of 100 did", hot)
+	}
+}
+
+func TestEvictionLFU(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 500; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), make([]byte, 100), 0)
+	}
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 50; i++ {
+			s.Get(fmt.Sprintf("key:%d", i))
+		}
+	}
+
+	s.SetMaxMemory(s.UsedMemory()/3, store.AllKeysLFU)
+	if _, err := s.Evict(); err != nil {
+		t.Fatalf("Evict failed: %v", err)
+	}
+	frequent := 0
+	for i := 0; i < 50; i++ {
+		frequent += s.Exists([]string{fmt.Sprintf("key:%d", i)})
+	}
+	if frequent < 45 {
+		t.Errorf("expected the frequently used keys to survive, only %d of 50 did", frequent)
+	}
+}
+
+func TestVolatileEvictionSparesPersistentKeys(t *testing.T) {
+	for _, policy := range []store.EvictionPolicy{store.VolatileLRU, store.VolatileTTL, store.VolatileRandom} {
+		t.Run(policy.String(), func(t *testing.T) {
+			s := newTestStore(t)
+			for i := 0; i < 100; i++ {
+				s.Set(fmt.Sprintf("persistent:%d", i), make([]byte, 100), 0)
+				s.Set(fmt.Sprintf("volatile:%d", i), make([]byte, 100), time.Hour+time.Duration(i)*time.Second)
+			}
+			s.SetMaxMemory(1, policy)
+			evicted, err := s.Evict()
+			if err != store.ErrOOM {
+				t.Errorf("expected ErrOOM once only persistent keys are left, got %v", err)
+			}
+			if len(evicted) != 100 || s.DBSize() != 100 {
+				t.Errorf("expected the 100 volatile keys to be evicted, got %d evicted and %d left", len(evicted), s.DBSize())
+			}
+		})
+	}
+}
+
+func TestNoEvictionDeniesWrites(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("k", make([]byte, 1000), 0)
+	s.SetMaxMemory(100, store.NoEviction)
+
+	if err := cmd.EnforceMaxMemory(s, cmd.SetCommand, nil, nil); err != store.ErrOOM {
+		t.Errorf("expected SET to be denied with OOM, got %v", err)
+	}
+	if err := cmd.EnforceMaxMemory(s, cmd.DelCommand, nil, nil); err != nil {
+		t.Errorf("expected DEL to be allowed, got %v", err)
+	}
+	if err := cmd.EnforceMaxMemory(s, cmd.GetCommand, nil, nil); err != nil {
+		t.Errorf("expected GET to be allowed, got %v", err)
+	}
+	s.Del([]string{"k"})
+	if err := cmd.EnforceMaxMemory(s, cmd.SetCommand, nil, nil); err != nil {
+		t.Errorf("expected SET to be allowed again under the limit, got %v", err)
+	}
+}
+
+func TestParseMemoryAndPolicy(t *testing.T) {
+	for input, want := range map[string]int64{"100": 100, "1k": 1000, "1kb": 1024, "2mb": 2 << 20, "1GB": 1 << 30} {
+		if got, err := store.ParseMemory(input); err != nil || got != want {
+			t.Errorf("ParseMemory(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "mb", "-1", "1tb"} {
+		if _, err := store.ParseMemory(input); err == nil {
+			t.Errorf("expected ParseMemory(%q) to fail", input)
+		}
+	}
+	if policy, err := store.ParseEvictionPolicy("ALLKEYS-LFU"); err != nil || policy != store.AllKeysLFU {
+		t.Errorf("unexpected policy %v, %v", policy, err)
+	}
+	if _, err := store.ParseEvictionPolicy("lru"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
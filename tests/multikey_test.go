@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/PetarGeorgiev-hash/flashdb/cluster"
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func TestVariadicDelAndExists(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("a", []byte("1"), 0)
+	s.Set("b", []byte("2"), 0)
+	s.SAdd("c", []string{"m"})
+
+	if n := s.Exists([]string{"a", "a", "c", "missing"}); n != 3 {
+		t.Errorf("expected 3 existing keys, got %d", n)
+	}
+	if n := s.Del([]string{"a", "c", "missing"}); n != 2 {
+		t.Errorf("expected 2 deleted keys, got %d", n)
+	}
+	if n := s.Exists([]string{"a", "b", "c"}); n != 1 {
+		t.Errorf("expected only b to remain, got %d keys", n)
+	}
+}
+
+func TestMGetAndMSet(t *testing.T) {
+	s := newTestStore(t)
+	s.MSet(map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+	s.HSet("h", map[string][]byte{"f": []byte("v")})
+
+	got := s.MGet([]string{"a", "missing", "h", "b"})
+	want := [][]byte{[]byte("1"), nil, nil, []byte("2")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MGet = %q, want %q", got, want)
+	}
+
+	if s.MSetNX(map[string][]byte{"new": []byte("x"), "a": []byte("y")}) {
+		t.Error("expected MSetNX to fail when a key exists")
+	}
+	if n := s.Exists([]string{"new"}); n != 0 {
+		t.Error("expected MSetNX to write nothing on failure")
+	}
+	if !s.MSetNX(map[string][]byte{"new": []byte("x"), "other": []byte("y")}) {
+		t.Error("expected MSetNX to succeed on missing keys")
+	}
+}
+
+func TestMSetNXIsAllOrNothing(t *testing.T) {
+	s := newTestStore(t)
+
+	// Writers race on overlapping key sets spread over many shards: exactly one
+	// of them may win and its values must be the only ones stored.
+	var wg sync.WaitGroup
+	wins := make(chan int, 20)
+	for w := 0; w < 20; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			values := make(map[string][]byte)
+			for k := w % 3; k < 30; k++ {
+				values[fmt.Sprintf("key%d", k)] = []byte(fmt.Sprint(w))
+			}
+			if s.MSetNX(values) {
+				wins <- w
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(wins)
+
+	winners := 0
+	for w := range wins {
+		winners++
+		for k := w % 3; k < 30; k++ {
+			if v := s.MGet([]string{fmt.Sprintf("key%d", k)})[0]; string(v) != fmt.Sprint(w) {
+				t.Errorf("key%d = %q, expected the winner %d", k, v, w)
+			}
+		}
+	}
+	if winners != 1 {
+		t.Errorf("expected exactly one MSetNX to win, got %d", winners)
+	}
+
+	if err := store.ApplyCommand(s, []string{"DEL", "key1", "key2"}); err != nil {
+		t.Fatalf("ApplyCommand failed: %v", err)
+	}
+	if n := s.Exists([]string{"key1", "key2", "key3"}); n != 1 {
+		t.Errorf("expected replayed DEL to remove two keys, %d left", n)
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	for _, tc := range []struct {
+		parts []string
+		want  []string
+	}{
+		{[]string{"GET", "k"}, []string{"k"}},
+		{[]string{"PING", "hello"}, nil},
+		{[]string{"MSET", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]string{"DEL", "a", "b"}, []string{"a", "b"}},
+		{[]string{"BLPOP", "a", "b", "0"}, []string{"a", "b"}},
+		{[]string{"LMOVE", "a", "b", "LEFT", "RIGHT"}, []string{"a", "b"}},
+		{[]string{"ZUNIONSTORE", "d", "2", "a", "b", "WEIGHTS", "1", "2"}, []string{"d", "a", "b"}},
+		{[]string{"XREAD", "COUNT", "2", "STREAMS", "a", "b", "0", "0"}, []string{"a", "b"}},
+		{[]string{"XGROUP", "CREATE", "s", "g", "$"}, []string{"s"}},
+	} {
+		var got []string
+		for _, key := range cmd.CommandKeys(byteArgs(tc.parts...)) {
+			got = append(got, string(key))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("CommandKeys(%v) = %v, want %v", tc.parts, got, tc.want)
+		}
+	}
+}
+
+func TestSlotHashTags(t *testing.T) {
+	m := &cluster.Manager{}
+	if m.GetSlotForKey([]byte("{user1}.name")) != m.GetSlotForKey([]byte("{user1}.email")) {
+		t.Error("expected keys sharing a hash tag to map to the same slot")
+	}
+	if m.GetSlotForKey([]byte("{}a")) == m.GetSlotForKey([]byte("{}b")) {
+		t.Error("expected an empty hash tag to hash the whole key")
+	}
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/pubsub"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+// eventRecorder collects the keyspace events of a store as "event key" strings.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func recordEvents(t *testing.T, s store.IStore) *eventRecorder {
+	r := &eventRecorder{}
+	cancel := s.OnKeyspaceEvent(func(e store.KeyspaceEvent) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, e.Event+" "+e.Key)
+	})
+	t.Cleanup(cancel)
+	return r
+}
+
+func (r *eventRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestKeyspaceEventCallbacks(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("ignored", []byte("v"), 0)
+	flags, _ := store.ParseNotifyFlags("Kg$l")
+	s.SetNotifyKeyspaceEvents(flags)
+	r := recordEvents(t, s)
+
+	s.Set("name", []byte("v"), time.Hour)
+	s.LPush("queue", [][]byte{[]byte("a")})
+	s.LPop("queue", 1)
+	s.Rename("name", "other", false)
+	s.HSet("hash", map[string][]byte{"f": []byte("v")})
+	s.Del([]string{"other", "missing"})
+
+	want := []string{
+		"set name", "expire name",
+		"lpush queue", "lpop queue", "del queue",
+		"rename_from name", "rename_to other",
+		"del other",
+	}
+	if got := r.take(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected events %v, got %v", want, got)
+	}
+
+	flags, _ = store.ParseNotifyFlags("gn")
+	s.SetNotifyKeyspaceEvents(flags)
+	s.Set("fresh", []byte("v"), 0)
+	s.Set("fresh", []byte("w"), 0)
+	if got := r.take(); len(got) != 1 || got[0] != "new fresh" {
+		t.Errorf("expected a single new event, got %v", got)
+	}
+}
+
+func TestExpiredKeyspaceEvents(t *testing.T) {
+	s := newTestStore(t)
+	s.SetNotifyKeyspaceEvents(store.NotifyExpired)
+	r := recordEvents(t, s)
+
+	s.Set("lazy", []byte("v"), 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if item, _ := s.Get("lazy"); item != nil {
+		t.Fatal("expected lazy to be expired")
+	}
+	s.Set("active", []byte("v"), 20*time.Millisecond)
+
+	// Whether lazy went to Get or to the background cycle, both must be reported once.
+	var got []string
+	waitFor(2*time.Second, func() bool {
+		got = append(got, r.take()...)
+		return len(got) >= 2
+	})
+	if strings.Join(got, ",") != "expired lazy,expired active" {
+		t.Errorf("expected both keys to report expired, got %v", got)
+	}
+}
+
+func TestParseNotifyFlags(t *testing.T) {
+	for input, want := range map[string]string{
+		"":      "",
+		"KEA":   "AKE",
+		"Ex":    "xE",
+		"g$lsh": "g$lsh",
+		"AKn":   "AKn",
+	} {
+		flags, err := store.ParseNotifyFlags(input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", input, err)
+			continue
+		}
+		if flags.String() != want {
+			t.Errorf("%q: expected %q, got %q", input, want, flags.String())
+		}
+	}
+	if _, err := store.ParseNotifyFlags("KQ"); err != store.ErrNotifyFlags {
+		t.Errorf("expected ErrNotifyFlags, got %v", err)
+	}
+}
+
+func TestKeyspaceEventsArePublished(t *testing.T) {
+	s := newTestStore(t)
+	broker := pubsub.NewBroker()
+	defer broker.PublishKeyspaceEvents(s)()
+	flags, _ := store.ParseNotifyFlags("KE$")
+	s.SetNotifyKeyspaceEvents(flags)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	sub := broker.NewSubscriber(server)
+	defer sub.Close()
+	broker.PSubscribe(sub, "__key*__:*")
+	r := bufio.NewReader(client)
+
+	s.Set("name", []byte("v"), 0)
+	for _, want := range []string{
+		"pmessage __key*__:* __keyspace@0__:name set",
+		"pmessage __key*__:* __keyevent@0__:set name",
+	} {
+		if got := readReply(t, r); strings.Join(got, " ") != want {
+			t.Errorf("expected %q, got %v", want, got)
+		}
+	}
+}
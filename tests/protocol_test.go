@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/PetarGeorgiev-hash/flashdb/acl"
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+)
+
+// parseAll parses every command of input and returns them joined by spaces,
+// along with the error that stopped the parser, nil at the end of input.
+func parseAll(input string, limits protocol.Limits) ([]string, error) {
+	parser := protocol.NewRESPParser(limits)
+	r := bufio.NewReader(strings.NewReader(input))
+	var commands []string
+	for {
+		args, err := parser.ParseRESP(r)
+		if err == io.EOF {
+			return commands, nil
+		}
+		if err != nil {
+			return commands, err
+		}
+		commands = append(commands, strings.Join(protocol.Strings(args), " "))
+	}
+}
+
+// byteArgs returns parts as the parser returns the arguments of a command.
+func byteArgs(parts ...string) [][]byte {
+	args := make([][]byte, len(parts))
+	for i, part := range parts {
+		args[i] = []byte(part)
+	}
+	return args
+}
+
+// encodeRequest encodes args the way clients send commands.
+func encodeRequest(args [][]byte) []byte {
+	e := protocol.Encoder{Version: protocol.RESP2}
+	buf := e.AppendArrayHeader(nil, len(args))
+	for _, arg := range args {
+		buf = e.AppendBulk(buf, arg)
+	}
+	return buf
+}
+
+func TestParsePipelinedAndInlineCommands(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nv\r\nv2\r\n" +
+		"PING\r\n" +
+		"\r\n" +
+		"SET  \"a b\"  'it\\'s'\n" +
+		"SET \"\\x41\\n\" \"\"\r\n" +
+		"*0\r\n"
+	commands, err := parseAll(input, protocol.DefaultLimits())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []string{"SET k v\r\nv2", "PING", "", "SET a b it's", "SET A\n ", ""}
+	if strings.Join(commands, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, commands)
+	}
+}
+
+func TestParseLargeBulkFromShortReads(t *testing.T) {
+	value := bytes.Repeat([]byte("x"), 3<<20)
+	input := append(encodeRequest([][]byte{[]byte("SET"), []byte("big"), value}), "*1\r\n$4\r\nPING\r\n"...)
+	// OneByteReader makes every read short, as a slow socket does.
+	r := bufio.NewReaderSize(iotest.OneByteReader(bytes.NewReader(input)), 16)
+	parser := protocol.NewRESPParser(protocol.DefaultLimits())
+
+	args, err := parser.ParseRESP(r)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(args) != 3 || !bytes.Equal(args[2], value) {
+		t.Fatalf("the value was not read in full, got %d arguments", len(args))
+	}
+	if args, err = parser.ParseRESP(r); err != nil || string(args[0]) != "PING" {
+		t.Errorf("expected the next command to be PING, got %q, %v", args, err)
+	}
+}
+
+func TestParseProtocolErrors(t *testing.T) {
+	limits := protocol.Limits{MaxBulkLen: 8, MaxMultiBulkLen: 2}
+	for input, want := range map[string]string{
+		"*3\r\n$1\r\na\r\n":         "invalid multibulk length",
+		"*x\r\n":                    "invalid multibulk length",
+		"*1\r\n$9\r\n123456789\r\n": "invalid bulk length",
+		"*1\r\n$-1\r\n":             "invalid bulk length",
+		"*1\r\n:1\r\n":              "expected '$', got ':'",
+		"*1\r\n$3\r\nabcd\r\n":      "not terminated by CRLF",
+		"SET \"unterminated\r\n":    "unbalanced quotes",
+		"SET \"a\"b\r\n":            "unbalanced quotes",
+		strings.Repeat("a", 70000):  "too big inline request",
+	} {
+		_, err := parseAll(input, limits)
+		if !errors.Is(err, protocol.ErrProtocol) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%.20q: expected a protocol error about %q, got %v", input, want, err)
+		}
+	}
+	if _, err := parseAll("*2\r\n$3\r\nGET\r\n$3\r\nke", limits); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected a truncated command to fail with io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func FuzzParseRESP(f *testing.F) {
+	f.Add([]byte("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+	f.Add([]byte("SET \"a\\x41\" 'b'\r\n"))
+	f.Add([]byte("*1\r\n$-1\r\n"))
+	f.Add([]byte("*-1\r\n*0\r\nPING\n"))
+	f.Add([]byte("*1\r\n$9223372036854775807\r\n"))
+	f.Add([]byte("*1\r\n$9223372036854775806\r\nx\r\n"))
+	limits := protocol.Limits{MaxBulkLen: 1024, MaxMultiBulkLen: 64}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		// Without limits the parser must fail cleanly too.
+		unlimited := protocol.NewRESPParser(protocol.Limits{})
+		for r := bufio.NewReader(bytes.NewReader(input)); ; {
+			if _, err := unlimited.ParseRESP(r); err != nil {
+				break
+			}
+		}
+
+		parser := protocol.NewRESPParser(limits)
+		r := bufio.NewReader(bytes.NewReader(input))
+		for {
+			args, err := parser.ParseRESP(r)
+			if err != nil {
+				return
+			}
+			for _, arg := range args {
+				if len(arg) > max(int(limits.MaxBulkLen), protocol.MaxInlineLen) {
+					t.Fatalf("an argument of %d bytes exceeds the limits", len(arg))
+				}
+			}
+		}
+	})
+}
+
+func FuzzParseRoundTrip(f *testing.F) {
+	f.Add([]byte("SET"), []byte("key"), []byte("value\r\n"))
+	f.Add([]byte(""), []byte("\x00"), []byte("*1\r\n$1\r\n"))
+	f.Fuzz(func(t *testing.T, a, b, c []byte) {
+		want := [][]byte{a, b, c}
+		input := encodeRequest(want)
+		input = append(input, input...)
+		parser := protocol.NewRESPParser(protocol.DefaultLimits())
+		r := bufio.NewReaderSize(iotest.HalfReader(bytes.NewReader(input)), 16)
+		for i := 0; i < 2; i++ {
+			args, err := parser.ParseRESP(r)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if len(args) != len(want) {
+				t.Fatalf("expected %d arguments, got %d", len(want), len(args))
+			}
+			for j := range want {
+				if !bytes.Equal(args[j], want[j]) {
+					t.Fatalf("argument %d: expected %q, got %q", j, want[j], args[j])
+				}
+			}
+		}
+	})
+}
+
+/*
+BenchmarkRequest compares the server copying every argument of a request as
+soon as it is parsed with routing and checking the request on the parser buffer
+and copying the arguments once, for the handler.
+*/
+func BenchmarkRequest(b *testing.B) {
+	request := encodeRequest(byteArgs("MSET", "user:1", "alice", "user:2", "bob", "user:3", "carol"))
+	users := cmd.NewACL()
+	run := func(b *testing.B, serve func(args [][]byte)) {
+		parser := protocol.NewRESPParser(protocol.DefaultLimits())
+		input := bytes.NewReader(request)
+		r := bufio.NewReader(input)
+		b.ReportAllocs()
+		for b.Loop() {
+			input.Reset(request)
+			r.Reset(input)
+			args, err := parser.ParseRESP(r)
+			if err != nil {
+				b.Fatal(err)
+			}
+			serve(args)
+		}
+	}
+
+	b.Run("copy", func(b *testing.B) {
+		run(b, func(args [][]byte) {
+			parts := make([]string, len(args))
+			for i, arg := range args {
+				parts[i] = string(arg)
+			}
+		})
+	})
+	b.Run("route", func(b *testing.B) {
+		run(b, func(args [][]byte) {
+			if cmd.CommandName(args[0]) != cmd.MSetCommand {
+				b.Fatal("expected MSET")
+			}
+			if err := users.Check(acl.DefaultUser, "mset", cmd.CommandKeys(args), nil, false); err != nil {
+				b.Fatal(err)
+			}
+			protocol.Strings(args)
+		})
+	})
+}
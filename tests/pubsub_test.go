@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/pubsub"
+)
+
+// readReply reads one RESP reply, flattening arrays into the list of their elements.
+func readReply(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '*':
+		var lines []string
+		n, _ := strconv.Atoi(line[1:])
+		for i := 0; i < n; i++ {
+			lines = append(lines, readReply(t, r)...)
+		}
+		return lines
+	case '$':
+		value, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		return []string{strings.TrimSuffix(value, "\r\n")}
+	}
+	return []string{line}
+}
+
+func TestPublishToChannelsAndPatterns(t *testing.T) {
+	broker := pubsub.NewBroker()
+	client, server := net.Pipe()
+	defer client.Close()
+	s := broker.NewSubscriber(server)
+	defer s.Close()
+	r := bufio.NewReader(client)
+
+	if n := broker.Subscribe(s, "news"); n != 1 {
+		t.Errorf("expected 1 subscription, got %d", n)
+	}
+	if n := broker.PSubscribe(s, "n*"); n != 2 {
+		t.Errorf("expected 2 subscriptions, got %d", n)
+	}
+	if n := broker.Publish("news", []byte("hello")); n != 2 {
+		t.Errorf("expected the message to reach the channel and the pattern, got %d", n)
+	}
+	if got := readReply(t, r); strings.Join(got, " ") != "message news hello" {
+		t.Errorf("unexpected message %v", got)
+	}
+	if got := readReply(t, r); strings.Join(got, " ") != "pmessage n* news hello" {
+		t.Errorf("unexpected pattern message %v", got)
+	}
+	if n := broker.Publish("other", []byte("x")); n != 0 {
+		t.Errorf("expected nobody to receive a message on other, got %d", n)
+	}
+
+	if channels := broker.Channels(""); len(channels) != 1 || channels[0] != "news" {
+		t.Errorf("unexpected channels %v", channels)
+	}
+	if broker.NumSub("news") != 1 || broker.NumPat() != 1 {
+		t.Errorf("unexpected counts numsub=%d numpat=%d", broker.NumSub("news"), broker.NumPat())
+	}
+	broker.UnsubscribeAll(s)
+	if broker.NumSub("news") != 0 || broker.NumPat() != 0 || len(broker.Channels("")) != 0 {
+		t.Error("expected no subscription left after UnsubscribeAll")
+	}
+}
+
+func TestSlowSubscriberIsDisconnected(t *testing.T) {
+	broker := pubsub.NewBroker()
+	broker.SetOutputLimit(64 * 1024)
+	client, server := net.Pipe()
+	defer client.Close()
+	s := broker.NewSubscriber(server)
+	defer s.Close()
+	broker.Subscribe(s, "big")
+
+	// Nobody reads the client side: publishing must neither block nor queue forever.
+	payload := make([]byte, 1024)
+	done := make(chan int)
+	go func() {
+		received := 0
+		for i := 0; i < 1000; i++ {
+			received += broker.Publish("big", payload)
+		}
+		done <- received
+	}()
+	select {
+	case received := <-done:
+		if received >= 1000 {
+			t.Errorf("expected the subscriber to be dropped, it received all %d messages", received)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("PUBLISH blocked on a slow subscriber")
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadAll(client); err != nil {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/pubsub"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+// runClient sends commands through a cmd.Client the way the server does and returns the replies.
+func runClient(t *testing.T, s store.IStore, commands ...[]string) string {
+	t.Helper()
+	conn, server := net.Pipe()
+	replies := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(conn)
+		replies <- string(data)
+	}()
+	client := cmd.NewClient(server, newTestServer())
+	w := client.Replies()
+	for _, parts := range commands {
+		if !client.Handle(w, parts, nil, false) {
+			cmd.Run(cmd.CommandHandlers[strings.ToUpper(parts[0])], w, s, parts, nil, nil)
+		}
+	}
+	w.Flush()
+	client.Close()
+	return <-replies
+}
+
+func TestEncoderTypes(t *testing.T) {
+	resp2, resp3 := protocol.Encoder{Version: protocol.RESP2}, protocol.Encoder{Version: protocol.RESP3}
+	tests := []struct {
+		name         string
+		encode       func(e protocol.Encoder) []byte
+		want2, want3 string
+	}{
+		{"null", func(e protocol.Encoder) []byte { return e.AppendNull(nil) }, "$-1\r\n", "_\r\n"},
+		{"null array", func(e protocol.Encoder) []byte { return e.AppendNullArray(nil) }, "*-1\r\n", "_\r\n"},
+		{"map", func(e protocol.Encoder) []byte { return e.AppendMapHeader(nil, 2) }, "*4\r\n", "%2\r\n"},
+		{"set", func(e protocol.Encoder) []byte { return e.AppendSetHeader(nil, 2) }, "*2\r\n", "~2\r\n"},
+		{"push", func(e protocol.Encoder) []byte { return e.AppendPushHeader(nil, 3) }, "*3\r\n", ">3\r\n"},
+		{"double", func(e protocol.Encoder) []byte { return e.AppendDouble(nil, 1.5) }, "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"infinity", func(e protocol.Encoder) []byte { return e.AppendDouble(nil, math.Inf(-1)) }, "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"boolean", func(e protocol.Encoder) []byte { return e.AppendBoolean(nil, true) }, ":1\r\n", "#t\r\n"},
+		{"big number", func(e protocol.Encoder) []byte {
+			n, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+			return e.AppendBigNumber(nil, n)
+		}, "$43\r\n3492890328409238509324850943850943825024385\r\n", "(3492890328409238509324850943850943825024385\r\n"},
+		{"verbatim", func(e protocol.Encoder) []byte { return e.AppendVerbatim(nil, "txt", "Some string") }, "$11\r\nSome string\r\n", "=15\r\ntxt:Some string\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(tt.encode(resp2)); got != tt.want2 {
+			t.Errorf("%s: expected RESP2 %q, got %q", tt.name, tt.want2, got)
+		}
+		if got := string(tt.encode(resp3)); got != tt.want3 {
+			t.Errorf("%s: expected RESP3 %q, got %q", tt.name, tt.want3, got)
+		}
+	}
+}
+
+func TestHelloNegotiatesProtocol(t *testing.T) {
+	s := newTestStore(t)
+	s.HSet("hash", map[string][]byte{"f": []byte("v")})
+	s.ZAdd("zset", []store.ZMember{{Member: "a", Score: 1.5}}, store.ZAddOptions{})
+
+	replies := runClient(t, s,
+		[]string{"HGETALL", "hash"},
+		[]string{"GET", "missing"},
+		[]string{"HELLO", "3", "SETNAME", "cache"},
+		[]string{"HGETALL", "hash"},
+		[]string{"GET", "missing"},
+		[]string{"ZRANGE", "zset", "0", "-1", "WITHSCORES"},
+		[]string{"ZSCORE", "zset", "a"},
+		[]string{"HELLO", "2"},
+		[]string{"ZSCORE", "zset", "a"},
+	)
+	for _, want := range []string{
+		"*2\r\n$1\r\nf\r\n$1\r\nv\r\n$-1\r\n",
+		"%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n",
+		"$5\r\nproto\r\n:3\r\n",
+		"%1\r\n$1\r\nf\r\n$1\r\nv\r\n_\r\n*1\r\n*2\r\n$1\r\na\r\n,1.5\r\n,1.5\r\n",
+		"*14\r\n$6\r\nserver\r\n",
+		"$5\r\nproto\r\n:2\r\n",
+		"$3\r\n1.5\r\n",
+	} {
+		if !strings.Contains(replies, want) {
+			t.Errorf("expected the replies to contain %q, got %q", want, replies)
+		}
+	}
+
+	for parts, want := range map[string]string{
+		"HELLO 4":                      "-NOPROTO",
+		"HELLO three":                  "-ERR Protocol version",
+		"HELLO 3 AUTH admin pass":      "-WRONGPASS",
+		"HELLO 3 SETNAME has\nnewline": "-ERR Client names",
+		"HELLO 3 FOO":                  "-ERR Syntax error",
+	} {
+		if got := runClient(t, s, strings.Split(parts, " ")); !strings.HasPrefix(got, want) {
+			t.Errorf("%q: expected %q, got %q", parts, want, got)
+		}
+	}
+}
+
+// resp3Conn is a connection whose client switched to RESP3.
+type resp3Conn struct{ net.Conn }
+
+func (resp3Conn) Protocol() int { return protocol.RESP3 }
+
+func TestPushMessagesInRESP3(t *testing.T) {
+	broker := pubsub.NewBroker()
+	client, server := net.Pipe()
+	defer client.Close()
+	s := broker.NewSubscriber(resp3Conn{server})
+	defer s.Close()
+	broker.Subscribe(s, "news")
+	broker.Publish("news", []byte("hello"))
+
+	r := bufio.NewReader(client)
+	if header, _ := r.ReadString('\n'); header != ">3\r\n" {
+		t.Fatalf("expected a push message, got %q", header)
+	}
+	if got := readReply(t, r); got[0] != "message" {
+		t.Errorf("unexpected message %v", got)
+	}
+}
@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+	"github.com/PetarGeorgiev-hash/flashdb/util"
+)
+
+func scanAll(s store.IStore, opts store.ScanOptions) []string {
+	var keys []string
+	cursor := uint64(0)
+	for {
+		next, batch := s.Scan(cursor, opts)
+		keys = append(keys, batch...)
+		if next == 0 {
+			return keys
+		}
+		cursor = next
+	}
+}
+
+func TestScanReturnsEveryKeyOnce(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 1000; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), []byte("v"), 0)
+	}
+
+	keys := scanAll(s, store.ScanOptions{Count: 25})
+	seen := make(map[string]int)
+	for _, key := range keys {
+		seen[key]++
+	}
+	if len(seen) != 1000 || len(keys) != 1000 {
+		t.Errorf("expected 1000 distinct keys, got %d keys and %d distinct", len(keys), len(seen))
+	}
+}
+
+func TestScanWhileWriting(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 500; i++ {
+		s.Set(fmt.Sprintf("stable:%d", i), []byte("v"), 0)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("churn:%d", i%200)
+			s.Set(key, []byte("v"), 0)
+			s.Del([]string{fmt.Sprintf("churn:%d", (i+100)%200)})
+		}
+	}()
+
+	keys := scanAll(s, store.ScanOptions{Match: "stable:*", Count: 7})
+	close(stop)
+	wg.Wait()
+
+	if len(keys) != 500 {
+		t.Fatalf("expected all 500 stable keys, got %d", len(keys))
+	}
+}
+
+func TestScanTypeAndKeysIterator(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("str", []byte("v"), 0)
+	s.HSet("hash", map[string][]byte{"f": []byte("v")})
+	s.SAdd("set", []string{"m"})
+
+	if keys := scanAll(s, store.ScanOptions{Type: "hash"}); len(keys) != 1 || keys[0] != "hash" {
+		t.Errorf("expected only the hash, got %v", keys)
+	}
+
+	var keys []string
+	for key := range s.Keys(store.ScanOptions{Match: "s*"}) {
+		keys = append(keys, key)
+	}
+	if got := sorted(keys); len(got) != 2 || got[0] != "set" || got[1] != "str" {
+		t.Errorf("unexpected Keys result %v", got)
+	}
+	// Breaking out of the loop stops the iteration.
+	n := 0
+	for range s.Keys(store.ScanOptions{}) {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("expected a single iteration, got %d", n)
+	}
+}
+
+func TestCollectionScan(t *testing.T) {
+	s := newTestStore(t)
+	fields := make(map[string][]byte)
+	members := make([]string, 0, 300)
+	for i := 0; i < 300; i++ {
+		fields[fmt.Sprintf("f%d", i)] = []byte("v")
+		members = append(members, fmt.Sprintf("m%d", i))
+		s.ZAdd("z", []store.ZMember{{Member: fmt.Sprintf("m%d", i), Score: float64(i)}}, store.ZAddOptions{})
+	}
+	s.HSet("h", fields)
+	s.SAdd("s", members)
+
+	seen := make(map[string]bool)
+	for cursor := uint64(0); ; {
+		next, pairs, err := s.HScan("h", cursor, store.ScanOptions{Count: 20})
+		if err != nil {
+			t.Fatalf("HScan failed: %v", err)
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			seen[string(pairs[i])] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 300 {
+		t.Errorf("expected 300 fields, got %d", len(seen))
+	}
+
+	// Small collections come back in a single call.
+	s.SAdd("small", []string{"a", "b", "c"})
+	if next, got, _ := s.SScan("small", 0, store.ScanOptions{Match: "[ab]"}); next != 0 || len(got) != 2 {
+		t.Errorf("unexpected SScan result %v, cursor %d", got, next)
+	}
+
+	total := 0
+	for cursor := uint64(0); ; {
+		next, got, _ := s.ZScan("z", cursor, store.ScanOptions{Match: "m1*"})
+		total += len(got)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	// m1, m10-m19 and m100-m199.
+	if total != 111 {
+		t.Errorf("expected 111 members matching m1*, got %d", total)
+	}
+	if _, _, err := s.SScan("h", 0, store.ScanOptions{}); err != store.ErrWrongType {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestScanIndexFollowsWrites(t *testing.T) {
+	s := newTestStore(t)
+	fields := make(map[string][]byte)
+	members := make([]string, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), []byte("v"), 0)
+		fields[fmt.Sprintf("f%d", i)] = []byte("v")
+		members = append(members, fmt.Sprintf("m%d", i))
+	}
+	s.HSet("h", fields)
+	s.SAdd("s", members)
+
+	// Each call stops after about COUNT elements, whatever the size.
+	for cursor := uint64(0); ; {
+		next, keys := s.Scan(cursor, store.ScanOptions{Count: 10})
+		if len(keys) > 30 {
+			t.Fatalf("expected about 10 keys per call, got %d", len(keys))
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	// Removing most elements leaves only the others to scan.
+	var gone []string
+	for i := 10; i < 2000; i++ {
+		gone = append(gone, fmt.Sprintf("key:%d", i))
+		s.HDel("h", []string{fmt.Sprintf("f%d", i)})
+		s.SRem("s", []string{fmt.Sprintf("m%d", i)})
+	}
+	s.Del(gone)
+	if keys := scanAll(s, store.ScanOptions{Count: 3}); len(keys) != 12 {
+		t.Errorf("expected the 10 keys left and the collections, got %d", len(keys))
+	}
+	var fieldsLeft, membersLeft []string
+	for cursor := uint64(0); ; {
+		next, pairs, _ := s.HScan("h", cursor, store.ScanOptions{Count: 3})
+		for i := 0; i < len(pairs); i += 2 {
+			fieldsLeft = append(fieldsLeft, string(pairs[i]))
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	for cursor := uint64(0); ; {
+		next, got, _ := s.SScan("s", cursor, store.ScanOptions{Count: 3})
+		membersLeft = append(membersLeft, got...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(fieldsLeft) != 10 || len(membersLeft) != 10 {
+		t.Errorf("expected 10 fields and 10 members left, got %v and %v", sorted(fieldsLeft), sorted(membersLeft))
+	}
+
+	s.Flush()
+	s.Set("fresh", []byte("v"), 0)
+	if keys := scanAll(s, store.ScanOptions{}); len(keys) != 1 || keys[0] != "fresh" {
+		t.Errorf("expected only the key set after FLUSHALL, got %v", keys)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:email", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"*a*b", "xaxxb", true},
+		{"a[", "a", false},
+	} {
+		if got := util.GlobMatch(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("GlobMatch(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func sorted(members []string) []string {
+	sort.Strings(members)
+	return members
+}
+
+func TestSetAddRemove(t *testing.T) {
+	s := newTestStore(t)
+
+	added, _ := s.SAdd("s", []string{"a", "b", "a"})
+	if added != 2 {
+		t.Errorf("expected 2 added members, got %d", added)
+	}
+	if ok, _ := s.SIsMember("s", "a"); !ok {
+		t.Error("expected a to be a member")
+	}
+	flags, _ := s.SMIsMember("s", []string{"b", "c"})
+	if !flags[0] || flags[1] {
+		t.Errorf("unexpected SMIsMember result %v", flags)
+	}
+
+	removed, _ := s.SRem("s", []string{"a", "b", "c"})
+	if removed != 2 {
+		t.Errorf("expected 2 removed members, got %d", removed)
+	}
+	if item, _ := s.Get("s"); item != nil {
+		t.Error("expected empty set to be removed")
+	}
+}
+
+func TestSetPopAndRandMember(t *testing.T) {
+	s := newTestStore(t)
+	s.SAdd("s", []string{"a", "b", "c"})
+
+	members, _ := s.SRandMember("s", 5)
+	if len(members) != 3 {
+		t.Errorf("expected 3 distinct members, got %v", members)
+	}
+	members, _ = s.SRandMember("s", -5)
+	if len(members) != 5 {
+		t.Errorf("expected 5 members with repetitions, got %v", members)
+	}
+	if _, err := s.SRandMember("s", math.MinInt); err != store.ErrRange {
+		t.Errorf("expected ErrRange for the smallest count, got %v", err)
+	}
+
+	popped, _ := s.SPop("s", 2)
+	if n, _ := s.SCard("s"); len(popped) != 2 || n != 1 {
+		t.Errorf("expected 2 popped and 1 left, got %v and %d", popped, n)
+	}
+}
+
+func TestSRandMemberNegativeCount(t *testing.T) {
+	s := newTestStore(t)
+	s.SAdd("s", []string{"a", "b"})
+
+	// The reply is larger than the write buffer, so it is flushed as it goes.
+	reply := runClient(t, s, []string{"SRANDMEMBER", "s", "-5000"})
+	if !strings.HasPrefix(reply, "*5000\r\n") || strings.Count(reply, "$1\r\n") != 5000 {
+		t.Errorf("expected 5000 members, got %d bytes starting %.20q", len(reply), reply)
+	}
+	if reply := runClient(t, s, []string{"SRANDMEMBER", "missing", "-5"}); reply != "*0\r\n" {
+		t.Errorf("expected an empty array for a missing key, got %q", reply)
+	}
+	if reply := runClient(t, s, []string{"SRANDMEMBER", "s", "-9223372036854775808"}); reply != "-ERR value is out of range\r\n" {
+		t.Errorf("expected the smallest count to be refused, got %q", reply)
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	s := newTestStore(t)
+	s.SAdd("a", []string{"1", "2", "3"})
+	s.SAdd("b", []string{"2", "3", "4"})
+
+	if got := sorted(must(s.SInter([]string{"a", "b"}))); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("unexpected SInter result %v", got)
+	}
+	if got := must(s.SUnion([]string{"a", "b"})); len(got) != 4 {
+		t.Errorf("unexpected SUnion result %v", got)
+	}
+	if got := must(s.SDiff([]string{"a", "b"})); len(got) != 1 || got[0] != "1" {
+		t.Errorf("unexpected SDiff result %v", got)
+	}
+	if got := must(s.SInter([]string{"a", "missing"})); len(got) != 0 {
+		t.Errorf("expected empty intersection with missing key, got %v", got)
+	}
+
+	n, _ := s.SUnionStore("dst", []string{"a", "b"})
+	if card, _ := s.SCard("dst"); n != 4 || card != 4 {
+		t.Errorf("expected 4 stored members, got %d (%d)", n, card)
+	}
+	if n, _ := s.SInterCard([]string{"a", "b"}, 1); n != 1 {
+		t.Errorf("expected SInterCard capped at 1, got %d", n)
+	}
+
+	s.Set("str", []byte("v"), 0)
+	if _, err := s.SInter([]string{"a", "str"}); err != store.ErrWrongType {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestSetMove(t *testing.T) {
+	s := newTestStore(t)
+	s.SAdd("src", []string{"m"})
+
+	moved, _ := s.SMove("src", "dst", "m")
+	if !moved {
+		t.Fatal("expected member to be moved")
+	}
+	if ok, _ := s.SIsMember("dst", "m"); !ok {
+		t.Error("expected m in destination")
+	}
+	if item, _ := s.Get("src"); item != nil {
+		t.Error("expected empty source set to be removed")
+	}
+}
+
+func must(members []string, err error) []string {
+	if err != nil {
+		panic(err)
+	}
+	return members
+}
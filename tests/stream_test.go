@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func streamIDs(entries []store.StreamEntry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.ID.String()
+	}
+	return out
+}
+
+func TestStreamAddAndRange(t *testing.T) {
+	s := newTestStore(t)
+
+	for _, id := range []string{"1-1", "1-2", "2-0", "5-*"} {
+		if _, _, err := s.XAdd("st", id, []string{"f", id}, store.XAddOptions{}); err != nil {
+			t.Fatalf("XAdd %s failed: %v", id, err)
+		}
+	}
+	if _, _, err := s.XAdd("st", "2-0", []string{"f", "v"}, store.XAddOptions{}); err != store.ErrStreamIDSmall {
+		t.Errorf("expected ErrStreamIDSmall, got %v", err)
+	}
+	if _, _, err := s.XAdd("empty", "0-0", []string{"f", "v"}, store.XAddOptions{}); err != store.ErrStreamIDZero {
+		t.Errorf("expected ErrStreamIDZero, got %v", err)
+	}
+	if _, ok, _ := s.XAdd("missing", "*", []string{"f", "v"}, store.XAddOptions{NoMkStream: true}); ok {
+		t.Error("expected NOMKSTREAM to skip a missing key")
+	}
+
+	all, _ := s.XRange("st", store.StreamID{}, store.MaxStreamID, 0, false)
+	if got := streamIDs(all); len(got) != 4 || got[3] != "5-0" {
+		t.Fatalf("unexpected entries %v", got)
+	}
+	rev, _ := s.XRange("st", store.StreamID{Ms: 1}, store.StreamID{Ms: 1, Seq: 9}, 1, true)
+	if got := streamIDs(rev); len(got) != 1 || got[0] != "1-2" {
+		t.Errorf("unexpected reverse range %v", got)
+	}
+
+	next, _, _ := s.XAdd("st", "*", []string{"f", "v"}, store.XAddOptions{
+		Trim: &store.StreamTrim{MaxLen: 2},
+	})
+	if n, _ := s.XLen("st"); n != 2 {
+		t.Errorf("expected 2 entries after MAXLEN, got %d", n)
+	}
+	if n, _ := s.XTrim("st", store.StreamTrim{MinID: true, Boundary: next}); n != 1 {
+		t.Errorf("expected MINID to evict 1 entry, got %d", n)
+	}
+	if n, _ := s.XDel("st", []store.StreamID{next, {Ms: 1}}); n != 1 {
+		t.Errorf("expected 1 deleted entry, got %d", n)
+	}
+	// An emptied stream keeps its last ID.
+	if _, _, err := s.XAdd("st", "5-1", []string{"f", "v"}, store.XAddOptions{}); err != store.ErrStreamIDSmall {
+		t.Errorf("expected ErrStreamIDSmall on emptied stream, got %v", err)
+	}
+}
+
+func TestStreamBlockingRead(t *testing.T) {
+	s := newTestStore(t)
+	s.XAdd("st", "1-0", []string{"f", "old"}, store.XAddOptions{})
+
+	results, _ := s.XRead([]string{"st"}, []string{"$"}, 0, 50*time.Millisecond)
+	if results != nil {
+		t.Fatalf("expected timeout, got %v", results)
+	}
+
+	done := make(chan []store.StreamReadResult)
+	go func() {
+		results, _ := s.XRead([]string{"other", "st"}, []string{"0", "$"}, 0, 0)
+		done <- results
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.XAdd("st", "2-0", []string{"f", "new"}, store.XAddOptions{})
+
+	select {
+	case results := <-done:
+		if len(results) != 1 || results[0].Key != "st" || results[0].Entries[0].Fields[1] != "new" {
+			t.Errorf("unexpected read %v", results)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("XRead was not woken up by XAdd")
+	}
+}
+
+func TestStreamConsumerGroup(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.XGroupCreate("st", "g", "$", false); err != store.ErrXGroupNoStream {
+		t.Errorf("expected ErrXGroupNoStream, got %v", err)
+	}
+	if _, err := s.XGroupCreate("st", "g", "$", true); err != nil {
+		t.Fatalf("XGroupCreate failed: %v", err)
+	}
+	if _, err := s.XGroupCreate("st", "g", "$", true); err != store.ErrBusyGroup {
+		t.Errorf("expected ErrBusyGroup, got %v", err)
+	}
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		s.XAdd("st", id, []string{"f", id}, store.XAddOptions{})
+	}
+
+	results, err := s.XReadGroup("g", "alice", []string{"st"}, []string{">"}, 2, -1, false)
+	if err != nil || len(results) != 1 || len(results[0].Entries) != 2 {
+		t.Fatalf("unexpected XReadGroup result %v, %v", results, err)
+	}
+	results, _ = s.XReadGroup("g", "bob", []string{"st"}, []string{">"}, 0, -1, false)
+	if got := streamIDs(results[0].Entries); len(got) != 1 || got[0] != "3-0" {
+		t.Errorf("expected bob to get 3-0, got %v", got)
+	}
+
+	summary, _ := s.XPending("st", "g")
+	if summary.Count != 3 || len(summary.Consumers) != 2 || summary.Consumers[0].Count != 2 {
+		t.Errorf("unexpected pending summary %+v", summary)
+	}
+
+	// Reading the history replays alice's pending entries without new deliveries.
+	history, _ := s.XReadGroup("g", "alice", []string{"st"}, []string{"0"}, 0, -1, false)
+	if got := streamIDs(history[0].Entries); len(got) != 2 || got[0] != "1-0" {
+		t.Errorf("unexpected history %v", got)
+	}
+
+	if n, _ := s.XAck("st", "g", []store.StreamID{{Ms: 1}, {Ms: 9}}); n != 1 {
+		t.Errorf("expected 1 acknowledged entry, got %d", n)
+	}
+	pending, _ := s.XPendingRange("st", "g", store.StreamID{}, store.MaxStreamID, 10, "alice", 0)
+	if len(pending) != 1 || pending[0].ID.String() != "2-0" || pending[0].DeliveryCount != 1 {
+		t.Errorf("unexpected pending entries %+v", pending)
+	}
+
+	if _, err := s.XReadGroup("nope", "alice", []string{"st"}, []string{">"}, 0, -1, false); err == nil {
+		t.Error("expected NOGROUP error")
+	}
+}
+
+func TestStreamClaim(t *testing.T) {
+	s := newTestStore(t)
+	s.XGroupCreate("st", "g", "0", true)
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		s.XAdd("st", id, []string{"f", id}, store.XAddOptions{})
+	}
+	s.XReadGroup("g", "alice", []string{"st"}, []string{">"}, 0, -1, false)
+
+	result, _ := s.XClaim("st", "g", "bob", time.Hour, []store.StreamID{{Ms: 1}}, store.XClaimOptions{})
+	if len(result.Entries) != 0 {
+		t.Errorf("expected entries not idle enough to stay with alice, got %v", result.Entries)
+	}
+	result, _ = s.XClaim("st", "g", "bob", 0, []store.StreamID{{Ms: 1}}, store.XClaimOptions{})
+	if len(result.Entries) != 1 || result.Pending[0].DeliveryCount != 2 {
+		t.Errorf("unexpected claim %+v", result)
+	}
+
+	s.XDel("st", []store.StreamID{{Ms: 2}})
+	auto, _ := s.XAutoClaim("st", "g", "carol", 0, store.StreamID{}, 1, false)
+	if got := streamIDs(auto.Entries); len(got) != 1 || got[0] != "1-0" || auto.Next.String() != "2-0" {
+		t.Errorf("unexpected first XAutoClaim %v, next %s", got, auto.Next)
+	}
+	auto, _ = s.XAutoClaim("st", "g", "carol", 0, auto.Next, 10, true)
+	if got := streamIDs(auto.Entries); len(got) != 1 || got[0] != "3-0" || !auto.Next.IsZero() {
+		t.Errorf("unexpected second XAutoClaim %v, next %s", got, auto.Next)
+	}
+	if len(auto.Deleted) != 1 || auto.Deleted[0].String() != "2-0" {
+		t.Errorf("expected 2-0 to be reported deleted, got %v", auto.Deleted)
+	}
+	summary, _ := s.XPending("st", "g")
+	if summary.Count != 2 || summary.Consumers[0].Name != "carol" {
+		t.Errorf("unexpected pending summary %+v", summary)
+	}
+}
+
+func TestStreamSnapshotAndReplay(t *testing.T) {
+	s := newTestStore(t)
+	s.XGroupCreate("st", "g", "0", true)
+	s.XAdd("st", "1-0", []string{"a", "1"}, store.XAddOptions{})
+	s.XAdd("st", "2-0", []string{"b", "2"}, store.XAddOptions{})
+	s.XReadGroup("g", "alice", []string{"st"}, []string{">"}, 1, -1, false)
+
+	var buf bytes.Buffer
+	if err := s.Dump(&buf); err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	s2 := newTestStore(t)
+	if err := s2.Restore(&buf); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if n, _ := s2.XLen("st"); n != 2 {
+		t.Errorf("expected 2 restored entries, got %d", n)
+	}
+	summary, err := s2.XPending("st", "g")
+	if err != nil || summary.Count != 1 || summary.Consumers[0].Name != "alice" {
+		t.Errorf("unexpected restored PEL %+v, %v", summary, err)
+	}
+
+	// Replay the commands the server propagates for an XREADGROUP delivery.
+	for _, parts := range [][]string{
+		{"XADD", "st", "MAXLEN", "=", "2", "3-0", "c", "3"},
+		{"XCLAIM", "st", "g", "bob", "0", "2-0", "TIME", "1000", "RETRYCOUNT", "1", "FORCE", "JUSTID", "LASTID", "2-0"},
+		{"XACK", "st", "g", "1-0"},
+	} {
+		if err := store.ApplyCommand(s2, parts); err != nil {
+			t.Fatalf("ApplyCommand %v failed: %v", parts, err)
+		}
+	}
+	pending, _ := s2.XPendingRange("st", "g", store.StreamID{}, store.MaxStreamID, 10, "", 0)
+	if len(pending) != 1 || pending[0].Consumer != "bob" || pending[0].ID.String() != "2-0" {
+		t.Errorf("unexpected replayed PEL %+v", pending)
+	}
+	results, _ := s2.XReadGroup("g", "bob", []string{"st"}, []string{">"}, 0, -1, false)
+	if got := streamIDs(results[0].Entries); len(got) != 1 || got[0] != "3-0" {
+		t.Errorf("expected LASTID to move the group past 2-0, got %v", got)
+	}
+}
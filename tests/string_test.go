@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func TestIncrIsAtomic(t *testing.T) {
+	s := newTestStore(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.IncrBy("counter", 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, _ := s.IncrBy("counter", -5000); n != 0 {
+		t.Errorf("expected 0 after concurrent increments, got %d", n)
+	}
+}
+
+func TestIncrErrors(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("text", []byte("abc"), 0)
+	s.Set("max", []byte("9223372036854775807"), 0)
+	s.HSet("h", map[string][]byte{"f": []byte("1")})
+
+	if _, err := s.IncrBy("text", 1); err != store.ErrNotInt {
+		t.Errorf("expected ErrNotInt, got %v", err)
+	}
+	if _, err := s.IncrBy("max", 1); err != store.ErrOverflow {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+	if _, err := s.IncrBy("h", 1); err != store.ErrWrongType {
+		t.Errorf("expected ErrWrongType, got %v", err)
+	}
+	if _, err := s.IncrByFloat("text", 1); err != store.ErrNotFloat {
+		t.Errorf("expected ErrNotFloat, got %v", err)
+	}
+	if v, _ := s.IncrByFloat("f", 10.5); string(v) != "10.5" {
+		t.Errorf("expected 10.5, got %s", v)
+	}
+	if v, _ := s.IncrByFloat("f", 0.1); string(v) != "10.6" {
+		t.Errorf("expected 10.6, got %s", v)
+	}
+}
+
+func TestStringCommandsKeepTTL(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("k", []byte("10"), time.Hour)
+
+	s.IncrBy("k", 5)
+	s.Append("k", []byte("0"))
+	if n, _ := s.SetRange("k", 1, []byte("9")); n != 3 {
+		t.Errorf("expected length 3, got %d", n)
+	}
+	item, _ := s.Get("k")
+	if string(item.Value) != "190" || item.ExpiresAt.IsZero() {
+		t.Errorf("expected 190 with a TTL, got %q expiring at %v", item.Value, item.ExpiresAt)
+	}
+
+	if old, _ := s.GetSet("k", []byte("new")); string(old) != "190" {
+		t.Errorf("expected GetSet to return 190, got %q", old)
+	}
+	if item, _ := s.Get("k"); !item.ExpiresAt.IsZero() {
+		t.Error("expected GetSet to discard the TTL")
+	}
+}
+
+func TestStringRanges(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("k", []byte("Hello World"), 0)
+
+	for _, tc := range []struct {
+		start, end int
+		want       string
+	}{
+		{0, 4, "Hello"},
+		{-5, -1, "World"},
+		{-3, -5, ""},
+		{5, 100, " World"},
+		{20, 30, ""},
+	} {
+		if v, _ := s.GetRange("k", tc.start, tc.end); string(v) != tc.want {
+			t.Errorf("GetRange(%d, %d) = %q, want %q", tc.start, tc.end, v, tc.want)
+		}
+	}
+
+	if n, _ := s.SetRange("pad", 3, []byte("x")); n != 4 {
+		t.Errorf("expected padded length 4, got %d", n)
+	}
+	if v, _ := s.GetRange("pad", 0, -1); string(v) != "\x00\x00\x00x" {
+		t.Errorf("expected zero padding, got %q", v)
+	}
+	if _, err := s.SetRange("pad", -1, []byte("x")); err != store.ErrOffsetRange {
+		t.Errorf("expected ErrOffsetRange, got %v", err)
+	}
+	for _, offset := range []int{store.MaxStringLength, math.MaxInt} {
+		if _, err := s.SetRange("pad", offset, []byte("x")); err != store.ErrStringTooLong {
+			t.Errorf("SetRange at %d: expected ErrStringTooLong, got %v", offset, err)
+		}
+	}
+	if n, _ := s.StrLen("missing"); n != 0 {
+		t.Errorf("expected 0 for a missing key, got %d", n)
+	}
+}
+
+func TestGetDelAndGetEx(t *testing.T) {
+	s := newTestStore(t)
+	s.Set("k", []byte("v"), 0)
+
+	if v, _ := s.GetEx("k", time.Now().Add(time.Hour), false); string(v) != "v" {
+		t.Errorf("expected v, got %q", v)
+	}
+	if item, _ := s.Get("k"); item.ExpiresAt.IsZero() {
+		t.Error("expected GetEx to set a TTL")
+	}
+	s.GetEx("k", time.Time{}, true)
+	if item, _ := s.Get("k"); !item.ExpiresAt.IsZero() {
+		t.Error("expected PERSIST to remove the TTL")
+	}
+
+	if v, _ := s.GetDel("k"); string(v) != "v" {
+		t.Errorf("expected v, got %q", v)
+	}
+	if v, _ := s.GetDel("k"); v != nil {
+		t.Errorf("expected nil after GetDel, got %q", v)
+	}
+
+	s.Set("k", []byte("v"), 0)
+	if err := store.ApplyCommand(s, []string{"GETEX", "k", "PXAT", "1000"}); err != nil {
+		t.Fatalf("ApplyCommand failed: %v", err)
+	}
+	if item, _ := s.Get("k"); item != nil {
+		t.Error("expected a PXAT in the past to delete the key")
+	}
+
+	// Times whose timestamp in milliseconds overflows are refused like SET's.
+	s.Set("k", []byte("v"), 0)
+	for _, args := range [][]string{
+		{"EX", "9223372036854775807"},
+		{"PX", "9223372036854775807"},
+		{"EXAT", "9223372036854775807"},
+	} {
+		reply := runClient(t, s, append([]string{"GETEX", "k"}, args...))
+		if reply != "-ERR invalid expire time in 'getex' command\r\n" {
+			t.Errorf("GETEX k %v: expected an invalid expire time, got %q", args, reply)
+		}
+	}
+	if item, _ := s.Get("k"); item == nil || !item.ExpiresAt.IsZero() {
+		t.Error("expected the refused GETEX to leave the key untouched")
+	}
+}
+
+func TestSetOptionGrammar(t *testing.T) {
+	for _, args := range [][]string{
+		{"NX", "XX"},
+		{"EX", "10", "PX", "10"},
+		{"KEEPTTL", "EX", "10"},
+		{"EX"},
+		{"BOGUS"},
+	} {
+		if _, err := store.ParseSetArgs(args); err != store.ErrSyntax {
+			t.Errorf("ParseSetArgs(%v): expected ErrSyntax, got %v", args, err)
+		}
+	}
+	if _, err := store.ParseSetArgs([]string{"EX", "0"}); err != store.ErrSetExpireTime {
+		t.Errorf("expected ErrSetExpireTime, got %v", err)
+	}
+	if _, err := store.ParseSetArgs([]string{"PX", "ten"}); err != store.ErrNotInt {
+		t.Errorf("expected ErrNotInt, got %v", err)
+	}
+	opts, err := store.ParseSetArgs([]string{"nx", "GET", "PXAT", "4102444800000"})
+	if err != nil || !opts.NX || !opts.Get || opts.ExpiresAt.UnixMilli() != 4102444800000 {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+	// The legacy bare seconds form is still accepted.
+	if opts, err := store.ParseSetArgs([]string{"10"}); err != nil || opts.ExpiresAt.IsZero() {
+		t.Errorf("unexpected legacy options %+v, %v", opts, err)
+	}
+}
+
+func TestSetExpireOverflow(t *testing.T) {
+	// Every expiry is propagated as a PXAT in milliseconds, so one that does not
+	// fit in 64 bits must be refused instead of wrapping around to the past.
+	for _, args := range [][]string{
+		{"EX", "99999999999999999"},
+		{"99999999999999999"},
+		{"PX", "9223372036854775000"},
+		{"EXAT", "9223372036854776"},
+	} {
+		if _, err := store.ParseSetArgs(args); err != store.ErrSetExpireTime {
+			t.Errorf("ParseSetArgs(%v): expected ErrSetExpireTime, got %v", args, err)
+		}
+	}
+	if _, err := store.ParseSetArgs([]string{"PXAT", "9223372036854775808"}); err != store.ErrNotInt {
+		t.Errorf("expected a PXAT beyond 64 bits to be refused, got %v", err)
+	}
+	opts, err := store.ParseSetArgs([]string{"PXAT", "9223372036854775807"})
+	if err != nil || opts.ExpiresAt.UnixMilli() != math.MaxInt64 {
+		t.Errorf("expected the largest PXAT to be kept as is, got %v, %v", opts.ExpiresAt.UnixMilli(), err)
+	}
+	opts, err = store.ParseSetArgs([]string{"EXAT", "9223372036854775"})
+	if err != nil || opts.ExpiresAt.UnixMilli() != 9223372036854775000 {
+		t.Errorf("expected the largest EXAT to be kept as is, got %v, %v", opts.ExpiresAt.UnixMilli(), err)
+	}
+}
+
+func TestSetConditionalWrites(t *testing.T) {
+	s := newTestStore(t)
+
+	if _, ok, _ := s.SetWithOptions("lock", []byte("a"), store.SetOptions{NX: true, ExpiresAt: time.Now().Add(time.Hour)}); !ok {
+		t.Fatal("expected NX to write a missing key")
+	}
+	if _, ok, _ := s.SetWithOptions("lock", []byte("b"), store.SetOptions{NX: true}); ok {
+		t.Error("expected NX to skip an existing key")
+	}
+	if _, ok, _ := s.SetWithOptions("missing", []byte("b"), store.SetOptions{XX: true}); ok {
+		t.Error("expected XX to skip a missing key")
+	}
+
+	old, ok, _ := s.SetWithOptions("lock", []byte("c"), store.SetOptions{XX: true, KeepTTL: true, Get: true})
+	if !ok || string(old) != "a" {
+		t.Errorf("expected XX GET to return a, got %q (written %v)", old, ok)
+	}
+	if item, _ := s.Get("lock"); string(item.Value) != "c" || item.ExpiresAt.IsZero() {
+		t.Errorf("expected c with its TTL kept, got %q expiring at %v", item.Value, item.ExpiresAt)
+	}
+	s.SetWithOptions("lock", []byte("d"), store.SetOptions{})
+	if item, _ := s.Get("lock"); !item.ExpiresAt.IsZero() {
+		t.Error("expected a plain SET to clear the TTL")
+	}
+
+	s.SAdd("set", []string{"m"})
+	if _, _, err := s.SetWithOptions("set", []byte("v"), store.SetOptions{Get: true}); err != store.ErrWrongType {
+		t.Errorf("expected ErrWrongType for GET on a set, got %v", err)
+	}
+	if _, ok, _ := s.SetWithOptions("set", []byte("v"), store.SetOptions{}); !ok {
+		t.Error("expected SET without GET to overwrite a set")
+	}
+}
+
+func TestSetReplayUsesAbsoluteExpiry(t *testing.T) {
+	s := newTestStore(t)
+	if err := store.ApplyCommand(s, []string{"SET", "old", "v", "PXAT", "1000"}); err != nil {
+		t.Fatalf("ApplyCommand failed: %v", err)
+	}
+	if item, _ := s.Get("old"); item != nil {
+		t.Error("expected a key replayed with a past PXAT to be expired")
+	}
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	store.ApplyCommand(s, []string{"SET", "k", "v", "PXAT", strconv.FormatInt(deadline.UnixMilli(), 10)})
+	if item, _ := s.Get("k"); item == nil || !item.ExpiresAt.Equal(deadline) {
+		t.Errorf("expected expiry %v, got %v", deadline, item)
+	}
+}
@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/replication"
+	"github.com/PetarGeorgiev-hash/flashdb/tlsconfig"
+)
+
+// testCA signs the certificates of the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "flashdb test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate for 127.0.0.1 named name, usable by servers and
+// clients, and its key to dir, and returns the paths of the files.
+func (ca *testCA) issue(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadTestCertificates issues a certificate named first in a new directory and loads it with its CA.
+func loadTestCertificates(t *testing.T, clientAuth tls.ClientAuthType) (*tlsconfig.Certificates, *testCA, string) {
+	t.Helper()
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+	certFile, keyFile := ca.issue(t, dir, "first")
+	certs, err := tlsconfig.Load(tlsconfig.Options{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ClientAuth: clientAuth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs, ca, dir
+}
+
+// startTLSListener accepts TLS connections and replies +OK to those whose handshake succeeds.
+func startTLSListener(t *testing.T, certs *tlsconfig.Certificates) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", certs.ServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if conn.(*tls.Conn).Handshake() == nil {
+				conn.Write([]byte("+OK\r\n"))
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// dialTLS connects to addr and returns the name of the server's certificate, or the error.
+func dialTLS(addr string, config *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// With TLS 1.3 a refused client certificate shows on the first read.
+	if _, err := conn.Read(make([]byte, 5)); err != nil {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	certs, _, _ := loadTestCertificates(t, tls.RequireAndVerifyClientCert)
+	addr := startTLSListener(t, certs)
+
+	if name, err := dialTLS(addr, certs.ClientConfig(addr)); err != nil || name != "first" {
+		t.Fatalf("expected a client with a certificate to connect, got %q, %v", name, err)
+	}
+	withoutCert := certs.ClientConfig(addr)
+	withoutCert.Certificates = nil
+	if _, err := dialTLS(addr, withoutCert); err == nil {
+		t.Error("expected a client without a certificate to be refused")
+	}
+	untrusted := certs.ClientConfig(addr)
+	untrusted.RootCAs = x509.NewCertPool()
+	if _, err := dialTLS(addr, untrusted); err == nil {
+		t.Error("expected the server certificate to be checked against the CA")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	certs, ca, dir := loadTestCertificates(t, tls.NoClientCert)
+	addr := startTLSListener(t, certs)
+
+	ca.issue(t, dir, "second")
+	if name, err := dialTLS(addr, certs.ClientConfig(addr)); err != nil || name != "first" {
+		t.Fatalf("expected the loaded certificate until the reload, got %q, %v", name, err)
+	}
+	if err := certs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := dialTLS(addr, certs.ClientConfig(addr)); err != nil || name != "second" {
+		t.Fatalf("expected the reloaded certificate, got %q, %v", name, err)
+	}
+
+	writeFile(t, filepath.Join(dir, "server.key"), []byte("not a key"))
+	if err := certs.Reload(); err == nil {
+		t.Fatal("expected an invalid key to be refused")
+	}
+	if name, err := dialTLS(addr, certs.ClientConfig(addr)); err != nil || name != "second" {
+		t.Errorf("expected a failed reload to keep the certificate, got %q, %v", name, err)
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	for value, want := range map[string]tls.ClientAuthType{
+		"no":       tls.NoClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+		"YES":      tls.RequireAndVerifyClientCert,
+	} {
+		if got, err := tlsconfig.ParseClientAuth(value); err != nil || got != want {
+			t.Errorf("%s: expected %v, got %v, %v", value, want, got, err)
+		}
+	}
+	if _, err := tlsconfig.ParseClientAuth("maybe"); err == nil {
+		t.Error("expected an invalid value to be refused")
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := newTestCA(t).issue(t, dir, "first")
+	if _, err := tlsconfig.Load(tlsconfig.Options{CertFile: certFile, KeyFile: keyFile, ClientAuth: tls.RequireAndVerifyClientCert}); err == nil {
+		t.Error("expected client authentication without a CA to be refused")
+	}
+	if _, err := tlsconfig.Load(tlsconfig.Options{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")}); err == nil {
+		t.Error("expected a missing key to be refused")
+	}
+}
+
+func TestReplicationOverTLS(t *testing.T) {
+	certs, _, _ := loadTestCertificates(t, tls.RequireAndVerifyClientCert)
+	master, replica := newTestStore(t), newTestStore(t)
+	master.Set("k", []byte("v"), 0)
+	manager := replication.NewManager(master)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", certs.ServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go manager.HandleReplicationConn(conn)
+		}
+	}()
+	addr := ln.Addr().String()
+	status := &replication.ReplicaStatus{}
+	go replication.StartReplica(addr, replica, certs.ClientConfig(addr), status)
+
+	waitFor := func(key, want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if item, err := replica.Get(key); err == nil && item != nil && string(item.Value) == want {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected the replica to receive %s=%s", key, want)
+	}
+	waitFor("k", "v")
+	manager.Broadcast([]string{"SET", "k2", "v2"})
+	waitFor("k2", "v2")
+	if !status.Connected() || status.Offset() != manager.Info().Offset {
+		t.Errorf("expected the replica to be connected at offset %d, got %v at %d", manager.Info().Offset, status.Connected(), status.Offset())
+	}
+}
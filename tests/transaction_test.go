@@ -0,0 +1,292 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/aof"
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+// runTransaction sends commands through tx the way the server does and returns the replies.
+func runTransaction(t *testing.T, s store.IStore, tx *cmd.Transaction, aofWriter aof.IAOF, commands ...[]string) string {
+	t.Helper()
+	var replies bytes.Buffer
+	w := protocol.NewWriter(&replies, protocol.Fixed(protocol.RESP2))
+	for _, parts := range commands {
+		if !tx.Handle(w, s, parts, aofWriter, nil) {
+			cmd.Run(cmd.CommandHandlers[strings.ToUpper(parts[0])], w, s, parts, aofWriter, nil)
+		}
+	}
+	w.Flush()
+	return replies.String()
+}
+
+func TestWatchVersions(t *testing.T) {
+	s := newTestStore(t)
+	s.HSet("hash", map[string][]byte{"f": []byte("v")})
+	keys := []string{"hash", "missing"}
+	versions := s.Watch(keys)
+	defer s.Unwatch(keys)
+
+	s.Set("other", []byte("v"), 0)
+	s.HGet("hash", "f")
+	if s.Modified(keys, versions) {
+		t.Fatal("expected reads and writes of other keys to leave the watched keys unmodified")
+	}
+	// Hashes are modified in place, the write still counts.
+	s.HSet("hash", map[string][]byte{"f": []byte("w")})
+	if !s.Modified(keys, versions) {
+		t.Error("expected HSET to modify the watched hash")
+	}
+
+	versions = s.Watch([]string{"missing"})
+	defer s.Unwatch([]string{"missing"})
+	s.Flush()
+	if !s.Modified([]string{"missing"}, versions) {
+		t.Error("expected FLUSHALL to modify every watched key")
+	}
+
+	s.Set("volatile", []byte("v"), 20*time.Millisecond)
+	versions = s.Watch([]string{"volatile"})
+	defer s.Unwatch([]string{"volatile"})
+	time.Sleep(30 * time.Millisecond)
+	if !s.Modified([]string{"volatile"}, versions) {
+		t.Error("expected a watched key expiring to count as modified")
+	}
+}
+
+func TestExecIsPropagatedAsOneBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx.aof")
+	a, err := aof.NewAOF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	s := newTestStore(t)
+
+	replies := runTransaction(t, s, &cmd.Transaction{}, a,
+		[]string{"MULTI"},
+		[]string{"SET", "counter", "1"},
+		[]string{"INCR", "counter"},
+		[]string{"GET", "counter"},
+		[]string{"EXEC"},
+	)
+	want := "+OK\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n*3\r\n+OK\r\n:2\r\n$1\r\n2\r\n"
+	if replies != want {
+		t.Fatalf("unexpected replies %q, want %q", replies, want)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), "*1\r\n$5\r\nMULTI\r\n") || !strings.HasSuffix(string(data), "*1\r\n$4\r\nEXEC\r\n") {
+		t.Errorf("expected the writes wrapped in MULTI/EXEC, got %q", data)
+	}
+	replayed := newTestStore(t)
+	if err := a.LoadAOF(path, replayed); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := replayed.Get("counter"); item == nil || string(item.Value) != "2" {
+		t.Errorf("expected counter=2 after replay, got %v", item)
+	}
+}
+
+// slowAOF takes its time appending the commands named slow, as a busy disk would.
+type slowAOF struct {
+	aof.IAOF
+	slow string
+}
+
+func (a *slowAOF) AppendCommand(args ...string) error {
+	if strings.EqualFold(args[0], a.slow) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return a.IAOF.AppendCommand(args...)
+}
+
+func TestServedBlockingPopIsPropagatedAfterThePush(t *testing.T) {
+	tests := []struct {
+		block, push []string
+	}{
+		{[]string{"BLPOP", "jobs", "0"}, []string{"LPUSH", "jobs", "a"}},
+		{[]string{"BLMOVE", "jobs", "done", "LEFT", "RIGHT", "0"}, []string{"RPUSH", "jobs", "a"}},
+		{[]string{"BZPOPMIN", "jobs", "0"}, []string{"ZADD", "jobs", "1", "a"}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "blocking.aof")
+		a, err := aof.NewAOF(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slow := &slowAOF{IAOF: a, slow: tt.push[0]}
+		s := newTestStore(t)
+
+		done := make(chan struct{})
+		go func() {
+			runTransaction(t, s, &cmd.Transaction{}, slow, tt.block)
+			close(done)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		runTransaction(t, s, &cmd.Transaction{}, slow, tt.push)
+		<-done
+		a.Close()
+
+		replayed := newTestStore(t)
+		if err := a.LoadAOF(path, replayed); err != nil {
+			t.Fatal(err)
+		}
+		if n := replayed.Exists([]string{"jobs"}); n != 0 {
+			data, _ := os.ReadFile(path)
+			t.Errorf("%s: expected the pop to replay after the push, got %q", tt.block[0], data)
+		}
+	}
+}
+
+func TestWatchAbortsExec(t *testing.T) {
+	s := newTestStore(t)
+	tx := &cmd.Transaction{}
+	runTransaction(t, s, tx, nil, []string{"WATCH", "balance"})
+	s.Set("balance", []byte("100"), 0)
+
+	replies := runTransaction(t, s, tx, nil,
+		[]string{"MULTI"},
+		[]string{"SET", "balance", "0"},
+		[]string{"EXEC"},
+	)
+	if !strings.HasSuffix(replies, "*-1\r\n") {
+		t.Fatalf("expected EXEC to return a null array, got %q", replies)
+	}
+	if item, _ := s.Get("balance"); string(item.Value) != "100" {
+		t.Errorf("expected the transaction to be discarded, balance is %s", item.Value)
+	}
+
+	// EXEC unwatches, the next transaction goes through.
+	replies = runTransaction(t, s, tx, nil,
+		[]string{"MULTI"},
+		[]string{"SET", "balance", "0"},
+		[]string{"EXEC"},
+	)
+	if !strings.HasSuffix(replies, "*1\r\n+OK\r\n") {
+		t.Errorf("expected the second EXEC to run, got %q", replies)
+	}
+}
+
+func TestExecAbortAndErrors(t *testing.T) {
+	s := newTestStore(t)
+	tx := &cmd.Transaction{}
+	replies := runTransaction(t, s, tx, nil,
+		[]string{"EXEC"},
+		[]string{"MULTI"},
+		[]string{"MULTI"},
+		[]string{"WATCH", "k"},
+		[]string{"SET", "k", "v"},
+		[]string{"NOSUCHCOMMAND"},
+		[]string{"EXEC"},
+		[]string{"DISCARD"},
+	)
+	want := "-ERR EXEC without MULTI\r\n+OK\r\n-ERR MULTI calls can not be nested\r\n" +
+		"-ERR WATCH inside MULTI is not allowed\r\n+QUEUED\r\n-ERR unknown command\r\n" +
+		"-EXECABORT Transaction discarded because of previous errors.\r\n-ERR DISCARD without MULTI\r\n"
+	if replies != want {
+		t.Fatalf("unexpected replies %q, want %q", replies, want)
+	}
+	if s.Exists([]string{"k"}) != 0 {
+		t.Error("expected the aborted transaction to leave the store unchanged")
+	}
+
+	// Blocking commands do not block inside a transaction.
+	replies = runTransaction(t, s, tx,
+		nil,
+		[]string{"MULTI"},
+		[]string{"BLPOP", "empty", "0"},
+		[]string{"EXEC"},
+	)
+	if !strings.HasSuffix(replies, "*1\r\n*-1\r\n") {
+		t.Errorf("expected BLPOP to time out right away, got %q", replies)
+	}
+}
+
+func TestLoadAOFDropsIncompleteTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crash.aof")
+	content := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n" +
+		"*1\r\n$5\r\nMULTI\r\n" +
+		"*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n" +
+		"*3\r\n$3\r\nSET\r\n$1\r\nc\r\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := aof.NewAOF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	s := newTestStore(t)
+	if err := a.LoadAOF(path, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Exists([]string{"a"}) != 1 || s.Exists([]string{"b", "c"}) != 0 {
+		t.Error("expected only the writes before the unfinished MULTI to be replayed")
+	}
+}
+
+// slowStore makes the first GET of an EXEC last while the keys of the EXEC expire.
+type slowStore struct {
+	store.IStore
+	during func()
+}
+
+func (s *slowStore) Get(key string) (*store.Item, error) {
+	item, err := s.IStore.Get(key)
+	if s.during != nil {
+		s.during()
+		s.during = nil
+	}
+	return item, err
+}
+
+func TestExecIsNotInterruptedByExpiryOrEviction(t *testing.T) {
+	s := newTestStore(t)
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("volatile:%d", i), []byte("v"), 50*time.Millisecond)
+		s.Set(fmt.Sprintf("plain:%d", i), []byte("v"), 0)
+	}
+	// Low enough that expiring the volatile keys is not enough.
+	s.SetMaxMemory(s.UsedMemory()/4, store.AllKeysLRU)
+
+	evicted := make(chan []string)
+	slow := &slowStore{IStore: s}
+	slow.during = func() {
+		go func() {
+			keys, _ := s.Evict()
+			evicted <- keys
+		}()
+		time.Sleep(3 * store.ExpireCycleInterval)
+		if stats := s.Stats(); stats.ExpiredKeys != 0 || s.MemoryStats().EvictedKeys != 0 {
+			t.Errorf("expected no key to expire or be evicted during EXEC, got %d expired and %d evicted", stats.ExpiredKeys, s.MemoryStats().EvictedKeys)
+		}
+	}
+	got := runTransaction(t, slow, &cmd.Transaction{}, nil,
+		[]string{"MULTI"},
+		[]string{"GET", "volatile:0"},
+		[]string{"DBSIZE"},
+		[]string{"EXEC"},
+	)
+	want := "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n$1\r\nv\r\n:200\r\n"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// Once the EXEC is over, the keys go.
+	if keys := <-evicted; len(keys) == 0 {
+		t.Error("expected the eviction to run after EXEC")
+	}
+	if !waitFor(2*time.Second, func() bool { return s.Stats().Expires == 0 }) {
+		t.Errorf("expected the volatile keys to expire after EXEC, %d left", s.Stats().Expires)
+	}
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/PetarGeorgiev-hash/flashdb/cmd"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+// countingConn records what is written to it and in how many calls.
+type countingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes int
+	data   strings.Builder
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	return c.data.Write(b)
+}
+
+func TestPipelinedRepliesAreBuffered(t *testing.T) {
+	s := newTestStore(t)
+	server, _ := net.Pipe()
+	conn := &countingConn{Conn: server}
+	client := cmd.NewClient(conn, newTestServer())
+	defer client.Close()
+	w := client.Replies()
+	for _, parts := range [][]string{
+		{"SET", "k", "v"},
+		{"GET", "k"},
+		{"GET", "missing"},
+		{"HGETALL", "missing"},
+		{"INCR", "n"},
+	} {
+		cmd.Run(cmd.CommandHandlers[parts[0]], w, s, parts, nil, nil)
+	}
+	if conn.writes != 0 {
+		t.Fatalf("expected nothing to be written before Flush, got %d writes", conn.writes)
+	}
+	w.Flush()
+	if conn.writes != 1 {
+		t.Errorf("expected the pipeline to be answered in one write, got %d", conn.writes)
+	}
+	if got, want := conn.data.String(), "+OK\r\n$1\r\nv\r\n$-1\r\n*0\r\n:1\r\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// A blocking command sends what is buffered before it waits.
+	cmd.Run(cmd.CommandHandlers["SET"], w, s, []string{"SET", "k", "w"}, nil, nil)
+	cmd.Run(cmd.CommandHandlers["BLPOP"], w, s, []string{"BLPOP", "list", "0.01"}, nil, nil)
+	if conn.writes != 2 || !strings.HasSuffix(conn.data.String(), "+OK\r\n") {
+		t.Errorf("expected the SET reply to be flushed before BLPOP blocked, got %q", conn.data.String())
+	}
+}
+
+func TestHandlersWriteToAnyReplyWriter(t *testing.T) {
+	s := newTestStore(t)
+	s.HSet("hash", map[string][]byte{"f": []byte("v")})
+	s.ZAdd("zset", []store.ZMember{{Member: "a", Score: 1.5}}, store.ZAddOptions{})
+
+	var replies bytes.Buffer
+	w := protocol.NewWriter(&replies, protocol.Fixed(protocol.RESP3))
+	for _, parts := range [][]string{
+		{"HGETALL", "hash"},
+		{"ZSCORE", "zset", "a"},
+		{"GET", "missing"},
+		{"LPUSH", "hash", "x"},
+	} {
+		cmd.Run(cmd.CommandHandlers[parts[0]], w, s, parts, nil, nil)
+	}
+	w.Flush()
+	want := "%1\r\n$1\r\nf\r\n$1\r\nv\r\n" + ",1.5\r\n" + "_\r\n" +
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	if got := replies.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAppendCommand(t *testing.T) {
+	buf := protocol.AppendCommand(nil, "MULTI")
+	buf = protocol.AppendCommand(buf, "SET", "key", "two words\r\n")
+	if got, want := string(buf), "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$11\r\ntwo words\r\n\r\n"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	commands, err := parseAll(string(buf), protocol.Limits{})
+	if err != nil || len(commands) != 2 || commands[1] != "SET key two words\r\n" {
+		t.Errorf("expected the commands to parse back, got %q, %v", commands, err)
+	}
+}
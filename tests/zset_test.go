@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/PetarGeorgiev-hash/flashdb/store"
+)
+
+func zmembers(pairs ...any) []store.ZMember {
+	members := make([]store.ZMember, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		members = append(members, store.ZMember{Score: float64(pairs[i].(int)), Member: pairs[i+1].(string)})
+	}
+	return members
+}
+
+func names(members []store.ZMember) []string {
+	out := make([]string, len(members))
+	for i, m := range members {
+		out[i] = m.Member
+	}
+	return out
+}
+
+func TestZSetRanksMatchSortedOrder(t *testing.T) {
+	s := newTestStore(t)
+
+	expected := make([]store.ZMember, 0, 500)
+	for i := 0; i < 500; i++ {
+		m := store.ZMember{Member: fmt.Sprintf("m%03d", i), Score: float64(rand.Intn(50))}
+		expected = append(expected, m)
+		s.ZAdd("z", []store.ZMember{m}, store.ZAddOptions{})
+	}
+	// Remove a few members to exercise span bookkeeping on delete.
+	for i := 0; i < 100; i += 7 {
+		s.ZRem("z", []string{expected[i].Member})
+		expected[i].Member = ""
+	}
+	kept := expected[:0]
+	for _, m := range expected {
+		if m.Member != "" {
+			kept = append(kept, m)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if kept[i].Score != kept[j].Score {
+			return kept[i].Score < kept[j].Score
+		}
+		return kept[i].Member < kept[j].Member
+	})
+
+	for i, m := range kept {
+		rank, ok, _ := s.ZRank("z", m.Member, false)
+		if !ok || rank != i {
+			t.Fatalf("expected rank %d for %s, got %d", i, m.Member, rank)
+		}
+	}
+	all, _ := s.ZRangeByRank("z", 0, -1, false)
+	if len(all) != len(kept) {
+		t.Fatalf("expected %d members, got %d", len(kept), len(all))
+	}
+	for i := range kept {
+		if all[i] != kept[i] {
+			t.Fatalf("position %d: expected %v, got %v", i, kept[i], all[i])
+		}
+	}
+
+	r := store.ScoreRange{Min: store.ScoreBound{Value: 10}, Max: store.ScoreBound{Value: 20, Exclusive: true}}
+	want := 0
+	for _, m := range kept {
+		if m.Score >= 10 && m.Score < 20 {
+			want++
+		}
+	}
+	if n, _ := s.ZCount("z", r); n != want {
+		t.Errorf("expected ZCount %d, got %d", want, n)
+	}
+	if got, _ := s.ZRangeByScore("z", r, false, 0, -1); len(got) != want {
+		t.Errorf("expected %d members in score range, got %d", want, len(got))
+	}
+}
+
+func TestZSetRanges(t *testing.T) {
+	s := newTestStore(t)
+	s.ZAdd("z", zmembers(1, "a", 2, "b", 3, "c", 4, "d", 5, "e"), store.ZAddOptions{})
+
+	got, _ := s.ZRangeByRank("z", 0, 1, true)
+	if fmt.Sprint(names(got)) != "[e d]" {
+		t.Errorf("unexpected reverse rank range %v", names(got))
+	}
+
+	all := store.ScoreRange{Min: store.ScoreBound{Value: math.Inf(-1)}, Max: store.ScoreBound{Value: math.Inf(1)}}
+	got, _ = s.ZRangeByScore("z", all, false, 1, 2)
+	if fmt.Sprint(names(got)) != "[b c]" {
+		t.Errorf("unexpected limited score range %v", names(got))
+	}
+	got, _ = s.ZRangeByScore("z", all, true, 1, 2)
+	if fmt.Sprint(names(got)) != "[d c]" {
+		t.Errorf("unexpected reverse limited score range %v", names(got))
+	}
+
+	s.ZAdd("lex", zmembers(0, "apple", 0, "banana", 0, "cherry"), store.ZAddOptions{})
+	lex := store.LexRange{Min: store.LexBound{Value: "b"}, Max: store.LexBound{Inf: 1}}
+	got, _ = s.ZRangeByLex("lex", lex, false, 0, -1)
+	if fmt.Sprint(names(got)) != "[banana cherry]" {
+		t.Errorf("unexpected lex range %v", names(got))
+	}
+}
+
+func TestZAddOptions(t *testing.T) {
+	s := newTestStore(t)
+	s.ZAdd("z", zmembers(10, "a"), store.ZAddOptions{})
+
+	if n, _ := s.ZAdd("z", zmembers(5, "a", 1, "b"), store.ZAddOptions{GT: true, CH: true}); n != 1 {
+		t.Errorf("expected only b to count as changed, got %d", n)
+	}
+	if score, _, _ := s.ZScore("z", "a"); score != 10 {
+		t.Errorf("expected GT to keep score 10, got %v", score)
+	}
+
+	s.ZAdd("z", zmembers(20, "c"), store.ZAddOptions{XX: true})
+	if _, ok, _ := s.ZScore("z", "c"); ok {
+		t.Error("expected XX not to add new members")
+	}
+
+	score, ok, _ := s.ZAddIncr("z", "a", 2.5, store.ZAddOptions{})
+	if !ok || score != 12.5 {
+		t.Errorf("expected 12.5, got %v", score)
+	}
+	if _, ok, _ := s.ZAddIncr("z", "a", 1, store.ZAddOptions{NX: true}); ok {
+		t.Error("expected NX INCR on existing member to be skipped")
+	}
+}
+
+func TestZSetStoreAndPop(t *testing.T) {
+	s := newTestStore(t)
+	s.ZAdd("z1", zmembers(1, "a", 2, "b"), store.ZAddOptions{})
+	s.ZAdd("z2", zmembers(10, "b", 20, "c"), store.ZAddOptions{})
+	s.SAdd("set", []string{"c"})
+
+	n, _ := s.ZUnionStore("out", []string{"z1", "z2", "set"}, []float64{1, 2, 3}, store.ZAggregateSum)
+	if n != 3 {
+		t.Fatalf("expected 3 members in union, got %d", n)
+	}
+	if score, _, _ := s.ZScore("out", "b"); score != 22 {
+		t.Errorf("expected weighted score 22, got %v", score)
+	}
+	if score, _, _ := s.ZScore("out", "c"); score != 43 {
+		t.Errorf("expected weighted score 43, got %v", score)
+	}
+
+	n, _ = s.ZInterStore("out", []string{"z1", "z2"}, nil, store.ZAggregateMax)
+	if score, _, _ := s.ZScore("out", "b"); n != 1 || score != 10 {
+		t.Errorf("expected intersection {b:10}, got %d members, score %v", n, score)
+	}
+
+	popped, _ := s.ZPopMax("z2", 1)
+	if len(popped) != 1 || popped[0].Member != "c" {
+		t.Errorf("unexpected ZPopMax result %v", popped)
+	}
+	if popped, _ := s.ZPopMin("z2", 0); len(popped) != 0 {
+		t.Errorf("expected a count of 0 to pop nothing, got %v", popped)
+	}
+	if reply := runClient(t, s, []string{"ZPOPMIN", "z2", "0"}, []string{"ZCARD", "z2"}); reply != "*0\r\n:1\r\n" {
+		t.Errorf("expected ZPOPMIN z2 0 to leave the set unchanged, got %q", reply)
+	}
+	if reply := runClient(t, s, []string{"ZPOPMIN", "z2", "-1"}); reply != "-ERR value is out of range, must be positive\r\n" {
+		t.Errorf("expected a negative count to be refused, got %q", reply)
+	}
+
+	done := make(chan *store.ZMember)
+	go func() {
+		_, m, _ := s.BZPopMin([]string{"delayed"}, 5*time.Second)
+		done <- m
+	}()
+	time.Sleep(50 * time.Millisecond)
+	s.ZAdd("delayed", zmembers(3, "job"), store.ZAddOptions{})
+	select {
+	case m := <-done:
+		if m == nil || m.Member != "job" {
+			t.Errorf("unexpected BZPopMin result %v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("BZPopMin was not woken up")
+	}
+}
+
+func TestFormatScore(t *testing.T) {
+	cases := map[float64]string{1: "1", 1.5: "1.5", 1000000: "1000000", math.Inf(1): "inf", 1e21: "1e+21"}
+	for score, want := range cases {
+		if got := store.FormatScore(score); got != want {
+			t.Errorf("FormatScore(%v): expected %s, got %s", score, want, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Package tlsconfig builds the TLS configurations of the server: the one its TLS
+listeners use for clients and replicas, and the one replicas dial their master
+with. The certificates are read from files and can be reloaded while the
+server runs, connections opened afterwards then use the new ones.
+*/
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var errNoCA = errors.New("authenticating clients needs a CA certificate file")
+
+// Options names the files the certificates are read from and how clients are authenticated.
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// CAFile holds the certificates of the authorities trusted to sign the
+	// certificates of clients and of the master. Without it replicas trust the
+	// system roots and clients can not be authenticated.
+	CAFile string
+	// ClientAuth is tls.NoClientCert unless clients must, or may, present a certificate.
+	ClientAuth tls.ClientAuthType
+}
+
+/*
+ParseClientAuth parses how clients authenticate, with the values of Redis'
+tls-auth-clients: "no", "optional" to check the certificate of the clients that
+send one, or "yes" to require one.
+*/
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(s) {
+	case "no":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "yes":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("invalid client authentication %q, expected no, optional or yes", s)
+}
+
+// FormatClientAuth returns the value ParseClientAuth parses into clientAuth.
+func FormatClientAuth(clientAuth tls.ClientAuthType) string {
+	switch clientAuth {
+	case tls.VerifyClientCertIfGiven:
+		return "optional"
+	case tls.RequireAndVerifyClientCert:
+		return "yes"
+	}
+	return "no"
+}
+
+// certificates are the certificates read by one Reload.
+type certificates struct {
+	cert tls.Certificate
+	// pool is nil without a CA file.
+	pool   *x509.CertPool
+	server *tls.Config
+}
+
+// Certificates holds the certificates currently in use.
+type Certificates struct {
+	options Options
+	current atomic.Pointer[certificates]
+}
+
+// Load reads the certificates named by options.
+func Load(options Options) (*Certificates, error) {
+	if options.ClientAuth != tls.NoClientCert && options.CAFile == "" {
+		return nil, errNoCA
+	}
+	c := &Certificates{options: options}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again. An error leaves the certificates in use untouched.
+func (c *Certificates) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.options.CertFile, c.options.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	loaded := &certificates{cert: cert}
+	if c.options.CAFile != "" {
+		pem, err := os.ReadFile(c.options.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the TLS CA certificate: %w", err)
+		}
+		loaded.pool = x509.NewCertPool()
+		if !loaded.pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", c.options.CAFile)
+		}
+	}
+	loaded.server = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   c.options.ClientAuth,
+		ClientCAs:    loaded.pool,
+	}
+	c.current.Store(loaded)
+	return nil
+}
+
+// ServerConfig returns the configuration of the TLS listeners. Every handshake
+// uses the certificates loaded last.
+func (c *Certificates) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.current.Load().server, nil
+		},
+	}
+}
+
+// ClientConfig returns the configuration to dial addr with. The certificate is
+// presented to servers that authenticate their clients.
+func (c *Certificates) ClientConfig(addr string) *tls.Config {
+	loaded := c.current.Load()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		ServerName:   host,
+		RootCAs:      loaded.pool,
+		Certificates: []tls.Certificate{loaded.cert},
+	}
+}
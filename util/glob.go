@@ -0,0 +1,85 @@
+package util
+
+/*
+GlobMatch reports whether s matches the Redis style glob pattern: * matches any
+sequence, ? any single byte, [abc], [^abc] and [a-z] byte classes, and a
+backslash escapes the next byte. Matching works on bytes, not runes, like Redis,
+and s may be given as bytes so that arguments are matched without a copy.
+*/
+func GlobMatch[T string | []byte](pattern string, s T) bool {
+	// Backtracking point for the last star: where it is in the pattern and how
+	// much of s it currently swallows.
+	starP, starS := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starS = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern, p, s[i]); ok {
+					p = end
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == s[i] {
+					p += 2
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, i = starP+1, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the [...] class starting at pattern[start] and
+// returns the index following the class.
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	p := start + 1
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+	matched := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			matched = matched || pattern[p] == c
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			p += 2
+		default:
+			matched = matched || pattern[p] == c
+		}
+		p++
+	}
+	// Like Redis, an unterminated class runs to the end of the pattern.
+	return min(p+1, len(pattern)), matched != negate
+}
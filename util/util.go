@@ -1,26 +1,75 @@
 package util
 
 import (
-	"net"
-	"strconv"
-	"time"
+	"github.com/PetarGeorgiev-hash/flashdb/protocol"
 )
 
-func WriteString(conn net.Conn, s string) {
-	conn.Write([]byte("+" + s + "\r\n"))
+// The helpers below write the most common replies of commands, see
+// protocol.ReplyWriter for the others.
+
+func WriteString(w protocol.ReplyWriter, s string) {
+	w.WriteSimpleString(s)
+}
+
+func WriteError(w protocol.ReplyWriter, s string) {
+	w.WriteError("ERR " + s)
+}
+
+// WriteErr writes err as an error reply. The error message is expected to
+// carry its own error code prefix such as ERR or WRONGTYPE.
+func WriteErr(w protocol.ReplyWriter, err error) {
+	w.WriteError(err.Error())
+}
+
+func WriteInteger(w protocol.ReplyWriter, n int) {
+	w.WriteInteger(int64(n))
+}
+
+func WriteBulk(w protocol.ReplyWriter, b []byte) {
+	w.WriteBulk(b)
+}
+
+func WriteNull(w protocol.ReplyWriter) {
+	w.WriteNull()
+}
+
+func WriteNullArray(w protocol.ReplyWriter) {
+	w.WriteNullArray()
+}
+
+func WriteArrayHeader(w protocol.ReplyWriter, n int) {
+	w.WriteArrayHeader(n)
+}
+
+// WriteBulkArray writes an array of bulk strings, nil entries are written as nulls.
+func WriteBulkArray(w protocol.ReplyWriter, items [][]byte) {
+	w.WriteArrayHeader(len(items))
+	writeBulks(w, items)
+}
+
+// WriteBulkSet writes items as a set, an array in RESP2.
+func WriteBulkSet(w protocol.ReplyWriter, items [][]byte) {
+	w.WriteSetHeader(len(items))
+	writeBulks(w, items)
 }
 
-func WriteError(conn net.Conn, s string) {
-	conn.Write([]byte("-ERR " + s + "\r\n"))
+// WriteBulkMap writes items, alternating keys and values, as a map.
+func WriteBulkMap(w protocol.ReplyWriter, items [][]byte) {
+	w.WriteMapHeader(len(items) / 2)
+	writeBulks(w, items)
 }
 
-func WriteInteger(conn net.Conn, n int) {
-	conn.Write([]byte(":" + strconv.Itoa(n) + "\r\n"))
+func writeBulks(w protocol.ReplyWriter, items [][]byte) {
+	for _, item := range items {
+		if item == nil {
+			w.WriteNull()
+			continue
+		}
+		w.WriteBulk(item)
+	}
 }
 
-const FileVersion = "FDB1"
-const NumShards = 16
-const FileName = "snapshot.fdb"
-const AppendFile = "appendonly.aof"
+// ServerVersion is the version reported by INFO and HELLO.
+const ServerVersion = "0.0.1-flashdb"
 
-var StartTime = time.Now()
+const FileVersion = "FDB2"